@@ -22,6 +22,7 @@ func main() {
 	gpath := flag.String("gpath", GATEWAY_PATH, "The path the client server will use in the discovery server. This is used by the gateway when proxying")
 	cname := flag.String("cname", SERVER_NAME, "Name of the client server")
 	dlocation := flag.String("dlocation", DISCOVERY_LOCATION, "Address of the discovery server")
+	dkey := flag.String("dkey", "", "Secret key of the discovery server")
 	flag.DurationVar(&heartBeatInterval, "cinterval", HEARTBEAT_INTERVAL, "Interval at which the client server will be sending out heartbeats")
 
 	flag.Parse()
@@ -32,6 +33,7 @@ func main() {
 		ServerName:        *cname,
 		HeartBeatInterval: heartBeatInterval,
 		RegistryLocation:  *dlocation,
+		DiscoveryKey:      *dkey,
 	}
 
 	duller.InitClientServer(clientServerSettings)
@@ -13,10 +13,10 @@ require (
 require github.com/stretchr/objx v0.5.2 // indirect
 
 require (
-	github.com/a-h/templ v0.2.598 // indirect
+	github.com/a-h/templ v0.2.598
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	golang.org/x/crypto v0.21.0
 	golang.org/x/net v0.21.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -1,10 +1,154 @@
 package balancer
 
-import "github.com/anjolaoluwaakindipe/duller/internal/service"
+import (
+	"sync"
+
+	"github.com/anjolaoluwaakindipe/duller/internal/registry"
+	"github.com/anjolaoluwaakindipe/duller/internal/service"
+	"github.com/anjolaoluwaakindipe/duller/internal/utils"
+)
+
+// ServiceFilter removes the services a load balancer must not select from the
+// services registered on a path
+type ServiceFilter interface {
+	Filter(path string, services []*service.ServiceInfo) []*service.ServiceInfo
+}
+
+// balancerConfig holds the configuration shared by all load balancers
+type balancerConfig struct {
+	filters   []ServiceFilter
+	inFlight  *InFlight
+	latencies *Latencies
+	hashKey   HashKey
+	slowStart *SlowStart
+	splits    *Splits
+}
+
+// BalancerOpt are option functions that setup a load balancer
+type BalancerOpt func(*balancerConfig)
+
+// WithServiceFilter makes a load balancer skip every service removed by the filter
+func WithServiceFilter(filter ServiceFilter) BalancerOpt {
+	return func(bc *balancerConfig) {
+		bc.filters = append(bc.filters, filter)
+	}
+}
+
+// WithInFlight makes a load balancer count requests in flight with the given
+// InFlight, so load balancers sharing it see the same requests
+func WithInFlight(inFlight *InFlight) BalancerOpt {
+	return func(bc *balancerConfig) {
+		bc.inFlight = inFlight
+	}
+}
+
+// WithLatencies makes a load balancer record the latency of requests in the given
+// Latencies, so load balancers sharing it see the same latencies
+func WithLatencies(latencies *Latencies) BalancerOpt {
+	return func(bc *balancerConfig) {
+		bc.latencies = latencies
+	}
+}
+
+// WithHashKey sets the request attribute consistent hashing routes by. The address
+// of the client is used by default.
+func WithHashKey(key HashKey) BalancerOpt {
+	return func(bc *balancerConfig) {
+		bc.hashKey = key
+	}
+}
+
+// WithSlowStart makes a load balancer ramp up the share of requests of newly
+// registered services. Consistent hashing ignores it so keys stay on their service.
+func WithSlowStart(slowStart *SlowStart) BalancerOpt {
+	return func(bc *balancerConfig) {
+		bc.slowStart = slowStart
+	}
+}
+
+// WithSplits makes a PathBalancer divide the requests of paths between versions of
+// their services as the splits say. Other load balancers ignore it.
+func WithSplits(splits *Splits) BalancerOpt {
+	return func(bc *balancerConfig) {
+		bc.splits = splits
+	}
+}
+
+func newBalancerConfig(opts ...BalancerOpt) balancerConfig {
+	config := balancerConfig{filters: make([]ServiceFilter, 0), hashKey: HashKey{Source: HashKeyIP}}
+	for _, opt := range opts {
+		opt(&config)
+	}
+	if config.inFlight == nil {
+		config.inFlight = NewInFlight()
+	}
+	if config.latencies == nil {
+		config.latencies = NewLatencies(utils.NewClock(), DefaultLatencyDecay)
+	}
+	return config
+}
+
+// startRequest counts a request to the service as in flight until the returned
+// function is called, which records the latency of the request. The counts and
+// latencies of services that left the registry are dropped along the way.
+func (bc balancerConfig) startRequest(reg registry.Registry, serviceId string) func() {
+	if !bc.inFlight.has(serviceId) {
+		// counts are only added here, so the ones of services that left the registry
+		// are dropped before the counts grow
+		bc.inFlight.removeStale(registeredIds(reg))
+	}
+
+	start := bc.latencies.clock.Now()
+	done := bc.inFlight.Start(serviceId)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			done()
+			if !bc.latencies.has(serviceId) {
+				// likewise latencies are only added here
+				bc.latencies.removeStale(registeredIds(reg))
+			}
+			bc.latencies.Observe(serviceId, bc.latencies.clock.Now().Sub(start))
+		})
+	}
+}
+
+// registeredIds returns the ids of every service of the registry
+func registeredIds(reg registry.Registry) map[string]bool {
+	registered := make(map[string]bool)
+	for _, registeredService := range reg.GetServices() {
+		registered[registeredService.ServiceId] = true
+	}
+	return registered
+}
+
+// availableServices returns the services of a path a load balancer may select.
+// Only UP services that are not removed by a filter are kept.
+func (bc balancerConfig) availableServices(path string, services []*service.ServiceInfo) []*service.ServiceInfo {
+	available := make([]*service.ServiceInfo, 0, len(services))
+	for _, registeredService := range services {
+		if registeredService.CurrentStatus() == service.StatusUp {
+			available = append(available, registeredService)
+		}
+	}
+
+	for _, filter := range bc.filters {
+		available = filter.Filter(path, available)
+	}
+	return available
+}
+
+// warmServices drops services that are still warming up at random so strategies
+// without weights send them their slow start share of requests
+func (bc balancerConfig) warmServices(path string, services []*service.ServiceInfo) []*service.ServiceInfo {
+	return bc.slowStart.Filter(path, services)
+}
 
 type LoadBalancer interface {
 	// GetNextService uses implemented load balancing algorithm
-	// to return a serviceInfo given a speific path.
+	// to return a serviceInfo given a speific path. Only services whose current
+	// status is UP are returned and nil is returned when no service is available.
 	GetNextService(path string) (*service.ServiceInfo, error)
 	// AddService takes in a service and verifies that service for certain
 	// criterias before adding it to the registry
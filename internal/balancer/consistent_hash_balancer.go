@@ -0,0 +1,241 @@
+package balancer
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/anjolaoluwaakindipe/duller/internal/registry"
+	"github.com/anjolaoluwaakindipe/duller/internal/service"
+)
+
+// sources of the request attribute a HashKey reads
+const (
+	HashKeyHeader = "header"
+	HashKeyCookie = "cookie"
+	HashKeyQuery  = "query"
+	HashKeyIP     = "ip"
+)
+
+// virtualNodes is the number of points every unit of weight of a service gets on
+// the hash ring
+const virtualNodes = 100
+
+// HashKey is the request attribute the ConsistentHash load balancer routes by
+type HashKey struct {
+	// Source is one of header, cookie, query or ip
+	Source string
+	// Name is the name of the header, cookie or query parameter. It is empty for ip.
+	Name string
+}
+
+// ParseHashKey parses a hash key written as source:name, e.g. header:X-User-Id,
+// cookie:session or query:user, or as ip for the address of the client
+func ParseHashKey(value string) (HashKey, error) {
+	source, name, _ := strings.Cut(strings.TrimSpace(value), ":")
+	key := HashKey{Source: strings.ToLower(source), Name: strings.TrimSpace(name)}
+
+	switch key.Source {
+	case HashKeyIP:
+		if len(key.Name) > 0 {
+			return key, fmt.Errorf("invalid hash key '%v', ip takes no name", value)
+		}
+	case HashKeyHeader, HashKeyCookie, HashKeyQuery:
+		if len(key.Name) == 0 {
+			return key, fmt.Errorf("invalid hash key '%v', expected %v:name", value, key.Source)
+		}
+	default:
+		return key, fmt.Errorf("invalid hash key '%v', expected one of header:name, cookie:name, query:name or ip", value)
+	}
+	return key, nil
+}
+
+func (hk HashKey) String() string {
+	if len(hk.Name) == 0 {
+		return hk.Source
+	}
+	return hk.Source + ":" + hk.Name
+}
+
+// Value returns the attribute of the request the key reads, or an empty string
+// when the request does not have it
+func (hk HashKey) Value(r *http.Request) string {
+	if r == nil {
+		return ""
+	}
+	switch hk.Source {
+	case HashKeyHeader:
+		return r.Header.Get(hk.Name)
+	case HashKeyCookie:
+		if cookie, err := r.Cookie(hk.Name); err == nil {
+			return cookie.Value
+		}
+	case HashKeyQuery:
+		return r.URL.Query().Get(hk.Name)
+	case HashKeyIP:
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			return host
+		}
+		return r.RemoteAddr
+	}
+	return ""
+}
+
+// RequestBalancer is implemented by load balancers that pick services by the
+// request being proxied
+type RequestBalancer interface {
+	// GetServiceForRequest works like GetNextService for the given request
+	GetServiceForRequest(path string, r *http.Request) (*service.ServiceInfo, error)
+}
+
+// GetServiceForRequest picks a service of the path for the request with the load
+// balancer, falling back to GetNextService when the load balancer does not pick
+// services by request
+func GetServiceForRequest(loadBalancer LoadBalancer, path string, r *http.Request) (*service.ServiceInfo, error) {
+	if requestBalancer, ok := loadBalancer.(RequestBalancer); ok && r != nil {
+		return requestBalancer.GetServiceForRequest(path, r)
+	}
+	return loadBalancer.GetNextService(path)
+}
+
+// hashRing places every service on a ring of hashes many times over
+type hashRing struct {
+	// members identifies the services and weights the ring was built for
+	members    string
+	hashes     []uint64
+	serviceIds []string
+}
+
+func hashOf(value string) uint64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(value))
+	// fnv barely changes the high bits of similar strings, so they are mixed in
+	sum := hash.Sum64()
+	sum ^= sum >> 33
+	sum *= 0xff51afd7ed558ccd
+	sum ^= sum >> 33
+	return sum
+}
+
+// ringMembers identifies a set of services and their weights
+func ringMembers(services []*service.ServiceInfo) string {
+	members := make([]string, 0, len(services))
+	for _, registeredService := range services {
+		members = append(members, registeredService.ServiceId+"="+strconv.Itoa(serviceWeight(registeredService)))
+	}
+	sort.Strings(members)
+	return strings.Join(members, ",")
+}
+
+func newHashRing(members string, services []*service.ServiceInfo) *hashRing {
+	ring := &hashRing{members: members}
+	points := make(map[uint64]string)
+	for _, registeredService := range services {
+		for i := 0; i < virtualNodes*serviceWeight(registeredService); i++ {
+			hash := hashOf(registeredService.ServiceId + "#" + strconv.Itoa(i))
+			// the smaller id wins the rare collision so every ring of the same services is equal
+			if owner, exists := points[hash]; exists && owner < registeredService.ServiceId {
+				continue
+			}
+			points[hash] = registeredService.ServiceId
+		}
+	}
+
+	ring.hashes = make([]uint64, 0, len(points))
+	for hash := range points {
+		ring.hashes = append(ring.hashes, hash)
+	}
+	sort.Slice(ring.hashes, func(i, j int) bool { return ring.hashes[i] < ring.hashes[j] })
+
+	ring.serviceIds = make([]string, len(ring.hashes))
+	for i, hash := range ring.hashes {
+		ring.serviceIds[i] = points[hash]
+	}
+	return ring
+}
+
+// lookup returns the id of the service owning the key, which is the first one
+// clockwise of the hash of the key
+func (hr *hashRing) lookup(key string) string {
+	hash := hashOf(key)
+	index := sort.Search(len(hr.hashes), func(i int) bool { return hr.hashes[i] >= hash })
+	if index == len(hr.hashes) {
+		index = 0
+	}
+	return hr.serviceIds[index]
+}
+
+// ConsistentHash sends the requests with the same hash key to the same service.
+// Services sit on a hash ring with virtual nodes, so a service joining or leaving
+// a path only moves the keys it gains or loses. Requests without the hash key
+// are balanced round robin.
+type ConsistentHash struct {
+	reg    registry.Registry
+	config balancerConfig
+	mutex  sync.Mutex
+	rings  map[string]*hashRing
+	next   atomic.Uint64
+}
+
+func (ch *ConsistentHash) AddService(service *service.ServiceInfo) error {
+	return ch.reg.RegisterService(service)
+}
+
+// GetNextService implements LoadBalancer. Without a request there is no hash key,
+// so services are picked round robin.
+func (ch *ConsistentHash) GetNextService(path string) (*service.ServiceInfo, error) {
+	return ch.GetServiceForRequest(path, nil)
+}
+
+// GetServiceForRequest implements RequestBalancer
+func (ch *ConsistentHash) GetServiceForRequest(path string, r *http.Request) (*service.ServiceInfo, error) {
+	services, err := ch.reg.GetServicesByPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	services = ch.config.availableServices(path, services)
+
+	if len(services) == 0 {
+		return nil, nil
+	}
+
+	key := ch.config.hashKey.Value(r)
+	if len(key) == 0 {
+		return services[int(ch.next.Add(1)%uint64(len(services)))], nil
+	}
+
+	serviceId := ch.ring(path, services).lookup(key)
+	for _, registeredService := range services {
+		if registeredService.ServiceId == serviceId {
+			return registeredService, nil
+		}
+	}
+	return services[0], nil
+}
+
+// ring returns the hash ring of the services of a path, building a new one when
+// they changed
+func (ch *ConsistentHash) ring(path string, services []*service.ServiceInfo) *hashRing {
+	members := ringMembers(services)
+
+	ch.mutex.Lock()
+	defer ch.mutex.Unlock()
+
+	ring, exists := ch.rings[path]
+	if !exists || ring.members != members {
+		ring = newHashRing(members, services)
+		ch.rings[path] = ring
+	}
+	return ring
+}
+
+func NewConsistentHashLoadBalancer(reg registry.Registry, opts ...BalancerOpt) LoadBalancer {
+	return &ConsistentHash{reg: reg, config: newBalancerConfig(opts...), rings: make(map[string]*hashRing)}
+}
@@ -0,0 +1,146 @@
+package balancer_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/anjolaoluwaakindipe/duller/internal/balancer"
+	"github.com/anjolaoluwaakindipe/duller/internal/registry"
+	"github.com/anjolaoluwaakindipe/duller/internal/service"
+	"github.com/stretchr/testify/assert"
+)
+
+// stubUserRequest returns a request of the user on /path1
+func stubUserRequest(user int) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/path1", nil)
+	req.Header.Set("X-User-Id", fmt.Sprintf("user%v", user))
+	return req
+}
+
+// ownersOf returns the id of the service picked for every user
+func ownersOf(t *testing.T, loadBalancer balancer.LoadBalancer, users int) []string {
+	owners := make([]string, 0, users)
+	for user := 0; user < users; user++ {
+		next, err := balancer.GetServiceForRequest(loadBalancer, "/path1", stubUserRequest(user))
+		assert.Nil(t, err)
+		owners = append(owners, next.ServiceId)
+	}
+	return owners
+}
+
+func stubHashBalancer(reg registry.Registry) balancer.LoadBalancer {
+	return balancer.NewConsistentHashLoadBalancer(reg, balancer.WithHashKey(balancer.HashKey{Source: balancer.HashKeyHeader, Name: "X-User-Id"}))
+}
+
+func Test_ParseHashKey(t *testing.T) {
+	t.Run("SHOULD parse the source and name WHEN the hash key is valid", func(t *testing.T) {
+		key, err := balancer.ParseHashKey("header:X-User-Id")
+		assert.Nil(t, err)
+		assert.Equal(t, balancer.HashKey{Source: balancer.HashKeyHeader, Name: "X-User-Id"}, key)
+
+		key, err = balancer.ParseHashKey("ip")
+		assert.Nil(t, err)
+		assert.Equal(t, balancer.HashKey{Source: balancer.HashKeyIP}, key)
+	})
+
+	t.Run("SHOULD return an error WHEN the source is unknown or the name is missing", func(t *testing.T) {
+		for _, value := range []string{"", "body:user", "cookie", "query:", "ip:client"} {
+			_, err := balancer.ParseHashKey(value)
+			assert.NotNil(t, err, value)
+		}
+	})
+}
+
+func Test_HashKey_Value(t *testing.T) {
+	t.Run("SHOULD read the attribute of the request WHEN it has one", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/path1?user=42", nil)
+		req.Header.Set("X-User-Id", "7")
+		req.AddCookie(&http.Cookie{Name: "session", Value: "abc"})
+		req.RemoteAddr = "10.0.0.1:5000"
+
+		assert.Equal(t, "7", balancer.HashKey{Source: balancer.HashKeyHeader, Name: "X-User-Id"}.Value(req))
+		assert.Equal(t, "abc", balancer.HashKey{Source: balancer.HashKeyCookie, Name: "session"}.Value(req))
+		assert.Equal(t, "42", balancer.HashKey{Source: balancer.HashKeyQuery, Name: "user"}.Value(req))
+		assert.Equal(t, "10.0.0.1", balancer.HashKey{Source: balancer.HashKeyIP}.Value(req))
+		assert.Empty(t, balancer.HashKey{Source: balancer.HashKeyCookie, Name: "missing"}.Value(req))
+	})
+}
+
+func Test_ConsistentHash_GetServiceForRequest(t *testing.T) {
+	t.Run("SHOULD return the same service WHEN requests have the same key", func(t *testing.T) {
+		registry, _ := stubFactory()
+		loadBalancer := stubHashBalancer(registry)
+
+		owners := ownersOf(t, loadBalancer, 300)
+		assert.Equal(t, owners, ownersOf(t, loadBalancer, 300))
+		assert.Equal(t, owners, ownersOf(t, stubHashBalancer(registry), 300))
+
+		// the weights of the stub services are 5, 3 and 2
+		counts := make(map[string]int)
+		for _, owner := range owners {
+			counts[owner]++
+		}
+		assert.InDelta(t, 150, counts["server1"], 40)
+		assert.InDelta(t, 90, counts["server2"], 40)
+		assert.InDelta(t, 60, counts["server3"], 40)
+	})
+
+	t.Run("SHOULD only move keys to the new service WHEN a service joins", func(t *testing.T) {
+		registry, _ := stubFactory()
+		loadBalancer := stubHashBalancer(registry)
+		before := ownersOf(t, loadBalancer, 500)
+
+		loadBalancer.AddService(&service.ServiceInfo{Path: "/path1", ServiceId: "server4", IP: "localhost", Port: "7000", WeightedUse: 5})
+		after := ownersOf(t, loadBalancer, 500)
+
+		moved := 0
+		for user := range before {
+			if before[user] != after[user] {
+				assert.Equal(t, "server4", after[user])
+				moved++
+			}
+		}
+		assert.InDelta(t, 500/3, moved, 60)
+	})
+
+	t.Run("SHOULD only move the keys of a service WHEN it leaves", func(t *testing.T) {
+		registry, stubServices := stubFactory()
+		loadBalancer := stubHashBalancer(registry)
+		before := ownersOf(t, loadBalancer, 500)
+
+		registry.OverrideServiceStatus(stubServices[1].ServiceId, service.StatusDraining)
+		after := ownersOf(t, loadBalancer, 500)
+
+		for user := range before {
+			if before[user] != stubServices[1].ServiceId {
+				assert.Equal(t, before[user], after[user])
+			} else {
+				assert.NotEqual(t, stubServices[1].ServiceId, after[user])
+			}
+		}
+	})
+
+	t.Run("SHOULD cycle through the services WHEN requests have no key", func(t *testing.T) {
+		registry, _ := stubFactory()
+		loadBalancer := stubHashBalancer(registry)
+
+		ids := make(map[string]bool)
+		for i := 0; i < 3; i++ {
+			next, err := balancer.GetServiceForRequest(loadBalancer, "/path1", httptest.NewRequest(http.MethodGet, "/path1", nil))
+			assert.Nil(t, err)
+			ids[next.ServiceId] = true
+		}
+		assert.Len(t, ids, 3)
+	})
+
+	t.Run("SHOULD route by key WHEN services ask a path balancer for consistent hashing", func(t *testing.T) {
+		registry, stubServices := stubFactory()
+		stubServices[0].Strategy = balancer.ConsistentHashStrategy
+		loadBalancer := balancer.NewPathBalancer(registry, balancer.StrategyConfig{Default: balancer.RoundRobinStrategy},
+			balancer.WithHashKey(balancer.HashKey{Source: balancer.HashKeyHeader, Name: "X-User-Id"}))
+
+		assert.Equal(t, ownersOf(t, stubHashBalancer(registry), 50), ownersOf(t, loadBalancer, 50))
+	})
+}
@@ -0,0 +1,84 @@
+package balancer
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/anjolaoluwaakindipe/duller/internal/service"
+)
+
+// InFlight counts the requests proxied to every service that have not been
+// answered yet. It is safe for concurrent use and never takes a global lock once
+// a service has been seen.
+type InFlight struct {
+	counts sync.Map
+}
+
+// NewInFlight creates an InFlight without any request in flight
+func NewInFlight() *InFlight {
+	return &InFlight{}
+}
+
+func (inf *InFlight) counter(serviceId string) *atomic.Int64 {
+	if count, exists := inf.counts.Load(serviceId); exists {
+		return count.(*atomic.Int64)
+	}
+	count, _ := inf.counts.LoadOrStore(serviceId, &atomic.Int64{})
+	return count.(*atomic.Int64)
+}
+
+// Start counts a new request to the service. The returned function must be called
+// once the request is answered and does nothing when called again.
+func (inf *InFlight) Start(serviceId string) func() {
+	count := inf.counter(serviceId)
+	count.Add(1)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { count.Add(-1) })
+	}
+}
+
+// has reports whether requests to the service were counted before
+func (inf *InFlight) has(serviceId string) bool {
+	_, exists := inf.counts.Load(serviceId)
+	return exists
+}
+
+// removeStale forgets the counts of services that are no longer registered, so
+// services that come and go do not leak counters. Requests still in flight to them
+// are no longer counted anywhere.
+func (inf *InFlight) removeStale(registered map[string]bool) {
+	inf.counts.Range(func(serviceId, _ any) bool {
+		if !registered[serviceId.(string)] {
+			inf.counts.Delete(serviceId)
+		}
+		return true
+	})
+}
+
+// Count returns the number of requests to the service still in flight
+func (inf *InFlight) Count(serviceId string) int64 {
+	if count, exists := inf.counts.Load(serviceId); exists {
+		return count.(*atomic.Int64).Load()
+	}
+	return 0
+}
+
+// RequestTracker is implemented by load balancers that need to know which
+// requests the services they pick are still answering
+type RequestTracker interface {
+	// StartRequest counts a request proxied to the service and returns the function
+	// to call once it is answered
+	StartRequest(service *service.ServiceInfo) func()
+}
+
+// StartRequest counts a request proxied to a service picked by the load balancer
+// when the load balancer tracks requests. The returned function must be called
+// once the request is answered.
+func StartRequest(loadBalancer LoadBalancer, service *service.ServiceInfo) func() {
+	if tracker, ok := loadBalancer.(RequestTracker); ok {
+		return tracker.StartRequest(service)
+	}
+	return func() {}
+}
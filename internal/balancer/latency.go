@@ -0,0 +1,92 @@
+package balancer
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/anjolaoluwaakindipe/duller/internal/utils"
+)
+
+// DefaultLatencyDecay is how long it takes the latency of a service to forget most
+// of its past when load balancers are not given a Latencies
+const DefaultLatencyDecay = 10 * time.Second
+
+// latencyStats is the moving average of the latency of a single service
+type latencyStats struct {
+	average  float64
+	observed time.Time
+}
+
+// Latencies keeps a peak exponentially weighted moving average of the latency of
+// every service. A latency above the average replaces it at once, while lower
+// latencies only pull it down as older ones decay, so a service that slows down is
+// avoided straight away and only slowly trusted again.
+type Latencies struct {
+	clock utils.Clock
+	decay time.Duration
+	mutex sync.Mutex
+	stats map[string]*latencyStats
+}
+
+// NewLatencies creates a Latencies whose averages forget past latencies over decay
+func NewLatencies(clock utils.Clock, decay time.Duration) *Latencies {
+	return &Latencies{clock: clock, decay: decay, stats: make(map[string]*latencyStats)}
+}
+
+// Observe records the latency of a request answered by the service
+func (l *Latencies) Observe(serviceId string, latency time.Duration) {
+	now := l.clock.Now()
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	stats, exists := l.stats[serviceId]
+	if !exists || float64(latency) > stats.average {
+		l.stats[serviceId] = &latencyStats{average: float64(latency), observed: now}
+		return
+	}
+
+	weight := math.Exp(-float64(now.Sub(stats.observed)) / float64(l.decay))
+	stats.average = stats.average*weight + float64(latency)*(1-weight)
+	stats.observed = now
+}
+
+// has reports whether a latency of the service was observed before
+func (l *Latencies) has(serviceId string) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	_, exists := l.stats[serviceId]
+	return exists
+}
+
+// removeStale forgets the latencies of services that are no longer registered, so
+// services that come and go do not leak their averages
+func (l *Latencies) removeStale(registered map[string]bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for serviceId := range l.stats {
+		if !registered[serviceId] {
+			delete(l.stats, serviceId)
+		}
+	}
+}
+
+// Average returns the moving average of the latency of the service and false when
+// none of its requests were answered yet. The average keeps decaying while the
+// service answers no requests, so a service that was slow is tried again.
+func (l *Latencies) Average(serviceId string) (time.Duration, bool) {
+	now := l.clock.Now()
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	stats, exists := l.stats[serviceId]
+	if !exists {
+		return 0, false
+	}
+	weight := math.Exp(-float64(now.Sub(stats.observed)) / float64(l.decay))
+	return time.Duration(stats.average * weight), true
+}
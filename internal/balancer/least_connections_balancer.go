@@ -0,0 +1,110 @@
+package balancer
+
+import (
+	"math/rand"
+	"sync/atomic"
+
+	"github.com/anjolaoluwaakindipe/duller/internal/registry"
+	"github.com/anjolaoluwaakindipe/duller/internal/service"
+)
+
+// LeastConnections picks the service of a path with the fewest requests in flight.
+// Services with as few requests in flight take turns.
+type LeastConnections struct {
+	reg    registry.Registry
+	config balancerConfig
+	next   atomic.Uint64
+}
+
+func (lc *LeastConnections) AddService(service *service.ServiceInfo) error {
+	return lc.reg.RegisterService(service)
+}
+
+func (lc *LeastConnections) GetNextService(path string) (*service.ServiceInfo, error) {
+	services, err := lc.reg.GetServicesByPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	services = lc.config.warmServices(path, lc.config.availableServices(path, services))
+
+	if len(services) == 0 {
+		return nil, nil
+	}
+
+	// start the scan at a different service every time so ties are spread
+	start := int(lc.next.Add(1) % uint64(len(services)))
+
+	var selectedService *service.ServiceInfo
+	var fewest int64
+	for i := range services {
+		candidate := services[(start+i)%len(services)]
+		count := lc.config.inFlight.Count(candidate.ServiceId)
+		if selectedService == nil || count < fewest {
+			selectedService, fewest = candidate, count
+		}
+	}
+	return selectedService, nil
+}
+
+// StartRequest implements RequestTracker
+func (lc *LeastConnections) StartRequest(service *service.ServiceInfo) func() {
+	return lc.config.startRequest(lc.reg, service.ServiceId)
+}
+
+func NewLeastConnectionsLoadBalancer(reg registry.Registry, opts ...BalancerOpt) LoadBalancer {
+	return &LeastConnections{reg: reg, config: newBalancerConfig(opts...)}
+}
+
+// PowerOfTwoChoices picks two services of a path at random and keeps the one with
+// fewer requests in flight. Unlike LeastConnections it does not look at every
+// service, so it scales to large pools.
+type PowerOfTwoChoices struct {
+	reg    registry.Registry
+	config balancerConfig
+}
+
+func (p2c *PowerOfTwoChoices) AddService(service *service.ServiceInfo) error {
+	return p2c.reg.RegisterService(service)
+}
+
+func (p2c *PowerOfTwoChoices) GetNextService(path string) (*service.ServiceInfo, error) {
+	services, err := p2c.reg.GetServicesByPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	services = p2c.config.warmServices(path, p2c.config.availableServices(path, services))
+
+	if len(services) == 0 {
+		return nil, nil
+	}
+	if len(services) == 1 {
+		return services[0], nil
+	}
+
+	first, second := pickTwo(len(services))
+	if p2c.config.inFlight.Count(services[second].ServiceId) < p2c.config.inFlight.Count(services[first].ServiceId) {
+		return services[second], nil
+	}
+	return services[first], nil
+}
+
+// StartRequest implements RequestTracker
+func (p2c *PowerOfTwoChoices) StartRequest(service *service.ServiceInfo) func() {
+	return p2c.config.startRequest(p2c.reg, service.ServiceId)
+}
+
+// pickTwo returns two different random indexes below n, which must be at least 2
+func pickTwo(n int) (int, int) {
+	first := rand.Intn(n)
+	second := rand.Intn(n - 1)
+	if second >= first {
+		second++
+	}
+	return first, second
+}
+
+func NewPowerOfTwoChoicesLoadBalancer(reg registry.Registry, opts ...BalancerOpt) LoadBalancer {
+	return &PowerOfTwoChoices{reg: reg, config: newBalancerConfig(opts...)}
+}
@@ -0,0 +1,126 @@
+package balancer_test
+
+import (
+	"testing"
+
+	"github.com/anjolaoluwaakindipe/duller/internal/balancer"
+	"github.com/anjolaoluwaakindipe/duller/internal/service"
+	"github.com/stretchr/testify/assert"
+)
+
+// startRequests picks a service count times and leaves every request in flight
+func startRequests(t *testing.T, loadBalancer balancer.LoadBalancer, path string, count int) map[string]int {
+	picked := make(map[string]int)
+	for i := 0; i < count; i++ {
+		next, err := loadBalancer.GetNextService(path)
+		assert.Nil(t, err)
+		balancer.StartRequest(loadBalancer, next)
+		picked[next.ServiceId]++
+	}
+	return picked
+}
+
+func Test_LeastConnections_GetNextService(t *testing.T) {
+	t.Run("SHOULD return the service with the fewest requests in flight WHEN requests are unanswered", func(t *testing.T) {
+		registry, services := stubFactory()
+		loadBalancer := balancer.NewLeastConnectionsLoadBalancer(registry)
+
+		done := balancer.StartRequest(loadBalancer, services[0])
+		balancer.StartRequest(loadBalancer, services[0])
+		balancer.StartRequest(loadBalancer, services[1])
+
+		next, err := loadBalancer.GetNextService("/path1")
+		assert.Nil(t, err)
+		assert.Equal(t, "server3", next.ServiceId)
+
+		done()
+		done()
+		balancer.StartRequest(loadBalancer, services[2])
+		balancer.StartRequest(loadBalancer, services[2])
+
+		next, _ = loadBalancer.GetNextService("/path1")
+		assert.Equal(t, "server1", next.ServiceId)
+	})
+
+	t.Run("SHOULD spread requests evenly WHEN every request stays in flight", func(t *testing.T) {
+		registry, _ := stubFactory()
+		loadBalancer := balancer.NewLeastConnectionsLoadBalancer(registry)
+
+		picked := startRequests(t, loadBalancer, "/path1", 9)
+		assert.Equal(t, map[string]int{"server1": 3, "server2": 3, "server3": 3}, picked)
+	})
+
+	t.Run("SHOULD return nil and an error WHEN an invalid path is given", func(t *testing.T) {
+		registry, _ := stubFactory()
+		loadBalancer := balancer.NewLeastConnectionsLoadBalancer(registry)
+		next, err := loadBalancer.GetNextService("/invalid")
+
+		assert.NotNil(t, err)
+		assert.Nil(t, next)
+	})
+}
+
+func Test_PowerOfTwoChoices_GetNextService(t *testing.T) {
+	t.Run("SHOULD never return the busier service WHEN a path has two services", func(t *testing.T) {
+		registry, services := stubFactory()
+		registry.DeregisterService("/path1", "server3")
+		loadBalancer := balancer.NewPowerOfTwoChoicesLoadBalancer(registry)
+		balancer.StartRequest(loadBalancer, services[0])
+
+		for i := 0; i < 20; i++ {
+			next, err := loadBalancer.GetNextService("/path1")
+			assert.Nil(t, err)
+			assert.Equal(t, "server2", next.ServiceId)
+		}
+	})
+
+	t.Run("SHOULD keep the requests in flight balanced WHEN every request stays in flight", func(t *testing.T) {
+		registry, _ := stubFactory()
+		loadBalancer := balancer.NewPowerOfTwoChoicesLoadBalancer(registry)
+
+		picked := startRequests(t, loadBalancer, "/path1", 300)
+		for _, count := range picked {
+			assert.InDelta(t, 100, count, 10)
+		}
+	})
+
+	t.Run("SHOULD return the only service WHEN a path has a single service", func(t *testing.T) {
+		registry, _ := stubFactory()
+		loadBalancer := balancer.NewPowerOfTwoChoicesLoadBalancer(registry)
+		loadBalancer.AddService(&service.ServiceInfo{Path: "/path2", ServiceId: "server4", IP: "localhost", Port: "7000"})
+
+		next, err := loadBalancer.GetNextService("/path2")
+		assert.Nil(t, err)
+		assert.Equal(t, "server4", next.ServiceId)
+	})
+}
+
+func Test_PathBalancer_StartRequest(t *testing.T) {
+	t.Run("SHOULD keep the requests in flight WHEN the strategy of a path changes", func(t *testing.T) {
+		registry, services := stubFactory()
+		loadBalancer := balancer.NewPathBalancer(registry, balancer.StrategyConfig{Default: balancer.RoundRobinStrategy})
+
+		startRequests(t, loadBalancer, "/path1", 2)
+		services[0].Strategy = balancer.LeastConnectionsStrategy
+
+		next, err := loadBalancer.GetNextService("/path1/orders")
+		assert.Nil(t, err)
+		assert.Equal(t, "server3", next.ServiceId)
+	})
+	t.Run("SHOULD forget the requests in flight of a service WHEN it leaves the registry", func(t *testing.T) {
+		registry, services := stubFactory()
+		inFlight := balancer.NewInFlight()
+		loadBalancer := balancer.NewPathBalancer(registry, balancer.StrategyConfig{Default: balancer.LeastConnectionsStrategy}, balancer.WithInFlight(inFlight))
+
+		balancer.StartRequest(loadBalancer, services[0])
+		assert.Equal(t, int64(1), inFlight.Count(services[0].ServiceId))
+
+		assert.Nil(t, registry.DeregisterService(services[0].Path, services[0].ServiceId))
+		newService := &service.ServiceInfo{Path: "/path1", ServiceId: "server4", IP: "localhost", Port: "7000"}
+		assert.Nil(t, registry.RegisterService(newService))
+		balancer.StartRequest(loadBalancer, newService)
+
+		assert.Equal(t, int64(0), inFlight.Count(services[0].ServiceId))
+		assert.Equal(t, int64(1), inFlight.Count(newService.ServiceId))
+	})
+}
@@ -0,0 +1,82 @@
+package balancer
+
+import (
+	"time"
+
+	"github.com/anjolaoluwaakindipe/duller/internal/registry"
+	"github.com/anjolaoluwaakindipe/duller/internal/service"
+)
+
+// PeakEWMA picks two services of a path at random and keeps the one expected to
+// answer first, which is its average latency times the requests it already has in
+// flight. A slow service keeps receiving requests, only fewer of them, so it is
+// never ejected for being slow. Services without a latency yet are expected to be
+// as fast as the average of the path.
+type PeakEWMA struct {
+	reg    registry.Registry
+	config balancerConfig
+}
+
+func (pe *PeakEWMA) AddService(service *service.ServiceInfo) error {
+	return pe.reg.RegisterService(service)
+}
+
+func (pe *PeakEWMA) GetNextService(path string) (*service.ServiceInfo, error) {
+	services, err := pe.reg.GetServicesByPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	services = pe.config.warmServices(path, pe.config.availableServices(path, services))
+
+	if len(services) == 0 {
+		return nil, nil
+	}
+	if len(services) == 1 {
+		return services[0], nil
+	}
+
+	first, second := pickTwo(len(services))
+
+	if pe.cost(services[second], services) < pe.cost(services[first], services) {
+		return services[second], nil
+	}
+	return services[first], nil
+}
+
+// cost estimates how long the service takes to answer a new request
+func (pe *PeakEWMA) cost(registeredService *service.ServiceInfo, services []*service.ServiceInfo) float64 {
+	latency, observed := pe.config.latencies.Average(registeredService.ServiceId)
+	if !observed {
+		latency = pe.defaultLatency(services)
+	}
+	inFlight := pe.config.inFlight.Count(registeredService.ServiceId)
+	return float64(latency) * float64(inFlight+1)
+}
+
+// defaultLatency returns the latency expected of services without one yet, which
+// is the average latency of the services of the path that have one. When none has,
+// every service gets the same latency and only the requests in flight count.
+func (pe *PeakEWMA) defaultLatency(services []*service.ServiceInfo) time.Duration {
+	var total time.Duration
+	count := 0
+	for _, registeredService := range services {
+		if latency, observed := pe.config.latencies.Average(registeredService.ServiceId); observed {
+			total += latency
+			count++
+		}
+	}
+	if count == 0 {
+		return time.Millisecond
+	}
+	return total / time.Duration(count)
+}
+
+// StartRequest implements RequestTracker
+func (pe *PeakEWMA) StartRequest(service *service.ServiceInfo) func() {
+	return pe.config.startRequest(pe.reg, service.ServiceId)
+}
+
+func NewPeakEWMALoadBalancer(reg registry.Registry, opts ...BalancerOpt) LoadBalancer {
+	return &PeakEWMA{reg: reg, config: newBalancerConfig(opts...)}
+}
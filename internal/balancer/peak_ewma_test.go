@@ -0,0 +1,149 @@
+package balancer_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/anjolaoluwaakindipe/duller/internal/balancer"
+	"github.com/anjolaoluwaakindipe/duller/internal/service"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeClock struct {
+	currentTime time.Time
+}
+
+func (fc *fakeClock) Now() time.Time {
+	return fc.currentTime
+}
+
+func Test_Latencies_Observe(t *testing.T) {
+	t.Run("SHOULD replace the average WHEN a latency is higher", func(t *testing.T) {
+		latencies := balancer.NewLatencies(&fakeClock{currentTime: time.Now()}, 10*time.Second)
+
+		_, observed := latencies.Average("server1")
+		assert.False(t, observed)
+
+		latencies.Observe("server1", 10*time.Millisecond)
+		latencies.Observe("server1", 100*time.Millisecond)
+
+		average, observed := latencies.Average("server1")
+		assert.True(t, observed)
+		assert.Equal(t, 100*time.Millisecond, average)
+	})
+
+	t.Run("SHOULD decay the average towards lower latencies WHEN time passes", func(t *testing.T) {
+		clock := &fakeClock{currentTime: time.Now()}
+		latencies := balancer.NewLatencies(clock, 10*time.Second)
+		latencies.Observe("server1", 100*time.Millisecond)
+
+		clock.currentTime = clock.currentTime.Add(10 * time.Second)
+		latencies.Observe("server1", 10*time.Millisecond)
+
+		// 100ms * e^-1 + 10ms * (1 - e^-1)
+		average, _ := latencies.Average("server1")
+		assert.InDelta(t, 43.1, float64(average)/float64(time.Millisecond), 0.1)
+
+		clock.currentTime = clock.currentTime.Add(time.Minute)
+		latencies.Observe("server1", 10*time.Millisecond)
+		average, _ = latencies.Average("server1")
+		assert.InDelta(t, 10, float64(average)/float64(time.Millisecond), 0.1)
+	})
+
+	t.Run("SHOULD decay the average WHEN the service answers no requests", func(t *testing.T) {
+		clock := &fakeClock{currentTime: time.Now()}
+		latencies := balancer.NewLatencies(clock, 10*time.Second)
+		latencies.Observe("server1", 100*time.Millisecond)
+
+		// 100ms * e^-1
+		clock.currentTime = clock.currentTime.Add(10 * time.Second)
+		average, observed := latencies.Average("server1")
+		assert.True(t, observed)
+		assert.InDelta(t, 36.8, float64(average)/float64(time.Millisecond), 0.1)
+	})
+}
+
+func Test_PeakEWMA_GetNextService(t *testing.T) {
+	t.Run("SHOULD return the faster service WHEN two services have no requests in flight", func(t *testing.T) {
+		registry, _ := stubFactory()
+		registry.DeregisterService("/path1", "server3")
+		latencies := balancer.NewLatencies(&fakeClock{currentTime: time.Now()}, 10*time.Second)
+		latencies.Observe("server1", 100*time.Millisecond)
+		latencies.Observe("server2", 10*time.Millisecond)
+		loadBalancer := balancer.NewPeakEWMALoadBalancer(registry, balancer.WithLatencies(latencies))
+
+		for i := 0; i < 20; i++ {
+			next, err := loadBalancer.GetNextService("/path1")
+			assert.Nil(t, err)
+			assert.Equal(t, "server2", next.ServiceId)
+		}
+	})
+
+	t.Run("SHOULD send fewer requests to a slow service without ejecting it WHEN requests pile up", func(t *testing.T) {
+		registry, _ := stubFactory()
+		latencies := balancer.NewLatencies(&fakeClock{currentTime: time.Now()}, 10*time.Second)
+		latencies.Observe("server1", 100*time.Millisecond)
+		latencies.Observe("server2", 10*time.Millisecond)
+		latencies.Observe("server3", 10*time.Millisecond)
+		loadBalancer := balancer.NewPeakEWMALoadBalancer(registry, balancer.WithLatencies(latencies))
+
+		picked := startRequests(t, loadBalancer, "/path1", 60)
+		assert.Greater(t, picked["server1"], 0)
+		assert.Less(t, picked["server1"], picked["server2"])
+		assert.Less(t, picked["server1"], picked["server3"])
+	})
+
+	t.Run("SHOULD expect the average latency of the path WHEN a service has no latency yet", func(t *testing.T) {
+		registry, services := stubFactory()
+		registry.DeregisterService("/path1", "server1")
+		latencies := balancer.NewLatencies(&fakeClock{currentTime: time.Now()}, 10*time.Second)
+		latencies.Observe("server2", 10*time.Millisecond)
+		loadBalancer := balancer.NewPeakEWMALoadBalancer(registry, balancer.WithLatencies(latencies))
+
+		// server3 is expected to answer in 10ms too, so its requests in flight count
+		for i := 0; i < 3; i++ {
+			balancer.StartRequest(loadBalancer, services[2])
+		}
+
+		for i := 0; i < 20; i++ {
+			next, err := loadBalancer.GetNextService("/path1")
+			assert.Nil(t, err)
+			assert.Equal(t, "server2", next.ServiceId)
+		}
+	})
+
+	t.Run("SHOULD record the latency of a request WHEN it is answered", func(t *testing.T) {
+		registry, services := stubFactory()
+		clock := &fakeClock{currentTime: time.Now()}
+		latencies := balancer.NewLatencies(clock, 10*time.Second)
+		loadBalancer := balancer.NewPathBalancer(registry, balancer.StrategyConfig{Default: balancer.PeakEWMAStrategy}, balancer.WithLatencies(latencies))
+
+		done := balancer.StartRequest(loadBalancer, services[0])
+		clock.currentTime = clock.currentTime.Add(30 * time.Millisecond)
+		done()
+
+		average, observed := latencies.Average("server1")
+		assert.True(t, observed)
+		assert.Equal(t, 30*time.Millisecond, average)
+	})
+	t.Run("SHOULD forget the latency of a service WHEN it leaves the registry", func(t *testing.T) {
+		registry, services := stubFactory()
+		clock := &fakeClock{currentTime: time.Now()}
+		latencies := balancer.NewLatencies(clock, 10*time.Second)
+		loadBalancer := balancer.NewPathBalancer(registry, balancer.StrategyConfig{Default: balancer.PeakEWMAStrategy}, balancer.WithLatencies(latencies))
+
+		balancer.StartRequest(loadBalancer, services[0])()
+		_, observed := latencies.Average(services[0].ServiceId)
+		assert.True(t, observed)
+
+		assert.Nil(t, registry.DeregisterService(services[0].Path, services[0].ServiceId))
+		newService := &service.ServiceInfo{Path: "/path1", ServiceId: "server4", IP: "localhost", Port: "7000"}
+		assert.Nil(t, registry.RegisterService(newService))
+		balancer.StartRequest(loadBalancer, newService)()
+
+		_, observed = latencies.Average(services[0].ServiceId)
+		assert.False(t, observed)
+		_, observed = latencies.Average(newService.ServiceId)
+		assert.True(t, observed)
+	})
+}
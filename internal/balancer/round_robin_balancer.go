@@ -11,6 +11,7 @@ type RoundRobin struct {
 	reg     registry.Registry
 	pathMap map[string]int
 	mutex   sync.Mutex
+	config  balancerConfig
 }
 
 func (lb *RoundRobin) AddService(service *service.ServiceInfo) error {
@@ -39,6 +40,8 @@ func (lb *RoundRobin) GetNextService(path string) (*service.ServiceInfo, error)
 		return nil, err
 	}
 
+	services = lb.config.warmServices(path, lb.config.availableServices(path, services))
+
 	if len(services) == 0 {
 		return nil, nil
 	}
@@ -60,6 +63,6 @@ func (lb *RoundRobin) GetRegitry() registry.Registry {
 	return lb.reg
 }
 
-func NewRoundRobinLoadBalancer(reg registry.Registry) LoadBalancer {
-	return &RoundRobin{reg: reg, pathMap: make(map[string]int)}
+func NewRoundRobinLoadBalancer(reg registry.Registry, opts ...BalancerOpt) LoadBalancer {
+	return &RoundRobin{reg: reg, pathMap: make(map[string]int), config: newBalancerConfig(opts...)}
 }
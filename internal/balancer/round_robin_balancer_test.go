@@ -103,4 +103,58 @@ func Test_RoundRobinLoadBalancer_GetNextService(t *testing.T) {
 		assert.NotNil(t, firstService)
 		assert.Equal(t, firstService, services[0])
 	})
+
+	t.Run("SHOULD skip services failing their health checks WHEN given a valid path", func(t *testing.T) {
+		registry, services := stubFactory()
+		loadBalancer := balancer.NewRoundRobinLoadBalancer(registry)
+
+		registry.SetCheckStatus(services[1].ServiceId, service.StatusDown)
+
+		for i := 0; i < 4; i++ {
+			service, err := loadBalancer.GetNextService("/path1")
+			assert.Nil(t, err)
+			assert.NotEqual(t, services[1], service)
+		}
+	})
+
+	t.Run("SHOULD only select UP services WHEN services are in other states", func(t *testing.T) {
+		registry, services := stubFactory()
+		loadBalancer := balancer.NewRoundRobinLoadBalancer(registry)
+
+		services[0].Status = service.StatusStarting
+		registry.RegisterService(services[0])
+		registry.OverrideServiceStatus(services[1].ServiceId, service.StatusDraining)
+
+		for i := 0; i < 4; i++ {
+			selected, err := loadBalancer.GetNextService("/path1")
+			assert.Nil(t, err)
+			assert.Equal(t, services[2], selected)
+		}
+	})
+
+	t.Run("SHOULD return nil WHEN no service of a path is UP", func(t *testing.T) {
+		registry, services := stubFactory()
+		loadBalancer := balancer.NewRoundRobinLoadBalancer(registry)
+
+		for _, registeredService := range services {
+			registry.OverrideServiceStatus(registeredService.ServiceId, service.StatusOutOfService)
+		}
+
+		service, err := loadBalancer.GetNextService("/path1")
+		assert.Nil(t, err)
+		assert.Nil(t, service)
+	})
+
+	t.Run("SHOULD only select matching services WHEN a selector is used as a filter", func(t *testing.T) {
+		registry, services := stubFactory()
+		services[1].Tags = []string{"canary"}
+		registry.RegisterService(services[1])
+		loadBalancer := balancer.NewRoundRobinLoadBalancer(registry, balancer.WithServiceFilter(service.Selector{Tags: []string{"canary"}}))
+
+		for i := 0; i < 3; i++ {
+			selected, err := loadBalancer.GetNextService("/path1")
+			assert.Nil(t, err)
+			assert.Equal(t, services[1].ServiceId, selected.ServiceId)
+		}
+	})
 }
@@ -0,0 +1,94 @@
+package balancer
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/anjolaoluwaakindipe/duller/internal/service"
+	"github.com/anjolaoluwaakindipe/duller/internal/utils"
+)
+
+// SlowStartConfig holds configuration for SlowStart
+type SlowStartConfig struct {
+	// Window is how long a newly registered service takes to get its full share of
+	// requests. Slow start is disabled when it is 0.
+	Window time.Duration
+	// Aggression shapes the ramp. The share of a service grows with
+	// (elapsed / Window) ^ (1 / Aggression), so 1 is linear and higher values give a
+	// service more requests early on.
+	Aggression float64
+	// MinPercent is the percentage of its full share a service gets as soon as it
+	// registers
+	MinPercent int
+}
+
+// Validate returns an error when a setting of the config is out of range
+func (sc SlowStartConfig) Validate() error {
+	if sc.Window < 0 {
+		return fmt.Errorf("slow start window cannot be negative, got %v", sc.Window)
+	}
+	if sc.Aggression <= 0 {
+		return fmt.Errorf("slow start aggression must be positive, got %v", sc.Aggression)
+	}
+	if sc.MinPercent < 0 || sc.MinPercent > 100 {
+		return fmt.Errorf("slow start minimum percentage must be between 0 and 100, got %v", sc.MinPercent)
+	}
+	return nil
+}
+
+// SlowStart ramps up the share of requests of newly registered services over a
+// window starting when the registry first saw them. A service registering again
+// after it expired starts over.
+type SlowStart struct {
+	clock  utils.Clock
+	config SlowStartConfig
+}
+
+// NewSlowStart creates a SlowStart. The config must be valid.
+func NewSlowStart(clock utils.Clock, config SlowStartConfig) *SlowStart {
+	return &SlowStart{clock: clock, config: config}
+}
+
+// Factor returns the share of its full weight the service gets, from MinPercent
+// right after it registered up to 1 once the window passed. A nil SlowStart gives
+// every service its full weight.
+func (ss *SlowStart) Factor(registeredService *service.ServiceInfo) float64 {
+	if ss == nil || ss.config.Window <= 0 || registeredService.RegisteredAt.IsZero() {
+		return 1
+	}
+
+	elapsed := ss.clock.Now().Sub(registeredService.RegisteredAt)
+	if elapsed >= ss.config.Window {
+		return 1
+	}
+	if elapsed < 0 {
+		elapsed = 0
+	}
+
+	minimum := float64(ss.config.MinPercent) / 100
+	ramp := math.Pow(float64(elapsed)/float64(ss.config.Window), 1/ss.config.Aggression)
+	return math.Max(minimum, ramp)
+}
+
+// Filter implements ServiceFilter. Every service still warming up is kept with a
+// probability of its Factor, so strategies without weights send it a growing share
+// of requests. All services are kept when none would be left.
+func (ss *SlowStart) Filter(path string, services []*service.ServiceInfo) []*service.ServiceInfo {
+	if ss == nil || ss.config.Window <= 0 {
+		return services
+	}
+
+	kept := make([]*service.ServiceInfo, 0, len(services))
+	for _, registeredService := range services {
+		if factor := ss.Factor(registeredService); factor >= 1 || rand.Float64() < factor {
+			kept = append(kept, registeredService)
+		}
+	}
+
+	if len(kept) == 0 {
+		return services
+	}
+	return kept
+}
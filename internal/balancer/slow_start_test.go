@@ -0,0 +1,111 @@
+package balancer_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/anjolaoluwaakindipe/duller/internal/balancer"
+	"github.com/anjolaoluwaakindipe/duller/internal/registry"
+	"github.com/anjolaoluwaakindipe/duller/internal/service"
+	"github.com/stretchr/testify/assert"
+)
+
+// stubWarmingServices registers a warm service and a service that registered at the
+// time of the clock
+func stubWarmingServices(clock *fakeClock) registry.Registry {
+	reg := registry.InitInMemoryRegistry(clock)
+	reg.RegisterService(&service.ServiceInfo{Path: "/path1", ServiceId: "warm", IP: "localhost", Port: "4000", RegisteredAt: clock.currentTime.Add(-time.Hour)})
+	reg.RegisterService(&service.ServiceInfo{Path: "/path1", ServiceId: "new", IP: "localhost", Port: "5000"})
+	return reg
+}
+
+func Test_SlowStart_Factor(t *testing.T) {
+	t.Run("SHOULD ramp up linearly from the minimum WHEN the aggression is 1", func(t *testing.T) {
+		clock := &fakeClock{currentTime: time.Unix(1000, 0)}
+		slowStart := balancer.NewSlowStart(clock, balancer.SlowStartConfig{Window: 10 * time.Second, Aggression: 1, MinPercent: 10})
+		newService := &service.ServiceInfo{RegisteredAt: clock.currentTime}
+
+		assert.Equal(t, 0.1, slowStart.Factor(newService))
+
+		clock.currentTime = clock.currentTime.Add(5 * time.Second)
+		assert.Equal(t, 0.5, slowStart.Factor(newService))
+
+		clock.currentTime = clock.currentTime.Add(5 * time.Second)
+		assert.Equal(t, 1.0, slowStart.Factor(newService))
+	})
+
+	t.Run("SHOULD ramp up faster early on WHEN the aggression is higher", func(t *testing.T) {
+		clock := &fakeClock{currentTime: time.Unix(1000, 0)}
+		slowStart := balancer.NewSlowStart(clock, balancer.SlowStartConfig{Window: 10 * time.Second, Aggression: 2})
+		newService := &service.ServiceInfo{RegisteredAt: clock.currentTime}
+
+		clock.currentTime = clock.currentTime.Add(2500 * time.Millisecond)
+		assert.Equal(t, 0.5, slowStart.Factor(newService))
+	})
+
+	t.Run("SHOULD give every service its full weight WHEN slow start is disabled", func(t *testing.T) {
+		clock := &fakeClock{currentTime: time.Unix(1000, 0)}
+		newService := &service.ServiceInfo{RegisteredAt: clock.currentTime}
+
+		assert.Equal(t, 1.0, balancer.NewSlowStart(clock, balancer.SlowStartConfig{Aggression: 1}).Factor(newService))
+		var disabled *balancer.SlowStart
+		assert.Equal(t, 1.0, disabled.Factor(newService))
+	})
+}
+
+func Test_SlowStart_Balancers(t *testing.T) {
+	t.Run("SHOULD scale the weight of a new service WHEN balancing weighted round robin", func(t *testing.T) {
+		clock := &fakeClock{currentTime: time.Unix(1000, 0)}
+		reg := stubWarmingServices(clock)
+		slowStart := balancer.NewSlowStart(clock, balancer.SlowStartConfig{Window: 10 * time.Second, Aggression: 1})
+		loadBalancer := balancer.NewWeightedRoundRobinLoadBalancer(reg, balancer.WithSlowStart(slowStart))
+
+		clock.currentTime = clock.currentTime.Add(5 * time.Second)
+		picked := make([]string, 0)
+		for i := 0; i < 6; i++ {
+			selectedService, _ := loadBalancer.GetNextService("/path1")
+			picked = append(picked, selectedService.ServiceId)
+		}
+		assert.Equal(t, []string{"warm", "new", "warm", "warm", "new", "warm"}, picked)
+	})
+
+	t.Run("SHOULD send a new service a growing share of requests WHEN balancing round robin", func(t *testing.T) {
+		clock := &fakeClock{currentTime: time.Unix(1000, 0)}
+		reg := stubWarmingServices(clock)
+		slowStart := balancer.NewSlowStart(clock, balancer.SlowStartConfig{Window: 10 * time.Second, Aggression: 1})
+		loadBalancer := balancer.NewRoundRobinLoadBalancer(reg, balancer.WithSlowStart(slowStart))
+
+		countNew := func() int {
+			count := 0
+			for i := 0; i < 1000; i++ {
+				selectedService, _ := loadBalancer.GetNextService("/path1")
+				if selectedService.ServiceId == "new" {
+					count++
+				}
+			}
+			return count
+		}
+
+		assert.Equal(t, 0, countNew())
+
+		clock.currentTime = clock.currentTime.Add(5 * time.Second)
+		halfway := countNew()
+		assert.Greater(t, halfway, 100)
+		assert.Less(t, halfway, 400)
+
+		clock.currentTime = clock.currentTime.Add(5 * time.Second)
+		assert.Equal(t, 500, countNew())
+	})
+
+	t.Run("SHOULD still pick a new service WHEN it is the only one available", func(t *testing.T) {
+		clock := &fakeClock{currentTime: time.Unix(1000, 0)}
+		reg := registry.InitInMemoryRegistry(clock)
+		reg.RegisterService(&service.ServiceInfo{Path: "/path1", ServiceId: "new", IP: "localhost", Port: "5000"})
+		slowStart := balancer.NewSlowStart(clock, balancer.SlowStartConfig{Window: 10 * time.Second, Aggression: 1})
+		loadBalancer := balancer.NewLeastConnectionsLoadBalancer(reg, balancer.WithSlowStart(slowStart))
+
+		selectedService, err := loadBalancer.GetNextService("/path1")
+		assert.Nil(t, err)
+		assert.Equal(t, "new", selectedService.ServiceId)
+	})
+}
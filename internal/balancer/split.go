@@ -0,0 +1,227 @@
+package balancer
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/anjolaoluwaakindipe/duller/internal/service"
+)
+
+// VersionHeader and VersionCookie let a request force the version of its service,
+// e.g. for testers trying out a canary. Versions no service runs are ignored.
+const (
+	VersionHeader = "X-Duller-Version"
+	VersionCookie = "duller-version"
+)
+
+// VersionWeight is the share of the requests of a path sent to the services of a
+// version. Version is a full version or a prefix such as "1" or "1.4".
+type VersionWeight struct {
+	Version string `json:"version"`
+	Weight  int    `json:"weight"`
+}
+
+// Split divides the requests of a path between versions of its services in
+// proportion to their weights
+type Split struct {
+	Versions []VersionWeight `json:"versions"`
+}
+
+// Validate returns an error when the split has no version to send requests to
+func (s Split) Validate() error {
+	if len(s.Versions) == 0 {
+		return fmt.Errorf("a split needs at least one version")
+	}
+	seen := make(map[string]bool)
+	total := 0
+	for _, version := range s.Versions {
+		if len(strings.TrimSpace(version.Version)) == 0 {
+			return fmt.Errorf("split versions cannot be blank")
+		}
+		if seen[version.Version] {
+			return fmt.Errorf("version '%v' is split more than once", version.Version)
+		}
+		if version.Weight < 0 {
+			return fmt.Errorf("weight of version '%v' cannot be negative, got %v", version.Version, version.Weight)
+		}
+		seen[version.Version] = true
+		total += version.Weight
+	}
+	if total == 0 {
+		return fmt.Errorf("a split needs a version with a positive weight")
+	}
+	return nil
+}
+
+// SplitStatus is the split of a path along with the requests every version got
+type SplitStatus struct {
+	Path     string          `json:"path"`
+	Versions []VersionWeight `json:"versions"`
+	// Observed counts the requests the load balancer holding the splits sent to every
+	// version since the split was set. Requests balanced elsewhere, e.g. by gateways
+	// forwarding to instances directly, are not counted.
+	Observed map[string]uint64 `json:"observed"`
+}
+
+// pathSplit is the split of a single path
+type pathSplit struct {
+	split    Split
+	observed map[string]uint64
+}
+
+// Splits holds the traffic splits of every path, which operators can change at
+// runtime
+type Splits struct {
+	mutex  sync.Mutex
+	splits map[string]*pathSplit
+}
+
+// NewSplits creates Splits without any split
+func NewSplits() *Splits {
+	return &Splits{splits: make(map[string]*pathSplit)}
+}
+
+// Set replaces the split of the path and starts counting its requests over unless
+// the split did not change. The split must be valid.
+func (s *Splits) Set(path string, split Split) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if current, exists := s.splits[path]; exists && reflect.DeepEqual(current.split.Versions, split.Versions) {
+		return
+	}
+	s.splits[path] = &pathSplit{split: split, observed: make(map[string]uint64)}
+}
+
+// Remove removes the split of the path and reports whether it had one
+func (s *Splits) Remove(path string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, exists := s.splits[path]
+	delete(s.splits, path)
+	return exists
+}
+
+// Get returns the split of the path and false when it has none
+func (s *Splits) Get(path string) (SplitStatus, bool) {
+	if s == nil {
+		return SplitStatus{}, false
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	current, exists := s.splits[path]
+	if !exists {
+		return SplitStatus{}, false
+	}
+	return current.status(path), true
+}
+
+// All returns the split of every path in order of path
+func (s *Splits) All() []SplitStatus {
+	if s == nil {
+		return []SplitStatus{}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	statuses := make([]SplitStatus, 0, len(s.splits))
+	for path, current := range s.splits {
+		statuses = append(statuses, current.status(path))
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Path < statuses[j].Path })
+	return statuses
+}
+
+// pick returns the version the request goes to, which is the version it forces
+// or else a version picked at random in proportion to the weights of the split.
+// Versions without an UP service are skipped and an empty version is returned
+// when the path has no split or none of its versions can take the request.
+func (s *Splits) pick(path string, r *http.Request, services []*service.ServiceInfo) string {
+	if s == nil {
+		return ""
+	}
+
+	if forced := forcedVersion(r); len(forced) > 0 && hasUpService(forced, services) {
+		return forced
+	}
+
+	s.mutex.Lock()
+	current, exists := s.splits[path]
+	if !exists {
+		s.mutex.Unlock()
+		return ""
+	}
+	versions := current.split.Versions
+	s.mutex.Unlock()
+
+	candidates := make([]VersionWeight, 0, len(versions))
+	total := 0
+	for _, version := range versions {
+		if version.Weight > 0 && hasUpService(version.Version, services) {
+			candidates = append(candidates, version)
+			total += version.Weight
+		}
+	}
+	if total == 0 {
+		return ""
+	}
+
+	target := rand.Intn(total)
+	for _, version := range candidates {
+		if target < version.Weight {
+			return version.Version
+		}
+		target -= version.Weight
+	}
+	return ""
+}
+
+// observe counts a request sent to a version of the split of the path
+func (s *Splits) observe(path string, version string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if current, exists := s.splits[path]; exists {
+		current.observed[version]++
+	}
+}
+
+func (ps *pathSplit) status(path string) SplitStatus {
+	observed := make(map[string]uint64, len(ps.observed))
+	for version, count := range ps.observed {
+		observed[version] = count
+	}
+	versions := append([]VersionWeight(nil), ps.split.Versions...)
+	return SplitStatus{Path: path, Versions: versions, Observed: observed}
+}
+
+// forcedVersion returns the version named by the header or cookie of the request
+func forcedVersion(r *http.Request) string {
+	if r == nil {
+		return ""
+	}
+	if version := r.Header.Get(VersionHeader); len(version) > 0 {
+		return version
+	}
+	if cookie, err := r.Cookie(VersionCookie); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+// hasUpService reports whether a service of the version is UP
+func hasUpService(version string, services []*service.ServiceInfo) bool {
+	selector := service.Selector{Version: version}
+	for _, registeredService := range services {
+		if registeredService.CurrentStatus() == service.StatusUp && selector.Matches(registeredService) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+package balancer_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/anjolaoluwaakindipe/duller/internal/balancer"
+	"github.com/anjolaoluwaakindipe/duller/internal/registry"
+	"github.com/anjolaoluwaakindipe/duller/internal/service"
+	"github.com/anjolaoluwaakindipe/duller/internal/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// stubVersionedBalancer registers two services of version 1 and one of version 2
+// on /orders and balances them round robin with the splits
+func stubVersionedBalancer(splits *balancer.Splits) (registry.Registry, *balancer.PathBalancer) {
+	reg := registry.InitInMemoryRegistry(utils.NewClock())
+	reg.RegisterService(&service.ServiceInfo{Path: "/orders", ServiceId: "v1a", IP: "localhost", Port: "4000", Version: "1.0.0"})
+	reg.RegisterService(&service.ServiceInfo{Path: "/orders", ServiceId: "v1b", IP: "localhost", Port: "4001", Version: "1.0.0"})
+	reg.RegisterService(&service.ServiceInfo{Path: "/orders", ServiceId: "v2", IP: "localhost", Port: "5000", Version: "2.0.0"})
+	return reg, balancer.NewPathBalancer(reg, balancer.StrategyConfig{Default: balancer.RoundRobinStrategy}, balancer.WithSplits(splits))
+}
+
+// countVersions sends count requests and counts the requests every version got
+func countVersions(pb *balancer.PathBalancer, r *http.Request, count int) map[string]int {
+	versions := make(map[string]int)
+	for i := 0; i < count; i++ {
+		selectedService, _ := pb.GetServiceForRequest("/orders", r)
+		versions[selectedService.Version]++
+	}
+	return versions
+}
+
+func Test_Split_Validate(t *testing.T) {
+	t.Run("SHOULD return an error WHEN the split cannot send requests anywhere", func(t *testing.T) {
+		assert.Nil(t, balancer.Split{Versions: []balancer.VersionWeight{{Version: "1", Weight: 95}, {Version: "2", Weight: 5}}}.Validate())
+
+		assert.NotNil(t, balancer.Split{}.Validate())
+		assert.NotNil(t, balancer.Split{Versions: []balancer.VersionWeight{{Version: "1", Weight: 0}}}.Validate())
+		assert.NotNil(t, balancer.Split{Versions: []balancer.VersionWeight{{Version: "1", Weight: 1}, {Version: "1", Weight: 1}}}.Validate())
+		assert.NotNil(t, balancer.Split{Versions: []balancer.VersionWeight{{Version: "", Weight: 1}}}.Validate())
+		assert.NotNil(t, balancer.Split{Versions: []balancer.VersionWeight{{Version: "1", Weight: 2}, {Version: "2", Weight: -1}}}.Validate())
+	})
+}
+
+func Test_PathBalancer_Splits(t *testing.T) {
+	t.Run("SHOULD divide requests between versions WHEN the path has a split", func(t *testing.T) {
+		splits := balancer.NewSplits()
+		_, pb := stubVersionedBalancer(splits)
+		splits.Set("/orders", balancer.Split{Versions: []balancer.VersionWeight{{Version: "1", Weight: 75}, {Version: "2", Weight: 25}}})
+
+		versions := countVersions(pb, nil, 1000)
+		assert.InDelta(t, 750, versions["1.0.0"], 80)
+		assert.InDelta(t, 250, versions["2.0.0"], 80)
+
+		status, exists := splits.Get("/orders")
+		assert.True(t, exists)
+		assert.Equal(t, uint64(versions["1.0.0"]), status.Observed["1"])
+		assert.Equal(t, uint64(versions["2.0.0"]), status.Observed["2"])
+	})
+
+	t.Run("SHOULD send every request to the forced version WHEN the header or cookie names one", func(t *testing.T) {
+		splits := balancer.NewSplits()
+		_, pb := stubVersionedBalancer(splits)
+		splits.Set("/orders", balancer.Split{Versions: []balancer.VersionWeight{{Version: "1", Weight: 100}}})
+
+		req := httptest.NewRequest(http.MethodGet, "/orders", nil)
+		req.Header.Set(balancer.VersionHeader, "2")
+		assert.Equal(t, map[string]int{"2.0.0": 10}, countVersions(pb, req, 10))
+
+		req = httptest.NewRequest(http.MethodGet, "/orders", nil)
+		req.AddCookie(&http.Cookie{Name: balancer.VersionCookie, Value: "2.0.0"})
+		assert.Equal(t, map[string]int{"2.0.0": 10}, countVersions(pb, req, 10))
+
+		// no service runs version 3 so the split is followed
+		req = httptest.NewRequest(http.MethodGet, "/orders", nil)
+		req.Header.Set(balancer.VersionHeader, "3")
+		assert.Equal(t, map[string]int{"1.0.0": 10}, countVersions(pb, req, 10))
+	})
+
+	t.Run("SHOULD skip a version WHEN none of its services is UP", func(t *testing.T) {
+		splits := balancer.NewSplits()
+		reg, pb := stubVersionedBalancer(splits)
+		splits.Set("/orders", balancer.Split{Versions: []balancer.VersionWeight{{Version: "1", Weight: 50}, {Version: "2", Weight: 50}}})
+		reg.OverrideServiceStatus("v2", service.StatusOutOfService)
+
+		assert.Equal(t, map[string]int{"1.0.0": 10}, countVersions(pb, nil, 10))
+	})
+
+	t.Run("SHOULD keep counting requests WHEN the same split is set again", func(t *testing.T) {
+		splits := balancer.NewSplits()
+		_, pb := stubVersionedBalancer(splits)
+		split := balancer.Split{Versions: []balancer.VersionWeight{{Version: "2", Weight: 1}}}
+		splits.Set("/orders", split)
+		countVersions(pb, nil, 5)
+
+		splits.Set("/orders", split)
+		status, _ := splits.Get("/orders")
+		assert.Equal(t, uint64(5), status.Observed["2"])
+
+		splits.Set("/orders", balancer.Split{Versions: []balancer.VersionWeight{{Version: "1", Weight: 1}}})
+		status, _ = splits.Get("/orders")
+		assert.Empty(t, status.Observed)
+
+		assert.True(t, splits.Remove("/orders"))
+		assert.Empty(t, splits.All())
+	})
+}
@@ -0,0 +1,266 @@
+package balancer
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/anjolaoluwaakindipe/duller/internal/registry"
+	"github.com/anjolaoluwaakindipe/duller/internal/service"
+	"github.com/anjolaoluwaakindipe/duller/internal/utils"
+)
+
+// names of the built in load balancing strategies
+const (
+	RoundRobinStrategy         = "round-robin"
+	WeightedRoundRobinStrategy = "weighted-round-robin"
+	LeastConnectionsStrategy   = "least-connections"
+	PowerOfTwoChoicesStrategy  = "power-of-two-choices"
+	ConsistentHashStrategy     = "consistent-hash"
+	PeakEWMAStrategy           = "peak-ewma"
+)
+
+// Factory creates a load balancer of the services of the registry
+type Factory func(reg registry.Registry, opts ...BalancerOpt) LoadBalancer
+
+var factories = map[string]Factory{
+	RoundRobinStrategy:         NewRoundRobinLoadBalancer,
+	WeightedRoundRobinStrategy: NewWeightedRoundRobinLoadBalancer,
+	LeastConnectionsStrategy:   NewLeastConnectionsLoadBalancer,
+	PowerOfTwoChoicesStrategy:  NewPowerOfTwoChoicesLoadBalancer,
+	ConsistentHashStrategy:     NewConsistentHashLoadBalancer,
+	PeakEWMAStrategy:           NewPeakEWMALoadBalancer,
+}
+
+// RegisterStrategy makes a load balancing strategy selectable by name. It is meant
+// to be called before any load balancer is created, e.g. in an init function.
+func RegisterStrategy(name string, factory Factory) {
+	factories[name] = factory
+}
+
+// IsStrategy reports whether a strategy is registered under the name
+func IsStrategy(name string) bool {
+	_, exists := factories[name]
+	return exists
+}
+
+// Strategies returns the names of every registered strategy in order
+func Strategies() []string {
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// StrategyConfig holds configuration for a PathBalancer
+type StrategyConfig struct {
+	// Default is the strategy of paths that have no other strategy
+	Default string
+	// Paths maps service paths to the strategy operators chose for them. It wins
+	// over the strategy services declare in their registration.
+	Paths map[string]string
+	// Zone is the zone of the callers of the load balancer. Services in the zone of
+	// the caller are preferred and requests may name their zone in the ZoneHeader.
+	Zone string
+	// MinLocalPercent is the percentage of the services registered in the zone of
+	// the caller that must be available for requests to stay in the zone. Requests
+	// spill over to other zones below it and whenever the zone has no service left.
+	MinLocalPercent int
+}
+
+// Validate returns an error when the config names an unknown strategy
+func (sc StrategyConfig) Validate() error {
+	if !IsStrategy(sc.Default) {
+		return fmt.Errorf("unknown load balancing strategy '%v', expected one of %v", sc.Default, Strategies())
+	}
+	for path, strategy := range sc.Paths {
+		if !IsStrategy(strategy) {
+			return fmt.Errorf("unknown load balancing strategy '%v' for path '%v', expected one of %v", strategy, path, Strategies())
+		}
+	}
+	if sc.MinLocalPercent < 0 || sc.MinLocalPercent > 100 {
+		return fmt.Errorf("minimum local percentage must be between 0 and 100, got %v", sc.MinLocalPercent)
+	}
+	return nil
+}
+
+// balancerKey identifies the load balancer of a path for callers of a zone, the
+// version of the split requests were sent to and the subset they are limited to
+type balancerKey struct {
+	path    string
+	zone    string
+	version string
+	subset  string
+}
+
+// pathStrategy is the load balancer of a single path
+type pathStrategy struct {
+	name     string
+	balancer LoadBalancer
+}
+
+// PathBalancer balances every path with its own strategy. The strategy of a path
+// is the one operators chose for it, else the one its services declare in their
+// registration, else the default strategy. Callers of every zone get their own
+// load balancer preferring the services of their zone, every version of a traffic
+// split gets its own load balancer of the services of that version and so does
+// every subset requests are limited to with WithSubset.
+type PathBalancer struct {
+	reg    registry.Registry
+	config StrategyConfig
+	opts   []BalancerOpt
+	// shared holds the requests in flight and latencies of every path
+	shared    balancerConfig
+	mutex     sync.Mutex
+	balancers map[balancerKey]*pathStrategy
+}
+
+// NewPathBalancer creates a PathBalancer of the services of the registry. The config
+// must be valid and the options are passed to the load balancer of every path.
+func NewPathBalancer(reg registry.Registry, config StrategyConfig, opts ...BalancerOpt) *PathBalancer {
+	paths := make(map[string]string)
+	for path, strategy := range config.Paths {
+		utils.MakeUrlPathValid(&path)
+		paths[path] = strategy
+	}
+	config.Paths = paths
+
+	// the requests in flight and latencies outlive the load balancer of a path when its
+	// strategy changes
+	shared := newBalancerConfig(opts...)
+	opts = append(opts, WithInFlight(shared.inFlight), WithLatencies(shared.latencies))
+
+	return &PathBalancer{reg: reg, config: config, opts: opts, shared: shared, balancers: make(map[balancerKey]*pathStrategy)}
+}
+
+// GetNextService implements LoadBalancer
+func (pb *PathBalancer) GetNextService(path string) (*service.ServiceInfo, error) {
+	return pb.GetServiceForRequest(path, nil)
+}
+
+// GetServiceForRequest implements RequestBalancer
+func (pb *PathBalancer) GetServiceForRequest(path string, r *http.Request) (*service.ServiceInfo, error) {
+	servicePath, err := pb.reg.GetPathFromRequest(path)
+	if err != nil {
+		return nil, err
+	}
+
+	services, err := pb.reg.GetServicesByPath(servicePath)
+	if err != nil {
+		return nil, err
+	}
+
+	zone := requestZone(r, services, pb.config.Zone)
+	requested := requestSubset(r)
+	if version := pb.shared.splits.pick(servicePath, r, requested.selector.Filter(servicePath, services)); len(version) > 0 {
+		selectedService, err := GetServiceForRequest(pb.balancer(servicePath, zone, version, requested, services), servicePath, r)
+		if err != nil || selectedService != nil {
+			if selectedService != nil {
+				pb.shared.splits.observe(servicePath, version)
+			}
+			return selectedService, err
+		}
+		// every service of the version was filtered out, so the whole path is balanced
+	}
+	return GetServiceForRequest(pb.balancer(servicePath, zone, "", requested, services), servicePath, r)
+}
+
+// AddService implements LoadBalancer. The strategy the service declares is used
+// for its path unless operators chose another one.
+func (pb *PathBalancer) AddService(newService *service.ServiceInfo) error {
+	path := newService.Path
+	utils.MakeUrlPathValid(&path)
+
+	services, _ := pb.reg.GetServicesByPath(path)
+	services = append([]*service.ServiceInfo{newService}, services...)
+
+	return pb.balancer(path, pb.config.Zone, "", subset{}, services).AddService(newService)
+}
+
+// StartRequest implements RequestTracker. Requests are tracked whatever the strategy
+// of the path so a strategy relying on them starts with the right counts and latencies.
+func (pb *PathBalancer) StartRequest(service *service.ServiceInfo) func() {
+	return pb.shared.startRequest(pb.reg, service.ServiceId)
+}
+
+// Strategy returns the name of the strategy of the path
+func (pb *PathBalancer) Strategy(path string) string {
+	services, _ := pb.reg.GetServicesByPath(path)
+	return pb.strategy(path, services)
+}
+
+// strategy returns the name of the strategy of a path given its services
+func (pb *PathBalancer) strategy(path string, services []*service.ServiceInfo) string {
+	if strategy, exists := pb.config.Paths[path]; exists {
+		return strategy
+	}
+	for _, registeredService := range services {
+		if len(registeredService.Strategy) == 0 {
+			continue
+		}
+		if IsStrategy(registeredService.Strategy) {
+			return registeredService.Strategy
+		}
+		slog.Warn(fmt.Sprintf("Service %v declares unknown load balancing strategy '%v'", registeredService.ServiceId, registeredService.Strategy))
+	}
+	return pb.config.Default
+}
+
+// balancer returns the load balancer of a path for callers of the zone, limited to
+// the services of the version and subset when they are set, creating a new one
+// whenever the strategy of the path changes
+func (pb *PathBalancer) balancer(path string, zone string, version string, requested subset, services []*service.ServiceInfo) LoadBalancer {
+	name := pb.strategy(path, services)
+	key := balancerKey{path: path, zone: strings.ToLower(zone), version: version, subset: requested.key}
+
+	pb.mutex.Lock()
+	defer pb.mutex.Unlock()
+
+	current, exists := pb.balancers[key]
+	if !exists {
+		// load balancers are only added here, so the ones of paths that left the
+		// registry are dropped before the map grows
+		pb.removeStaleBalancers()
+	}
+	if !exists || current.name != name {
+		opts := pb.opts
+		selectors := make([]service.Selector, 0, 2)
+		if len(requested.key) > 0 {
+			selectors = append(selectors, requested.selector)
+		}
+		if len(version) > 0 {
+			selectors = append(selectors, service.Selector{Version: version})
+		}
+		for _, selector := range selectors {
+			opts = append(opts[:len(opts):len(opts)], WithServiceFilter(selector))
+		}
+		if len(zone) > 0 {
+			// the zone filter comes last so it only sees services other filters kept
+			// and only counts the registered services they would keep
+			opts = append(opts[:len(opts):len(opts)], WithServiceFilter(zoneFilter{reg: pb.reg, zone: zone, selectors: selectors, minLocalPercent: pb.config.MinLocalPercent}))
+		}
+		current = &pathStrategy{name: name, balancer: factories[name](pb.reg, opts...)}
+		pb.balancers[key] = current
+	}
+	return current.balancer
+}
+
+// removeStaleBalancers forgets the load balancers of paths that are no longer
+// registered. The mutex must be held when calling it.
+func (pb *PathBalancer) removeStaleBalancers() {
+	registered := make(map[string]bool)
+	for key := range pb.balancers {
+		if _, checked := registered[key.path]; !checked {
+			servicePath, err := pb.reg.GetPathFromRequest(key.path)
+			registered[key.path] = err == nil && servicePath == key.path
+		}
+		if !registered[key.path] {
+			delete(pb.balancers, key)
+		}
+	}
+}
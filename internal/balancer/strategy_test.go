@@ -0,0 +1,117 @@
+package balancer_test
+
+import (
+	"testing"
+
+	"github.com/anjolaoluwaakindipe/duller/internal/balancer"
+	"github.com/anjolaoluwaakindipe/duller/internal/registry"
+	"github.com/anjolaoluwaakindipe/duller/internal/service"
+	"github.com/anjolaoluwaakindipe/duller/internal/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// firstServiceBalancer always returns the first service of a path
+type firstServiceBalancer struct {
+	reg registry.Registry
+}
+
+func (fb *firstServiceBalancer) GetNextService(path string) (*service.ServiceInfo, error) {
+	services, err := fb.reg.GetServicesByPath(path)
+	if err != nil || len(services) == 0 {
+		return nil, err
+	}
+	return services[0], nil
+}
+
+func (fb *firstServiceBalancer) AddService(service *service.ServiceInfo) error {
+	return fb.reg.RegisterService(service)
+}
+
+func nextServiceIds(t *testing.T, loadBalancer balancer.LoadBalancer, path string, count int) []string {
+	ids := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		next, err := loadBalancer.GetNextService(path)
+		assert.Nil(t, err)
+		ids = append(ids, next.ServiceId)
+	}
+	return ids
+}
+
+func Test_PathBalancer_GetNextService(t *testing.T) {
+	t.Run("SHOULD use the default strategy WHEN neither operators nor services choose one", func(t *testing.T) {
+		reg, _ := stubFactory()
+		loadBalancer := balancer.NewPathBalancer(reg, balancer.StrategyConfig{Default: balancer.RoundRobinStrategy})
+
+		assert.Equal(t, balancer.RoundRobinStrategy, loadBalancer.Strategy("/path1"))
+		assert.Equal(t, []string{"server1", "server2", "server3", "server1"}, nextServiceIds(t, loadBalancer, "/path1", 4))
+	})
+
+	t.Run("SHOULD use the strategy of the services WHEN they declare one in their registration", func(t *testing.T) {
+		reg := registry.InitInMemoryRegistry(utils.NewClock())
+		loadBalancer := balancer.NewPathBalancer(reg, balancer.StrategyConfig{Default: balancer.RoundRobinStrategy})
+		loadBalancer.AddService(&service.ServiceInfo{Path: "/path1", ServiceId: "server1", IP: "localhost", Port: "4000", WeightedUse: 2, Strategy: balancer.WeightedRoundRobinStrategy})
+		loadBalancer.AddService(&service.ServiceInfo{Path: "/path1", ServiceId: "server2", IP: "localhost", Port: "5000", WeightedUse: 1, Strategy: balancer.WeightedRoundRobinStrategy})
+
+		assert.Equal(t, balancer.WeightedRoundRobinStrategy, loadBalancer.Strategy("/path1"))
+		assert.Equal(t, []string{"server1", "server2", "server1", "server1"}, nextServiceIds(t, loadBalancer, "/path1", 4))
+	})
+
+	t.Run("SHOULD use the strategy operators chose for a path WHEN services declare another one", func(t *testing.T) {
+		reg := registry.InitInMemoryRegistry(utils.NewClock())
+		loadBalancer := balancer.NewPathBalancer(reg, balancer.StrategyConfig{
+			Default: balancer.WeightedRoundRobinStrategy,
+			Paths:   map[string]string{"path1": balancer.RoundRobinStrategy},
+		})
+		loadBalancer.AddService(&service.ServiceInfo{Path: "/path1", ServiceId: "server1", IP: "localhost", Port: "4000", WeightedUse: 2, Strategy: balancer.WeightedRoundRobinStrategy})
+		loadBalancer.AddService(&service.ServiceInfo{Path: "/path1", ServiceId: "server2", IP: "localhost", Port: "5000", WeightedUse: 1})
+
+		assert.Equal(t, balancer.RoundRobinStrategy, loadBalancer.Strategy("/path1"))
+		assert.Equal(t, []string{"server1", "server2", "server1"}, nextServiceIds(t, loadBalancer, "/path1", 3))
+	})
+
+	t.Run("SHOULD use a registered strategy WHEN it is chosen by name", func(t *testing.T) {
+		balancer.RegisterStrategy("first", func(reg registry.Registry, opts ...balancer.BalancerOpt) balancer.LoadBalancer {
+			return &firstServiceBalancer{reg: reg}
+		})
+		reg, _ := stubFactory()
+		config := balancer.StrategyConfig{Default: "first"}
+		assert.Nil(t, config.Validate())
+
+		loadBalancer := balancer.NewPathBalancer(reg, config)
+		assert.Equal(t, []string{"server1", "server1"}, nextServiceIds(t, loadBalancer, "/path1", 2))
+	})
+}
+
+func Test_PathBalancer_RemoveStaleBalancers(t *testing.T) {
+	t.Run("SHOULD create a new load balancer for a path WHEN it left the registry and came back", func(t *testing.T) {
+		created := 0
+		balancer.RegisterStrategy("counted", func(reg registry.Registry, opts ...balancer.BalancerOpt) balancer.LoadBalancer {
+			created++
+			return &firstServiceBalancer{reg: reg}
+		})
+		reg := registry.InitInMemoryRegistry(utils.NewClock())
+		loadBalancer := balancer.NewPathBalancer(reg, balancer.StrategyConfig{Default: "counted"})
+
+		orders := &service.ServiceInfo{Path: "/orders", ServiceId: "server1", IP: "localhost", Port: "4000"}
+		assert.Nil(t, reg.RegisterService(orders))
+		nextServiceIds(t, loadBalancer, "/orders", 2)
+		assert.Equal(t, 1, created)
+
+		assert.Nil(t, reg.DeregisterService(orders.Path, orders.ServiceId))
+		assert.Nil(t, reg.RegisterService(&service.ServiceInfo{Path: "/users", ServiceId: "server2", IP: "localhost", Port: "5000"}))
+		nextServiceIds(t, loadBalancer, "/users", 1)
+		assert.Equal(t, 2, created)
+
+		assert.Nil(t, reg.RegisterService(&service.ServiceInfo{Path: "/orders", ServiceId: "server3", IP: "localhost", Port: "6000"}))
+		nextServiceIds(t, loadBalancer, "/orders", 1)
+		assert.Equal(t, 3, created)
+	})
+}
+
+func Test_StrategyConfig_Validate(t *testing.T) {
+	t.Run("SHOULD return an error WHEN a strategy is unknown", func(t *testing.T) {
+		assert.NotNil(t, balancer.StrategyConfig{Default: "random"}.Validate())
+		assert.NotNil(t, balancer.StrategyConfig{Default: balancer.RoundRobinStrategy, Paths: map[string]string{"/path1": "random"}}.Validate())
+		assert.Nil(t, balancer.StrategyConfig{Default: balancer.RoundRobinStrategy, Paths: map[string]string{"/path1": balancer.WeightedRoundRobinStrategy}}.Validate())
+	})
+}
@@ -0,0 +1,53 @@
+package balancer
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/anjolaoluwaakindipe/duller/internal/service"
+)
+
+// subsetContextKey is the key of the subset of a request in its context
+type subsetContextKey struct{}
+
+// subset is a selector limiting the services a request can be sent to, along with
+// a key identifying its load balancer
+type subset struct {
+	selector service.Selector
+	key      string
+}
+
+// WithSubset returns a copy of the context limiting a PathBalancer to the services
+// matching the selector, e.g. a pool dedicated to some tenants. Requests carrying
+// the context get no service when none of the subset is available.
+func WithSubset(ctx context.Context, selector service.Selector) context.Context {
+	if selector.IsEmpty() {
+		return ctx
+	}
+	return context.WithValue(ctx, subsetContextKey{}, subset{selector: selector, key: subsetKey(selector)})
+}
+
+// requestSubset returns the subset of the request, which is empty when it has none
+func requestSubset(r *http.Request) subset {
+	if r == nil {
+		return subset{}
+	}
+	requested, _ := r.Context().Value(subsetContextKey{}).(subset)
+	return requested
+}
+
+// subsetKey returns a key that is the same for selectors matching the same services
+func subsetKey(selector service.Selector) string {
+	tags := append([]string(nil), selector.Tags...)
+	sort.Strings(tags)
+
+	metadata := make([]string, 0, len(selector.Metadata))
+	for key, value := range selector.Metadata {
+		metadata = append(metadata, fmt.Sprintf("%q=%q", key, value))
+	}
+	sort.Strings(metadata)
+
+	return fmt.Sprintf("%q %q %q %q", selector.Version, selector.Zone, tags, metadata)
+}
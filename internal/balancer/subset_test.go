@@ -0,0 +1,50 @@
+package balancer_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/anjolaoluwaakindipe/duller/internal/balancer"
+	"github.com/anjolaoluwaakindipe/duller/internal/registry"
+	"github.com/anjolaoluwaakindipe/duller/internal/service"
+	"github.com/anjolaoluwaakindipe/duller/internal/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_PathBalancer_Subset(t *testing.T) {
+	reg := registry.InitInMemoryRegistry(utils.NewClock())
+	pb := balancer.NewPathBalancer(reg, balancer.StrategyConfig{Default: balancer.RoundRobinStrategy})
+	pb.AddService(&service.ServiceInfo{Path: "/orders", ServiceId: "shared1", IP: "localhost", Port: "4000"})
+	pb.AddService(&service.ServiceInfo{Path: "/orders", ServiceId: "shared2", IP: "localhost", Port: "4001"})
+	pb.AddService(&service.ServiceInfo{Path: "/orders", ServiceId: "enterprise1", IP: "localhost", Port: "5000", Tags: []string{"enterprise"}})
+
+	serviceIds := func(req *http.Request, count int) map[string]int {
+		ids := make(map[string]int)
+		for i := 0; i < count; i++ {
+			next, err := pb.GetServiceForRequest("/orders", req)
+			assert.Nil(t, err)
+			if next == nil {
+				ids[""]++
+				continue
+			}
+			ids[next.ServiceId]++
+		}
+		return ids
+	}
+
+	t.Run("SHOULD only pick services of the subset WHEN the request is limited to one", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/orders", nil)
+		req = req.WithContext(balancer.WithSubset(req.Context(), service.Selector{Tags: []string{"enterprise"}}))
+		assert.Equal(t, map[string]int{"enterprise1": 6}, serviceIds(req, 6))
+
+		// requests without a subset still reach every service
+		assert.Equal(t, map[string]int{"shared1": 2, "shared2": 2, "enterprise1": 2}, serviceIds(httptest.NewRequest(http.MethodGet, "/orders", nil), 6))
+	})
+
+	t.Run("SHOULD pick no service WHEN none of the subset is registered", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/orders", nil)
+		req = req.WithContext(balancer.WithSubset(req.Context(), service.Selector{Metadata: map[string]string{"pool": "mobile"}}))
+		assert.Equal(t, map[string]int{"": 3}, serviceIds(req, 3))
+	})
+}
@@ -8,13 +8,21 @@ import (
 	"github.com/invopop/validation"
 )
 
+// WeightedRoundRobin is a smooth weighted round robin like the one of nginx. Every
+// service gets a share of requests proportional to its WeightedUse and selections
+// are interleaved, so weights 5, 1 and 1 give a a b a c a a instead of five
+// requests in a row to the same service. Services warming up count with their weight
+// scaled by their slow start factor.
 type WeightedRoundRobin struct {
-	reg   registry.Registry
-	mutex sync.Mutex
+	reg    registry.Registry
+	mutex  sync.Mutex
+	config balancerConfig
+	// currentWeights holds the current weight of every service by path
+	currentWeights map[string]map[string]float64
 }
 
 func (wrb *WeightedRoundRobin) validateService(service *service.ServiceInfo) error {
-	return validation.ValidateStruct(service, validation.Field(service.WeightedUse, validation.Required, validation.Min(1)))
+	return validation.ValidateStruct(service, validation.Field(&service.WeightedUse, validation.Required, validation.Min(1)))
 }
 
 func (wrb *WeightedRoundRobin) AddService(service *service.ServiceInfo) error {
@@ -42,6 +50,10 @@ func (wrb *WeightedRoundRobin) RemoveService(service *service.ServiceInfo) error
 	return nil
 }
 
+// GetNextService adds the weight of every service to its current weight, picks the
+// service with the highest current weight and lowers it by the total weight.
+// Services joining a path start with a current weight of 0 and services leaving it
+// are forgotten, so the cycle carries on with the services that are left.
 func (wrb *WeightedRoundRobin) GetNextService(path string) (*service.ServiceInfo, error) {
 	wrb.mutex.Lock()
 	defer wrb.mutex.Unlock()
@@ -50,38 +62,43 @@ func (wrb *WeightedRoundRobin) GetNextService(path string) (*service.ServiceInfo
 		return nil, err
 	}
 
+	services = wrb.config.availableServices(path, services)
+
 	if len(services) == 0 {
 		return nil, nil
 	}
 
+	previous := wrb.currentWeights[path]
+	current := make(map[string]float64, len(services))
+
 	var selectedService *service.ServiceInfo = nil
+	total := 0.0
 
-	for i := range services {
-		service := services[i]
-		isFull, err := wrb.reg.IsServiceWeightFull(service.ServiceId)
-		if err != nil {
-			return nil, err
-		}
-		if !isFull {
-			wrb.reg.UpdateServiceCurrentUse(service.ServiceId)
-			selectedService = service
-			break
-		}
-	}
+	for _, registeredService := range services {
+		weight := float64(serviceWeight(registeredService)) * wrb.config.slowStart.Factor(registeredService)
+		total += weight
+		current[registeredService.ServiceId] = previous[registeredService.ServiceId] + weight
 
-	if selectedService == nil {
-		for i := range services {
-			service := services[i]
-			wrb.reg.ResetCurrentUse(service.ServiceId)
+		if selectedService == nil || current[registeredService.ServiceId] > current[selectedService.ServiceId] {
+			selectedService = registeredService
 		}
-		firstService := services[0]
-		selectedService = firstService
-		wrb.reg.UpdateServiceCurrentUse(firstService.ServiceId)
 	}
 
+	current[selectedService.ServiceId] -= total
+	wrb.currentWeights[path] = current
+
 	return selectedService, nil
 }
 
-func NewWeightedRoundRobinLoadBalancer(reg registry.Registry) LoadBalancer {
-	return &WeightedRoundRobin{reg: reg}
+// serviceWeight returns the weight of a service. Services registered without a
+// weight count as 1.
+func serviceWeight(registeredService *service.ServiceInfo) int {
+	if registeredService.WeightedUse < 1 {
+		return 1
+	}
+	return registeredService.WeightedUse
+}
+
+func NewWeightedRoundRobinLoadBalancer(reg registry.Registry, opts ...BalancerOpt) LoadBalancer {
+	return &WeightedRoundRobin{reg: reg, config: newBalancerConfig(opts...), currentWeights: make(map[string]map[string]float64)}
 }
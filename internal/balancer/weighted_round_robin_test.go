@@ -79,28 +79,47 @@ func Test_WeightedRoundRobin_GetNextService(t *testing.T) {
 		}
 	})
 
-	t.Run("SHOULD reset all other services except one WHEN after a weighted cycle of all the service is completed", func(t *testing.T) {
-		registry, stubServices := stubFactory()
+	t.Run("SHOULD interleave services WHEN a service has a higher weight than the others", func(t *testing.T) {
+		registry, _ := stubFactory()
 		loadBalancer := balancer.NewWeightedRoundRobinLoadBalancer(registry)
-		services := make([]*service.ServiceInfo, 0)
 
-		for i := 0; i < 10; i++ {
-			service, err := loadBalancer.GetNextService("/path1")
-			assert.Nil(t, err)
-			services = append(services, service)
-		}
+		assert.Equal(t, []string{
+			"server1", "server2", "server3", "server1", "server1",
+			"server2", "server1", "server3", "server2", "server1",
+		}, nextServiceIds(t, loadBalancer, "/path1", 10))
+	})
 
-		resetService, err := loadBalancer.GetNextService("/path1")
+	t.Run("SHOULD keep the weighted shares WHEN services join or leave mid cycle", func(t *testing.T) {
+		registry, _ := stubFactory()
+		loadBalancer := balancer.NewWeightedRoundRobinLoadBalancer(registry)
+		nextServiceIds(t, loadBalancer, "/path1", 4)
 
-		assert.Nil(t, err)
-		assert.Equal(t, 1, resetService.CurrentUse)
+		loadBalancer.AddService(&service.ServiceInfo{Path: "/path1", ServiceId: "server4", IP: "localhost", Port: "7000", WeightedUse: 5})
+		service_count := make(map[string]int)
+		for _, id := range nextServiceIds(t, loadBalancer, "/path1", 15) {
+			service_count[id] += 1
+		}
+		assert.InDelta(t, 5, service_count["server1"], 1)
+		assert.InDelta(t, 3, service_count["server2"], 1)
+		assert.InDelta(t, 2, service_count["server3"], 1)
+		assert.InDelta(t, 5, service_count["server4"], 1)
+
+		registry.DeregisterService("/path1", "server1")
+		registry.DeregisterService("/path1", "server4")
+		assert.ElementsMatch(t, []string{"server2", "server2", "server2", "server3", "server3"}, nextServiceIds(t, loadBalancer, "/path1", 5))
+	})
 
-		for _, service := range stubServices {
-			if service.ServiceId == resetService.ServiceId {
-				continue
-			}
+	t.Run("SHOULD skip services that are not UP WHEN given a valid path", func(t *testing.T) {
+		registry, stubServices := stubFactory()
+		loadBalancer := balancer.NewWeightedRoundRobinLoadBalancer(registry)
+
+		registry.OverrideServiceStatus(stubServices[0].ServiceId, service.StatusDraining)
 
-			assert.Equal(t, 0, service.CurrentUse)
+		for i := 0; i < 10; i++ {
+			service, err := loadBalancer.GetNextService("/path1")
+			assert.Nil(t, err)
+			assert.NotNil(t, service)
+			assert.NotEqual(t, stubServices[0].ServiceId, service.ServiceId)
 		}
 	})
 }
@@ -0,0 +1,79 @@
+package balancer
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/anjolaoluwaakindipe/duller/internal/registry"
+	"github.com/anjolaoluwaakindipe/duller/internal/service"
+)
+
+// ZoneHeader names the zone of the caller of a request. It wins over the zone a
+// PathBalancer is configured with.
+const ZoneHeader = "X-Duller-Zone"
+
+// zoneFilter keeps the services in the zone of the caller as long as enough of
+// them are available
+type zoneFilter struct {
+	reg  registry.Registry
+	zone string
+	// selectors are those of the filters before the zone filter, e.g. the version
+	// of a split, so only the registered services they match are counted
+	selectors []service.Selector
+	// minLocalPercent is the percentage of the services registered in the zone that
+	// must be available for requests to stay in the zone
+	minLocalPercent int
+}
+
+// Filter implements ServiceFilter. Every available service is kept when the zone
+// does not have enough available services, so requests spill over to other zones.
+func (zf zoneFilter) Filter(path string, services []*service.ServiceInfo) []*service.ServiceInfo {
+	local := make([]*service.ServiceInfo, 0, len(services))
+	for _, available := range services {
+		if strings.EqualFold(available.Zone, zf.zone) {
+			local = append(local, available)
+		}
+	}
+	if len(local) == 0 {
+		return services
+	}
+
+	registered, _ := zf.reg.GetServicesByPath(path)
+	registeredLocal := 0
+	for _, registeredService := range registered {
+		if strings.EqualFold(registeredService.Zone, zf.zone) && zf.matches(registeredService) {
+			registeredLocal++
+		}
+	}
+
+	if len(local)*100 < zf.minLocalPercent*registeredLocal {
+		return services
+	}
+	return local
+}
+
+// matches reports whether the service matches every selector of the filter
+func (zf zoneFilter) matches(registeredService *service.ServiceInfo) bool {
+	for _, selector := range zf.selectors {
+		if !selector.Matches(registeredService) {
+			return false
+		}
+	}
+	return true
+}
+
+// requestZone returns the zone the caller of the request names, else the default
+// zone. Zones no service is registered in are ignored, so callers cannot make the
+// PathBalancer create load balancers for any zone they like.
+func requestZone(r *http.Request, services []*service.ServiceInfo, defaultZone string) string {
+	if r == nil {
+		return defaultZone
+	}
+	zone := strings.TrimSpace(r.Header.Get(ZoneHeader))
+	for _, registeredService := range services {
+		if len(zone) > 0 && strings.EqualFold(registeredService.Zone, zone) {
+			return zone
+		}
+	}
+	return defaultZone
+}
@@ -0,0 +1,116 @@
+package balancer_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/anjolaoluwaakindipe/duller/internal/balancer"
+	"github.com/anjolaoluwaakindipe/duller/internal/registry"
+	"github.com/anjolaoluwaakindipe/duller/internal/service"
+	"github.com/anjolaoluwaakindipe/duller/internal/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// stubZones registers four services on /path1 in zone a and two in zone b
+func stubZones() (registry.Registry, []*service.ServiceInfo) {
+	reg := registry.InitInMemoryRegistry(utils.NewClock())
+	services := make([]*service.ServiceInfo, 0)
+	for i, zone := range []string{"a", "a", "a", "a", "b", "b"} {
+		registeredService := &service.ServiceInfo{Path: "/path1", ServiceId: "server" + strconv.Itoa(i+1), IP: "localhost", Port: strconv.Itoa(4000 + i), Zone: zone}
+		reg.RegisterService(registeredService)
+		services = append(services, registeredService)
+	}
+	return reg, services
+}
+
+// zonesOf returns the zones of the services picked for count requests
+func zonesOf(t *testing.T, loadBalancer balancer.LoadBalancer, req *http.Request, count int) map[string]int {
+	zones := make(map[string]int)
+	for i := 0; i < count; i++ {
+		next, err := balancer.GetServiceForRequest(loadBalancer, "/path1", req)
+		assert.Nil(t, err)
+		zones[next.Zone]++
+	}
+	return zones
+}
+
+func Test_PathBalancer_Zone(t *testing.T) {
+	t.Run("SHOULD only return services of the zone WHEN enough of them are available", func(t *testing.T) {
+		reg, services := stubZones()
+		reg.OverrideServiceStatus(services[0].ServiceId, service.StatusDown)
+		loadBalancer := balancer.NewPathBalancer(reg, balancer.StrategyConfig{Default: balancer.RoundRobinStrategy, Zone: "a", MinLocalPercent: 50})
+
+		assert.Equal(t, map[string]int{"a": 12}, zonesOf(t, loadBalancer, httptest.NewRequest(http.MethodGet, "/path1", nil), 12))
+	})
+
+	t.Run("SHOULD spill over to other zones WHEN too few services of the zone are available", func(t *testing.T) {
+		reg, services := stubZones()
+		reg.OverrideServiceStatus(services[0].ServiceId, service.StatusDown)
+		reg.OverrideServiceStatus(services[1].ServiceId, service.StatusDown)
+		reg.OverrideServiceStatus(services[2].ServiceId, service.StatusDown)
+		loadBalancer := balancer.NewPathBalancer(reg, balancer.StrategyConfig{Default: balancer.RoundRobinStrategy, Zone: "a", MinLocalPercent: 50})
+
+		assert.Equal(t, map[string]int{"a": 4, "b": 8}, zonesOf(t, loadBalancer, httptest.NewRequest(http.MethodGet, "/path1", nil), 12))
+
+		reg.OverrideServiceStatus(services[3].ServiceId, service.StatusDown)
+		loadBalancer = balancer.NewPathBalancer(reg, balancer.StrategyConfig{Default: balancer.RoundRobinStrategy, Zone: "a"})
+		assert.Equal(t, map[string]int{"b": 4}, zonesOf(t, loadBalancer, httptest.NewRequest(http.MethodGet, "/path1", nil), 4))
+	})
+
+	t.Run("SHOULD prefer the zone of the header WHEN a request names a known zone", func(t *testing.T) {
+		reg, _ := stubZones()
+		loadBalancer := balancer.NewPathBalancer(reg, balancer.StrategyConfig{Default: balancer.LeastConnectionsStrategy, Zone: "a", MinLocalPercent: 50})
+
+		req := httptest.NewRequest(http.MethodGet, "/path1", nil)
+		req.Header.Set(balancer.ZoneHeader, "B")
+		assert.Equal(t, map[string]int{"b": 6}, zonesOf(t, loadBalancer, req, 6))
+
+		req.Header.Set(balancer.ZoneHeader, "unknown")
+		assert.Equal(t, map[string]int{"a": 6}, zonesOf(t, loadBalancer, req, 6))
+	})
+
+	t.Run("SHOULD stay in the zone WHEN enough of the services of the subset in the zone are available", func(t *testing.T) {
+		reg, _ := stubZones()
+		reg.RegisterService(&service.ServiceInfo{Path: "/path1", ServiceId: "canary1", IP: "localhost", Port: "5000", Zone: "a", Version: "2.0.0"})
+		reg.RegisterService(&service.ServiceInfo{Path: "/path1", ServiceId: "canary2", IP: "localhost", Port: "5001", Zone: "b", Version: "2.0.0"})
+		loadBalancer := balancer.NewPathBalancer(reg, balancer.StrategyConfig{Default: balancer.RoundRobinStrategy, Zone: "a", MinLocalPercent: 50})
+
+		req := httptest.NewRequest(http.MethodGet, "/path1", nil)
+		req = req.WithContext(balancer.WithSubset(req.Context(), service.Selector{Version: "2.0.0"}))
+		assert.Equal(t, map[string]int{"a": 4}, zonesOf(t, loadBalancer, req, 4))
+	})
+
+	t.Run("SHOULD not race WHEN services send heartbeats while requests are balanced", func(t *testing.T) {
+		reg, services := stubZones()
+		loadBalancer := balancer.NewPathBalancer(reg, balancer.StrategyConfig{Default: balancer.RoundRobinStrategy, Zone: "a", MinLocalPercent: 50})
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			zones := []string{"a", "b"}
+			for i := 0; i < 200; i++ {
+				heartbeat := *services[i%len(services)]
+				heartbeat.Zone = zones[i%len(zones)]
+				heartbeat.Status = service.StatusUp
+				assert.Nil(t, reg.RegisterService(&heartbeat))
+				assert.Nil(t, reg.SetCheckStatus(heartbeat.ServiceId, service.StatusUp))
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				_, err := balancer.GetServiceForRequest(loadBalancer, "/path1", httptest.NewRequest(http.MethodGet, "/path1", nil))
+				assert.Nil(t, err)
+			}
+		}()
+		wg.Wait()
+	})
+
+	t.Run("SHOULD return an error WHEN the minimum local percentage is out of range", func(t *testing.T) {
+		assert.NotNil(t, balancer.StrategyConfig{Default: balancer.RoundRobinStrategy, MinLocalPercent: 101}.Validate())
+	})
+}
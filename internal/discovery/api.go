@@ -0,0 +1,300 @@
+package discovery
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anjolaoluwaakindipe/duller/internal/balancer"
+	"github.com/anjolaoluwaakindipe/duller/internal/service"
+	"github.com/anjolaoluwaakindipe/duller/internal/utils"
+	"github.com/gorilla/mux"
+)
+
+// instanceFilter selects the instances returned by the read api
+type instanceFilter struct {
+	statuses map[service.Status]bool
+	selector service.Selector
+}
+
+// parseInstanceFilter reads the filters of a read api request. Statuses are given
+// with repeated or comma separated "status" parameters and the other attributes
+// as described by service.ParseSelector.
+func parseInstanceFilter(r *http.Request) instanceFilter {
+	query := r.URL.Query()
+	filter := instanceFilter{statuses: make(map[service.Status]bool), selector: service.ParseSelector(query)}
+
+	for _, statuses := range query["status"] {
+		for _, status := range strings.Split(statuses, ",") {
+			if status = strings.TrimSpace(status); len(status) > 0 {
+				filter.statuses[service.Status(strings.ToUpper(status))] = true
+			}
+		}
+	}
+	return filter
+}
+
+func (f instanceFilter) matches(registeredService *service.ServiceInfo) bool {
+	if len(f.statuses) > 0 && !f.statuses[registeredService.CurrentStatus()] {
+		return false
+	}
+	return f.selector.Matches(registeredService)
+}
+
+// instances converts the services matching the filter to instance responses
+// sorted by service id, so the same registry state always gives the same ETag.
+func (f instanceFilter) instances(services []*service.ServiceInfo) []InstanceResponse {
+	instances := make([]InstanceResponse, 0, len(services))
+	for _, registeredService := range services {
+		if f.matches(registeredService) {
+			instances = append(instances, newInstanceResponse(registeredService))
+		}
+	}
+
+	sort.Slice(instances, func(i, j int) bool {
+		return instances[i].ServiceId < instances[j].ServiceId
+	})
+	return instances
+}
+
+// indexHeader holds the registry index a read api response was built at
+const indexHeader = "X-Duller-Index"
+
+// keep alive interval of event streams
+const eventKeepAlive = 15 * time.Second
+
+// parseBlockingQuery reads the "index" and "wait" parameters of a read api request.
+// The returned bool is false when no index is given and the request must not block.
+func parseBlockingQuery(r *http.Request) (uint64, time.Duration, bool, error) {
+	query := r.URL.Query()
+	if !query.Has("index") {
+		return 0, 0, false, nil
+	}
+
+	index, err := strconv.ParseUint(query.Get("index"), 10, 64)
+	if err != nil {
+		return 0, 0, false, fmt.Errorf("invalid index '%v'", query.Get("index"))
+	}
+
+	wait := defaultWatchWait
+	if query.Has("wait") {
+		if wait, err = time.ParseDuration(query.Get("wait")); err != nil || wait < 0 {
+			return 0, 0, false, fmt.Errorf("invalid wait '%v'", query.Get("wait"))
+		}
+	}
+	if wait > maxWatchWait {
+		wait = maxWatchWait
+	}
+
+	return index, wait, true, nil
+}
+
+// ListServices returns every registered path with its instances. Given an index,
+// the request blocks until any path changes past that index.
+func (rt *MuxRouter) ListServices() func(wr http.ResponseWriter, r *http.Request) {
+	return func(wr http.ResponseWriter, r *http.Request) {
+		index, wait, blocking, err := parseBlockingQuery(r)
+		if err != nil {
+			http.Error(wr, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if blocking {
+			index = rt.watcher.Wait(r.Context(), index, wait, rt.watcher.Index)
+		} else {
+			index = rt.watcher.Index()
+		}
+		wr.Header().Set(indexHeader, strconv.FormatUint(index, 10))
+
+		filter := parseInstanceFilter(r)
+
+		servicesByPath := make(map[string][]*service.ServiceInfo)
+		for _, registeredService := range rt.registry.GetServices() {
+			servicesByPath[registeredService.Path] = append(servicesByPath[registeredService.Path], registeredService)
+		}
+
+		response := make([]ServiceResponse, 0, len(servicesByPath))
+		for path, services := range servicesByPath {
+			instances := filter.instances(services)
+			if len(instances) == 0 {
+				continue
+			}
+			serviceResponse := ServiceResponse{Path: path, Instances: instances}
+			if status, exists := rt.splits.Get(path); exists {
+				serviceResponse.Split = &balancer.Split{Versions: status.Versions}
+			}
+			response = append(response, serviceResponse)
+		}
+
+		sort.Slice(response, func(i, j int) bool {
+			return response[i].Path < response[j].Path
+		})
+
+		writeWithETag(wr, r, response)
+	}
+}
+
+// ListInstances returns the instances registered on a path. Given an index, the
+// request blocks until the instance set of the path changes past that index.
+func (rt *MuxRouter) ListInstances() func(wr http.ResponseWriter, r *http.Request) {
+	return func(wr http.ResponseWriter, r *http.Request) {
+		path := mux.Vars(r)["path"]
+
+		utils.MakeUrlPathValid(&path)
+
+		index, wait, blocking, err := parseBlockingQuery(r)
+		if err != nil {
+			http.Error(wr, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if _, err := rt.servicesOfPath(path); err != nil {
+			http.Error(wr, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		pathIndex := func() uint64 { return rt.watcher.PathIndex(path) }
+		if blocking {
+			index = rt.watcher.Wait(r.Context(), index, wait, pathIndex)
+		} else {
+			index = pathIndex()
+		}
+		wr.Header().Set(indexHeader, strconv.FormatUint(index, 10))
+
+		services, err := rt.servicesOfPath(path)
+		if err != nil {
+			http.Error(wr, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		writeWithETag(wr, r, parseInstanceFilter(r).instances(services))
+	}
+}
+
+// servicesOfPath returns the services registered on exactly the path. Unlike
+// GetServicesByPath, a path nested in a registered path does not fall back to it,
+// so the instances returned are always the ones the watcher indexes the path by.
+func (rt *MuxRouter) servicesOfPath(path string) ([]*service.ServiceInfo, error) {
+	servicePath, err := rt.registry.GetPathFromRequest(path)
+	if err == nil && servicePath != path {
+		err = fmt.Errorf("path '%v' does not exist", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rt.registry.GetServicesByPath(servicePath)
+}
+
+// ListSplits returns the traffic split of every path along with the number of
+// requests every version got through /get-service. Gateways send requests to the
+// instances directly, so their traffic is not part of the counts.
+func (rt *MuxRouter) ListSplits() func(wr http.ResponseWriter, r *http.Request) {
+	return func(wr http.ResponseWriter, r *http.Request) {
+		wr.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(wr).Encode(rt.splits.All())
+	}
+}
+
+// WatchEvents streams a RegistryEvent for every change of the registry as
+// Server-Sent Events. The "path" parameter limits the stream to a single path.
+func (rt *MuxRouter) WatchEvents() func(wr http.ResponseWriter, r *http.Request) {
+	return func(wr http.ResponseWriter, r *http.Request) {
+		flusher, ok := wr.(http.Flusher)
+		if !ok {
+			http.Error(wr, "streaming is not supported", http.StatusInternalServerError)
+			return
+		}
+
+		path := r.URL.Query().Get("path")
+		if len(path) > 0 {
+			utils.MakeUrlPathValid(&path)
+		}
+
+		// event clients have no websocket connection, this handler writes their messages
+		client := NewSocketClient(rt.events, nil)
+		select {
+		case rt.events.Register() <- &client:
+		case <-r.Context().Done():
+			return
+		case <-rt.ctx.Done():
+			return
+		}
+		defer func() {
+			select {
+			case rt.events.Unregister() <- &client:
+			case <-rt.ctx.Done():
+			}
+		}()
+
+		wr.Header().Set("Content-Type", "text/event-stream")
+		wr.Header().Set("Cache-Control", "no-cache")
+		wr.Header().Set("Connection", "keep-alive")
+		wr.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		ticker := time.NewTicker(eventKeepAlive)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-rt.ctx.Done():
+				return
+			case <-ticker.C:
+				fmt.Fprint(wr, ": keep-alive\n\n")
+				flusher.Flush()
+			case message, ok := <-client.Send():
+				if !ok {
+					// the hub dropped this client for reading too slowly
+					return
+				}
+
+				var event RegistryEvent
+				if err := json.Unmarshal(message, &event); err != nil {
+					continue
+				}
+				if len(path) > 0 && event.Instance.Path != path {
+					continue
+				}
+
+				fmt.Fprintf(wr, "id: %v\nevent: %v\ndata: %s\n\n", event.Index, event.Type, message)
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+// writeWithETag writes the response as json along with an ETag computed from its
+// content. A request whose If-None-Match header holds the same ETag gets an empty
+// 304 response instead.
+func writeWithETag(wr http.ResponseWriter, r *http.Request, response interface{}) {
+	body := new(bytes.Buffer)
+	if err := json.NewEncoder(body).Encode(response); err != nil {
+		http.Error(wr, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	hash := sha256.Sum256(body.Bytes())
+	etag := `"` + hex.EncodeToString(hash[:16]) + `"`
+
+	wr.Header().Set("ETag", etag)
+	wr.Header().Set("Cache-Control", "no-cache")
+
+	for _, match := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		if strings.TrimSpace(match) == etag {
+			wr.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	wr.Header().Set("Content-Type", "application/json")
+	wr.Write(body.Bytes())
+}
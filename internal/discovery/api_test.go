@@ -0,0 +1,145 @@
+package discovery_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/anjolaoluwaakindipe/duller/internal/discovery"
+	"github.com/anjolaoluwaakindipe/duller/internal/service"
+	"github.com/stretchr/testify/assert"
+)
+
+// stubCatalog starts a discovery server with services on two paths
+func stubCatalog(t *testing.T, ctx context.Context) string {
+	server, _ := stubPeer(t, ctx, "")
+	sendHeartbeat(t, server.URL, "", discovery.HeartBeatMessage{ServiceId: "orders1", Path: "/orders", IP: "127.0.0.1", Port: "4000", Version: "1.0.0", Tags: []string{"stable"}})
+	sendHeartbeat(t, server.URL, "", discovery.HeartBeatMessage{ServiceId: "orders2", Path: "/orders", IP: "127.0.0.1", Port: "4001", Version: "1.1.0", Tags: []string{"canary"}, Metadata: map[string]string{"team": "payments"}})
+	sendHeartbeat(t, server.URL, "", discovery.HeartBeatMessage{ServiceId: "users1", Path: "/users", IP: "127.0.0.1", Port: "4002", Status: service.StatusStarting})
+	return server.URL
+}
+
+func getJson(t *testing.T, address string, etag string, response interface{}) *http.Response {
+	req, _ := http.NewRequest(http.MethodGet, address, nil)
+	if len(etag) > 0 {
+		req.Header.Set("If-None-Match", etag)
+	}
+	httpResponse, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	defer httpResponse.Body.Close()
+
+	if httpResponse.StatusCode == http.StatusOK {
+		assert.Nil(t, json.NewDecoder(httpResponse.Body).Decode(response))
+	}
+	return httpResponse
+}
+
+func instanceIds(instances []discovery.InstanceResponse) []string {
+	ids := make([]string, 0)
+	for _, instance := range instances {
+		ids = append(ids, instance.ServiceId)
+	}
+	return ids
+}
+
+func Test_MuxRouter_ListServices(t *testing.T) {
+	t.Run("SHOULD return every path with its instances WHEN no filter is given", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		address := stubCatalog(t, ctx)
+
+		var services []discovery.ServiceResponse
+		response := getJson(t, address+"/v1/services", "", &services)
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+
+		assert.Len(t, services, 2)
+		assert.Equal(t, "/orders", services[0].Path)
+		assert.Equal(t, []string{"orders1", "orders2"}, instanceIds(services[0].Instances))
+		assert.Equal(t, "/users", services[1].Path)
+		assert.Equal(t, service.StatusStarting, services[1].Instances[0].Status)
+	})
+
+	t.Run("SHOULD only return matching instances WHEN filters are given", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		address := stubCatalog(t, ctx)
+
+		var services []discovery.ServiceResponse
+		getJson(t, address+"/v1/services?status=UP&tag=canary", "", &services)
+
+		assert.Len(t, services, 1)
+		assert.Equal(t, []string{"orders2"}, instanceIds(services[0].Instances))
+	})
+}
+
+func Test_MuxRouter_ListInstances(t *testing.T) {
+	t.Run("SHOULD return the instances of a path WHEN filters are given", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		address := stubCatalog(t, ctx)
+
+		var instances []discovery.InstanceResponse
+		getJson(t, address+"/v1/services/orders/instances", "", &instances)
+		assert.Equal(t, []string{"orders1", "orders2"}, instanceIds(instances))
+
+		getJson(t, address+"/v1/services/orders/instances?version=1.0", "", &instances)
+		assert.Equal(t, []string{"orders1"}, instanceIds(instances))
+
+		getJson(t, address+"/v1/services/orders/instances?meta.team=payments", "", &instances)
+		assert.Equal(t, []string{"orders2"}, instanceIds(instances))
+
+		getJson(t, address+"/v1/services/users/instances?status=up", "", &instances)
+		assert.Empty(t, instances)
+	})
+
+	t.Run("SHOULD return the instances of a nested path WHEN it has several segments", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		address := stubCatalog(t, ctx)
+		sendHeartbeat(t, address, "", discovery.HeartBeatMessage{ServiceId: "reports1", Path: "/api/v2/reports", IP: "127.0.0.1", Port: "4003"})
+
+		var instances []discovery.InstanceResponse
+		response := getJson(t, address+"/v1/services/api/v2/reports/instances", "", &instances)
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		assert.Equal(t, []string{"reports1"}, instanceIds(instances))
+	})
+
+	t.Run("SHOULD return not found WHEN the path does not exist", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		address := stubCatalog(t, ctx)
+
+		var instances []discovery.InstanceResponse
+		response := getJson(t, address+"/v1/services/payments/instances", "", &instances)
+		assert.Equal(t, http.StatusNotFound, response.StatusCode)
+
+		// a path nested in a registered path is not registered itself
+		response = getJson(t, address+"/v1/services/orders/123/instances?index=1&wait=5s", "", &instances)
+		assert.Equal(t, http.StatusNotFound, response.StatusCode)
+	})
+
+	t.Run("SHOULD return not modified WHEN the instances did not change since the ETag", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		address := stubCatalog(t, ctx)
+
+		var instances []discovery.InstanceResponse
+		response := getJson(t, address+"/v1/services/orders/instances", "", &instances)
+		etag := response.Header.Get("ETag")
+		assert.NotEmpty(t, etag)
+
+		// a heartbeat that changes nothing keeps the ETag
+		sendHeartbeat(t, address, "", discovery.HeartBeatMessage{ServiceId: "orders1", Path: "/orders", IP: "127.0.0.1", Port: "4000", Version: "1.0.0", Tags: []string{"stable"}})
+
+		response = getJson(t, address+"/v1/services/orders/instances", etag, &instances)
+		assert.Equal(t, http.StatusNotModified, response.StatusCode)
+
+		sendHeartbeat(t, address, "", discovery.HeartBeatMessage{ServiceId: "orders3", Path: "/orders", IP: "127.0.0.1", Port: "4003"})
+
+		response = getJson(t, address+"/v1/services/orders/instances", etag, &instances)
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		assert.NotEqual(t, etag, response.Header.Get("ETag"))
+		assert.Len(t, instances, 3)
+	})
+}
@@ -4,10 +4,15 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/anjolaoluwaakindipe/duller/internal/balancer"
+	"github.com/anjolaoluwaakindipe/duller/internal/health"
+	"github.com/anjolaoluwaakindipe/duller/internal/proxy"
+	"github.com/anjolaoluwaakindipe/duller/internal/raft"
 	"github.com/anjolaoluwaakindipe/duller/internal/registry"
 	"github.com/anjolaoluwaakindipe/duller/internal/utils"
 )
@@ -21,6 +26,20 @@ type DiscCommand struct {
 	DiscoveryServicePath       string
 	DiscoveryHeartbeatPath     string
 	DiscoveryHeartbeatInterval time.Duration
+	RegistryStore              string
+	RegistryDir                string
+	RegistrySnapshotInterval   time.Duration
+	DiscoveryPeers             string
+	RaftAddress                string
+	HealthCheck                health.Config
+	OutlierDetection           health.OutlierConfig
+	Balancer                   string
+	BalancerPaths              string
+	HashKey                    string
+	ZoneMinLocalPercent        int
+	Retries                    proxy.RetryPolicy
+	Breakers                   proxy.BreakerConfig
+	SlowStart                  balancer.SlowStartConfig
 }
 
 // Name returns the name of the command
@@ -39,6 +58,40 @@ func (dc *DiscCommand) Init(args ...string) error {
 	dc.fs.StringVar(&dc.DiscoveryPort, utils.DISCOVERY_PORT_FLAG, utils.DISCOVERY_PORT, "The PORT number the discovery should run on")
 	dc.fs.StringVar(&dc.DiscoveryServicePath, utils.DISCOVERY_SERVICE_PATH_FLAG, utils.DISCOVERY_SERVICE_PATH, "Path the discoveryService will use to proxy requests to corresponding services")
 	dc.fs.DurationVar(&dc.DiscoveryHeartbeatInterval, utils.HEARTBEAT_INTERVAL_FLAG, utils.HEARTBEAT_INTERVAL, "The interval of heartbeats expected")
+	dc.fs.StringVar(&dc.RegistryStore, utils.REGISTRY_STORE_FLAG, utils.REGISTRY_STORE, "Where registrations are stored. Either 'memory', 'file' or 'raft'. A 'file' store restores all registrations after a restart. A 'raft' store replicates every write to the discovery servers in dpeers through a raft log")
+	dc.fs.StringVar(&dc.RegistryDir, utils.REGISTRY_DIR_FLAG, utils.REGISTRY_DIR, "Directory the 'file' store keeps its log and snapshot in")
+	dc.fs.DurationVar(&dc.RegistrySnapshotInterval, utils.REGISTRY_SNAPSHOT_FLAG, utils.REGISTRY_SNAPSHOT, "The interval at which the 'file' store snapshots the registry and compacts its log")
+	dc.fs.StringVar(&dc.DiscoveryPeers, utils.DISCOVERY_PEERS_FLAG, utils.DISCOVERY_PEERS, "Comma separated addresses (host:port) of peer discovery servers that registry changes are replicated to")
+	dc.fs.StringVar(&dc.RaftAddress, utils.RAFT_ADDRESS_FLAG, utils.RAFT_ADDRESS, "Address (host:port) peers reach this discovery server on when using the 'raft' store. Defaults to localhost and the discovery port")
+	dc.fs.StringVar(&dc.HealthCheck.Mode, utils.HEALTH_CHECK_MODE_FLAG, utils.HEALTH_CHECK_MODE, "How services are actively health checked. Either 'none', 'http' or 'tcp'")
+	dc.fs.StringVar(&dc.HealthCheck.Path, utils.HEALTH_CHECK_PATH_FLAG, utils.HEALTH_CHECK_PATH, "Path requested on every service by 'http' health checks")
+	dc.fs.IntVar(&dc.HealthCheck.ExpectedStatus, utils.HEALTH_CHECK_STATUS_FLAG, utils.HEALTH_CHECK_STATUS, "Status code healthy services respond with to 'http' health checks. Any 2xx status code is accepted when it is 0")
+	dc.fs.DurationVar(&dc.HealthCheck.Interval, utils.HEALTH_CHECK_INTERVAL_FLAG, utils.HEALTH_CHECK_INTERVAL, "The interval at which every service is health checked")
+	dc.fs.DurationVar(&dc.HealthCheck.Timeout, utils.HEALTH_CHECK_TIMEOUT_FLAG, utils.HEALTH_CHECK_TIMEOUT, "The time after which a health check fails")
+	dc.fs.IntVar(&dc.HealthCheck.HealthyThreshold, utils.HEALTHY_THRESHOLD_FLAG, utils.HEALTHY_THRESHOLD, "Number of consecutive successful health checks needed to mark a service healthy")
+	dc.fs.IntVar(&dc.HealthCheck.UnhealthyThreshold, utils.UNHEALTHY_THRESHOLD_FLAG, utils.UNHEALTHY_THRESHOLD, "Number of consecutive failed health checks needed to mark a service unhealthy")
+	dc.fs.IntVar(&dc.OutlierDetection.ConsecutiveFailures, utils.OUTLIER_FAILURES_FLAG, utils.OUTLIER_FAILURES, "Number of proxied requests in a row a service must fail (5xx or connection error) to be ejected from load balancing. Ejection is disabled when it is 0")
+	dc.fs.DurationVar(&dc.OutlierDetection.BaseEjectionTime, utils.OUTLIER_EJECTION_FLAG, utils.OUTLIER_EJECTION, "How long a service is ejected the first time. It doubles with every following ejection")
+	dc.fs.DurationVar(&dc.OutlierDetection.MaxEjectionTime, utils.OUTLIER_MAX_EJECTION_FLAG, utils.OUTLIER_MAX_EJECTION, "The longest time a service can be ejected for")
+	dc.fs.IntVar(&dc.OutlierDetection.MaxEjectionPercent, utils.OUTLIER_MAX_PERCENT_FLAG, utils.OUTLIER_MAX_PERCENT, "Highest percentage of the services of a path that can be ejected at once")
+	dc.fs.StringVar(&dc.Balancer, utils.BALANCER_STRATEGY_FLAG, utils.BALANCER_STRATEGY, fmt.Sprintf("Default load balancing strategy of every path. One of %v", strings.Join(balancer.Strategies(), ", ")))
+	dc.fs.StringVar(&dc.HashKey, utils.DISCOVERY_HASH_KEY_FLAG, utils.BALANCER_HASH_KEY, "Request attribute the consistent-hash strategy routes by. One of header:name, cookie:name, query:name or ip")
+	dc.fs.IntVar(&dc.ZoneMinLocalPercent, utils.DISCOVERY_ZONE_MIN_LOCAL_FLAG, utils.ZONE_MIN_LOCAL_PERCENT, "Percentage of the services of a path in the zone named by the X-Duller-Zone header of a request that must be available before it spills over to other zones.")
+	dc.fs.IntVar(&dc.Retries.Retries, utils.DISCOVERY_RETRIES_FLAG, utils.PROXY_RETRIES, "Number of times a proxied request that fails is retried on another service. Requests with an idempotent method are retried after any error, others only when the connection was refused")
+	dc.fs.DurationVar(&dc.Retries.PerTryTimeout, utils.DISCOVERY_RETRY_TIMEOUT_FLAG, utils.PROXY_RETRY_TIMEOUT, "The time a service has to answer a single attempt of a proxied request. Attempts are not bounded when it is 0")
+	dc.fs.IntVar(&dc.Retries.BudgetPercent, utils.DISCOVERY_RETRY_BUDGET_FLAG, utils.PROXY_RETRY_BUDGET, "Highest percentage of proxied requests of the last 10s that can be retries")
+	dc.fs.Int64Var(&dc.Retries.MaxBodyBytes, utils.DISCOVERY_RETRY_MAX_BODY_FLAG, utils.PROXY_RETRY_MAX_BODY, "Largest request body in bytes buffered so it can be retried. Requests with larger bodies are never retried")
+	dc.fs.IntVar(&dc.Breakers.ErrorPercent, utils.DISCOVERY_BREAKER_ERRORS_FLAG, utils.BREAKER_ERROR_PERCENT, "Percentage of the proxied requests of a path that must fail within the breaker window to open its circuit. Circuit breakers are disabled when it is 0")
+	dc.fs.DurationVar(&dc.Breakers.SlowCall, utils.DISCOVERY_BREAKER_SLOW_FLAG, utils.BREAKER_SLOW_CALL, "Latency above which a proxied request counts as failed for its circuit breaker. Latency is ignored when it is 0")
+	dc.fs.DurationVar(&dc.Breakers.Window, utils.DISCOVERY_BREAKER_WINDOW_FLAG, utils.BREAKER_WINDOW, "How far back proxied requests are counted by circuit breakers")
+	dc.fs.IntVar(&dc.Breakers.MinRequests, utils.DISCOVERY_BREAKER_MIN_FLAG, utils.BREAKER_MIN_REQUESTS, "Number of proxied requests within the breaker window needed before a circuit opens")
+	dc.fs.DurationVar(&dc.Breakers.OpenTime, utils.DISCOVERY_BREAKER_OPEN_FLAG, utils.BREAKER_OPEN_TIME, "How long an open circuit fails proxied requests before letting probes through")
+	dc.fs.IntVar(&dc.Breakers.Probes, utils.DISCOVERY_BREAKER_PROBES_FLAG, utils.BREAKER_PROBES, "Number of probe requests a half-open circuit lets through. It closes once they all succeed")
+	dc.fs.BoolVar(&dc.Breakers.PerInstance, utils.DISCOVERY_BREAKER_SERVICE_FLAG, utils.BREAKER_PER_INSTANCE, "Give every service its own circuit breaker as well as its path. Proxied requests skip services whose circuit is open")
+	dc.fs.DurationVar(&dc.SlowStart.Window, utils.DISCOVERY_SLOW_START_FLAG, utils.SLOW_START_WINDOW, "How long a newly registered service takes to get its full share of proxied requests. Slow start is disabled when it is 0")
+	dc.fs.Float64Var(&dc.SlowStart.Aggression, utils.DISCOVERY_SLOW_START_CURVE_FLAG, utils.SLOW_START_AGGRESSION, "Shape of the slow start ramp. 1 is linear and higher values give new services more requests early on")
+	dc.fs.IntVar(&dc.SlowStart.MinPercent, utils.DISCOVERY_SLOW_START_MIN_FLAG, utils.SLOW_START_MIN_PERCENT, "Percentage of its full share of proxied requests a service gets as soon as it registers")
+	dc.fs.StringVar(&dc.BalancerPaths, utils.BALANCER_PATHS_FLAG, utils.BALANCER_PATHS, "Comma separated path=strategy pairs overriding the load balancing strategy of a path, including the one its services ask for - e.g. /orders=weighted-round-robin")
 	return dc.fs.Parse(args)
 }
 
@@ -47,13 +100,130 @@ func (dc *DiscCommand) UsageInfo() {
 	dc.fs.Usage()
 }
 
+// initRegistry creates the registry selected by the RegistryStore flag
+func (dc *DiscCommand) initRegistry(ctx context.Context) (registry.Registry, error) {
+	switch dc.RegistryStore {
+	case "memory":
+		return registry.InitInMemoryRegistry(utils.NewClock()), nil
+	case "file":
+		fileRegistry, err := registry.InitFileRegistry(utils.NewClock(), dc.RegistryDir)
+		if err != nil {
+			return nil, err
+		}
+		go fileRegistry.RunSnapshots(dc.RegistrySnapshotInterval, ctx)
+		return fileRegistry, nil
+	case "raft":
+		storage, err := raft.NewFileStorage(dc.RegistryDir)
+		if err != nil {
+			return nil, err
+		}
+		raftRegistry, err := registry.InitRaftRegistry(utils.NewClock(), raft.Config{
+			ID:              dc.raftAddress(),
+			Peers:           dc.peers(),
+			ElectionTimeout: utils.RAFT_ELECTION_TIMEOUT,
+			Key:             dc.DiscoveryKey,
+		}, storage)
+		if err != nil {
+			return nil, err
+		}
+		go raftRegistry.Run(ctx)
+		return raftRegistry, nil
+	default:
+		return nil, fmt.Errorf("Unknown registry store '%v'", dc.RegistryStore)
+	}
+}
+
+func (dc *DiscCommand) raftAddress() string {
+	if len(dc.RaftAddress) > 0 {
+		return dc.RaftAddress
+	}
+	return "localhost:" + dc.DiscoveryPort
+}
+
+func (dc *DiscCommand) peers() []string {
+	peers := make([]string, 0)
+	for _, peer := range strings.Split(dc.DiscoveryPeers, ",") {
+		if peer = strings.TrimSpace(peer); len(peer) > 0 {
+			peers = append(peers, peer)
+		}
+	}
+	return peers
+}
+
+// strategyConfig builds the load balancing strategies selected by the Balancer and
+// BalancerPaths flags
+func (dc *DiscCommand) strategyConfig() (balancer.StrategyConfig, error) {
+	config := balancer.StrategyConfig{Default: dc.Balancer, Paths: make(map[string]string), MinLocalPercent: dc.ZoneMinLocalPercent}
+	for _, pair := range strings.Split(dc.BalancerPaths, ",") {
+		if pair = strings.TrimSpace(pair); len(pair) == 0 {
+			continue
+		}
+		path, strategy, ok := strings.Cut(pair, "=")
+		if !ok {
+			return config, fmt.Errorf("invalid balancer path '%v', expected path=strategy", pair)
+		}
+		config.Paths[strings.TrimSpace(path)] = strings.TrimSpace(strategy)
+	}
+	return config, config.Validate()
+}
+
 func (dc *DiscCommand) Run() error {
-	serviceRegistry := registry.InitInMemoryRegistry(utils.NewClock())
-	loadBalancer := balancer.NewRoundRobinLoadBalancer(serviceRegistry)
+	strategies, err := dc.strategyConfig()
+	if err != nil {
+		return err
+	}
+
+	hashKey, err := balancer.ParseHashKey(dc.HashKey)
+	if err != nil {
+		return err
+	}
+
+	dc.Retries.MinRetriesPerSecond = utils.PROXY_RETRY_MIN
+	if err := dc.Retries.Validate(); err != nil {
+		return err
+	}
+
+	if err := dc.Breakers.Validate(); err != nil {
+		return err
+	}
+
+	if err := dc.SlowStart.Validate(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	serviceRegistry, err := dc.initRegistry(ctx)
+	if err != nil {
+		return err
+	}
+
+	if closer, ok := serviceRegistry.(io.Closer); ok {
+		defer closer.Close()
+	}
 
-	ctx := context.TODO()
+	go serviceRegistry.RefreshRegistry(dc.DiscoveryHeartbeatInterval, ctx)
+
+	outliers := health.NewOutlierDetector(serviceRegistry, utils.NewClock(), dc.OutlierDetection)
+	splits := balancer.NewSplits()
+	loadBalancer := balancer.NewPathBalancer(serviceRegistry, strategies, balancer.WithServiceFilter(outliers), balancer.WithHashKey(hashKey),
+		balancer.WithSlowStart(balancer.NewSlowStart(utils.NewClock(), dc.SlowStart)), balancer.WithSplits(splits))
+
+	checker := health.NewChecker(serviceRegistry, dc.HealthCheck)
+	go checker.Run(ctx)
+
+	opts := []MuxRouterOpt{WithSecretKey(dc.DiscoveryKey), WithHealthChecker(checker), WithOutlierDetector(outliers), WithRetryPolicy(dc.Retries),
+		WithBreakers(proxy.NewBreakers(utils.NewClock(), dc.Breakers)), WithSplits(splits)}
+
+	if raftRegistry, ok := serviceRegistry.(*registry.RaftRegistry); ok {
+		// the raft log already replicates every change to the peers
+		opts = append(opts, WithHandler("/raft/", raftRegistry.Handler()))
+	} else {
+		opts = append(opts, WithReplicator(NewPeerReplicator(dc.peers(), dc.DiscoveryKey)))
+	}
 
-	router, err := NewMuxRouter(loadBalancer, serviceRegistry, ctx, WithSecretKey(dc.DiscoveryKey))
+	router, err := NewMuxRouter(loadBalancer, serviceRegistry, ctx, opts...)
 	if err != nil {
 		return err
 	}
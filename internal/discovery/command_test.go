@@ -21,6 +21,22 @@ func Test_Discommand_Init(t *testing.T) {
 		assert.Equal(t, utils.DISCOVERY_PORT, command.DiscoveryPort)
 		assert.Equal(t, utils.DISCOVERY_SERVICE_PATH, command.DiscoveryServicePath)
 		assert.Equal(t, utils.HEARTBEAT_INTERVAL, command.DiscoveryHeartbeatInterval)
+		assert.Equal(t, utils.REGISTRY_STORE, command.RegistryStore)
+		assert.Equal(t, utils.REGISTRY_DIR, command.RegistryDir)
+		assert.Equal(t, utils.REGISTRY_SNAPSHOT, command.RegistrySnapshotInterval)
+		assert.Equal(t, utils.DISCOVERY_PEERS, command.DiscoveryPeers)
+		assert.Equal(t, utils.RAFT_ADDRESS, command.RaftAddress)
+		assert.Equal(t, utils.HEALTH_CHECK_MODE, command.HealthCheck.Mode)
+		assert.Equal(t, utils.HEALTH_CHECK_PATH, command.HealthCheck.Path)
+		assert.Equal(t, utils.HEALTH_CHECK_STATUS, command.HealthCheck.ExpectedStatus)
+		assert.Equal(t, utils.HEALTH_CHECK_INTERVAL, command.HealthCheck.Interval)
+		assert.Equal(t, utils.HEALTH_CHECK_TIMEOUT, command.HealthCheck.Timeout)
+		assert.Equal(t, utils.HEALTHY_THRESHOLD, command.HealthCheck.HealthyThreshold)
+		assert.Equal(t, utils.UNHEALTHY_THRESHOLD, command.HealthCheck.UnhealthyThreshold)
+		assert.Equal(t, utils.OUTLIER_FAILURES, command.OutlierDetection.ConsecutiveFailures)
+		assert.Equal(t, utils.OUTLIER_EJECTION, command.OutlierDetection.BaseEjectionTime)
+		assert.Equal(t, utils.OUTLIER_MAX_EJECTION, command.OutlierDetection.MaxEjectionTime)
+		assert.Equal(t, utils.OUTLIER_MAX_PERCENT, command.OutlierDetection.MaxEjectionPercent)
 	})
 
 	t.Run("Test that arguement values are used when flags are passed", func(t *testing.T) {
@@ -30,6 +46,15 @@ func Test_Discommand_Init(t *testing.T) {
 			utils.DISCOVERY_PORT_FLAG:         "9999",
 			utils.DISCOVERY_SERVICE_PATH_FLAG: "/new-service-path",
 			utils.HEARTBEAT_INTERVAL_FLAG:     heartbeat.String(),
+			utils.REGISTRY_STORE_FLAG:         "file",
+			utils.REGISTRY_DIR_FLAG:           "/tmp/duller",
+			utils.REGISTRY_SNAPSHOT_FLAG:      heartbeat.String(),
+			utils.DISCOVERY_PEERS_FLAG:        "localhost:9877,localhost:9878",
+			utils.RAFT_ADDRESS_FLAG:           "localhost:9999",
+			utils.HEALTH_CHECK_MODE_FLAG:      "http",
+			utils.HEALTH_CHECK_PATH_FLAG:      "/ping",
+			utils.HEALTH_CHECK_STATUS_FLAG:    "204",
+			utils.OUTLIER_FAILURES_FLAG:       "3",
 		}
 
 		args := make([]string, 0)
@@ -48,5 +73,14 @@ func Test_Discommand_Init(t *testing.T) {
 		assert.Equal(t, argMap[utils.DISCOVERY_PORT_FLAG], command.DiscoveryPort)
 		assert.Equal(t, argMap[utils.DISCOVERY_SERVICE_PATH_FLAG], command.DiscoveryServicePath)
 		assert.Equal(t, heartbeat, command.DiscoveryHeartbeatInterval)
+		assert.Equal(t, argMap[utils.REGISTRY_STORE_FLAG], command.RegistryStore)
+		assert.Equal(t, argMap[utils.REGISTRY_DIR_FLAG], command.RegistryDir)
+		assert.Equal(t, heartbeat, command.RegistrySnapshotInterval)
+		assert.Equal(t, argMap[utils.DISCOVERY_PEERS_FLAG], command.DiscoveryPeers)
+		assert.Equal(t, argMap[utils.RAFT_ADDRESS_FLAG], command.RaftAddress)
+		assert.Equal(t, argMap[utils.HEALTH_CHECK_MODE_FLAG], command.HealthCheck.Mode)
+		assert.Equal(t, argMap[utils.HEALTH_CHECK_PATH_FLAG], command.HealthCheck.Path)
+		assert.Equal(t, 204, command.HealthCheck.ExpectedStatus)
+		assert.Equal(t, 3, command.OutlierDetection.ConsecutiveFailures)
 	})
 }
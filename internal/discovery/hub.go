@@ -49,6 +49,10 @@ func (h *InMemoryHub) Unregister() chan *SocketClient {
 }
 
 func (h *InMemoryHub) removeClient(client *SocketClient) {
+	// a client dropped for being too slow still unregisters itself afterwards
+	if _, exists := h.SocketClients[client]; !exists {
+		return
+	}
 	delete(h.SocketClients, client)
 	close(client.send)
 }
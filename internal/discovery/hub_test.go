@@ -18,8 +18,8 @@ func Test_Hub_Register(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	client := discovery.NewSocketClient(hub, nil)
 	var wg sync.WaitGroup
+	wg.Add(1)
 	go func(ctx context.Context, wg *sync.WaitGroup) {
-		wg.Add(1)
 		hub.Run(ctx)
 		wg.Done()
 	}(ctx, &wg)
@@ -40,8 +40,8 @@ func Test_Hub_UnRegister(t *testing.T) {
 	client2 := discovery.NewSocketClient(hub, nil)
 
 	var wg sync.WaitGroup
+	wg.Add(1)
 	go func(ctx context.Context, wg *sync.WaitGroup) {
-		wg.Add(1)
 		hub.Run(ctx)
 		wg.Done()
 	}(ctx1, &wg)
@@ -56,8 +56,8 @@ func Test_Hub_UnRegister(t *testing.T) {
 	assert.Equal(t, 2, len(inMemoryHub.SocketClients))
 
 	ctx2, cancel := context.WithCancel(context.Background())
+	wg.Add(1)
 	go func(ctx context.Context, wg *sync.WaitGroup) {
-		wg.Add(1)
 		hub.Run(ctx)
 		wg.Done()
 	}(ctx2, &wg)
@@ -79,8 +79,8 @@ func Test_Hub_Broadcaster(t *testing.T) {
 	var wg1 sync.WaitGroup
 	var wg2 sync.WaitGroup
 
+	wg1.Add(1)
 	go func(ctx context.Context, wg *sync.WaitGroup) {
-		wg.Add(1)
 		hub.Run(ctx)
 		wg.Done()
 	}(ctx1, &wg1)
@@ -1,5 +1,12 @@
 package discovery
 
+import (
+	"time"
+
+	"github.com/anjolaoluwaakindipe/duller/internal/balancer"
+	"github.com/anjolaoluwaakindipe/duller/internal/service"
+)
+
 type Message struct {
 	Type string
 	Data interface{}
@@ -15,6 +22,44 @@ type HeartBeatMessage struct {
 	Path      string `json:"path"`
 	IP        string `json:"ip"`
 	Port      string `json:"port"`
+	// Status is the lifecycle status of the service. UP is assumed when it is empty.
+	Status service.Status `json:"status,omitempty"`
+	// Metadata holds free-form key/value pairs describing the service
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Tags     []string          `json:"tags,omitempty"`
+	// Version is the semantic version of the service
+	Version string `json:"version,omitempty"`
+	Zone    string `json:"zone,omitempty"`
+	// Weight is the share of requests the service gets from weighted strategies. 1 is
+	// assumed when it is 0.
+	Weight int `json:"weight,omitempty"`
+	// Strategy is the load balancing strategy the service asks for on its path
+	Strategy string `json:"strategy,omitempty"`
+}
+
+// types of registry changes replicated between discovery servers
+const (
+	registerReplication   = "register"
+	deregisterReplication = "deregister"
+	overrideReplication   = "override"
+	splitReplication      = "split"
+)
+
+// ReplicationMessage is sent to peer discovery servers whenever a service
+// is registered or deregistered
+type ReplicationMessage struct {
+	Type    string           `json:"type"`
+	Service HeartBeatMessage `json:"service"`
+	// OverriddenStatus is the status set by an operator in override messages
+	OverriddenStatus service.Status `json:"overriddenStatus,omitempty"`
+	// Split is the traffic split set on the path of split messages. The split of the
+	// path is removed when it is nil.
+	Split *balancer.Split `json:"split,omitempty"`
+}
+
+// StatusMessage is sent by operators to override the status of a service
+type StatusMessage struct {
+	Status service.Status `json:"status"`
 }
 
 type GetServiceMessage struct {
@@ -32,3 +77,47 @@ type GetServiceResponse struct {
 	Port      string
 	ServiceId string
 }
+
+// InstanceResponse describes a registered instance in the read api. It leaves out
+// the last heartbeat so its ETag only changes when the instance does.
+type InstanceResponse struct {
+	ServiceId   string            `json:"serviceId"`
+	Path        string            `json:"path"`
+	IP          string            `json:"ip"`
+	Port        string            `json:"port"`
+	Status      service.Status    `json:"status"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
+	Version     string            `json:"version,omitempty"`
+	Zone        string            `json:"zone,omitempty"`
+	Strategy    string            `json:"strategy,omitempty"`
+	WeightedUse int               `json:"weightedUse,omitempty"`
+	// RegisteredAt is when the discovery server first saw the instance, which slow
+	// start ramps its share of requests from
+	RegisteredAt time.Time `json:"registeredAt"`
+}
+
+func newInstanceResponse(registeredService *service.ServiceInfo) InstanceResponse {
+	return InstanceResponse{
+		ServiceId:    registeredService.ServiceId,
+		Path:         registeredService.Path,
+		IP:           registeredService.IP,
+		Port:         registeredService.Port,
+		Status:       registeredService.CurrentStatus(),
+		Metadata:     registeredService.Metadata,
+		Tags:         registeredService.Tags,
+		Version:      registeredService.Version,
+		Zone:         registeredService.Zone,
+		Strategy:     registeredService.Strategy,
+		RegisteredAt: registeredService.RegisteredAt,
+		WeightedUse:  registeredService.WeightedUse,
+	}
+}
+
+// ServiceResponse describes a path and its instances in the read api
+type ServiceResponse struct {
+	Path      string             `json:"path"`
+	Instances []InstanceResponse `json:"instances"`
+	// Split is the traffic split of the path between versions of its instances
+	Split *balancer.Split `json:"split,omitempty"`
+}
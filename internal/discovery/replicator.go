@@ -0,0 +1,117 @@
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Replicator sends registry changes to the other discovery servers of a cluster
+// so that a heartbeat received by any server is visible on all of them.
+type Replicator interface {
+	Run(context.Context)
+	// Replicate queues a registry change to be sent to every peer. It never blocks.
+	Replicate(message ReplicationMessage)
+}
+
+// PeerReplicator is an implementation of Replicator that forwards registry changes
+// to every peer over http. Each peer has its own queue so a slow or unreachable
+// peer does not hold up replication to the others.
+type PeerReplicator struct {
+	peers  []string
+	key    string
+	client *http.Client
+	queues []chan ReplicationMessage
+}
+
+// NewPeerReplicator instantiates a PeerReplicator for the given peer addresses
+// (host:port). The key is the discovery key shared by every server in the cluster.
+func NewPeerReplicator(peers []string, key string) Replicator {
+	replicator := &PeerReplicator{
+		peers:  make([]string, 0),
+		key:    key,
+		client: &http.Client{Timeout: 5 * time.Second},
+		queues: make([]chan ReplicationMessage, 0),
+	}
+
+	for _, peer := range peers {
+		peer = strings.TrimSpace(peer)
+		if len(peer) == 0 {
+			continue
+		}
+		if !strings.HasPrefix(peer, "http://") && !strings.HasPrefix(peer, "https://") {
+			peer = "http://" + peer
+		}
+		replicator.peers = append(replicator.peers, strings.TrimSuffix(peer, "/"))
+		replicator.queues = append(replicator.queues, make(chan ReplicationMessage, 1024))
+	}
+
+	return replicator
+}
+
+// Replicate implements Replicator.
+func (pr *PeerReplicator) Replicate(message ReplicationMessage) {
+	for i, queue := range pr.queues {
+		select {
+		case queue <- message:
+		default:
+			slog.Warn(fmt.Sprintf("Replication queue for peer %v is full, dropping %v of service %v", pr.peers[i], message.Type, message.Service.ServiceId))
+		}
+	}
+}
+
+// Run implements Replicator.
+func (pr *PeerReplicator) Run(ctx context.Context) {
+	for i := range pr.peers {
+		go pr.runPeer(ctx, pr.peers[i], pr.queues[i])
+	}
+	<-ctx.Done()
+}
+
+func (pr *PeerReplicator) runPeer(ctx context.Context, peer string, queue chan ReplicationMessage) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case message := <-queue:
+			if err := pr.send(ctx, peer, message); err != nil {
+				slog.Warn(fmt.Sprintf("Could not replicate %v of service %v to peer %v: %v", message.Type, message.Service.ServiceId, peer, err))
+			}
+		}
+	}
+}
+
+func (pr *PeerReplicator) send(ctx context.Context, peer string, message ReplicationMessage) error {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, peer+"/replicate", bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(pr.key) > 0 {
+		req.Header.Set("Authorization", "Bearer "+pr.key)
+	}
+
+	response, err := pr.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= http.StatusBadRequest {
+		responseBody, _ := io.ReadAll(response.Body)
+		return fmt.Errorf("status code %v: %v", response.StatusCode, strings.TrimSpace(string(responseBody)))
+	}
+
+	return nil
+}
@@ -0,0 +1,129 @@
+package discovery_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anjolaoluwaakindipe/duller/internal/balancer"
+	"github.com/anjolaoluwaakindipe/duller/internal/discovery"
+	"github.com/anjolaoluwaakindipe/duller/internal/registry"
+	"github.com/anjolaoluwaakindipe/duller/internal/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// stubPeer starts a discovery server whose registry changes are replicated to the given peers
+func stubPeer(t *testing.T, ctx context.Context, key string, peers ...string) (*httptest.Server, registry.Registry) {
+	reg := registry.InitInMemoryRegistry(utils.NewClock())
+	router, err := discovery.NewMuxRouter(balancer.NewRoundRobinLoadBalancer(reg), reg, ctx,
+		discovery.WithSecretKey(key),
+		discovery.WithReplicator(discovery.NewPeerReplicator(peers, key)),
+	)
+	assert.Nil(t, err)
+
+	server := httptest.NewServer(router.SetupRoutes())
+	t.Cleanup(server.Close)
+	return server, reg
+}
+
+func sendHeartbeat(t *testing.T, address string, key string, message discovery.HeartBeatMessage) *http.Response {
+	body, _ := json.Marshal(message)
+	req, _ := http.NewRequest(http.MethodPost, address+"/heartbeat", bytes.NewBuffer(body))
+	req.Header.Set("Authorization", "Bearer "+key)
+	response, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	return response
+}
+
+func Test_PeerReplicator_Replicate(t *testing.T) {
+	t.Run("SHOULD register a service on every peer WHEN a heartbeat is sent to one discovery server", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		peer, peerRegistry := stubPeer(t, ctx, "")
+		server, serverRegistry := stubPeer(t, ctx, "", strings.TrimPrefix(peer.URL, "http://"))
+
+		response := sendHeartbeat(t, server.URL, "", discovery.HeartBeatMessage{ServiceId: "server1", Path: "/orders", IP: "127.0.0.1", Port: "4000"})
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+
+		_, err := serverRegistry.GetServiceById("server1")
+		assert.Nil(t, err)
+
+		assert.Eventually(t, func() bool {
+			replicated, err := peerRegistry.GetServiceById("server1")
+			return err == nil && replicated.IP == "127.0.0.1" && replicated.Port == "4000"
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("SHOULD deregister a service on the peer WHEN a deregistration is replicated", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		peer, peerRegistry := stubPeer(t, ctx, "")
+		replicator := discovery.NewPeerReplicator([]string{peer.URL}, "")
+		go replicator.Run(ctx)
+
+		service := discovery.HeartBeatMessage{ServiceId: "server1", Path: "/orders", IP: "127.0.0.1", Port: "4000"}
+		replicator.Replicate(discovery.ReplicationMessage{Type: "register", Service: service})
+		assert.Eventually(t, func() bool {
+			_, err := peerRegistry.GetServiceById("server1")
+			return err == nil
+		}, 5*time.Second, 10*time.Millisecond)
+
+		replicator.Replicate(discovery.ReplicationMessage{Type: "deregister", Service: service})
+
+		// the path is gone along with its last service
+		assert.Eventually(t, func() bool {
+			_, err := peerRegistry.GetServicesByPath("/orders")
+			return err != nil
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("SHOULD keep a service on every peer WHEN a heartbeat with a wrong key is sent", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		peer, peerRegistry := stubPeer(t, ctx, "secret")
+		server, serverRegistry := stubPeer(t, ctx, "secret", strings.TrimPrefix(peer.URL, "http://"))
+		service := discovery.HeartBeatMessage{ServiceId: "server1", Path: "/orders", IP: "127.0.0.1", Port: "4000"}
+		sendHeartbeat(t, server.URL, "secret", service)
+		assert.Eventually(t, func() bool {
+			_, err := peerRegistry.GetServiceById("server1")
+			return err == nil
+		}, 5*time.Second, 10*time.Millisecond)
+
+		response := sendHeartbeat(t, server.URL, "wrong", service)
+		assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+
+		// a registration sent after the heartbeat reaches the peer after it too
+		sendHeartbeat(t, server.URL, "secret", discovery.HeartBeatMessage{ServiceId: "server2", Path: "/orders", IP: "127.0.0.1", Port: "4001"})
+		assert.Eventually(t, func() bool {
+			_, err := peerRegistry.GetServiceById("server2")
+			return err == nil
+		}, 5*time.Second, 10*time.Millisecond)
+
+		_, err := serverRegistry.GetServiceById("server1")
+		assert.Nil(t, err)
+		_, err = peerRegistry.GetServiceById("server1")
+		assert.Nil(t, err)
+	})
+
+	t.Run("SHOULD reject replication WHEN the peer uses a different key", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		peer, _ := stubPeer(t, ctx, "secret")
+
+		body, _ := json.Marshal(discovery.ReplicationMessage{Type: "register", Service: discovery.HeartBeatMessage{ServiceId: "server1", Path: "/orders", IP: "127.0.0.1", Port: "4000"}})
+		req, _ := http.NewRequest(http.MethodPost, peer.URL+"/replicate", bytes.NewBuffer(body))
+		req.Header.Set("Authorization", "Bearer wrong")
+		response, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+	})
+}
@@ -11,6 +11,8 @@ import (
 	"time"
 
 	"github.com/anjolaoluwaakindipe/duller/internal/balancer"
+	"github.com/anjolaoluwaakindipe/duller/internal/health"
+	"github.com/anjolaoluwaakindipe/duller/internal/proxy"
 	"github.com/anjolaoluwaakindipe/duller/internal/registry"
 	"github.com/anjolaoluwaakindipe/duller/internal/service"
 	"github.com/anjolaoluwaakindipe/duller/internal/tmpl"
@@ -27,17 +29,30 @@ type MuxRouter struct {
 	hashSecretKey string
 	ctx           context.Context
 	hub           Hub
+	replicator    Replicator
+	// events broadcasts a json RegistryEvent for every registry change
+	events  Hub
+	watcher *Watcher
+	// outliers records the result of every proxied request
+	outliers *health.OutlierDetector
+	// retries sets how proxied requests that fail are retried on other services
+	retries   proxy.RetryPolicy
+	breakers  *proxy.Breakers
+	forwarder *proxy.Forwarder
+	// splits divides the requests of paths between versions of their services
+	splits *balancer.Splits
+	// handlers are extra handlers mounted on a path prefix
+	handlers map[string]http.Handler
 }
 
-func (rt *MuxRouter) isHeartbeatAuthorized(serviceId string, path string, key string) error {
-	err := bcrypt.CompareHashAndPassword([]byte(rt.hashSecretKey), []byte(key))
-
+// isKeyAuthorized checks the key sent by a peer discovery server or an operator.
+// Peers of a cluster must all be started with the same discovery key.
+func (rt *MuxRouter) isKeyAuthorized(key string) error {
 	if len(rt.hashSecretKey) == 0 {
 		return nil
 	}
 
-	if err != nil {
-		rt.registry.DeregisterService(path, serviceId)
+	if err := bcrypt.CompareHashAndPassword([]byte(rt.hashSecretKey), []byte(key)); err != nil {
 		return fmt.Errorf("Unauthorized Request")
 	}
 	return nil
@@ -66,54 +81,348 @@ func (rt *MuxRouter) SendHeartBeat() func(wr http.ResponseWriter, r *http.Reques
 
 		err := json.NewDecoder(r.Body).Decode(&message)
 		if err != nil {
-			wr.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(wr).Encode(err)
+			http.Error(wr, err.Error(), http.StatusBadRequest)
+			return
 		}
 
 		secret := rt.getAuthToken(r)
 
-		if err := rt.isHeartbeatAuthorized(message.ServiceId, message.Path, secret); err != nil {
+		// a heartbeat with a wrong key changes nothing, so it cannot be used to remove
+		// services it does not own
+		if err := rt.isKeyAuthorized(secret); err != nil {
+			http.Error(wr, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		if err := rt.registerService(message); err != nil {
 			http.Error(wr, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		newService := &service.ServiceInfo{
-			ServiceId: message.ServiceId,
-			Path:      message.Path,
-			Port:      message.Port,
-			IP:        message.Port,
+		rt.replicator.Replicate(ReplicationMessage{Type: registerReplication, Service: message})
+
+		if err := rt.broadcastServices(); err != nil {
+			http.Error(wr, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+// Replicate applies a registry change sent by a peer discovery server. Changes received
+// here are not replicated again, which prevents them from looping around the cluster.
+func (rt *MuxRouter) Replicate() func(wr http.ResponseWriter, r *http.Request) {
+	return func(wr http.ResponseWriter, r *http.Request) {
+		if err := rt.isKeyAuthorized(rt.getAuthToken(r)); err != nil {
+			http.Error(wr, err.Error(), http.StatusUnauthorized)
+			return
 		}
 
-		if err := rt.balancer.AddService(newService); err != nil {
+		var message ReplicationMessage
+
+		if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
 			http.Error(wr, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		updatedServices := rt.registry.GetServices()
+		switch message.Type {
+		case registerReplication:
+			if err := rt.registerService(message.Service); err != nil {
+				http.Error(wr, err.Error(), http.StatusBadRequest)
+				return
+			}
+		case deregisterReplication:
+			utils.MakeUrlPathValid(&message.Service.Path)
+			if err := rt.registry.DeregisterService(message.Service.Path, message.Service.ServiceId); err != nil {
+				http.Error(wr, err.Error(), http.StatusNotFound)
+				return
+			}
+		case overrideReplication:
+			if err := rt.registry.OverrideServiceStatus(message.Service.ServiceId, message.OverriddenStatus); err != nil {
+				http.Error(wr, err.Error(), http.StatusNotFound)
+				return
+			}
+		case splitReplication:
+			utils.MakeUrlPathValid(&message.Service.Path)
+			if message.Split != nil {
+				if err := message.Split.Validate(); err != nil {
+					http.Error(wr, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+			if err := rt.applySplit(message.Service.Path, message.Split); err != nil {
+				http.Error(wr, err.Error(), http.StatusNotFound)
+				return
+			}
+		default:
+			http.Error(wr, fmt.Sprintf("unknown replication type '%v'", message.Type), http.StatusBadRequest)
+			return
+		}
+
+		if err := rt.broadcastServices(); err != nil {
+			http.Error(wr, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+// registerService adds the service described by a heartbeat to the registry
+// through the load balancer.
+func (rt *MuxRouter) registerService(message HeartBeatMessage) error {
+	newService := &service.ServiceInfo{
+		ServiceId:   message.ServiceId,
+		Path:        message.Path,
+		Port:        message.Port,
+		IP:          message.IP,
+		Status:      message.Status,
+		Metadata:    message.Metadata,
+		Tags:        message.Tags,
+		Version:     message.Version,
+		Zone:        message.Zone,
+		Strategy:    message.Strategy,
+		WeightedUse: message.Weight,
+	}
+
+	if len(newService.Status) == 0 {
+		newService.Status = service.StatusUp
+	}
+
+	if newService.WeightedUse == 0 {
+		newService.WeightedUse = 1
+	}
+
+	if len(newService.Strategy) > 0 && !balancer.IsStrategy(newService.Strategy) {
+		return fmt.Errorf("unknown load balancing strategy '%v', expected one of %v", newService.Strategy, balancer.Strategies())
+	}
+
+	return rt.balancer.AddService(newService)
+}
+
+// DeregisterService removes a service from the registry, which services do when they
+// shut down so they stop receiving requests before their registration expires.
+// The path may be nested and is every segment before the id of the service.
+func (rt *MuxRouter) DeregisterService() func(wr http.ResponseWriter, r *http.Request) {
+	return func(wr http.ResponseWriter, r *http.Request) {
+		if err := rt.isKeyAuthorized(rt.getAuthToken(r)); err != nil {
+			http.Error(wr, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		params := mux.Vars(r)
+		path := params["path"]
+		serviceId := params["serviceId"]
+
+		utils.MakeUrlPathValid(&path)
+
+		if err := rt.registry.DeregisterService(path, serviceId); err != nil {
+			http.Error(wr, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		rt.replicator.Replicate(ReplicationMessage{Type: deregisterReplication, Service: HeartBeatMessage{ServiceId: serviceId, Path: path}})
+
+		if err := rt.broadcastServices(); err != nil {
+			http.Error(wr, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+// OverrideStatus lets operators set the status of a service, which then wins over
+// the status the service reports in its heartbeats. A DELETE request removes the
+// override.
+func (rt *MuxRouter) OverrideStatus() func(wr http.ResponseWriter, r *http.Request) {
+	return func(wr http.ResponseWriter, r *http.Request) {
+		if err := rt.isKeyAuthorized(rt.getAuthToken(r)); err != nil {
+			http.Error(wr, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		serviceId := mux.Vars(r)["serviceId"]
 
-		listComponent := make([]tmpl.Service, 0)
+		var message StatusMessage
+		if r.Method != http.MethodDelete {
+			if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
+				http.Error(wr, err.Error(), http.StatusBadRequest)
+				return
+			}
 
-		for _, updatedService := range updatedServices {
-			listComponent = append(listComponent,
-				tmpl.Service{
-					Port:      updatedService.Port,
-					Path:      updatedService.Path,
-					ServiceId: updatedService.ServiceId,
-					IP:        updatedService.IP,
-					IsHealthy: updatedService.IsHealthy,
-				})
+			if !message.Status.IsValid() {
+				http.Error(wr, fmt.Sprintf("unknown status '%v'", message.Status), http.StatusBadRequest)
+				return
+			}
 		}
 
-		buffer := new(bytes.Buffer)
-		comp := tmpl.ServiceListComponent(listComponent)
+		if err := rt.registry.OverrideServiceStatus(serviceId, message.Status); err != nil {
+			http.Error(wr, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		rt.replicator.Replicate(ReplicationMessage{Type: overrideReplication, Service: HeartBeatMessage{ServiceId: serviceId}, OverriddenStatus: message.Status})
+
+		if err := rt.broadcastServices(); err != nil {
+			http.Error(wr, err.Error(), http.StatusInternalServerError)
+			return
+		}
 
-		err = comp.Render(context.Background(), buffer)
+		updatedService, err := rt.registry.GetServiceById(serviceId)
 		if err != nil {
+			http.Error(wr, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		wr.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(wr).Encode(updatedService)
+	}
+}
+
+// SetSplit lets operators divide the requests of a path between versions of its
+// services, e.g. 95% to version 1 and 5% to a canary of version 2. A DELETE request
+// removes the split.
+func (rt *MuxRouter) SetSplit() func(wr http.ResponseWriter, r *http.Request) {
+	return func(wr http.ResponseWriter, r *http.Request) {
+		if err := rt.isKeyAuthorized(rt.getAuthToken(r)); err != nil {
+			http.Error(wr, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		path := mux.Vars(r)["path"]
+		utils.MakeUrlPathValid(&path)
+
+		var split *balancer.Split
+		if r.Method != http.MethodDelete {
+			var message balancer.Split
+			if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
+				http.Error(wr, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			if err := message.Validate(); err != nil {
+				http.Error(wr, err.Error(), http.StatusBadRequest)
+				return
+			}
+			split = &message
+		}
+
+		if err := rt.applySplit(path, split); err != nil {
+			http.Error(wr, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		rt.replicator.Replicate(ReplicationMessage{Type: splitReplication, Service: HeartBeatMessage{Path: path}, Split: split})
+
+		if err := rt.broadcastServices(); err != nil {
 			http.Error(wr, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if status, exists := rt.splits.Get(path); exists {
+			wr.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(wr).Encode(status)
+		}
+	}
+}
+
+// applySplit sets the split of the path, or removes it when split is nil, and wakes
+// the gateways syncing with blocking queries
+func (rt *MuxRouter) applySplit(path string, split *balancer.Split) error {
+	if split == nil {
+		if !rt.splits.Remove(path) {
+			return fmt.Errorf("path '%v' has no traffic split", path)
+		}
+	} else {
+		rt.splits.Set(path, *split)
+	}
+	rt.watcher.Touch(path)
+	return nil
+}
+
+// writeRegistryError answers the request with a RegistryResponse holding the error
+func writeRegistryError(wr http.ResponseWriter, status int, message string) {
+	wr.Header().Set("Content-Type", "application/json")
+	wr.WriteHeader(status)
+	json.NewEncoder(wr).Encode(RegistryResponse{Code: status, Message: message})
+}
+
+// breakerStates returns the state of every breaker that is not closed by path and
+// service id. The breaker of a path has an empty service id.
+func (rt *MuxRouter) breakerStates() map[proxy.BreakerStatus]proxy.BreakerState {
+	states := make(map[proxy.BreakerStatus]proxy.BreakerState)
+	for _, status := range rt.breakers.States() {
+		states[proxy.BreakerStatus{Path: status.Path, ServiceId: status.ServiceId}] = status.State
+	}
+	return states
+}
+
+// dashboardService describes a service on the dashboard. Its circuit is the state
+// of its own breaker, else the state of the breaker of its path.
+func dashboardService(registeredService *service.ServiceInfo, breakers map[proxy.BreakerStatus]proxy.BreakerState) tmpl.Service {
+	circuit, exists := breakers[proxy.BreakerStatus{Path: registeredService.Path, ServiceId: registeredService.ServiceId}]
+	if !exists {
+		circuit = breakers[proxy.BreakerStatus{Path: registeredService.Path}]
+	}
+	return tmpl.Service{
+		Circuit:   string(circuit),
+		Port:      registeredService.Port,
+		Path:      registeredService.Path,
+		ServiceId: registeredService.ServiceId,
+		IP:        registeredService.IP,
+		Status:    string(registeredService.CurrentStatus()),
+		Version:   registeredService.Version,
+		Zone:      registeredService.Zone,
+		Tags:      registeredService.Tags,
+		Metadata:  registeredService.Metadata,
+	}
+}
+
+// dashboardSplits describes the traffic splits on the dashboard with the share of
+// requests every version should get and the share of the requests proxied by the
+// discovery server it got
+func dashboardSplits(statuses []balancer.SplitStatus) []tmpl.Split {
+	splits := make([]tmpl.Split, 0, len(statuses))
+	for _, status := range statuses {
+		total, observed := 0, uint64(0)
+		for _, version := range status.Versions {
+			total += version.Weight
+			observed += status.Observed[version.Version]
+		}
+
+		split := tmpl.Split{Path: status.Path, Versions: make([]tmpl.SplitVersion, 0, len(status.Versions))}
+		for _, version := range status.Versions {
+			share := "-"
+			if observed > 0 {
+				share = fmt.Sprintf("%.1f%%", float64(status.Observed[version.Version])*100/float64(observed))
+			}
+			split.Versions = append(split.Versions, tmpl.SplitVersion{Version: version.Version, Percent: version.Weight * 100 / total, Observed: share})
 		}
+		splits = append(splits, split)
+	}
+	return splits
+}
+
+// broadcastServices renders the current list of services and sends it to every
+// dashboard connected to the hub.
+func (rt *MuxRouter) broadcastServices() error {
+	rt.watcher.Notify()
+
+	updatedServices := rt.registry.GetServices()
+
+	listComponent := make([]tmpl.Service, 0)
+	breakers := rt.breakerStates()
+
+	for _, updatedService := range updatedServices {
+		listComponent = append(listComponent,
+			dashboardService(updatedService, breakers))
+	}
 
-		rt.hub.Broadcaster() <- buffer.Bytes()
+	buffer := new(bytes.Buffer)
+	comp := tmpl.ServiceListComponent(listComponent, dashboardSplits(rt.splits.All()))
+
+	if err := comp.Render(context.Background(), buffer); err != nil {
+		return err
+	}
+
+	select {
+	case rt.hub.Broadcaster() <- buffer.Bytes():
+	case <-rt.ctx.Done():
 	}
+	return nil
 }
 
 // GetServiceMessage takes in a request with any http method and utilizes the LoadBalancer
@@ -125,42 +434,60 @@ func (rt *MuxRouter) GetServiceMessage() func(wr http.ResponseWriter, r *http.Re
 
 		utils.MakeUrlPathValid(&path)
 
-		serviceInfo, err := rt.balancer.GetNextService(path)
+		// the captured path holds the registered path followed by the request
+		// path of the service, e.g. /orders/items/7 for the path /orders
+		servicePath, err := rt.registry.GetPathFromRequest(path)
 		if err != nil {
-			wr.WriteHeader(http.StatusBadRequest)
+			http.Error(wr, err.Error(), http.StatusNotFound)
+			return
 		}
-		serviceLocation := "http://" + serviceInfo.IP + ":" + serviceInfo.Port
 
-		proxy, err := utils.ProxyRequest(serviceLocation)
+		serviceInfo, err := balancer.GetServiceForRequest(rt.balancer, servicePath, r)
 		if err != nil {
-			wr.WriteHeader(http.StatusInternalServerError)
+			http.Error(wr, err.Error(), http.StatusBadRequest)
+			return
 		}
 
-		r.URL.Path = strings.TrimPrefix("/getService", r.URL.Path)
+		if serviceInfo == nil {
+			http.Error(wr, fmt.Sprintf("no healthy service available for path '%v'", servicePath), http.StatusServiceUnavailable)
+			return
+		}
 
-		proxy.ServeHTTP(wr, r)
+		r.URL.Path = stripServicePath(params["path"], servicePath)
+		r.URL.RawPath = ""
+
+		rt.forwarder.Forward(wr, r, servicePath, serviceInfo, utils.ProxyRequest)
 	}
 }
 
+// stripServicePath removes the segments matched by the service path from the
+// request path, e.g. /items/7 is left of orders/items/7 for the path /orders
+// and of users/3/items/7 for the path /users/{id}
+func stripServicePath(requestPath string, servicePath string) string {
+	matched := 0
+	if trimmed := strings.Trim(servicePath, "/"); trimmed != "" {
+		matched = len(strings.Split(trimmed, "/"))
+	}
+	segments := strings.SplitN(strings.TrimPrefix(requestPath, "/"), "/", matched+1)
+	if len(segments) <= matched {
+		return "/"
+	}
+	return "/" + segments[matched]
+}
+
 // ShowServices renders a page where all services can be seen
 func (rt *MuxRouter) ShowServices() func(wr http.ResponseWriter, r *http.Request) {
 	return func(wr http.ResponseWriter, r *http.Request) {
 		services := rt.registry.GetServices()
 
 		serviceVal := make([]tmpl.Service, 0)
+		breakers := rt.breakerStates()
 
 		for _, val := range services {
-			newSrvComp := tmpl.Service{
-				Port:      val.Port,
-				Path:      val.Path,
-				IsHealthy: val.IsHealthy,
-				IP:        val.IP,
-				ServiceId: val.ServiceId,
-			}
-			serviceVal = append(serviceVal, newSrvComp)
+			serviceVal = append(serviceVal, dashboardService(val, breakers))
 		}
 
-		page := tmpl.Layout(tmpl.Services(serviceVal))
+		page := tmpl.Layout(tmpl.Services(serviceVal, dashboardSplits(rt.splits.All())))
 
 		page.Render(context.Background(), wr)
 	}
@@ -195,9 +522,20 @@ func (rt *MuxRouter) SetupRoutes() http.Handler {
 	router := mux.NewRouter()
 	router.HandleFunc("/", rt.ShowServices()).Methods("GET")
 	router.HandleFunc("/heartbeat", rt.SendHeartBeat()).Methods("POST")
-	router.HandleFunc("/get-service/{path}", rt.GetServiceMessage())
+	router.HandleFunc("/replicate", rt.Replicate()).Methods("POST")
+	router.HandleFunc("/admin/services/{serviceId}/status", rt.OverrideStatus()).Methods("PUT", "DELETE")
+	router.HandleFunc("/services/{path:.+}/{serviceId}", rt.DeregisterService()).Methods("DELETE")
+	router.HandleFunc("/splits/{path:.+}", rt.SetSplit()).Methods("PUT", "DELETE")
+	router.HandleFunc("/v1/services", rt.ListServices()).Methods("GET")
+	router.HandleFunc("/v1/services/{path:.+}/instances", rt.ListInstances()).Methods("GET")
+	router.HandleFunc("/v1/splits", rt.ListSplits()).Methods("GET")
+	router.HandleFunc("/v1/events", rt.WatchEvents()).Methods("GET")
+	router.HandleFunc("/get-service/{path:.+}", rt.GetServiceMessage())
 	router.HandleFunc("/services-socket", rt.ServicesSocket())
 	router.PathPrefix("/static/").HandlerFunc(rt.GetStaticFiles())
+	for prefix, handler := range rt.handlers {
+		router.PathPrefix(prefix).Handler(handler)
+	}
 	return router
 }
 
@@ -230,21 +568,98 @@ func WithHub(hub Hub) MuxRouterOpt {
 	}
 }
 
+// WithReplicator sets the Replicator used to send registry changes
+// to peer discovery servers.
+func WithReplicator(replicator Replicator) MuxRouterOpt {
+	return func(mr *MuxRouter) error {
+		mr.replicator = replicator
+		return nil
+	}
+}
+
+// WithHealthChecker updates the dashboards whenever the checker changes
+// the health of a service
+func WithHealthChecker(checker *health.Checker) MuxRouterOpt {
+	return func(mr *MuxRouter) error {
+		checker.Subscribe(func(*service.ServiceInfo) {
+			if err := mr.broadcastServices(); err != nil {
+				slog.Error(fmt.Sprintf("Could not broadcast services: %v", err))
+			}
+		})
+		return nil
+	}
+}
+
+// WithOutlierDetector records the result of every proxied request in the
+// detector, so services that keep failing can be ejected by the load balancer
+func WithOutlierDetector(detector *health.OutlierDetector) MuxRouterOpt {
+	return func(mr *MuxRouter) error {
+		mr.outliers = detector
+		return nil
+	}
+}
+
+// WithRetryPolicy retries proxied requests that fail on other services of their
+// path as the policy allows
+func WithRetryPolicy(policy proxy.RetryPolicy) MuxRouterOpt {
+	return func(mr *MuxRouter) error {
+		mr.retries = policy
+		return nil
+	}
+}
+
+// WithBreakers fails proxied requests fast while their circuit breaker is open and
+// updates the dashboards whenever a breaker changes state
+func WithBreakers(breakers *proxy.Breakers) MuxRouterOpt {
+	return func(mr *MuxRouter) error {
+		mr.breakers = breakers
+		breakers.Subscribe(func(proxy.BreakerStatus) {
+			if err := mr.broadcastServices(); err != nil {
+				slog.Error(fmt.Sprintf("Could not broadcast services: %v", err))
+			}
+		})
+		return nil
+	}
+}
+
+// WithSplits sets the traffic splits operators change through the admin api. They
+// must be the splits the load balancer was given.
+func WithSplits(splits *balancer.Splits) MuxRouterOpt {
+	return func(mr *MuxRouter) error {
+		mr.splits = splits
+		return nil
+	}
+}
+
+// WithHandler mounts an extra handler on every path starting with prefix
+func WithHandler(prefix string, handler http.Handler) MuxRouterOpt {
+	return func(mr *MuxRouter) error {
+		mr.handlers[prefix] = handler
+		return nil
+	}
+}
+
 // MuxRouterOpt are option functions that setup the mux router struct.
 type MuxRouterOpt func(*MuxRouter) error
 
 // New MuxRouter instantiates a MuxRouter with all the necessary handleFuncs utilizing the
 // service registry. The MuxRouter implements the Router interface for
-func NewMuxRouter(balancer balancer.LoadBalancer, registry registry.Registry, ctx context.Context, opts ...MuxRouterOpt) (Router, error) {
+func NewMuxRouter(loadBalancer balancer.LoadBalancer, registry registry.Registry, ctx context.Context, opts ...MuxRouterOpt) (Router, error) {
 	router := &MuxRouter{
-		balancer: balancer,
+		balancer: loadBalancer,
 		registry: registry,
 		upgrader: &websocket.Upgrader{
 			ReadBufferSize:  1024,
 			WriteBufferSize: 1024,
 		},
-		ctx: ctx,
-		hub: NewInMemoryHub(),
+		ctx:        ctx,
+		hub:        NewInMemoryHub(),
+		events:     NewInMemoryHub(),
+		replicator: NewPeerReplicator(nil, ""),
+		outliers:   health.NewOutlierDetector(registry, utils.NewClock(), health.OutlierConfig{}),
+		breakers:   proxy.NewBreakers(utils.NewClock(), proxy.BreakerConfig{}),
+		splits:     balancer.NewSplits(),
+		handlers:   make(map[string]http.Handler),
 	}
 
 	for _, opt := range opts {
@@ -254,7 +669,18 @@ func NewMuxRouter(balancer balancer.LoadBalancer, registry registry.Registry, ct
 		}
 	}
 
+	router.watcher = NewWatcher(registry, router.events, time.Second)
+	router.forwarder = proxy.NewForwarder(loadBalancer,
+		proxy.WithRetryPolicy(router.retries),
+		proxy.WithObserver(router.outliers),
+		proxy.WithBreakers(router.breakers),
+		proxy.WithErrorWriter(writeRegistryError),
+	)
+
 	go router.hub.Run(ctx)
+	go router.events.Run(ctx)
+	go router.watcher.Run(ctx)
+	go router.replicator.Run(ctx)
 
 	return router, nil
 }
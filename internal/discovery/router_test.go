@@ -0,0 +1,501 @@
+package discovery_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anjolaoluwaakindipe/duller/internal/balancer"
+	"github.com/anjolaoluwaakindipe/duller/internal/discovery"
+	"github.com/anjolaoluwaakindipe/duller/internal/health"
+	"github.com/anjolaoluwaakindipe/duller/internal/registry"
+	"github.com/anjolaoluwaakindipe/duller/internal/service"
+	"github.com/anjolaoluwaakindipe/duller/internal/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// stubUpstream starts a service answering every request with the given status code
+func stubUpstream(t *testing.T, reg registry.Registry, serviceId string, status int) *service.ServiceInfo {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+
+	serverUrl, _ := url.Parse(server.URL)
+	upstream := &service.ServiceInfo{Path: "/path1", ServiceId: serviceId, IP: serverUrl.Hostname(), Port: serverUrl.Port()}
+	reg.RegisterService(upstream)
+	return upstream
+}
+
+// stubOutlierServer starts a discovery server ejecting services after two failed requests
+func stubOutlierServer(t *testing.T, ctx context.Context, reg registry.Registry) (*httptest.Server, *health.OutlierDetector) {
+	detector := health.NewOutlierDetector(reg, utils.NewClock(), health.OutlierConfig{
+		ConsecutiveFailures: 2,
+		BaseEjectionTime:    time.Minute,
+		MaxEjectionTime:     time.Minute,
+		MaxEjectionPercent:  50,
+	})
+	router, err := discovery.NewMuxRouter(balancer.NewRoundRobinLoadBalancer(reg, balancer.WithServiceFilter(detector)), reg, ctx,
+		discovery.WithOutlierDetector(detector),
+	)
+	assert.Nil(t, err)
+
+	server := httptest.NewServer(router.SetupRoutes())
+	t.Cleanup(server.Close)
+	return server, detector
+}
+
+func Test_MuxRouter_GetServiceMessage(t *testing.T) {
+	t.Run("SHOULD eject a service WHEN it keeps answering with a 5xx status code", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		reg := registry.InitInMemoryRegistry(utils.NewClock())
+		failing := stubUpstream(t, reg, "server1", http.StatusInternalServerError)
+		healthy := stubUpstream(t, reg, "server2", http.StatusOK)
+		server, detector := stubOutlierServer(t, ctx, reg)
+
+		for i := 0; i < 4; i++ {
+			response, err := http.Get(server.URL + "/get-service/path1")
+			assert.Nil(t, err)
+			response.Body.Close()
+		}
+
+		assert.True(t, detector.IsEjected(failing.ServiceId))
+		assert.False(t, detector.IsEjected(healthy.ServiceId))
+
+		for i := 0; i < 4; i++ {
+			response, err := http.Get(server.URL + "/get-service/path1")
+			assert.Nil(t, err)
+			assert.Equal(t, http.StatusOK, response.StatusCode)
+			response.Body.Close()
+		}
+	})
+
+	t.Run("SHOULD send the rest of the request path to the service WHEN a request is forwarded", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		forwarded := make(chan string, 1)
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			forwarded <- r.URL.RequestURI()
+		}))
+		defer upstream.Close()
+
+		reg := registry.InitInMemoryRegistry(utils.NewClock())
+		upstreamUrl, _ := url.Parse(upstream.URL)
+		for serviceId, path := range map[string]string{"server1": "/path1", "server2": "/api/v2", "server3": "/users/{id}"} {
+			reg.RegisterService(&service.ServiceInfo{Path: path, ServiceId: serviceId, IP: upstreamUrl.Hostname(), Port: upstreamUrl.Port()})
+		}
+		server, _ := stubOutlierServer(t, ctx, reg)
+
+		requests := map[string]string{
+			"/get-service/path1":                     "/",
+			"/get-service/path1/items/7?expand=true": "/items/7?expand=true",
+			"/get-service/api/v2/items/":             "/items/",
+			"/get-service/users/3/orders":            "/orders",
+		}
+		for requestPath, expected := range requests {
+			response, err := http.Get(server.URL + requestPath)
+			assert.Nil(t, err)
+			response.Body.Close()
+			assert.Equal(t, http.StatusOK, response.StatusCode, requestPath)
+			assert.Equal(t, expected, <-forwarded, requestPath)
+		}
+	})
+
+	t.Run("SHOULD respond with not found WHEN no registered path matches the request", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		reg := registry.InitInMemoryRegistry(utils.NewClock())
+		stubUpstream(t, reg, "server1", http.StatusOK)
+		server, _ := stubOutlierServer(t, ctx, reg)
+
+		response, err := http.Get(server.URL + "/get-service/orders/items")
+		assert.Nil(t, err)
+		response.Body.Close()
+		assert.Equal(t, http.StatusNotFound, response.StatusCode)
+	})
+
+	t.Run("SHOULD eject a service WHEN connections to it fail", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		reg := registry.InitInMemoryRegistry(utils.NewClock())
+		stubUpstream(t, reg, "server1", http.StatusOK)
+		unreachable := &service.ServiceInfo{Path: "/path1", ServiceId: "server2", IP: "127.0.0.1", Port: "1"}
+		reg.RegisterService(unreachable)
+		server, detector := stubOutlierServer(t, ctx, reg)
+
+		statuses := make([]int, 0)
+		for i := 0; i < 4; i++ {
+			response, err := http.Get(server.URL + "/get-service/path1")
+			assert.Nil(t, err)
+			statuses = append(statuses, response.StatusCode)
+			response.Body.Close()
+		}
+
+		assert.Contains(t, statuses, http.StatusBadGateway)
+		assert.True(t, detector.IsEjected(unreachable.ServiceId))
+	})
+}
+
+func sendStatus(t *testing.T, method string, address string, key string, message discovery.StatusMessage) *http.Response {
+	body, _ := json.Marshal(message)
+	req, _ := http.NewRequest(method, address, bytes.NewBuffer(body))
+	req.Header.Set("Authorization", "Bearer "+key)
+	response, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	response.Body.Close()
+	return response
+}
+
+func Test_MuxRouter_OverrideStatus(t *testing.T) {
+	t.Run("SHOULD override the status of a service WHEN an operator sets it", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		server, reg := stubPeer(t, ctx, "")
+		sendHeartbeat(t, server.URL, "", discovery.HeartBeatMessage{ServiceId: "server1", Path: "/orders", IP: "127.0.0.1", Port: "4000"})
+
+		response := sendStatus(t, http.MethodPut, server.URL+"/admin/services/server1/status", "", discovery.StatusMessage{Status: service.StatusOutOfService})
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+
+		registeredService, err := reg.GetServiceById("server1")
+		assert.Nil(t, err)
+		assert.Equal(t, service.StatusOutOfService, registeredService.CurrentStatus())
+
+		response = sendStatus(t, http.MethodDelete, server.URL+"/admin/services/server1/status", "", discovery.StatusMessage{})
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		registeredService, _ = reg.GetServiceById("server1")
+		assert.Equal(t, service.StatusUp, registeredService.CurrentStatus())
+	})
+
+	t.Run("SHOULD reject the override WHEN the status is unknown or the service does not exist", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		server, _ := stubPeer(t, ctx, "")
+		sendHeartbeat(t, server.URL, "", discovery.HeartBeatMessage{ServiceId: "server1", Path: "/orders", IP: "127.0.0.1", Port: "4000"})
+
+		response := sendStatus(t, http.MethodPut, server.URL+"/admin/services/server1/status", "", discovery.StatusMessage{Status: "SLEEPING"})
+		assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+
+		response = sendStatus(t, http.MethodPut, server.URL+"/admin/services/server2/status", "", discovery.StatusMessage{Status: service.StatusDraining})
+		assert.Equal(t, http.StatusNotFound, response.StatusCode)
+	})
+
+	t.Run("SHOULD reject the override WHEN the discovery key is wrong", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		server, _ := stubPeer(t, ctx, "secret")
+
+		response := sendStatus(t, http.MethodPut, server.URL+"/admin/services/server1/status", "wrong", discovery.StatusMessage{Status: service.StatusDraining})
+		assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+	})
+
+	t.Run("SHOULD replicate the override WHEN peers are configured", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		peer, peerRegistry := stubPeer(t, ctx, "")
+		server, _ := stubPeer(t, ctx, "", strings.TrimPrefix(peer.URL, "http://"))
+		sendHeartbeat(t, server.URL, "", discovery.HeartBeatMessage{ServiceId: "server1", Path: "/orders", IP: "127.0.0.1", Port: "4000"})
+
+		assert.Eventually(t, func() bool {
+			_, err := peerRegistry.GetServiceById("server1")
+			return err == nil
+		}, 5*time.Second, 10*time.Millisecond)
+
+		response := sendStatus(t, http.MethodPut, server.URL+"/admin/services/server1/status", "", discovery.StatusMessage{Status: service.StatusDraining})
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+
+		assert.Eventually(t, func() bool {
+			replicated, err := peerRegistry.GetServiceById("server1")
+			return err == nil && replicated.OverriddenStatus == service.StatusDraining
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+}
+
+func Test_MuxRouter_SendHeartBeat(t *testing.T) {
+	t.Run("SHOULD store the status reported by a service WHEN it is sent with the heartbeat", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		server, reg := stubPeer(t, ctx, "")
+
+		response := sendHeartbeat(t, server.URL, "", discovery.HeartBeatMessage{ServiceId: "server1", Path: "/orders", IP: "127.0.0.1", Port: "4000", Status: service.StatusStarting})
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+
+		registeredService, err := reg.GetServiceById("server1")
+		assert.Nil(t, err)
+		assert.Equal(t, service.StatusStarting, registeredService.CurrentStatus())
+
+		response = sendHeartbeat(t, server.URL, "", discovery.HeartBeatMessage{ServiceId: "server1", Path: "/orders", IP: "127.0.0.1", Port: "4000", Status: service.StatusDraining})
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		registeredService, _ = reg.GetServiceById("server1")
+		assert.Equal(t, service.StatusDraining, registeredService.CurrentStatus())
+
+		response = sendHeartbeat(t, server.URL, "", discovery.HeartBeatMessage{ServiceId: "server1", Path: "/orders", IP: "127.0.0.1", Port: "4000", Status: "SLEEPING"})
+		assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+	})
+
+	t.Run("SHOULD store the attributes of a service WHEN they are sent with the heartbeat", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		server, reg := stubPeer(t, ctx, "")
+
+		response := sendHeartbeat(t, server.URL, "", discovery.HeartBeatMessage{ServiceId: "server1", Path: "/orders", IP: "127.0.0.1", Port: "4000",
+			Metadata: map[string]string{"team": "payments"}, Tags: []string{"canary"}, Version: "1.2.0", Zone: "eu-west-1a"})
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+
+		registeredService, err := reg.GetServiceById("server1")
+		assert.Nil(t, err)
+		assert.True(t, service.Selector{Tags: []string{"canary"}, Version: "1.2", Zone: "eu-west-1a", Metadata: map[string]string{"team": "payments"}}.Matches(registeredService))
+
+		response = sendHeartbeat(t, server.URL, "", discovery.HeartBeatMessage{ServiceId: "server1", Path: "/orders", IP: "127.0.0.1", Port: "4000", Version: "latest"})
+		assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+	})
+
+	t.Run("SHOULD store the weight and strategy of a service WHEN the strategy is known", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		server, reg := stubPeer(t, ctx, "")
+
+		response := sendHeartbeat(t, server.URL, "", discovery.HeartBeatMessage{ServiceId: "server1", Path: "/orders", IP: "127.0.0.1", Port: "4000", Weight: 3, Strategy: "weighted-round-robin"})
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+
+		registeredService, err := reg.GetServiceById("server1")
+		assert.Nil(t, err)
+		assert.Equal(t, 3, registeredService.WeightedUse)
+		assert.Equal(t, "weighted-round-robin", registeredService.Strategy)
+
+		response = sendHeartbeat(t, server.URL, "", discovery.HeartBeatMessage{ServiceId: "server1", Path: "/orders", IP: "127.0.0.1", Port: "4000", Strategy: "random"})
+		assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+	})
+}
+
+func sendDeregister(t *testing.T, address string, key string) *http.Response {
+	req, _ := http.NewRequest(http.MethodDelete, address, nil)
+	req.Header.Set("Authorization", "Bearer "+key)
+	response, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	response.Body.Close()
+	return response
+}
+
+func Test_MuxRouter_DeregisterService(t *testing.T) {
+	t.Run("SHOULD remove a service WHEN it deregisters itself", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		server, reg := stubPeer(t, ctx, "")
+		sendHeartbeat(t, server.URL, "", discovery.HeartBeatMessage{ServiceId: "server1", Path: "/orders", IP: "127.0.0.1", Port: "4000"})
+
+		response := sendDeregister(t, server.URL+"/services/orders/server1", "")
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+
+		_, err := reg.GetServiceById("server1")
+		assert.NotNil(t, err)
+
+		response = sendDeregister(t, server.URL+"/services/orders/server1", "")
+		assert.Equal(t, http.StatusNotFound, response.StatusCode)
+	})
+
+	t.Run("SHOULD keep a service WHEN the path does not match its registration", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		server, reg := stubPeer(t, ctx, "")
+		sendHeartbeat(t, server.URL, "", discovery.HeartBeatMessage{ServiceId: "server1", Path: "/orders", IP: "127.0.0.1", Port: "4000"})
+		sendHeartbeat(t, server.URL, "", discovery.HeartBeatMessage{ServiceId: "server2", Path: "/users", IP: "127.0.0.1", Port: "4001"})
+
+		response := sendDeregister(t, server.URL+"/services/users/server1", "")
+		assert.Equal(t, http.StatusNotFound, response.StatusCode)
+
+		_, err := reg.GetServiceById("server1")
+		assert.Nil(t, err)
+	})
+
+	t.Run("SHOULD remove a service WHEN it is registered on a nested path or its id is status", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		server, reg := stubPeer(t, ctx, "")
+		sendHeartbeat(t, server.URL, "", discovery.HeartBeatMessage{ServiceId: "server1", Path: "/orders/v2", IP: "127.0.0.1", Port: "4000"})
+		sendHeartbeat(t, server.URL, "", discovery.HeartBeatMessage{ServiceId: "status", Path: "/orders", IP: "127.0.0.1", Port: "4001"})
+
+		response := sendDeregister(t, server.URL+"/services/orders/v2/server1", "")
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		_, err := reg.GetServiceById("server1")
+		assert.NotNil(t, err)
+
+		response = sendDeregister(t, server.URL+"/services/orders/status", "")
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		_, err = reg.GetServiceById("status")
+		assert.NotNil(t, err)
+	})
+
+	t.Run("SHOULD keep a service WHEN the discovery key is wrong", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		server, reg := stubPeer(t, ctx, "secret")
+		sendHeartbeat(t, server.URL, "secret", discovery.HeartBeatMessage{ServiceId: "server1", Path: "/orders", IP: "127.0.0.1", Port: "4000"})
+
+		response := sendDeregister(t, server.URL+"/services/orders/server1", "wrong")
+		assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+
+		_, err := reg.GetServiceById("server1")
+		assert.Nil(t, err)
+	})
+
+	t.Run("SHOULD broadcast the removal WHEN a service deregisters", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		reg := registry.InitInMemoryRegistry(utils.NewClock())
+		hub := &stubHub{broadcaster: make(chan []byte, 10), register: make(chan *discovery.SocketClient, 1), unregister: make(chan *discovery.SocketClient, 1)}
+		router, err := discovery.NewMuxRouter(balancer.NewRoundRobinLoadBalancer(reg), reg, ctx, discovery.WithHub(hub))
+		assert.Nil(t, err)
+		server := httptest.NewServer(router.SetupRoutes())
+		defer server.Close()
+
+		sendHeartbeat(t, server.URL, "", discovery.HeartBeatMessage{ServiceId: "server1", Path: "/orders", IP: "127.0.0.1", Port: "4000"})
+		<-hub.broadcaster
+
+		response := sendDeregister(t, server.URL+"/services/orders/server1", "")
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+
+		broadcast := <-hub.broadcaster
+		assert.NotContains(t, string(broadcast), "server1")
+	})
+}
+
+// stubHub keeps every broadcast message so tests can read them
+type stubHub struct {
+	broadcaster chan []byte
+	register    chan *discovery.SocketClient
+	unregister  chan *discovery.SocketClient
+}
+
+func (sh *stubHub) Run(ctx context.Context) {}
+
+func (sh *stubHub) Broadcaster() chan []byte { return sh.broadcaster }
+
+func (sh *stubHub) Register() chan *discovery.SocketClient { return sh.register }
+
+func (sh *stubHub) Unregister() chan *discovery.SocketClient { return sh.unregister }
+
+func sendSplit(t *testing.T, method string, address string, key string, split balancer.Split) *http.Response {
+	body, _ := json.Marshal(split)
+	req, _ := http.NewRequest(method, address, bytes.NewBuffer(body))
+	req.Header.Set("Authorization", "Bearer "+key)
+	response, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	response.Body.Close()
+	return response
+}
+
+func Test_MuxRouter_SetSplit(t *testing.T) {
+	t.Run("SHOULD send requests to the versions of the split WHEN an operator sets it", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		reg := registry.InitInMemoryRegistry(utils.NewClock())
+		stubUpstream(t, reg, "server1", http.StatusOK).Version = "1.0.0"
+		stubUpstream(t, reg, "server2", http.StatusAccepted).Version = "2.0.0"
+		splits := balancer.NewSplits()
+		loadBalancer := balancer.NewPathBalancer(reg, balancer.StrategyConfig{Default: balancer.RoundRobinStrategy}, balancer.WithSplits(splits))
+		router, err := discovery.NewMuxRouter(loadBalancer, reg, ctx, discovery.WithSplits(splits))
+		assert.Nil(t, err)
+		server := httptest.NewServer(router.SetupRoutes())
+		defer server.Close()
+
+		response := sendSplit(t, http.MethodPut, server.URL+"/splits/path1", "", balancer.Split{Versions: []balancer.VersionWeight{{Version: "1", Weight: 0}, {Version: "2", Weight: 100}}})
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+
+		for i := 0; i < 3; i++ {
+			response, err := http.Get(server.URL + "/get-service/path1")
+			assert.Nil(t, err)
+			assert.Equal(t, http.StatusAccepted, response.StatusCode)
+			response.Body.Close()
+		}
+
+		var statuses []balancer.SplitStatus
+		getJson(t, server.URL+"/v1/splits", "", &statuses)
+		assert.Len(t, statuses, 1)
+		assert.Equal(t, "/path1", statuses[0].Path)
+		assert.Equal(t, map[string]uint64{"2": 3}, statuses[0].Observed)
+
+		var services []discovery.ServiceResponse
+		getJson(t, server.URL+"/v1/services", "", &services)
+		assert.Equal(t, &balancer.Split{Versions: []balancer.VersionWeight{{Version: "1", Weight: 0}, {Version: "2", Weight: 100}}}, services[0].Split)
+
+		response = sendSplit(t, http.MethodDelete, server.URL+"/splits/path1", "", balancer.Split{})
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		assert.Empty(t, splits.All())
+
+		response = sendSplit(t, http.MethodDelete, server.URL+"/splits/path1", "", balancer.Split{})
+		assert.Equal(t, http.StatusNotFound, response.StatusCode)
+	})
+
+	t.Run("SHOULD split a nested path WHEN it has several segments", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		server, _ := stubPeer(t, ctx, "")
+		sendHeartbeat(t, server.URL, "", discovery.HeartBeatMessage{ServiceId: "reports1", Path: "/api/v2/reports", IP: "127.0.0.1", Port: "4000", Version: "1.0.0"})
+
+		response := sendSplit(t, http.MethodPut, server.URL+"/splits/api/v2/reports", "", balancer.Split{Versions: []balancer.VersionWeight{{Version: "1", Weight: 100}}})
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+
+		var statuses []balancer.SplitStatus
+		getJson(t, server.URL+"/v1/splits", "", &statuses)
+		assert.Len(t, statuses, 1)
+		assert.Equal(t, "/api/v2/reports", statuses[0].Path)
+
+		response = sendSplit(t, http.MethodDelete, server.URL+"/splits/api/v2/reports", "", balancer.Split{})
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+	})
+
+	t.Run("SHOULD reject the split WHEN it is invalid or the discovery key is wrong", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		server, _ := stubPeer(t, ctx, "secret")
+
+		response := sendSplit(t, http.MethodPut, server.URL+"/splits/orders", "secret", balancer.Split{})
+		assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+
+		response = sendSplit(t, http.MethodPut, server.URL+"/splits/orders", "wrong", balancer.Split{Versions: []balancer.VersionWeight{{Version: "1", Weight: 1}}})
+		assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+	})
+
+	t.Run("SHOULD replicate the split WHEN peers are configured", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		peer, _ := stubPeer(t, ctx, "")
+		server, _ := stubPeer(t, ctx, "", strings.TrimPrefix(peer.URL, "http://"))
+
+		response := sendSplit(t, http.MethodPut, server.URL+"/splits/orders", "", balancer.Split{Versions: []balancer.VersionWeight{{Version: "1", Weight: 95}, {Version: "2", Weight: 5}}})
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+
+		assert.Eventually(t, func() bool {
+			var statuses []balancer.SplitStatus
+			getJson(t, peer.URL+"/v1/splits", "", &statuses)
+			return len(statuses) == 1 && statuses[0].Path == "/orders" && len(statuses[0].Versions) == 2
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+}
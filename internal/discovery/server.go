@@ -32,7 +32,7 @@ func InitRegistryServer(dc DiscoveryConfig, ctx context.Context, router Router)
 	go func() {
 		slog.Info(fmt.Sprintf("Starting Service Discovery Server on port %v... \n", dc.DISCOVERY_PORT))
 		if err := server.ListenAndServe(); err != http.ErrServerClosed {
-			slog.Error("Error starting Service Discovery Server: %v", err)
+			slog.Error(fmt.Sprintf("Error starting Service Discovery Server: %v", err))
 		}
 	}()
 
@@ -0,0 +1,255 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/anjolaoluwaakindipe/duller/internal/registry"
+)
+
+// types of registry events
+const (
+	registerEvent     = "register"
+	deregisterEvent   = "deregister"
+	healthChangeEvent = "health-change"
+	updateEvent       = "update"
+)
+
+// limits of blocking queries
+const (
+	defaultWatchWait = time.Minute
+	maxWatchWait     = 10 * time.Minute
+)
+
+// RegistryEvent describes a change made to an instance of the registry
+type RegistryEvent struct {
+	// Index is the registry index the change was seen at
+	Index    uint64           `json:"index"`
+	Type     string           `json:"type"`
+	Instance InstanceResponse `json:"instance"`
+}
+
+// watchedPath is the last known instance set of a path
+type watchedPath struct {
+	// index is the registry index the instance set last changed at
+	index     uint64
+	instances map[string]InstanceResponse
+}
+
+// Watcher keeps an index of the registry that grows every time the instance
+// set of a path changes. It wakes up blocking queries and broadcasts a json
+// RegistryEvent to its hub for every change.
+//
+// The registry is compared with its last known state whenever Notify is called
+// and at a regular interval, which catches expired services and changes made
+// by raft peers.
+type Watcher struct {
+	registry registry.Registry
+	hub      Hub
+	interval time.Duration
+	notify   chan struct{}
+	mutex    sync.Mutex
+	index    uint64
+	paths    map[string]*watchedPath
+	// changed is closed and replaced every time the index grows
+	changed chan struct{}
+}
+
+// NewWatcher creates a Watcher comparing the registry with its last known state
+// at the given interval and broadcasting events to the hub
+func NewWatcher(reg registry.Registry, hub Hub, interval time.Duration) *Watcher {
+	return &Watcher{
+		registry: reg,
+		hub:      hub,
+		interval: interval,
+		notify:   make(chan struct{}, 1),
+		paths:    make(map[string]*watchedPath),
+		changed:  make(chan struct{}),
+	}
+}
+
+// Notify asks the watcher to look for changes without waiting for the next interval
+func (w *Watcher) Notify() {
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Run looks for changes until the context is cancelled. This is meant to be used
+// in a goroutine
+func (w *Watcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.notify:
+		case <-ticker.C:
+		}
+
+		for _, event := range w.scan() {
+			message, err := json.Marshal(event)
+			if err != nil {
+				slog.Error(fmt.Sprintf("Could not encode registry event: %v", err))
+				continue
+			}
+
+			select {
+			case w.hub.Broadcaster() <- message:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// Index returns the index of the whole registry
+func (w *Watcher) Index() uint64 {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.index
+}
+
+// PathIndex returns the index the instance set of the path last changed at
+func (w *Watcher) PathIndex(path string) uint64 {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.pathIndex(path)
+}
+
+// Wait blocks until the index returned by current grows past index, the wait
+// time is over or the context is cancelled, and returns the last index. An index
+// ahead of current, e.g. one seen before the server restarted, returns at once so
+// the caller starts over from the current index.
+func (w *Watcher) Wait(ctx context.Context, index uint64, wait time.Duration, current func() uint64) uint64 {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		w.mutex.Lock()
+		changed := w.changed
+		w.mutex.Unlock()
+
+		lastIndex := current()
+		if lastIndex != index {
+			return lastIndex
+		}
+
+		select {
+		case <-changed:
+		case <-timer.C:
+			return current()
+		case <-ctx.Done():
+			return current()
+		}
+	}
+}
+
+// Touch grows the index of the path and of the whole registry although no instance
+// changed, which wakes blocking queries when something else served along with the
+// instances of the path changes, such as its traffic split
+func (w *Watcher) Touch(path string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	watched, exists := w.paths[path]
+	if !exists {
+		watched = &watchedPath{instances: make(map[string]InstanceResponse)}
+		w.paths[path] = watched
+	}
+
+	w.index++
+	watched.index = w.index
+	close(w.changed)
+	w.changed = make(chan struct{})
+}
+
+// pathIndex must be called with the mutex held
+func (w *Watcher) pathIndex(path string) uint64 {
+	if watched, exists := w.paths[path]; exists {
+		return watched.index
+	}
+	return 0
+}
+
+// scan compares the registry with its last known state and returns an event
+// for every instance that changed.
+func (w *Watcher) scan() []RegistryEvent {
+	current := make(map[string]map[string]InstanceResponse)
+	for _, registeredService := range w.registry.GetServices() {
+		if _, exists := current[registeredService.Path]; !exists {
+			current[registeredService.Path] = make(map[string]InstanceResponse)
+		}
+		current[registeredService.Path][registeredService.ServiceId] = newInstanceResponse(registeredService)
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	nextIndex := w.index + 1
+	events := make([]RegistryEvent, 0)
+
+	for path, watched := range w.paths {
+		if _, exists := current[path]; !exists && len(watched.instances) > 0 {
+			current[path] = make(map[string]InstanceResponse)
+		}
+	}
+
+	for path, instances := range current {
+		watched, exists := w.paths[path]
+		if !exists {
+			watched = &watchedPath{instances: make(map[string]InstanceResponse)}
+			w.paths[path] = watched
+		}
+
+		pathEvents := diffInstances(watched.instances, instances, nextIndex)
+		if len(pathEvents) == 0 {
+			continue
+		}
+
+		watched.index = nextIndex
+		watched.instances = instances
+		events = append(events, pathEvents...)
+	}
+
+	if len(events) > 0 {
+		w.index = nextIndex
+		close(w.changed)
+		w.changed = make(chan struct{})
+	}
+
+	return events
+}
+
+// diffInstances returns the events turning the previous instances of a path into
+// the current ones
+func diffInstances(previous map[string]InstanceResponse, current map[string]InstanceResponse, index uint64) []RegistryEvent {
+	events := make([]RegistryEvent, 0)
+
+	for serviceId, instance := range current {
+		previousInstance, existed := previous[serviceId]
+		switch {
+		case !existed:
+			events = append(events, RegistryEvent{Index: index, Type: registerEvent, Instance: instance})
+		case previousInstance.Status != instance.Status:
+			events = append(events, RegistryEvent{Index: index, Type: healthChangeEvent, Instance: instance})
+		case !reflect.DeepEqual(previousInstance, instance):
+			events = append(events, RegistryEvent{Index: index, Type: updateEvent, Instance: instance})
+		}
+	}
+
+	for serviceId, instance := range previous {
+		if _, exists := current[serviceId]; !exists {
+			events = append(events, RegistryEvent{Index: index, Type: deregisterEvent, Instance: instance})
+		}
+	}
+
+	return events
+}
@@ -0,0 +1,155 @@
+package discovery_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anjolaoluwaakindipe/duller/internal/discovery"
+	"github.com/anjolaoluwaakindipe/duller/internal/service"
+	"github.com/stretchr/testify/assert"
+)
+
+// currentIndex waits for the watcher to see the stub catalog and returns the
+// index of the given read api address
+func currentIndex(t *testing.T, address string) uint64 {
+	var index uint64
+	assert.Eventually(t, func() bool {
+		var instances []discovery.InstanceResponse
+		response := getJson(t, address, "", &instances)
+		index, _ = strconv.ParseUint(response.Header.Get("X-Duller-Index"), 10, 64)
+		return index > 0
+	}, 5*time.Second, 10*time.Millisecond)
+	return index
+}
+
+// readEvent reads the next event of a Server-Sent Events stream
+func readEvent(t *testing.T, reader *bufio.Reader) (string, discovery.RegistryEvent) {
+	var eventType string
+	var event discovery.RegistryEvent
+	for {
+		line, err := reader.ReadString('\n')
+		assert.Nil(t, err)
+		line = strings.TrimSuffix(line, "\n")
+
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			eventType = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			assert.Nil(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event))
+		case len(line) == 0 && len(eventType) > 0:
+			return eventType, event
+		}
+	}
+}
+
+func Test_MuxRouter_BlockingQuery(t *testing.T) {
+	t.Run("SHOULD return WHEN the instances of the path change past the index", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		address := stubCatalog(t, ctx)
+		index := currentIndex(t, address+"/v1/services/orders/instances")
+
+		type result struct {
+			response  *http.Response
+			instances []discovery.InstanceResponse
+		}
+		results := make(chan result, 1)
+		go func() {
+			var instances []discovery.InstanceResponse
+			response := getJson(t, address+"/v1/services/orders/instances?wait=5s&index="+strconv.FormatUint(index, 10), "", &instances)
+			results <- result{response, instances}
+		}()
+
+		sendHeartbeat(t, address, "", discovery.HeartBeatMessage{ServiceId: "orders3", Path: "/orders", IP: "127.0.0.1", Port: "4003"})
+
+		select {
+		case blocked := <-results:
+			assert.Equal(t, http.StatusOK, blocked.response.StatusCode)
+			assert.Equal(t, []string{"orders1", "orders2", "orders3"}, instanceIds(blocked.instances))
+			newIndex, _ := strconv.ParseUint(blocked.response.Header.Get("X-Duller-Index"), 10, 64)
+			assert.Greater(t, newIndex, index)
+		case <-time.After(4 * time.Second):
+			assert.Fail(t, "blocking query did not return after the instances changed")
+		}
+	})
+
+	t.Run("SHOULD return the same index WHEN nothing changes before the wait is over", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		address := stubCatalog(t, ctx)
+		index := currentIndex(t, address+"/v1/services/orders/instances")
+
+		start := time.Now()
+		var instances []discovery.InstanceResponse
+		response := getJson(t, address+"/v1/services/orders/instances?wait=200ms&index="+strconv.FormatUint(index, 10), "", &instances)
+
+		assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		assert.Equal(t, strconv.FormatUint(index, 10), response.Header.Get("X-Duller-Index"))
+	})
+
+	t.Run("SHOULD return the current index at once WHEN the index is ahead of the registry", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		address := stubCatalog(t, ctx)
+		index := currentIndex(t, address+"/v1/services/orders/instances")
+
+		start := time.Now()
+		var instances []discovery.InstanceResponse
+		response := getJson(t, address+"/v1/services/orders/instances?wait=5s&index="+strconv.FormatUint(index+100, 10), "", &instances)
+
+		assert.Less(t, time.Since(start), 4*time.Second)
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		assert.Equal(t, strconv.FormatUint(index, 10), response.Header.Get("X-Duller-Index"))
+		assert.Equal(t, []string{"orders1", "orders2"}, instanceIds(instances))
+	})
+
+	t.Run("SHOULD return bad request WHEN the index is invalid", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		address := stubCatalog(t, ctx)
+
+		var services []discovery.ServiceResponse
+		response := getJson(t, address+"/v1/services?index=abc", "", &services)
+		assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+	})
+}
+
+func Test_MuxRouter_WatchEvents(t *testing.T) {
+	t.Run("SHOULD stream register, health change and deregister events WHEN the instances of the path change", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		address := stubCatalog(t, ctx)
+		currentIndex(t, address+"/v1/services/orders/instances")
+
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, address+"/v1/events?path=orders", nil)
+		response, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		defer response.Body.Close()
+		assert.Equal(t, "text/event-stream", response.Header.Get("Content-Type"))
+		reader := bufio.NewReader(response.Body)
+
+		// events of other paths are not streamed
+		sendHeartbeat(t, address, "", discovery.HeartBeatMessage{ServiceId: "users2", Path: "/users", IP: "127.0.0.1", Port: "4004"})
+		sendHeartbeat(t, address, "", discovery.HeartBeatMessage{ServiceId: "orders3", Path: "/orders", IP: "127.0.0.1", Port: "4003"})
+		eventType, event := readEvent(t, reader)
+		assert.Equal(t, "register", eventType)
+		assert.Equal(t, "orders3", event.Instance.ServiceId)
+
+		sendStatus(t, http.MethodPut, address+"/admin/services/orders3/status", "", discovery.StatusMessage{Status: service.StatusOutOfService})
+		eventType, event = readEvent(t, reader)
+		assert.Equal(t, "health-change", eventType)
+		assert.Equal(t, service.StatusOutOfService, event.Instance.Status)
+
+		sendDeregister(t, address+"/services/orders/orders3", "")
+		eventType, event = readEvent(t, reader)
+		assert.Equal(t, "deregister", eventType)
+		assert.Equal(t, "orders3", event.Instance.ServiceId)
+	})
+}
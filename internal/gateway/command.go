@@ -4,8 +4,11 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/anjolaoluwaakindipe/duller/internal/balancer"
+	"github.com/anjolaoluwaakindipe/duller/internal/proxy"
 	"github.com/anjolaoluwaakindipe/duller/internal/utils"
 )
 
@@ -14,9 +17,19 @@ type GateCommand struct {
 	gatewayHearbeatInterval time.Duration
 	gatewayPort             string
 	gatewayGracefullWait    time.Duration
-	discoveryServicePath    string
+	gatewaySyncWait         time.Duration
 	discoveryHost           string
 	discoveryPort           string
+	discoveryPeers          string
+	stripPrefixes           string
+	rewrites                string
+	routeFile               string
+	hashKey                 string
+	zone                    string
+	zoneMinLocalPercent     int
+	retries                 proxy.RetryPolicy
+	breakers                proxy.BreakerConfig
+	slowStart               balancer.SlowStartConfig
 }
 
 func (gc *GateCommand) Name() string {
@@ -30,11 +43,32 @@ func (gc *GateCommand) Init(args ...string) error {
 		fmt.Printf("\n\n")
 	}
 	gc.fs.DurationVar(&gc.gatewayHearbeatInterval, "rheartbeat", utils.HEARTBEAT_INTERVAL, "The interval of heartbeats expected.")
-	gc.fs.StringVar(&gc.discoveryServicePath, "dservice_path", utils.DISCOVERY_SERVICE_PATH, "Path for proxying users.")
+	gc.fs.DurationVar(&gc.gatewaySyncWait, utils.GATEWAY_SYNC_WAIT_FLAG, utils.GATEWAY_SYNC_WAIT, "The longest time the discovery server may hold a sync request of the gateway when nothing changes - e.g. 30s or 1m")
 	gc.fs.DurationVar(&gc.gatewayGracefullWait, "gwait", utils.GATEWAY_GRACEFULL_WAIT, "the duration for which the server gracefully wait for existing connections to finish - e.g. 15s or 1m")
 	gc.fs.StringVar(&gc.gatewayPort, "gport", utils.GATEWAY_PORT, "The PORT number the gateway should run on.")
 	gc.fs.StringVar(&gc.discoveryPort, "dport", utils.DISCOVERY_PORT, "The PORT number the discovery server is running on.")
 	gc.fs.StringVar(&gc.discoveryHost, "dhost", utils.DISCOVERY_HOST, "The IP Address/Host of the discovery server.")
+	gc.fs.StringVar(&gc.discoveryPeers, utils.DISCOVERY_PEERS_FLAG, utils.DISCOVERY_PEERS, "Comma separated addresses (host:port) of peer discovery servers to fail over to.")
+	gc.fs.StringVar(&gc.stripPrefixes, utils.GATEWAY_STRIP_PREFIX_FLAG, utils.GATEWAY_STRIP_PREFIX, "Comma separated service paths whose prefix is removed from forwarded requests - e.g. /orders,/users")
+	gc.fs.StringVar(&gc.rewrites, utils.GATEWAY_REWRITE_FLAG, utils.GATEWAY_REWRITE, "Comma separated service paths whose prefix is replaced in forwarded requests - e.g. /orders=/api/orders")
+	gc.fs.StringVar(&gc.routeFile, utils.GATEWAY_ROUTE_FILE_FLAG, utils.GATEWAY_ROUTE_FILE, "YAML or JSON file of routing rules, reloaded when it changes or on SIGHUP.")
+	gc.fs.StringVar(&gc.hashKey, utils.GATEWAY_HASH_KEY_FLAG, utils.BALANCER_HASH_KEY, "Request attribute services asking for the consistent-hash strategy are picked by. One of header:name, cookie:name, query:name or ip")
+	gc.fs.StringVar(&gc.zone, utils.GATEWAY_ZONE_FLAG, utils.GATEWAY_ZONE, "Zone of the gateway. Services registered in the same zone are preferred.")
+	gc.fs.IntVar(&gc.zoneMinLocalPercent, utils.GATEWAY_ZONE_MIN_LOCAL_FLAG, utils.ZONE_MIN_LOCAL_PERCENT, "Percentage of the services of a path in the zone of the caller that must be available before requests spill over to other zones.")
+	gc.fs.IntVar(&gc.retries.Retries, utils.GATEWAY_RETRIES_FLAG, utils.PROXY_RETRIES, "Number of times a request that fails is retried on another service. Requests with an idempotent method are retried after any error, others only when the connection was refused.")
+	gc.fs.DurationVar(&gc.retries.PerTryTimeout, utils.GATEWAY_RETRY_TIMEOUT_FLAG, utils.PROXY_RETRY_TIMEOUT, "The time a service has to answer a single attempt of a request. Attempts are not bounded when it is 0 - e.g. 2s")
+	gc.fs.IntVar(&gc.retries.BudgetPercent, utils.GATEWAY_RETRY_BUDGET_FLAG, utils.PROXY_RETRY_BUDGET, "Highest percentage of the requests of the last 10s that can be retries.")
+	gc.fs.Int64Var(&gc.retries.MaxBodyBytes, utils.GATEWAY_RETRY_MAX_BODY_FLAG, utils.PROXY_RETRY_MAX_BODY, "Largest request body in bytes buffered so it can be retried. Requests with larger bodies are never retried.")
+	gc.fs.IntVar(&gc.breakers.ErrorPercent, utils.GATEWAY_BREAKER_ERRORS_FLAG, utils.BREAKER_ERROR_PERCENT, "Percentage of the requests of a path that must fail within the breaker window to open its circuit. Circuit breakers are disabled when it is 0.")
+	gc.fs.DurationVar(&gc.breakers.SlowCall, utils.GATEWAY_BREAKER_SLOW_FLAG, utils.BREAKER_SLOW_CALL, "Latency above which a request counts as failed for its circuit breaker. Latency is ignored when it is 0 - e.g. 2s")
+	gc.fs.DurationVar(&gc.breakers.Window, utils.GATEWAY_BREAKER_WINDOW_FLAG, utils.BREAKER_WINDOW, "How far back requests are counted by circuit breakers - e.g. 10s")
+	gc.fs.IntVar(&gc.breakers.MinRequests, utils.GATEWAY_BREAKER_MIN_FLAG, utils.BREAKER_MIN_REQUESTS, "Number of requests within the breaker window needed before a circuit opens.")
+	gc.fs.DurationVar(&gc.breakers.OpenTime, utils.GATEWAY_BREAKER_OPEN_FLAG, utils.BREAKER_OPEN_TIME, "How long an open circuit fails requests before letting probes through - e.g. 30s")
+	gc.fs.IntVar(&gc.breakers.Probes, utils.GATEWAY_BREAKER_PROBES_FLAG, utils.BREAKER_PROBES, "Number of probe requests a half-open circuit lets through. It closes once they all succeed.")
+	gc.fs.BoolVar(&gc.breakers.PerInstance, utils.GATEWAY_BREAKER_SERVICE_FLAG, utils.BREAKER_PER_INSTANCE, "Give every service its own circuit breaker as well as its path. Requests skip services whose circuit is open.")
+	gc.fs.DurationVar(&gc.slowStart.Window, utils.GATEWAY_SLOW_START_FLAG, utils.SLOW_START_WINDOW, "How long a newly registered service takes to get its full share of requests. Slow start is disabled when it is 0 - e.g. 1m")
+	gc.fs.Float64Var(&gc.slowStart.Aggression, utils.GATEWAY_SLOW_START_CURVE_FLAG, utils.SLOW_START_AGGRESSION, "Shape of the slow start ramp. 1 is linear and higher values give new services more requests early on.")
+	gc.fs.IntVar(&gc.slowStart.MinPercent, utils.GATEWAY_SLOW_START_MIN_FLAG, utils.SLOW_START_MIN_PERCENT, "Percentage of its full share of requests a service gets as soon as it registers.")
 	return gc.fs.Parse(args)
 }
 
@@ -44,10 +78,52 @@ func (gc *GateCommand) UsageInfo() {
 }
 
 func (gc *GateCommand) Run() error {
+	routes, err := parseRoutes(gc.stripPrefixes, gc.rewrites)
+	if err != nil {
+		return err
+	}
+
+	hashKey, err := balancer.ParseHashKey(gc.hashKey)
+	if err != nil {
+		return err
+	}
+
+	if gc.zoneMinLocalPercent < 0 || gc.zoneMinLocalPercent > 100 {
+		return fmt.Errorf("%v must be between 0 and 100, got %v", utils.GATEWAY_ZONE_MIN_LOCAL_FLAG, gc.zoneMinLocalPercent)
+	}
+
+	gc.retries.MinRetriesPerSecond = utils.PROXY_RETRY_MIN
+	if err := gc.retries.Validate(); err != nil {
+		return err
+	}
+
+	if err := gc.breakers.Validate(); err != nil {
+		return err
+	}
+
+	if err := gc.slowStart.Validate(); err != nil {
+		return err
+	}
+
+	var routeTable *RouteTable
+	if len(gc.routeFile) > 0 {
+		if routeTable, err = LoadRouteFile(gc.routeFile); err != nil {
+			return err
+		}
+	}
+
 	gatewayRouter := InitMuxRouter(
+		WithRoutes(routes...),
+		WithRouteFile(gc.routeFile, routeTable),
 		WithDiscoveryHost(gc.discoveryHost),
 		WithDiscoveryPort(gc.discoveryPort),
-		WithDiscoveryPath(gc.discoveryServicePath),
+		WithSyncWait(gc.gatewaySyncWait),
+		WithHashKey(hashKey),
+		WithSlowStart(gc.slowStart),
+		WithZone(gc.zone, gc.zoneMinLocalPercent),
+		WithRetryPolicy(gc.retries),
+		WithBreakers(gc.breakers),
+		WithDiscoveryPeers(strings.Split(gc.discoveryPeers, ",")),
 	)
 
 	InitGateway(gatewayRouter, GatewaySetting{
@@ -0,0 +1,53 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// failoverDialer dials the first reachable address out of a list of discovery
+// servers. The last address that was reachable is tried first on the next dial, so
+// connections stick to one discovery server until it becomes unreachable.
+//
+// Failing over at dial time is safe for every request since nothing has been sent
+// to the unreachable server yet.
+type failoverDialer struct {
+	addresses []string
+	current   atomic.Int32
+	dialer    *net.Dialer
+}
+
+// DialContext ignores the address it is given and dials the discovery servers instead.
+func (fd *failoverDialer) DialContext(ctx context.Context, network string, _ string) (net.Conn, error) {
+	start := int(fd.current.Load())
+	errs := make([]error, 0)
+
+	for i := range fd.addresses {
+		index := (start + i) % len(fd.addresses)
+		conn, err := fd.dialer.DialContext(ctx, network, fd.addresses[index])
+		if err == nil {
+			fd.current.Store(int32(index))
+			return conn, nil
+		}
+		errs = append(errs, err)
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+// newFailoverTransport creates an http transport whose connections fail over
+// between the given discovery server addresses (host:port).
+func newFailoverTransport(addresses []string) *http.Transport {
+	dialer := &failoverDialer{
+		addresses: addresses,
+		dialer:    &net.Dialer{Timeout: 2 * time.Second, KeepAlive: 30 * time.Second},
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	return transport
+}
@@ -0,0 +1,47 @@
+package gateway_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/anjolaoluwaakindipe/duller/internal/gateway"
+	"github.com/stretchr/testify/assert"
+)
+
+// unusedAddress returns an address nothing is listening on
+func unusedAddress(t *testing.T) (string, string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	address := listener.Addr().String()
+	listener.Close()
+	host, port, _ := net.SplitHostPort(address)
+	return host, port
+}
+
+func Test_MuxRouter_DiscoveryFailover(t *testing.T) {
+	t.Run("SHOULD sync instances from a peer discovery server WHEN the main discovery server is unreachable", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		peer := stubDiscovery(t, ctx)
+		registerInstance(t, peer.URL, "orders1", "/orders", stubInstance(t, "orders1"))
+
+		host, port := unusedAddress(t)
+		router := gateway.InitMuxRouter(
+			gateway.WithDiscoveryHost(host),
+			gateway.WithDiscoveryPort(port),
+			gateway.WithDiscoveryPeers([]string{peer.Listener.Addr().String()}),
+			gateway.WithSyncWait(time.Second),
+		)
+		router.RegisterRoutes()
+		go router.SyncRegistry(ctx)
+
+		assert.Eventually(t, func() bool {
+			code, body := get(router.GetRouter(), "/orders")
+			return code == http.StatusOK && body == "orders1"
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+}
@@ -22,6 +22,12 @@ type GatewaySetting struct {
 func InitGateway(router Router, settings GatewaySetting) {
 	log.SetFlags(log.LstdFlags | log.Llongfile)
 	router.RegisterRoutes()
+
+	syncCtx, stopSync := context.WithCancel(context.Background())
+	defer stopSync()
+	go router.SyncRegistry(syncCtx)
+	go router.WatchRoutes(syncCtx)
+
 	server := &http.Server{
 		Addr:         fmt.Sprintf("0.0.0.0:%v", settings.GATEWAY_PORT),
 		WriteTimeout: time.Second * 15,
@@ -34,7 +40,7 @@ func InitGateway(router Router, settings GatewaySetting) {
 		slog.Info(fmt.Sprintf("Gateway server starting on port %v... \n", settings.GATEWAY_PORT))
 		err := server.ListenAndServe()
 		if err != http.ErrServerClosed {
-			slog.Error("Gateway Server could not be started: %v \n", err)
+			slog.Error(fmt.Sprintf("Gateway Server could not be started: %v \n", err))
 		}
 	}()
 
@@ -48,6 +54,7 @@ func InitGateway(router Router, settings GatewaySetting) {
 	defer cancel()
 
 	server.Shutdown(ctx)
+	stopSync()
 
 	slog.Info("Shutting down gateway server")
 	os.Exit(0)
@@ -0,0 +1,80 @@
+package gateway
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// names of the middleware a route of the route file can use
+const (
+	requestIdMiddleware = "request-id"
+	corsMiddleware      = "cors"
+	accessLogMiddleware = "access-log"
+)
+
+const requestIdHeader = "X-Request-Id"
+
+// routeMiddleware holds the middleware a route of the route file can use by name
+var routeMiddleware = map[string]mux.MiddlewareFunc{
+	requestIdMiddleware: requestId,
+	corsMiddleware:      cors,
+	accessLogMiddleware: accessLog,
+}
+
+// requestId gives every request without an X-Request-Id header a new id and sends
+// the id back to the client
+func requestId(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIdHeader)
+		if len(id) == 0 {
+			id = uuid.NewString()
+			r.Header.Set(requestIdHeader, id)
+		}
+		w.Header().Set(requestIdHeader, id)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// cors allows requests from any origin and answers preflight requests itself
+func cors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		if r.Method == http.MethodOptions && len(r.Header.Get("Access-Control-Request-Method")) > 0 {
+			w.Header().Set("Access-Control-Allow-Methods", r.Header.Get("Access-Control-Request-Method"))
+			if headers := r.Header.Get("Access-Control-Request-Headers"); len(headers) > 0 {
+				w.Header().Set("Access-Control-Allow-Headers", headers)
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// statusRecorder remembers the status code written to a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	sr.status = status
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+// accessLog logs every request with the status code and duration of its response
+func accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		// the path may be rewritten before the request is forwarded
+		uri := r.URL.RequestURI()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		slog.Info(fmt.Sprintf("%v %v %v %v", r.Method, uri, recorder.status, time.Since(start)))
+	})
+}
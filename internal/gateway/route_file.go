@@ -0,0 +1,457 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/anjolaoluwaakindipe/duller/internal/registry"
+	"github.com/anjolaoluwaakindipe/duller/internal/service"
+	"github.com/anjolaoluwaakindipe/duller/internal/utils"
+	"github.com/gorilla/mux"
+	"github.com/invopop/validation"
+	"gopkg.in/yaml.v3"
+)
+
+// how often the route file is checked for changes
+const routeFileInterval = time.Second
+
+// RouteMatch describes the requests a RouteRule applies to. Every field that is
+// set must match.
+type RouteMatch struct {
+	// Host matches the host of the request without its port. A leading *. matches
+	// any subdomain, so *.example.com matches api.example.com.
+	Host string `json:"host,omitempty" yaml:"host,omitempty"`
+	// PathPrefix matches request paths starting with its segments. It may hold
+	// parameter segments and a wildcard like service paths.
+	PathPrefix string `json:"pathPrefix" yaml:"pathPrefix"`
+	// Methods matches any of the listed http methods
+	Methods []string `json:"methods,omitempty" yaml:"methods,omitempty"`
+	// Headers matches requests holding every listed header with the given value.
+	// An empty value only requires the header to be present.
+	Headers map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+	// Query matches requests holding every listed query parameter with the given
+	// value. An empty value only requires the parameter to be present.
+	Query map[string]string `json:"query,omitempty" yaml:"query,omitempty"`
+	// Claims matches requests whose bearer token is a JWT holding every listed claim
+	// with the given value. Nested claims are named with dots, such as org.plan, and
+	// a claim holding a list matches when any of its items does. The signature of
+	// the token is not verified, so claims must not be trusted for access control.
+	Claims map[string]string `json:"claims,omitempty" yaml:"claims,omitempty"`
+}
+
+// RouteSubset limits the services of a path a RouteRule sends requests to. Empty
+// fields match every service.
+type RouteSubset struct {
+	// Tags must all be carried by a service
+	Tags []string `json:"tags,omitempty" yaml:"tags,omitempty"`
+	// Version is either a full version or a prefix such as "1" or "1.4"
+	Version string `json:"version,omitempty" yaml:"version,omitempty"`
+	Zone    string `json:"zone,omitempty" yaml:"zone,omitempty"`
+	// Metadata keys must all be set to the given values
+	Metadata map[string]string `json:"metadata,omitempty" yaml:"metadata,omitempty"`
+}
+
+// RouteRule routes the requests it matches to the services of a path
+type RouteRule struct {
+	// Name identifies the rule in logs and error messages
+	Name  string     `json:"name,omitempty" yaml:"name,omitempty"`
+	Match RouteMatch `json:"match" yaml:"match"`
+	// Service is the service path requests are forwarded to. It defaults to the
+	// PathPrefix of Match.
+	Service string `json:"service,omitempty" yaml:"service,omitempty"`
+	// StripPrefix and Rewrite change the forwarded path like a Route of PathPrefix
+	StripPrefix bool   `json:"stripPrefix,omitempty" yaml:"stripPrefix,omitempty"`
+	Rewrite     string `json:"rewrite,omitempty" yaml:"rewrite,omitempty"`
+	// Timeout bounds how long the service may take to answer, e.g. 5s
+	Timeout string `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	// Middleware lists the middleware requests go through in order, out of
+	// request-id, cors and access-log
+	Middleware []string `json:"middleware,omitempty" yaml:"middleware,omitempty"`
+	// Subset limits the services requests are sent to, e.g. to a pool dedicated to
+	// some tenants. Requests are answered with 503 when none of them is available.
+	Subset RouteSubset `json:"subset,omitempty" yaml:"subset,omitempty"`
+}
+
+// routeFile is the content of a route file
+type routeFile struct {
+	Routes []RouteRule `json:"routes" yaml:"routes"`
+}
+
+var httpMethods = []interface{}{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+	http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace,
+}
+
+// isAbsolutePath accepts an empty path or one starting with /
+func isAbsolutePath(value interface{}) error {
+	path, _ := value.(string)
+	if len(path) > 0 && path[0] != '/' {
+		return fmt.Errorf("must start with '/'")
+	}
+	return nil
+}
+
+func isValidRoutePath(value interface{}) error {
+	path, _ := value.(string)
+	return registry.ValidatePath(path)
+}
+
+func isDuration(value interface{}) error {
+	duration, _ := value.(string)
+	if len(duration) == 0 {
+		return nil
+	}
+	timeout, err := time.ParseDuration(duration)
+	if err != nil || timeout <= 0 {
+		return fmt.Errorf("must be a positive duration such as 5s")
+	}
+	return nil
+}
+
+func isKnownMiddleware(value interface{}) error {
+	name, _ := value.(string)
+	if _, exists := routeMiddleware[name]; !exists {
+		return fmt.Errorf("unknown middleware '%v'", name)
+	}
+	return nil
+}
+
+// hasNoBlankNames rejects maps holding a blank name
+func hasNoBlankNames(kind string) validation.RuleFunc {
+	return func(value interface{}) error {
+		values, _ := value.(map[string]string)
+		for name := range values {
+			if len(strings.TrimSpace(name)) == 0 {
+				return fmt.Errorf("%v names cannot be blank", kind)
+			}
+		}
+		return nil
+	}
+}
+
+// isHttpMethod accepts http methods in any case
+func isHttpMethod(value interface{}) error {
+	method, _ := value.(string)
+	return validation.In(httpMethods...).Validate(strings.ToUpper(method))
+}
+
+// Validate implements validation.Validatable
+func (rm RouteMatch) Validate() error {
+	return validation.ValidateStruct(&rm,
+		validation.Field(&rm.PathPrefix, validation.Required, validation.By(isAbsolutePath), validation.By(isValidRoutePath)),
+		validation.Field(&rm.Methods, validation.Each(validation.By(isHttpMethod))),
+		validation.Field(&rm.Headers, validation.By(hasNoBlankNames("header"))),
+		validation.Field(&rm.Query, validation.By(hasNoBlankNames("query parameter"))),
+		validation.Field(&rm.Claims, validation.By(hasNoBlankNames("claim"))),
+	)
+}
+
+// Validate implements validation.Validatable
+func (rs RouteSubset) Validate() error {
+	return validation.ValidateStruct(&rs,
+		validation.Field(&rs.Tags, validation.Each(validation.Required)),
+		validation.Field(&rs.Metadata, validation.By(hasNoBlankNames("metadata"))),
+	)
+}
+
+// selector returns the service.Selector matching the services of the subset
+func (rs RouteSubset) selector() service.Selector {
+	return service.Selector{Tags: rs.Tags, Version: rs.Version, Zone: rs.Zone, Metadata: rs.Metadata}
+}
+
+// Validate implements validation.Validatable
+func (rr RouteRule) Validate() error {
+	return validation.ValidateStruct(&rr,
+		validation.Field(&rr.Match),
+		validation.Field(&rr.Service, validation.By(isAbsolutePath), validation.By(isValidRoutePath)),
+		validation.Field(&rr.Rewrite, validation.By(isAbsolutePath)),
+		validation.Field(&rr.Timeout, validation.By(isDuration)),
+		validation.Field(&rr.Middleware, validation.Each(validation.By(isKnownMiddleware))),
+		validation.Field(&rr.Subset),
+	)
+}
+
+// routeRule is a validated RouteRule ready to match requests
+type routeRule struct {
+	name       string
+	host       string
+	prefix     *registry.PathTrie
+	methods    map[string]bool
+	headers    map[string]string
+	query      map[string]string
+	claims     map[string]string
+	service    string
+	subset     service.Selector
+	forward    Route
+	timeout    time.Duration
+	middleware []mux.MiddlewareFunc
+}
+
+// RouteTable holds the rules of a route file. Requests are routed by the first
+// rule that matches them.
+type RouteTable struct {
+	rules []*routeRule
+}
+
+// ParseRouteTable parses and validates the rules of a route file. Content of files
+// with a .json extension is read as json and anything else as yaml.
+func ParseRouteTable(data []byte, fileName string) (*RouteTable, error) {
+	var file routeFile
+	if strings.EqualFold(filepath.Ext(fileName), ".json") {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&file); err != nil {
+			return nil, fmt.Errorf("%v: %v", fileName, err)
+		}
+	} else {
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&file); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("%v: %v", fileName, err)
+		}
+	}
+
+	table := &RouteTable{rules: make([]*routeRule, 0, len(file.Routes))}
+	for index, rule := range file.Routes {
+		if err := rule.Validate(); err != nil {
+			if len(rule.Name) > 0 {
+				return nil, fmt.Errorf("%v: routes[%v] (%v): %v", fileName, index, rule.Name, err)
+			}
+			return nil, fmt.Errorf("%v: routes[%v]: %v", fileName, index, err)
+		}
+		table.rules = append(table.rules, compileRouteRule(rule))
+	}
+	return table, nil
+}
+
+// LoadRouteFile reads, parses and validates a route file
+func LoadRouteFile(path string) (*RouteTable, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseRouteTable(data, path)
+}
+
+// compileRouteRule turns a validated RouteRule into a routeRule
+func compileRouteRule(rule RouteRule) *routeRule {
+	compiled := &routeRule{
+		name:       rule.Name,
+		host:       strings.ToLower(rule.Match.Host),
+		prefix:     registry.NewPathTrie(),
+		methods:    make(map[string]bool),
+		headers:    rule.Match.Headers,
+		query:      rule.Match.Query,
+		claims:     rule.Match.Claims,
+		service:    rule.Service,
+		subset:     rule.Subset.selector(),
+		forward:    Route{Path: rule.Match.PathPrefix, StripPrefix: rule.StripPrefix, Rewrite: rule.Rewrite},
+		middleware: make([]mux.MiddlewareFunc, 0, len(rule.Middleware)),
+	}
+
+	compiled.prefix.Insert(rule.Match.PathPrefix)
+	if len(rule.Rewrite) > 0 {
+		utils.MakeUrlPathValid(&compiled.forward.Rewrite)
+		// rewriting to the root is the same as stripping the prefix
+		compiled.forward.StripPrefix = compiled.forward.StripPrefix || len(compiled.forward.Rewrite) == 0
+	}
+	if len(compiled.service) == 0 {
+		compiled.service = rule.Match.PathPrefix
+	}
+	for _, method := range rule.Match.Methods {
+		compiled.methods[strings.ToUpper(method)] = true
+	}
+	if len(rule.Timeout) > 0 {
+		compiled.timeout, _ = time.ParseDuration(rule.Timeout)
+	}
+	for _, name := range rule.Middleware {
+		compiled.middleware = append(compiled.middleware, routeMiddleware[name])
+	}
+	return compiled
+}
+
+// match returns the first rule matching the request
+func (rt *RouteTable) match(r *http.Request) (*routeRule, bool) {
+	if rt == nil {
+		return nil, false
+	}
+	for _, rule := range rt.rules {
+		if rule.matches(r) {
+			return rule, true
+		}
+	}
+	return nil, false
+}
+
+func (rr *routeRule) matches(r *http.Request) bool {
+	if len(rr.host) > 0 && !matchesHost(rr.host, r.Host) {
+		return false
+	}
+	if len(rr.methods) > 0 && !rr.methods[r.Method] {
+		return false
+	}
+	for name, value := range rr.headers {
+		values, exists := r.Header[http.CanonicalHeaderKey(name)]
+		if !exists || (len(value) > 0 && values[0] != value) {
+			return false
+		}
+	}
+	if len(rr.query) > 0 {
+		query := r.URL.Query()
+		for name, value := range rr.query {
+			values, exists := query[name]
+			if !exists || (len(value) > 0 && values[0] != value) {
+				return false
+			}
+		}
+	}
+	if len(rr.claims) > 0 {
+		claims, ok := bearerClaims(r)
+		if !ok {
+			return false
+		}
+		for name, value := range rr.claims {
+			if !claimMatches(claims, name, value) {
+				return false
+			}
+		}
+	}
+	_, found := rr.prefix.Match(r.URL.Path)
+	return found
+}
+
+// bearerClaims returns the claims of the JWT the request carries as a bearer token
+// without verifying its signature
+func bearerClaims(r *http.Request) (map[string]interface{}, bool) {
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found {
+		return nil, false
+	}
+	parts := strings.Split(strings.TrimSpace(token), ".")
+	if len(parts) != 3 {
+		return nil, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, false
+	}
+
+	// numbers are kept as written so they compare with the values of the rule
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	var claims map[string]interface{}
+	if err := decoder.Decode(&claims); err != nil {
+		return nil, false
+	}
+	return claims, true
+}
+
+// claimMatches reports whether the claim has the value, or is present when the
+// value is empty. A claim named with dots is looked up as is before being read as
+// a path to a nested claim, since namespaced claims are often urls.
+func claimMatches(claims map[string]interface{}, name string, value string) bool {
+	claim, exists := claims[name]
+	if !exists {
+		var current interface{} = claims
+		for _, key := range strings.Split(name, ".") {
+			object, ok := current.(map[string]interface{})
+			if !ok {
+				return false
+			}
+			if current, ok = object[key]; !ok {
+				return false
+			}
+		}
+		claim = current
+	}
+
+	if len(value) == 0 {
+		return true
+	}
+	if items, ok := claim.([]interface{}); ok {
+		for _, item := range items {
+			if fmt.Sprint(item) == value {
+				return true
+			}
+		}
+		return false
+	}
+	return fmt.Sprint(claim) == value
+}
+
+func matchesHost(pattern string, host string) bool {
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	host = strings.ToLower(host)
+
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(host, pattern[1:])
+	}
+	return host == pattern
+}
+
+// handler wraps the handler forwarding a request in the middleware of the rule
+func (rr *routeRule) handler(next http.Handler) http.Handler {
+	for i := len(rr.middleware) - 1; i >= 0; i-- {
+		next = rr.middleware[i](next)
+	}
+	return next
+}
+
+// watchRouteFile reloads the route file whenever it changes or the gateway receives
+// SIGHUP and passes every valid route table to apply. Invalid route files are
+// logged and the last valid route table stays in use.
+func watchRouteFile(ctx context.Context, path string, apply func(*RouteTable)) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	ticker := time.NewTicker(routeFileInterval)
+	defer ticker.Stop()
+
+	lastModified := modTime(path)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+		case <-ticker.C:
+			modified := modTime(path)
+			if modified.Equal(lastModified) {
+				continue
+			}
+			lastModified = modified
+		}
+
+		table, err := LoadRouteFile(path)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Could not reload route file, keeping the current routes: %v", err))
+			continue
+		}
+		apply(table)
+		slog.Info(fmt.Sprintf("Reloaded %v routes from %v", len(table.rules), path))
+	}
+}
+
+func modTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
@@ -0,0 +1,275 @@
+package gateway_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/anjolaoluwaakindipe/duller/internal/discovery"
+	"github.com/anjolaoluwaakindipe/duller/internal/gateway"
+	"github.com/stretchr/testify/assert"
+)
+
+const stubRouteFile = `
+routes:
+  - name: admin
+    match:
+      host: admin.example.com
+      pathPrefix: /orders
+    service: /orders/archive
+  - name: writes
+    match:
+      pathPrefix: /api/orders
+      methods: [post]
+      headers:
+        X-Tenant: acme
+    service: /orders
+    stripPrefix: true
+    middleware: [request-id]
+  - name: reads
+    match:
+      pathPrefix: /api/orders
+    service: /orders
+    rewrite: /v1
+    timeout: 100ms
+`
+
+// stubSlowInstance starts a service answering every request after the delay
+func stubSlowInstance(t *testing.T, delay time.Duration) *url.URL {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(server.Close)
+	address, _ := url.Parse(server.URL)
+	return address
+}
+
+// stubRouteFileGateway starts a discovery server with services on /orders and
+// /orders/archive and a gateway routing with the route file
+func stubRouteFileGateway(t *testing.T, ctx context.Context, routeFile string) (*httptest.Server, http.Handler) {
+	discoveryServer := stubDiscovery(t, ctx)
+	registerInstance(t, discoveryServer.URL, "orders1", "/orders", stubEchoInstance(t, "orders1"))
+	registerInstance(t, discoveryServer.URL, "archive1", "/orders/archive", stubEchoInstance(t, "archive1"))
+
+	table, err := gateway.LoadRouteFile(routeFile)
+	assert.Nil(t, err)
+
+	host, port, _ := net.SplitHostPort(discoveryServer.Listener.Addr().String())
+	router := gateway.InitMuxRouter(
+		gateway.WithDiscoveryHost(host),
+		gateway.WithDiscoveryPort(port),
+		gateway.WithSyncWait(time.Second),
+		gateway.WithRouteFile(routeFile, table),
+	)
+	router.RegisterRoutes()
+	go router.SyncRegistry(ctx)
+	go router.WatchRoutes(ctx)
+
+	assert.Eventually(t, func() bool {
+		code, _ := get(router.GetRouter(), "/orders/archive")
+		return code == http.StatusOK
+	}, 5*time.Second, 10*time.Millisecond)
+	return discoveryServer, router.GetRouter()
+}
+
+// bearerToken returns an unsigned JWT holding the claims
+func bearerToken(claims string) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`))
+	return "Bearer " + header + "." + base64.RawURLEncoding.EncodeToString([]byte(claims)) + ".signature"
+}
+
+func writeRouteFile(t *testing.T, name string, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	assert.Nil(t, os.WriteFile(path, []byte(content), 0o644))
+	return path
+}
+
+func serve(handler http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func Test_ParseRouteTable(t *testing.T) {
+	t.Run("SHOULD parse the rules WHEN the file is valid yaml or json", func(t *testing.T) {
+		_, err := gateway.ParseRouteTable([]byte(stubRouteFile), "routes.yaml")
+		assert.Nil(t, err)
+
+		_, err = gateway.ParseRouteTable([]byte(`{"routes": [{"match": {"pathPrefix": "/orders"}, "timeout": "2s"}]}`), "routes.json")
+		assert.Nil(t, err)
+
+		_, err = gateway.ParseRouteTable([]byte(""), "routes.yaml")
+		assert.Nil(t, err)
+	})
+
+	t.Run("SHOULD point at the invalid field WHEN a rule is invalid", func(t *testing.T) {
+		_, err := gateway.ParseRouteTable([]byte("routes:\n  - match:\n      host: example.com\n"), "routes.yaml")
+		assert.ErrorContains(t, err, "routes.yaml: routes[0]: match: (pathPrefix: cannot be blank.)")
+
+		_, err = gateway.ParseRouteTable([]byte("routes:\n  - match:\n      pathPrefix: /orders\n  - name: slow\n    match:\n      pathPrefix: /users\n    timeout: soon\n"), "routes.yaml")
+		assert.ErrorContains(t, err, "routes[1] (slow): timeout: must be a positive duration")
+
+		_, err = gateway.ParseRouteTable([]byte("routes:\n  - match:\n      pathPrefix: orders\n      methods: [fetch]\n"), "routes.yaml")
+		assert.ErrorContains(t, err, "methods: (0: must be a valid value.)")
+		assert.ErrorContains(t, err, "pathPrefix: must start with '/'")
+
+		_, err = gateway.ParseRouteTable([]byte(`{"routes": [{"match": {"pathPrefix": "/orders"}, "middleware": ["gzip"]}]}`), "routes.json")
+		assert.ErrorContains(t, err, "middleware: (0: unknown middleware 'gzip'.)")
+
+		_, err = gateway.ParseRouteTable([]byte(`{"routes": [{"match": {"pathPrefix": "/orders", "query": {" ": "1"}, "claims": {"": "pro"}}}]}`), "routes.json")
+		assert.ErrorContains(t, err, "claims: claim names cannot be blank")
+		assert.ErrorContains(t, err, "query: query parameter names cannot be blank")
+
+		_, err = gateway.ParseRouteTable([]byte("routes:\n  - match:\n      pathPrefix: /orders\n    subset:\n      tags: [enterprise, '']\n"), "routes.yaml")
+		assert.ErrorContains(t, err, "subset: (tags: (1: cannot be blank.).)")
+	})
+
+	t.Run("SHOULD report the line WHEN a field is unknown", func(t *testing.T) {
+		_, err := gateway.ParseRouteTable([]byte("routes:\n  - match:\n      pathPrefix: /orders\n    retries: 3\n"), "routes.yaml")
+		assert.ErrorContains(t, err, "line 4: field retries not found")
+	})
+}
+
+func Test_MuxRouter_RouteFile(t *testing.T) {
+	t.Run("SHOULD route with the first matching rule WHEN rules match the host, method and headers", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		_, handler := stubRouteFileGateway(t, ctx, writeRouteFile(t, "routes.yaml", stubRouteFile))
+
+		req := httptest.NewRequest(http.MethodGet, "/orders/1", nil)
+		req.Host = "admin.example.com:5923"
+		assert.Equal(t, "archive1 /orders/1", serve(handler, req).Body.String())
+
+		req = httptest.NewRequest(http.MethodPost, "/api/orders/1", nil)
+		req.Header.Set("X-Tenant", "acme")
+		response := serve(handler, req)
+		assert.Equal(t, "orders1 /1", response.Body.String())
+		assert.NotEmpty(t, response.Header().Get("X-Request-Id"))
+
+		// the request misses the header of the writes rule
+		response = serve(handler, httptest.NewRequest(http.MethodPost, "/api/orders/1", nil))
+		assert.Equal(t, "orders1 /v1/1", response.Body.String())
+		assert.Empty(t, response.Header().Get("X-Request-Id"))
+
+		// requests no rule matches are routed by service path
+		_, body := get(handler, "/orders/archive/2023")
+		assert.Equal(t, "archive1 /orders/archive/2023", body)
+	})
+
+	t.Run("SHOULD answer gateway timeout WHEN the service is slower than the timeout of the rule", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		discoveryServer, handler := stubRouteFileGateway(t, ctx, writeRouteFile(t, "routes.yaml", `
+routes:
+  - match:
+      pathPrefix: /slow
+    timeout: 50ms
+`))
+		registerInstance(t, discoveryServer.URL, "slow1", "/slow", stubSlowInstance(t, time.Second))
+
+		assert.Eventually(t, func() bool {
+			code, _ := get(handler, "/slow")
+			return code == http.StatusGatewayTimeout
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("SHOULD use the new rules WHEN the route file changes", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		routeFile := writeRouteFile(t, "routes.json", `{"routes": []}`)
+		_, handler := stubRouteFileGateway(t, ctx, routeFile)
+
+		_, body := get(handler, "/orders/1")
+		assert.Equal(t, "orders1 /orders/1", body)
+
+		assert.Nil(t, os.WriteFile(routeFile, []byte(`{"routes": [{"match": {"pathPrefix": "/orders"}, "stripPrefix": true}]}`), 0o644))
+		later := time.Now().Add(time.Minute)
+		os.Chtimes(routeFile, later, later)
+
+		assert.Eventually(t, func() bool {
+			_, body := get(handler, "/orders/1")
+			return body == "orders1 /1"
+		}, 5*time.Second, 50*time.Millisecond)
+
+		// an invalid route file keeps the last valid rules
+		assert.Nil(t, os.WriteFile(routeFile, []byte(`{"routes": [{"match": {}}]}`), 0o644))
+		later = later.Add(time.Minute)
+		os.Chtimes(routeFile, later, later)
+
+		time.Sleep(1500 * time.Millisecond)
+		_, body = get(handler, "/orders/1")
+		assert.Equal(t, "orders1 /1", body)
+	})
+	t.Run("SHOULD route to the service and subset of the rule WHEN the query or the claims of the token match", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		discoveryServer, handler := stubRouteFileGateway(t, ctx, writeRouteFile(t, "routes.yaml", `
+routes:
+  - name: enterprise
+    match:
+      pathPrefix: /orders
+      claims:
+        org.plan: enterprise
+        roles: buyer
+    subset:
+      tags: [enterprise]
+  - name: mobile
+    match:
+      pathPrefix: /orders
+      query:
+        client: mobile
+    service: /orders/archive
+`))
+		address := stubEchoInstance(t, "enterprise1")
+		body, _ := json.Marshal(discovery.HeartBeatMessage{ServiceId: "enterprise1", Path: "/orders", IP: address.Hostname(), Port: address.Port(), Tags: []string{"enterprise"}})
+		response, err := http.Post(discoveryServer.URL+"/heartbeat", "application/json", bytes.NewBuffer(body))
+		assert.Nil(t, err)
+		response.Body.Close()
+
+		enterprise := httptest.NewRequest(http.MethodGet, "/orders/1", nil)
+		enterprise.Header.Set("Authorization", bearerToken(`{"sub":"42","org":{"plan":"enterprise"},"roles":["admin","buyer"]}`))
+		assert.Eventually(t, func() bool {
+			return serve(handler, enterprise).Body.String() == "enterprise1 /orders/1"
+		}, 5*time.Second, 10*time.Millisecond)
+		for i := 0; i < 4; i++ {
+			assert.Equal(t, "enterprise1 /orders/1", serve(handler, enterprise).Body.String())
+		}
+
+		// the claims of the token must all match
+		other := httptest.NewRequest(http.MethodGet, "/orders/1", nil)
+		other.Header.Set("Authorization", bearerToken(`{"org":{"plan":"free"},"roles":["buyer"]}`))
+		bodies := make(map[string]bool)
+		for i := 0; i < 4; i++ {
+			bodies[serve(handler, other).Body.String()] = true
+		}
+		assert.Equal(t, map[string]bool{"orders1 /orders/1": true, "enterprise1 /orders/1": true}, bodies)
+
+		_, mobile := get(handler, "/orders/1?client=mobile")
+		assert.Equal(t, "archive1 /orders/1", mobile)
+	})
+
+	t.Run("SHOULD answer service unavailable WHEN no service of the subset of the rule is available", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		_, handler := stubRouteFileGateway(t, ctx, writeRouteFile(t, "routes.json", `{"routes": [{"match": {"pathPrefix": "/orders", "headers": {"X-Tenant": "enterprise"}}, "subset": {"tags": ["enterprise"]}}]}`))
+
+		req := httptest.NewRequest(http.MethodGet, "/orders/1", nil)
+		req.Header.Set("X-Tenant", "enterprise")
+		assert.Equal(t, http.StatusServiceUnavailable, serve(handler, req).Code)
+
+		_, body := get(handler, "/orders/1")
+		assert.Equal(t, "orders1 /orders/1", body)
+	})
+}
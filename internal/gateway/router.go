@@ -1,11 +1,19 @@
 package gateway
 
 import (
+	"context"
 	"encoding/json"
-	"log"
+	"fmt"
+	"net"
 	"net/http"
 	"net/http/httputil"
+	"strings"
+	"sync/atomic"
+	"time"
 
+	"github.com/anjolaoluwaakindipe/duller/internal/balancer"
+	"github.com/anjolaoluwaakindipe/duller/internal/proxy"
+	"github.com/anjolaoluwaakindipe/duller/internal/registry"
 	"github.com/anjolaoluwaakindipe/duller/internal/utils"
 	"github.com/gorilla/mux"
 )
@@ -14,6 +22,12 @@ import (
 type Router interface {
 	RegisterRoutes()
 	GetRouter() http.Handler
+	// SyncRegistry keeps the instances the gateway routes to in sync with the
+	// discovery server. This is meant to be used in a goroutine
+	SyncRegistry(ctx context.Context)
+	// WatchRoutes reloads the route file of the gateway whenever it changes. This is
+	// meant to be used in a goroutine
+	WatchRoutes(ctx context.Context)
 }
 
 // MuxRouter this is a Gorilla Mux router implementation of the router needed for the gateway
@@ -21,45 +35,119 @@ type MuxRouter struct {
 	router        *mux.Router
 	discoveryHost string
 	discoveryPort string
-	discoveryPath string
+	// discoveryPeers are other discovery servers of the cluster the gateway fails over to
+	discoveryPeers []string
+	transport      http.RoundTripper
+	// registry is the local copy of the instances of the discovery server
+	registry registry.Registry
+	balancer balancer.LoadBalancer
+	// balancerOpts are passed to the load balancer of every path
+	balancerOpts []balancer.BalancerOpt
+	// zone is the zone of the gateway, whose services are preferred
+	zone            string
+	minLocalPercent int
+	syncer          *RegistrySyncer
+	// retries sets how requests that fail are retried on other services
+	retries   proxy.RetryPolicy
+	breakers  proxy.BreakerConfig
+	forwarder *proxy.Forwarder
+	syncWait  time.Duration
+	// routes holds the Route of every service path that has one
+	routes map[string]Route
+	// routeTable holds the rules of the route file, which win over routes
+	routeTable atomic.Pointer[RouteTable]
+	routeFile  string
 }
 
 // RegisterRoutes registers all handlers needed for the gateway
 func (mr *MuxRouter) RegisterRoutes() {
-	mr.router.HandleFunc("/{path}", mr.GetPath(utils.ProxyRequest))
+	mr.router.PathPrefix("/").HandlerFunc(mr.GetPath(utils.ProxyRequest))
 	mr.router.Use(mux.CORSMethodMiddleware(mr.router))
 }
 
-// GetPath takes in a path variable from the gateway url and proxies the request
-// to its associated service. It makes a request to the discovery server to fetch the right
-// address to proxy the request to
+// GetPath proxies the request to a service of the first rule of the route file that
+// matches it. Requests no rule matches go to a service of the longest registered path
+// the url starts with, so /orders/123/items reaches a service of /orders unless
+// /orders/123 is registered as well. The service is picked by the load balancer of the
+// gateway out of its local copy of the registry, so requests never go through the
+// discovery server
 func (mr *MuxRouter) GetPath(proxyfunc func(string) (*httputil.ReverseProxy, error)) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		vars := mux.Vars(r)
-		//  edit path
-		path, ok := vars["path"]
-		response := GatewayErrorMessage{}
-
-		if !ok {
-			response.Message = "No path specified"
-			response.Status = http.StatusBadRequest
-			jsonResponse, _ := json.Marshal(&response)
-			w.WriteHeader(response.Status)
-			w.Write(jsonResponse)
+		if rule, ok := mr.routeTable.Load().match(r); ok {
+			forward := rule.forward
+			handler := rule.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if rule.timeout > 0 {
+					ctx, cancel := context.WithTimeout(r.Context(), rule.timeout)
+					defer cancel()
+					r = r.WithContext(ctx)
+				}
+				if !rule.subset.IsEmpty() {
+					r = r.WithContext(balancer.WithSubset(r.Context(), rule.subset))
+				}
+				mr.forward(w, r, rule.service, &forward, proxyfunc)
+			}))
+			handler.ServeHTTP(w, r)
 			return
 		}
 
-		utils.MakeUrlPathValid(&path)
+		path, err := mr.registry.GetPathFromRequest(r.URL.Path)
 
-		r.URL.Path = mr.discoveryPath + r.URL.Path
-		proxy, err := proxyfunc("http://" + mr.discoveryHost + ":" + mr.discoveryPort)
 		if err != nil {
-			log.Printf("address of discovered service is invalid : %v", err)
+			writeGatewayError(w, http.StatusNotFound, fmt.Sprintf("No service registered for path '%v'", r.URL.Path))
 			return
 		}
 
-		proxy.ServeHTTP(w, r)
+		var forward *Route
+		if route, exists := mr.routes[path]; exists {
+			forward = &route
+		}
+		mr.forward(w, r, path, forward, proxyfunc)
+	}
+}
+
+// writeGatewayError answers the request with a GatewayErrorMessage
+func writeGatewayError(w http.ResponseWriter, status int, message string) {
+	jsonResponse, _ := json.Marshal(&GatewayErrorMessage{Message: message, Status: status})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(jsonResponse)
+}
+
+// forward proxies the request to a service of the path picked by the load balancer.
+// The forwarded path is changed by the route when there is one.
+func (mr *MuxRouter) forward(w http.ResponseWriter, r *http.Request, path string, route *Route, proxyfunc func(string) (*httputil.ReverseProxy, error)) {
+	serviceInfo, err := balancer.GetServiceForRequest(mr.balancer, path, r)
+	if err != nil {
+		writeGatewayError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	if serviceInfo == nil {
+		writeGatewayError(w, http.StatusServiceUnavailable, fmt.Sprintf("no healthy service available for path '%v'", path))
+		return
+	}
+
+	if route != nil {
+		r.URL.Path = route.forwardPath(r.URL.Path)
+		r.URL.RawPath = ""
+	}
+
+	mr.forwarder.Forward(w, r, path, serviceInfo, proxyfunc)
+}
+
+// WatchRoutes reloads the route file until the context is cancelled. In-flight
+// requests finish with the rules they started with.
+func (mr *MuxRouter) WatchRoutes(ctx context.Context) {
+	if len(mr.routeFile) == 0 {
+		return
 	}
+	watchRouteFile(ctx, mr.routeFile, mr.routeTable.Store)
+}
+
+// SyncRegistry keeps the local registry of the gateway in sync with the discovery
+// server until the context is cancelled.
+func (mr *MuxRouter) SyncRegistry(ctx context.Context) {
+	mr.syncer.Run(ctx)
 }
 
 func (mr *MuxRouter) GetRouter() http.Handler {
@@ -80,23 +168,120 @@ func WithDiscoveryPort(discoveryPort string) MuxRouterOpts {
 	}
 }
 
-func WithDiscoveryPath(discoveryPath string) MuxRouterOpts {
+// WithRoutes sets how the request paths of service paths are changed before
+// requests are forwarded
+func WithRoutes(routes ...Route) MuxRouterOpts {
+	return func(mr *MuxRouter) {
+		for _, route := range routes {
+			utils.MakeUrlPathValid(&route.Path)
+			mr.routes[route.Path] = route
+		}
+	}
+}
+
+// WithRouteFile routes requests with the rules of the route table, which was loaded
+// from the route file at path. WatchRoutes reloads the route file.
+func WithRouteFile(path string, table *RouteTable) MuxRouterOpts {
 	return func(mr *MuxRouter) {
-		mr.discoveryPath = discoveryPath
+		mr.routeFile = path
+		mr.routeTable.Store(table)
+	}
+}
+
+// WithSyncWait sets how long the discovery server may hold a sync request of the
+// gateway open before answering without changes
+func WithSyncWait(syncWait time.Duration) MuxRouterOpts {
+	return func(mr *MuxRouter) {
+		mr.syncWait = syncWait
+	}
+}
+
+// WithHashKey sets the request attribute services asking for consistent hashing
+// are picked by
+func WithHashKey(key balancer.HashKey) MuxRouterOpts {
+	return func(mr *MuxRouter) {
+		mr.balancerOpts = append(mr.balancerOpts, balancer.WithHashKey(key))
+	}
+}
+
+// WithSlowStart ramps up the share of requests of services newly registered with the
+// discovery server over the window of the config
+func WithSlowStart(config balancer.SlowStartConfig) MuxRouterOpts {
+	return func(mr *MuxRouter) {
+		mr.balancerOpts = append(mr.balancerOpts, balancer.WithSlowStart(balancer.NewSlowStart(utils.NewClock(), config)))
+	}
+}
+
+// WithRetryPolicy retries requests that fail on other services of their path as
+// the policy allows
+func WithRetryPolicy(policy proxy.RetryPolicy) MuxRouterOpts {
+	return func(mr *MuxRouter) {
+		mr.retries = policy
+	}
+}
+
+// WithBreakers fails requests fast with a GatewayErrorMessage while too many of
+// the recent requests to their path failed
+func WithBreakers(config proxy.BreakerConfig) MuxRouterOpts {
+	return func(mr *MuxRouter) {
+		mr.breakers = config
+	}
+}
+
+// WithZone makes the gateway prefer the services of its zone until fewer than
+// minLocalPercent of them are available. Requests may name another zone in the
+// X-Duller-Zone header.
+func WithZone(zone string, minLocalPercent int) MuxRouterOpts {
+	return func(mr *MuxRouter) {
+		mr.zone = zone
+		mr.minLocalPercent = minLocalPercent
+	}
+}
+
+// WithDiscoveryPeers sets the addresses (host:port) of other discovery servers
+// the gateway fails over to when the main discovery server is unreachable
+func WithDiscoveryPeers(peers []string) MuxRouterOpts {
+	return func(mr *MuxRouter) {
+		for _, peer := range peers {
+			peer = strings.TrimSpace(peer)
+			if len(peer) > 0 {
+				mr.discoveryPeers = append(mr.discoveryPeers, peer)
+			}
+		}
 	}
 }
 
 func InitMuxRouter(opts ...MuxRouterOpts) Router {
 	mr := &MuxRouter{
-		router:        mux.NewRouter(),
-		discoveryPort: "9876",
-		discoveryHost: "localhost",
-		discoveryPath: "/get-service/",
+		router:          mux.NewRouter(),
+		discoveryPort:   "9876",
+		discoveryHost:   "localhost",
+		syncWait:        utils.GATEWAY_SYNC_WAIT,
+		minLocalPercent: utils.ZONE_MIN_LOCAL_PERCENT,
+		routes:          make(map[string]Route),
+		registry:        registry.InitInMemoryRegistry(utils.NewClock()),
 	}
 
 	for _, opt := range opts {
 		opt(mr)
 	}
 
+	if len(mr.discoveryPeers) > 0 {
+		addresses := append([]string{net.JoinHostPort(mr.discoveryHost, mr.discoveryPort)}, mr.discoveryPeers...)
+		mr.transport = newFailoverTransport(addresses)
+	}
+
+	// services may ask for a strategy in their registration, which the gateway follows too
+	strategies := balancer.StrategyConfig{Default: balancer.RoundRobinStrategy, Zone: mr.zone, MinLocalPercent: mr.minLocalPercent}
+	// the splits operators set on the discovery server are synced along with the instances
+	splits := balancer.NewSplits()
+	mr.balancer = balancer.NewPathBalancer(mr.registry, strategies, append(mr.balancerOpts, balancer.WithSplits(splits))...)
+	mr.forwarder = proxy.NewForwarder(mr.balancer,
+		proxy.WithRetryPolicy(mr.retries),
+		proxy.WithBreakers(proxy.NewBreakers(utils.NewClock(), mr.breakers)),
+		proxy.WithErrorWriter(writeGatewayError),
+	)
+	mr.syncer = NewRegistrySyncer(mr.registry, "http://"+net.JoinHostPort(mr.discoveryHost, mr.discoveryPort), mr.syncWait, mr.transport, splits)
+
 	return mr
 }
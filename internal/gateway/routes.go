@@ -0,0 +1,102 @@
+package gateway
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/anjolaoluwaakindipe/duller/internal/utils"
+)
+
+// Route changes the path of the requests the gateway forwards to the services of
+// a path. Requests of paths without a Route are forwarded unchanged.
+type Route struct {
+	// Path is the service path the route applies to
+	Path string
+	// StripPrefix removes Path from the forwarded request path, so /orders/123
+	// reaches the services of /orders as /123
+	StripPrefix bool
+	// Rewrite replaces Path in the forwarded request path, so /orders/123 reaches
+	// the services of /orders as /api/orders/123 given /api/orders. It wins over
+	// StripPrefix.
+	Rewrite string
+}
+
+// forwardPath returns the path a request to requestPath is forwarded with once it
+// matched the service path of the route
+func (rc Route) forwardPath(requestPath string) string {
+	if !rc.StripPrefix && len(rc.Rewrite) == 0 {
+		return requestPath
+	}
+
+	forwarded := rc.Rewrite + trimSegments(requestPath, rc.Path)
+	if len(forwarded) == 0 || forwarded[0] != '/' {
+		forwarded = "/" + forwarded
+	}
+	return forwarded
+}
+
+// trimSegments removes as many segments from the request path as the service path
+// has. A service path ending with a wildcard removes every segment.
+func trimSegments(requestPath string, servicePath string) string {
+	count := strings.Count(strings.Trim(servicePath, "/"), "/") + 1
+	if strings.HasSuffix(servicePath, "/*") || servicePath == "*" {
+		return ""
+	}
+
+	rest := requestPath
+	for i := 0; i < count && len(rest) > 0; i++ {
+		rest = strings.TrimPrefix(rest, "/")
+		if next := strings.IndexByte(rest, '/'); next >= 0 {
+			rest = rest[next:]
+		} else {
+			rest = ""
+		}
+	}
+	return rest
+}
+
+// parseRoutes builds routes out of the strip prefix and rewrite flags of the gate
+// command. stripPrefixes is a comma separated list of paths and rewrites a comma
+// separated list of path=rewrite pairs.
+func parseRoutes(stripPrefixes string, rewrites string) ([]Route, error) {
+	routes := make(map[string]*Route)
+	order := make([]string, 0)
+	route := func(path string) *Route {
+		utils.MakeUrlPathValid(&path)
+		if _, exists := routes[path]; !exists {
+			routes[path] = &Route{Path: path}
+			order = append(order, path)
+		}
+		return routes[path]
+	}
+
+	for _, path := range strings.Split(stripPrefixes, ",") {
+		path = strings.TrimSpace(path)
+		if len(path) > 0 {
+			route(path).StripPrefix = true
+		}
+	}
+
+	for _, rewrite := range strings.Split(rewrites, ",") {
+		rewrite = strings.TrimSpace(rewrite)
+		if len(rewrite) == 0 {
+			continue
+		}
+		path, target, ok := strings.Cut(rewrite, "=")
+		if !ok || len(strings.TrimSpace(path)) == 0 {
+			return nil, fmt.Errorf("invalid rewrite '%v', expected path=rewrite", rewrite)
+		}
+		target = strings.TrimSpace(target)
+		utils.MakeUrlPathValid(&target)
+		rewritten := route(strings.TrimSpace(path))
+		rewritten.Rewrite = target
+		// rewriting to the root is the same as stripping the prefix
+		rewritten.StripPrefix = rewritten.StripPrefix || len(target) == 0
+	}
+
+	parsed := make([]Route, 0, len(order))
+	for _, path := range order {
+		parsed = append(parsed, *routes[path])
+	}
+	return parsed, nil
+}
@@ -0,0 +1,199 @@
+package gateway_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/anjolaoluwaakindipe/duller/internal/balancer"
+	"github.com/anjolaoluwaakindipe/duller/internal/discovery"
+	"github.com/anjolaoluwaakindipe/duller/internal/gateway"
+	"github.com/anjolaoluwaakindipe/duller/internal/service"
+	"github.com/stretchr/testify/assert"
+)
+
+// stubEchoInstance starts a service answering every request with its id and the
+// path it received
+func stubEchoInstance(t *testing.T, serviceId string) *url.URL {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(serviceId + " " + r.URL.Path))
+	}))
+	t.Cleanup(server.Close)
+	address, _ := url.Parse(server.URL)
+	return address
+}
+
+// stubRoutedGateway starts a discovery server with services on /orders and
+// /orders/archive and a gateway syncing from it
+func stubRoutedGateway(t *testing.T, ctx context.Context, routes ...gateway.Route) http.Handler {
+	discoveryServer := stubDiscovery(t, ctx)
+	registerInstance(t, discoveryServer.URL, "orders1", "/orders", stubEchoInstance(t, "orders1"))
+	registerInstance(t, discoveryServer.URL, "archive1", "/orders/archive", stubEchoInstance(t, "archive1"))
+
+	host, port, _ := net.SplitHostPort(discoveryServer.Listener.Addr().String())
+	router := gateway.InitMuxRouter(
+		gateway.WithDiscoveryHost(host),
+		gateway.WithDiscoveryPort(port),
+		gateway.WithSyncWait(time.Second),
+		gateway.WithRoutes(routes...),
+	)
+	router.RegisterRoutes()
+	go router.SyncRegistry(ctx)
+
+	assert.Eventually(t, func() bool {
+		code, _ := get(router.GetRouter(), "/orders/archive")
+		return code == http.StatusOK
+	}, 5*time.Second, 10*time.Millisecond)
+	return router.GetRouter()
+}
+
+func Test_MuxRouter_GetPath(t *testing.T) {
+	t.Run("SHOULD route to the longest matching service path WHEN the request path has many segments", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		handler := stubRoutedGateway(t, ctx)
+
+		_, body := get(handler, "/orders/123/items")
+		assert.Equal(t, "orders1 /orders/123/items", body)
+
+		_, body = get(handler, "/orders/archive/2023")
+		assert.Equal(t, "archive1 /orders/archive/2023", body)
+	})
+
+	t.Run("SHOULD return not found WHEN the request path only matches part of a segment", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		handler := stubRoutedGateway(t, ctx)
+
+		code, _ := get(handler, "/orders-archive")
+		assert.Equal(t, http.StatusNotFound, code)
+
+		code, _ = get(handler, "/users/1")
+		assert.Equal(t, http.StatusNotFound, code)
+	})
+
+	t.Run("SHOULD strip the service path WHEN its route strips the prefix", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		handler := stubRoutedGateway(t, ctx, gateway.Route{Path: "/orders", StripPrefix: true})
+
+		_, body := get(handler, "/orders/123/items")
+		assert.Equal(t, "orders1 /123/items", body)
+
+		_, body = get(handler, "/orders")
+		assert.Equal(t, "orders1 /", body)
+
+		// routes only apply to their own service path
+		_, body = get(handler, "/orders/archive/2023")
+		assert.Equal(t, "archive1 /orders/archive/2023", body)
+	})
+
+	t.Run("SHOULD replace the service path WHEN its route rewrites it", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		handler := stubRoutedGateway(t, ctx, gateway.Route{Path: "orders/archive", Rewrite: "/api/v2/archive"})
+
+		_, body := get(handler, "/orders/archive/2023")
+		assert.Equal(t, "archive1 /api/v2/archive/2023", body)
+	})
+}
+
+// getError sends a request to the gateway and decodes the error it answers with
+func getError(t *testing.T, handler http.Handler, path string) (int, gateway.GatewayErrorMessage) {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+
+	var message gateway.GatewayErrorMessage
+	if recorder.Code >= http.StatusBadRequest {
+		assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+		assert.Nil(t, json.NewDecoder(recorder.Body).Decode(&message))
+	}
+	return recorder.Code, message
+}
+
+func Test_MuxRouter_Errors(t *testing.T) {
+	t.Run("SHOULD answer with a json error WHEN the gateway fails the request", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		discoveryServer := stubDiscovery(t, ctx)
+		host, port := unusedAddress(t)
+		registerInstance(t, discoveryServer.URL, "orders1", "/orders", &url.URL{Host: net.JoinHostPort(host, port)})
+		handler := stubGateway(t, ctx, discoveryServer)
+
+		var message gateway.GatewayErrorMessage
+		assert.Eventually(t, func() bool {
+			var code int
+			code, message = getError(t, handler, "/orders/1")
+			return code == http.StatusBadGateway
+		}, 5*time.Second, 10*time.Millisecond)
+		assert.Equal(t, http.StatusBadGateway, message.Status)
+
+		code, message := getError(t, handler, "/users/1")
+		assert.Equal(t, http.StatusNotFound, code)
+		assert.Equal(t, http.StatusNotFound, message.Status)
+
+		body, _ := json.Marshal(discovery.StatusMessage{Status: service.StatusOutOfService})
+		req, _ := http.NewRequest(http.MethodPut, discoveryServer.URL+"/admin/services/orders1/status", bytes.NewBuffer(body))
+		response, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		response.Body.Close()
+
+		assert.Eventually(t, func() bool {
+			var code int
+			code, message = getError(t, handler, "/orders/1")
+			return code == http.StatusServiceUnavailable
+		}, 5*time.Second, 10*time.Millisecond)
+		assert.Equal(t, http.StatusServiceUnavailable, message.Status)
+		assert.Contains(t, message.Message, "/orders")
+	})
+}
+
+func Test_MuxRouter_HashKey(t *testing.T) {
+	t.Run("SHOULD send requests with the same key to the same service WHEN services ask for consistent hashing", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		discoveryServer := stubDiscovery(t, ctx)
+		for _, serviceId := range []string{"carts1", "carts2", "carts3"} {
+			address := stubEchoInstance(t, serviceId)
+			body, _ := json.Marshal(discovery.HeartBeatMessage{ServiceId: serviceId, Path: "/carts", IP: address.Hostname(), Port: address.Port(), Strategy: balancer.ConsistentHashStrategy})
+			response, err := http.Post(discoveryServer.URL+"/heartbeat", "application/json", bytes.NewBuffer(body))
+			assert.Nil(t, err)
+			response.Body.Close()
+		}
+
+		host, port, _ := net.SplitHostPort(discoveryServer.Listener.Addr().String())
+		router := gateway.InitMuxRouter(
+			gateway.WithDiscoveryHost(host),
+			gateway.WithDiscoveryPort(port),
+			gateway.WithSyncWait(time.Second),
+			gateway.WithHashKey(balancer.HashKey{Source: balancer.HashKeyCookie, Name: "session"}),
+		)
+		router.RegisterRoutes()
+		go router.SyncRegistry(ctx)
+
+		assert.Eventually(t, func() bool {
+			services := make(map[string]bool)
+			for i := 0; i < 3; i++ {
+				_, body := get(router.GetRouter(), "/carts")
+				services[body] = true
+			}
+			return len(services) == 3
+		}, 5*time.Second, 10*time.Millisecond)
+
+		for _, session := range []string{"a", "b", "c", "d"} {
+			req := httptest.NewRequest(http.MethodGet, "/carts", nil)
+			req.AddCookie(&http.Cookie{Name: "session", Value: session})
+			first := serve(router.GetRouter(), req).Body.String()
+			for i := 0; i < 5; i++ {
+				assert.Equal(t, first, serve(router.GetRouter(), req).Body.String())
+			}
+		}
+	})
+}
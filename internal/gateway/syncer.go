@@ -0,0 +1,179 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/anjolaoluwaakindipe/duller/internal/balancer"
+	"github.com/anjolaoluwaakindipe/duller/internal/discovery"
+	"github.com/anjolaoluwaakindipe/duller/internal/registry"
+	"github.com/anjolaoluwaakindipe/duller/internal/service"
+)
+
+// longest time the syncer waits before retrying an unreachable discovery server
+const maxSyncRetry = 30 * time.Second
+
+// RegistrySyncer keeps a local registry in sync with the instances of a discovery
+// server. It uses blocking queries of the read api, so changes reach the local
+// registry as soon as the discovery server sees them.
+//
+// The local registry is left untouched while the discovery server is unreachable,
+// which lets the gateway keep routing to the last known instances.
+type RegistrySyncer struct {
+	registry registry.Registry
+	// splits holds the traffic splits of the discovery server
+	splits  *balancer.Splits
+	client  *http.Client
+	address string
+	wait    time.Duration
+	synced  bool
+	index   uint64
+}
+
+// NewRegistrySyncer creates a RegistrySyncer fetching instances from the discovery
+// server at address (e.g. http://localhost:9876) and waiting at most wait for them
+// to change. A nil transport uses http.DefaultTransport. Traffic splits are synced
+// to splits unless it is nil.
+func NewRegistrySyncer(reg registry.Registry, address string, wait time.Duration, transport http.RoundTripper, splits *balancer.Splits) *RegistrySyncer {
+	return &RegistrySyncer{
+		registry: reg,
+		splits:   splits,
+		// the discovery server holds blocking queries for up to wait
+		client:  &http.Client{Transport: transport, Timeout: wait + 10*time.Second},
+		address: address,
+		wait:    wait,
+	}
+}
+
+// Run syncs the local registry until the context is cancelled. Failed syncs are
+// retried with an exponential backoff. This is meant to be used in a goroutine
+func (rs *RegistrySyncer) Run(ctx context.Context) {
+	retry := time.Duration(0)
+	for {
+		err := rs.Sync(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err == nil {
+			retry = 0
+			continue
+		}
+
+		retry = nextSyncRetry(retry)
+		slog.Warn(fmt.Sprintf("Could not sync instances from discovery server, retrying in %v: %v", retry, err))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retry):
+		}
+	}
+}
+
+// Sync fetches the instances of the discovery server once they change past the last
+// seen index and applies them to the local registry. The first sync returns at once.
+func (rs *RegistrySyncer) Sync(ctx context.Context) error {
+	address := rs.address + "/v1/services"
+	if rs.synced {
+		address += fmt.Sprintf("?index=%v&wait=%v", rs.index, rs.wait)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, address, nil)
+	if err != nil {
+		return err
+	}
+
+	response, err := rs.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("discovery server answered with status %v", response.StatusCode)
+	}
+
+	var services []discovery.ServiceResponse
+	if err := json.NewDecoder(response.Body).Decode(&services); err != nil {
+		return err
+	}
+
+	rs.apply(services)
+
+	index, err := strconv.ParseUint(response.Header.Get("X-Duller-Index"), 10, 64)
+	if err != nil {
+		return fmt.Errorf("discovery server sent an invalid index: %v", err)
+	}
+	// a restarted or failed over discovery server may send a lower index
+	rs.index = index
+	rs.synced = true
+
+	return nil
+}
+
+// apply registers every fetched instance in the local registry and removes the
+// instances the discovery server no longer knows. Traffic splits are replaced the
+// same way.
+func (rs *RegistrySyncer) apply(services []discovery.ServiceResponse) {
+	fetched := make(map[string]bool)
+	for _, fetchedService := range services {
+		for _, instance := range fetchedService.Instances {
+			fetched[instance.ServiceId] = true
+			err := rs.registry.RegisterService(&service.ServiceInfo{
+				ServiceId:    instance.ServiceId,
+				Path:         instance.Path,
+				IP:           instance.IP,
+				Port:         instance.Port,
+				Status:       instance.Status,
+				Metadata:     instance.Metadata,
+				Tags:         instance.Tags,
+				Version:      instance.Version,
+				Zone:         instance.Zone,
+				Strategy:     instance.Strategy,
+				WeightedUse:  instance.WeightedUse,
+				RegisteredAt: instance.RegisteredAt,
+			})
+			if err != nil {
+				slog.Warn(fmt.Sprintf("Could not cache instance %v: %v", instance.ServiceId, err))
+			}
+		}
+	}
+
+	for _, cached := range rs.registry.GetServices() {
+		if !fetched[cached.ServiceId] {
+			rs.registry.DeregisterService(cached.Path, cached.ServiceId)
+		}
+	}
+
+	if rs.splits == nil {
+		return
+	}
+	split := make(map[string]bool)
+	for _, fetchedService := range services {
+		if fetchedService.Split != nil {
+			split[fetchedService.Path] = true
+			rs.splits.Set(fetchedService.Path, *fetchedService.Split)
+		}
+	}
+	for _, status := range rs.splits.All() {
+		if !split[status.Path] {
+			rs.splits.Remove(status.Path)
+		}
+	}
+}
+
+func nextSyncRetry(retry time.Duration) time.Duration {
+	if retry == 0 {
+		return time.Second
+	}
+	if retry*2 > maxSyncRetry {
+		return maxSyncRetry
+	}
+	return retry * 2
+}
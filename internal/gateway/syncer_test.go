@@ -0,0 +1,173 @@
+package gateway_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/anjolaoluwaakindipe/duller/internal/balancer"
+	"github.com/anjolaoluwaakindipe/duller/internal/discovery"
+	"github.com/anjolaoluwaakindipe/duller/internal/gateway"
+	"github.com/anjolaoluwaakindipe/duller/internal/registry"
+	"github.com/anjolaoluwaakindipe/duller/internal/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// stubDiscovery starts a discovery server
+func stubDiscovery(t *testing.T, ctx context.Context) *httptest.Server {
+	reg := registry.InitInMemoryRegistry(utils.NewClock())
+	router, err := discovery.NewMuxRouter(balancer.NewRoundRobinLoadBalancer(reg), reg, ctx)
+	assert.Nil(t, err)
+
+	server := httptest.NewServer(router.SetupRoutes())
+	t.Cleanup(server.Close)
+	return server
+}
+
+// stubInstance starts a service answering every request with its id and returns its address
+func stubInstance(t *testing.T, serviceId string) *url.URL {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(serviceId))
+	}))
+	t.Cleanup(server.Close)
+	address, _ := url.Parse(server.URL)
+	return address
+}
+
+func registerInstance(t *testing.T, discoveryAddress string, serviceId string, path string, address *url.URL) {
+	body, _ := json.Marshal(discovery.HeartBeatMessage{ServiceId: serviceId, Path: path, IP: address.Hostname(), Port: address.Port()})
+	response, err := http.Post(discoveryAddress+"/heartbeat", "application/json", bytes.NewBuffer(body))
+	assert.Nil(t, err)
+	response.Body.Close()
+}
+
+func deregisterInstance(t *testing.T, discoveryAddress string, serviceId string, path string) {
+	req, _ := http.NewRequest(http.MethodDelete, discoveryAddress+"/services"+path+"/"+serviceId, nil)
+	response, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	response.Body.Close()
+}
+
+func get(handler http.Handler, path string) (int, string) {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+	return recorder.Code, recorder.Body.String()
+}
+
+// stubGateway starts a gateway syncing its instances from the discovery server
+func stubGateway(t *testing.T, ctx context.Context, discoveryServer *httptest.Server) http.Handler {
+	host, port, _ := net.SplitHostPort(discoveryServer.Listener.Addr().String())
+	router := gateway.InitMuxRouter(
+		gateway.WithDiscoveryHost(host),
+		gateway.WithDiscoveryPort(port),
+		gateway.WithSyncWait(time.Second),
+	)
+	router.RegisterRoutes()
+	go router.SyncRegistry(ctx)
+	return router.GetRouter()
+}
+
+func Test_MuxRouter_SyncRegistry(t *testing.T) {
+	t.Run("SHOULD proxy straight to an instance WHEN the instance is registered on the discovery server", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		discoveryServer := stubDiscovery(t, ctx)
+		registerInstance(t, discoveryServer.URL, "orders1", "/orders", stubInstance(t, "orders1"))
+		handler := stubGateway(t, ctx, discoveryServer)
+
+		assert.Eventually(t, func() bool {
+			code, body := get(handler, "/orders")
+			return code == http.StatusOK && body == "orders1"
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("SHOULD stop routing to an instance WHEN it deregisters from the discovery server", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		discoveryServer := stubDiscovery(t, ctx)
+		registerInstance(t, discoveryServer.URL, "orders1", "/orders", stubInstance(t, "orders1"))
+		registerInstance(t, discoveryServer.URL, "orders2", "/orders", stubInstance(t, "orders2"))
+		handler := stubGateway(t, ctx, discoveryServer)
+
+		assert.Eventually(t, func() bool {
+			code, _ := get(handler, "/orders")
+			return code == http.StatusOK
+		}, 5*time.Second, 10*time.Millisecond)
+
+		deregisterInstance(t, discoveryServer.URL, "orders1", "/orders")
+
+		assert.Eventually(t, func() bool {
+			for i := 0; i < 4; i++ {
+				if _, body := get(handler, "/orders"); body != "orders2" {
+					return false
+				}
+			}
+			return true
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("SHOULD keep routing to the last known instances WHEN the discovery server becomes unreachable", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		discoveryServer := stubDiscovery(t, ctx)
+		registerInstance(t, discoveryServer.URL, "orders1", "/orders", stubInstance(t, "orders1"))
+		handler := stubGateway(t, ctx, discoveryServer)
+
+		assert.Eventually(t, func() bool {
+			code, _ := get(handler, "/orders")
+			return code == http.StatusOK
+		}, 5*time.Second, 10*time.Millisecond)
+
+		discoveryServer.CloseClientConnections()
+		discoveryServer.Close()
+
+		code, body := get(handler, "/orders")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "orders1", body)
+	})
+	t.Run("SHOULD route to the versions of a split WHEN the split is set on the discovery server", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		discoveryServer := stubDiscovery(t, ctx)
+		for serviceId, version := range map[string]string{"orders1": "1.0.0", "orders2": "2.0.0"} {
+			address := stubInstance(t, serviceId)
+			body, _ := json.Marshal(discovery.HeartBeatMessage{ServiceId: serviceId, Path: "/orders", IP: address.Hostname(), Port: address.Port(), Version: version})
+			response, err := http.Post(discoveryServer.URL+"/heartbeat", "application/json", bytes.NewBuffer(body))
+			assert.Nil(t, err)
+			response.Body.Close()
+		}
+		handler := stubGateway(t, ctx, discoveryServer)
+
+		body, _ := json.Marshal(balancer.Split{Versions: []balancer.VersionWeight{{Version: "2", Weight: 1}}})
+		req, _ := http.NewRequest(http.MethodPut, discoveryServer.URL+"/splits/orders", bytes.NewBuffer(body))
+		response, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		response.Body.Close()
+
+		assert.Eventually(t, func() bool {
+			for i := 0; i < 4; i++ {
+				if _, body := get(handler, "/orders"); body != "orders2" {
+					return false
+				}
+			}
+			return true
+		}, 5*time.Second, 10*time.Millisecond)
+
+		// a forced version wins over the split
+		req = httptest.NewRequest(http.MethodGet, "/orders", nil)
+		req.Header.Set(balancer.VersionHeader, "1")
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		assert.Equal(t, "orders1", recorder.Body.String())
+	})
+}
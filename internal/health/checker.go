@@ -0,0 +1,245 @@
+// Package health provides active health checking of the services stored in a registry
+package health
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/anjolaoluwaakindipe/duller/internal/registry"
+	"github.com/anjolaoluwaakindipe/duller/internal/service"
+)
+
+// modes of probing a service
+const (
+	// NoneMode disables active health checks
+	NoneMode = "none"
+	// HTTPMode sends a GET request to the health path of a service
+	HTTPMode = "http"
+	// TCPMode opens a tcp connection to a service
+	TCPMode = "tcp"
+)
+
+// Config holds configuration for a Checker
+type Config struct {
+	// Mode is one of NoneMode, HTTPMode or TCPMode
+	Mode string
+	// Path is the path probed on every service in HTTPMode
+	Path string
+	// ExpectedStatus is the status code a healthy service responds with in HTTPMode.
+	// Any 2xx status code is accepted when it is 0.
+	ExpectedStatus int
+	// Interval is the time between two probes of a service
+	Interval time.Duration
+	// Timeout bounds a single probe
+	Timeout time.Duration
+	// HealthyThreshold is the number of consecutive successful probes needed to mark an
+	// unhealthy service healthy
+	HealthyThreshold int
+	// UnhealthyThreshold is the number of consecutive failed probes needed to mark a
+	// healthy service unhealthy
+	UnhealthyThreshold int
+}
+
+// checkState is the probe history of a single service
+type checkState struct {
+	successes int
+	failures  int
+	// healthy is the last health the checker stored in the registry, so the service
+	// is not read while the registry changes it
+	healthy bool
+}
+
+// Checker actively probes every service of a registry and keeps their
+// CheckStatus field up to date.
+type Checker struct {
+	registry registry.Registry
+	config   Config
+	client   *http.Client
+	mutex    sync.Mutex
+	states   map[string]*checkState
+	// subscribers are called whenever the health of a service changes
+	subscribers []func(*service.ServiceInfo)
+}
+
+// NewChecker creates a Checker for the services of the given registry.
+func NewChecker(reg registry.Registry, config Config) *Checker {
+	if config.HealthyThreshold < 1 {
+		config.HealthyThreshold = 1
+	}
+	if config.UnhealthyThreshold < 1 {
+		config.UnhealthyThreshold = 1
+	}
+
+	return &Checker{
+		registry: reg,
+		config:   config,
+		client: &http.Client{
+			Timeout: config.Timeout,
+			// a redirect is an answer from the service, it is not followed
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		states:      make(map[string]*checkState),
+		subscribers: make([]func(*service.ServiceInfo), 0),
+	}
+}
+
+// Subscribe registers a function called with the service whenever its health changes
+func (c *Checker) Subscribe(subscriber func(*service.ServiceInfo)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.subscribers = append(c.subscribers, subscriber)
+}
+
+// Run probes every service at the configured interval until the context is
+// cancelled. This is meant to be used in a goroutine
+func (c *Checker) Run(ctx context.Context) {
+	if c.config.Mode == NoneMode || len(c.config.Mode) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(c.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.CheckAll(ctx)
+		}
+	}
+}
+
+// CheckAll probes every registered service once and waits for all probes to finish
+func (c *Checker) CheckAll(ctx context.Context) {
+	services := c.registry.GetServices()
+
+	var wg sync.WaitGroup
+	for _, registeredService := range services {
+		wg.Add(1)
+		go func(registeredService *service.ServiceInfo) {
+			defer wg.Done()
+			c.record(registeredService, c.probe(ctx, registeredService))
+		}(registeredService)
+	}
+	wg.Wait()
+
+	c.removeStaleStates(services)
+}
+
+// probe returns nil if the service responded as a healthy service would
+func (c *Checker) probe(ctx context.Context, registeredService *service.ServiceInfo) error {
+	address := net.JoinHostPort(registeredService.IP, registeredService.Port)
+
+	switch c.config.Mode {
+	case TCPMode:
+		dialer := net.Dialer{Timeout: c.config.Timeout}
+		conn, err := dialer.DialContext(ctx, "tcp", address)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case HTTPMode:
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+address+c.config.Path, nil)
+		if err != nil {
+			return err
+		}
+		response, err := c.client.Do(req)
+		if err != nil {
+			return err
+		}
+		response.Body.Close()
+
+		if c.config.ExpectedStatus == 0 && response.StatusCode >= 200 && response.StatusCode < 300 {
+			return nil
+		}
+		if response.StatusCode == c.config.ExpectedStatus {
+			return nil
+		}
+		return fmt.Errorf("unexpected status code %v", response.StatusCode)
+	default:
+		return fmt.Errorf("unknown health check mode '%v'", c.config.Mode)
+	}
+}
+
+// record updates the probe history of a service and changes its health once a
+// threshold is crossed
+func (c *Checker) record(registeredService *service.ServiceInfo, probeErr error) {
+	c.mutex.Lock()
+	state, exists := c.states[registeredService.ServiceId]
+	if !exists {
+		// the checker is the only writer of CheckStatus, so it is safe to read before
+		// the first result of the checker is stored
+		state = &checkState{healthy: registeredService.CheckStatus != service.StatusDown}
+		c.states[registeredService.ServiceId] = state
+	}
+
+	if probeErr == nil {
+		state.successes++
+		state.failures = 0
+	} else {
+		state.failures++
+		state.successes = 0
+	}
+
+	isHealthy := state.healthy
+	changeTo := isHealthy
+	if !isHealthy && state.successes >= c.config.HealthyThreshold {
+		changeTo = true
+	}
+	if isHealthy && state.failures >= c.config.UnhealthyThreshold {
+		changeTo = false
+	}
+	subscribers := c.subscribers
+	c.mutex.Unlock()
+
+	if changeTo == isHealthy {
+		return
+	}
+
+	checkStatus := service.StatusDown
+	if changeTo {
+		checkStatus = service.StatusUp
+	}
+
+	if err := c.registry.SetCheckStatus(registeredService.ServiceId, checkStatus); err != nil {
+		// the service was removed while it was being probed
+		return
+	}
+
+	c.mutex.Lock()
+	state.healthy = changeTo
+	c.mutex.Unlock()
+
+	if changeTo {
+		slog.Info(fmt.Sprintf("Service %v is healthy again", registeredService.ServiceId))
+	} else {
+		slog.Warn(fmt.Sprintf("Service %v is unhealthy: %v", registeredService.ServiceId, probeErr))
+	}
+
+	for _, subscriber := range subscribers {
+		subscriber(registeredService)
+	}
+}
+
+// removeStaleStates forgets the probe history of services that are no longer registered
+func (c *Checker) removeStaleStates(services []*service.ServiceInfo) {
+	registered := make(map[string]bool)
+	for _, registeredService := range services {
+		registered[registeredService.ServiceId] = true
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for serviceId := range c.states {
+		if !registered[serviceId] {
+			delete(c.states, serviceId)
+		}
+	}
+}
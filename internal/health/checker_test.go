@@ -0,0 +1,151 @@
+package health_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/anjolaoluwaakindipe/duller/internal/health"
+	"github.com/anjolaoluwaakindipe/duller/internal/registry"
+	"github.com/anjolaoluwaakindipe/duller/internal/service"
+	"github.com/anjolaoluwaakindipe/duller/internal/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// stubService starts an http server answering health checks with the status
+// code stored in status and registers it as a service
+func stubService(t *testing.T, reg registry.Registry, status *atomic.Int32) *service.ServiceInfo {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(int(status.Load()))
+	}))
+	t.Cleanup(server.Close)
+
+	serverUrl, _ := url.Parse(server.URL)
+	stub := &service.ServiceInfo{
+		Path:      "/path1",
+		ServiceId: "server1",
+		IP:        serverUrl.Hostname(),
+		Port:      serverUrl.Port(),
+	}
+	reg.RegisterService(stub)
+	return stub
+}
+
+func stubConfig(mode string) health.Config {
+	return health.Config{
+		Mode:               mode,
+		Path:               "/health",
+		Interval:           time.Second,
+		Timeout:            time.Second,
+		HealthyThreshold:   2,
+		UnhealthyThreshold: 2,
+	}
+}
+
+func Test_Checker_CheckAll(t *testing.T) {
+	t.Run("SHOULD mark a service unhealthy WHEN it fails as many checks as the unhealthy threshold", func(t *testing.T) {
+		reg := registry.InitInMemoryRegistry(utils.NewClock())
+		status := &atomic.Int32{}
+		status.Store(http.StatusInternalServerError)
+		stub := stubService(t, reg, status)
+		checker := health.NewChecker(reg, stubConfig(health.HTTPMode))
+
+		checker.CheckAll(context.Background())
+		registeredService, _ := reg.GetServiceById(stub.ServiceId)
+		assert.NotEqual(t, service.StatusDown, registeredService.CurrentStatus())
+
+		checker.CheckAll(context.Background())
+		registeredService, _ = reg.GetServiceById(stub.ServiceId)
+		assert.Equal(t, service.StatusDown, registeredService.CurrentStatus())
+	})
+
+	t.Run("SHOULD mark a service healthy again WHEN it passes as many checks as the healthy threshold", func(t *testing.T) {
+		reg := registry.InitInMemoryRegistry(utils.NewClock())
+		status := &atomic.Int32{}
+		status.Store(http.StatusOK)
+		stub := stubService(t, reg, status)
+		reg.SetCheckStatus(stub.ServiceId, service.StatusDown)
+		checker := health.NewChecker(reg, stubConfig(health.HTTPMode))
+
+		checker.CheckAll(context.Background())
+		registeredService, _ := reg.GetServiceById(stub.ServiceId)
+		assert.Equal(t, service.StatusDown, registeredService.CurrentStatus())
+
+		checker.CheckAll(context.Background())
+		registeredService, _ = reg.GetServiceById(stub.ServiceId)
+		assert.NotEqual(t, service.StatusDown, registeredService.CurrentStatus())
+	})
+
+	t.Run("SHOULD only accept the expected status code WHEN one is configured", func(t *testing.T) {
+		reg := registry.InitInMemoryRegistry(utils.NewClock())
+		status := &atomic.Int32{}
+		status.Store(http.StatusOK)
+		stub := stubService(t, reg, status)
+		config := stubConfig(health.HTTPMode)
+		config.ExpectedStatus = http.StatusNoContent
+		config.UnhealthyThreshold = 1
+		checker := health.NewChecker(reg, config)
+
+		checker.CheckAll(context.Background())
+		registeredService, _ := reg.GetServiceById(stub.ServiceId)
+		assert.Equal(t, service.StatusDown, registeredService.CurrentStatus())
+	})
+
+	t.Run("SHOULD mark a service unhealthy WHEN nothing listens on its port in tcp mode", func(t *testing.T) {
+		reg := registry.InitInMemoryRegistry(utils.NewClock())
+		listener, _ := net.Listen("tcp", "127.0.0.1:0")
+		address := listener.Addr().(*net.TCPAddr)
+		listener.Close()
+		reg.RegisterService(&service.ServiceInfo{Path: "/path1", ServiceId: "server1", IP: "127.0.0.1", Port: strconv.Itoa(address.Port)})
+		config := stubConfig(health.TCPMode)
+		config.UnhealthyThreshold = 1
+		checker := health.NewChecker(reg, config)
+
+		checker.CheckAll(context.Background())
+		registeredService, _ := reg.GetServiceById("server1")
+		assert.Equal(t, service.StatusDown, registeredService.CurrentStatus())
+	})
+
+	t.Run("SHOULD keep a service healthy WHEN its port accepts connections in tcp mode", func(t *testing.T) {
+		reg := registry.InitInMemoryRegistry(utils.NewClock())
+		status := &atomic.Int32{}
+		status.Store(http.StatusInternalServerError)
+		stub := stubService(t, reg, status)
+		config := stubConfig(health.TCPMode)
+		config.UnhealthyThreshold = 1
+		checker := health.NewChecker(reg, config)
+
+		checker.CheckAll(context.Background())
+		registeredService, _ := reg.GetServiceById(stub.ServiceId)
+		assert.NotEqual(t, service.StatusDown, registeredService.CurrentStatus())
+	})
+
+	t.Run("SHOULD notify subscribers WHEN the health of a service changes", func(t *testing.T) {
+		reg := registry.InitInMemoryRegistry(utils.NewClock())
+		status := &atomic.Int32{}
+		status.Store(http.StatusInternalServerError)
+		stub := stubService(t, reg, status)
+		config := stubConfig(health.HTTPMode)
+		config.UnhealthyThreshold = 1
+		checker := health.NewChecker(reg, config)
+
+		changed := make([]string, 0)
+		checker.Subscribe(func(changedService *service.ServiceInfo) {
+			changed = append(changed, changedService.ServiceId)
+		})
+
+		checker.CheckAll(context.Background())
+		checker.CheckAll(context.Background())
+		assert.Equal(t, []string{stub.ServiceId}, changed)
+	})
+}
@@ -0,0 +1,196 @@
+package health
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/anjolaoluwaakindipe/duller/internal/registry"
+	"github.com/anjolaoluwaakindipe/duller/internal/service"
+	"github.com/anjolaoluwaakindipe/duller/internal/utils"
+)
+
+// OutlierConfig holds configuration for an OutlierDetector
+type OutlierConfig struct {
+	// ConsecutiveFailures is the number of failed proxied requests in a row after
+	// which a service is ejected. Outlier detection is disabled when it is 0.
+	ConsecutiveFailures int
+	// BaseEjectionTime is how long a service is ejected the first time. It doubles
+	// with every following ejection of the same service.
+	BaseEjectionTime time.Duration
+	// MaxEjectionTime caps the ejection time of a service. A service that stays in
+	// rotation for that long gets its ejection time reset to BaseEjectionTime.
+	MaxEjectionTime time.Duration
+	// MaxEjectionPercent is the highest percentage of the services of a path that
+	// may be ejected at the same time
+	MaxEjectionPercent int
+}
+
+// outlierState is the failure history of a single service
+type outlierState struct {
+	path         string
+	failures     int
+	ejections    int
+	ejectedUntil time.Time
+}
+
+// OutlierDetector passively watches the results of proxied requests and
+// temporarily ejects services that keep failing from load balancer rotation.
+type OutlierDetector struct {
+	registry registry.Registry
+	clock    utils.Clock
+	config   OutlierConfig
+	mutex    sync.Mutex
+	states   map[string]*outlierState
+}
+
+// NewOutlierDetector creates an OutlierDetector for the services of the given registry
+func NewOutlierDetector(reg registry.Registry, clock utils.Clock, config OutlierConfig) *OutlierDetector {
+	return &OutlierDetector{
+		registry: reg,
+		clock:    clock,
+		config:   config,
+		states:   make(map[string]*outlierState),
+	}
+}
+
+// RecordSuccess records a request the service answered successfully
+func (od *OutlierDetector) RecordSuccess(serviceInfo *service.ServiceInfo) {
+	od.mutex.Lock()
+	defer od.mutex.Unlock()
+
+	state, exists := od.states[serviceInfo.ServiceId]
+	if !exists {
+		return
+	}
+
+	state.failures = 0
+
+	now := od.clock.Now()
+	if now.Sub(state.ejectedUntil) >= od.config.MaxEjectionTime {
+		delete(od.states, serviceInfo.ServiceId)
+	}
+}
+
+// RecordFailure records a request the service failed to answer or answered with
+// a 5xx status code. The service is ejected once it fails ConsecutiveFailures
+// requests in a row, unless too many services of its path are already ejected.
+func (od *OutlierDetector) RecordFailure(serviceInfo *service.ServiceInfo) {
+	if od.config.ConsecutiveFailures < 1 {
+		return
+	}
+
+	od.mutex.Lock()
+	defer od.mutex.Unlock()
+
+	state, exists := od.states[serviceInfo.ServiceId]
+	if !exists {
+		// states are only added here, so the ones of services that left the registry
+		// are dropped before the map grows
+		od.removeStaleStates()
+		state = &outlierState{path: serviceInfo.Path}
+		od.states[serviceInfo.ServiceId] = state
+	}
+
+	now := od.clock.Now()
+	if now.Before(state.ejectedUntil) {
+		// requests sent before the ejection are still failing
+		return
+	}
+
+	state.failures++
+	if state.failures < od.config.ConsecutiveFailures || !od.canEject(state.path, now) {
+		return
+	}
+
+	state.ejections++
+	state.failures = 0
+	ejectionTime := od.ejectionTime(state.ejections)
+	state.ejectedUntil = now.Add(ejectionTime)
+
+	slog.Warn(fmt.Sprintf("Service %v ejected for %v after %v consecutive failures", serviceInfo.ServiceId, ejectionTime, od.config.ConsecutiveFailures))
+}
+
+// IsEjected reports whether the service is currently out of rotation
+func (od *OutlierDetector) IsEjected(serviceId string) bool {
+	od.mutex.Lock()
+	defer od.mutex.Unlock()
+
+	state, exists := od.states[serviceId]
+	return exists && od.clock.Now().Before(state.ejectedUntil)
+}
+
+// Filter implements balancer.ServiceFilter. Ejected services are removed.
+func (od *OutlierDetector) Filter(path string, services []*service.ServiceInfo) []*service.ServiceInfo {
+	od.mutex.Lock()
+	defer od.mutex.Unlock()
+
+	now := od.clock.Now()
+	available := make([]*service.ServiceInfo, 0, len(services))
+	for _, registeredService := range services {
+		state, exists := od.states[registeredService.ServiceId]
+		if exists && now.Before(state.ejectedUntil) {
+			continue
+		}
+		available = append(available, registeredService)
+	}
+	return available
+}
+
+// removeStaleStates forgets the failures and ejections of services that are no
+// longer registered, so they neither leak nor count against the ejections of their
+// path. The mutex must be held when calling it.
+func (od *OutlierDetector) removeStaleStates() {
+	registered := make(map[string]bool)
+	for _, registeredService := range od.registry.GetServices() {
+		registered[registeredService.ServiceId] = true
+	}
+
+	for serviceId := range od.states {
+		if !registered[serviceId] {
+			delete(od.states, serviceId)
+		}
+	}
+}
+
+// ejectionTime returns how long a service is ejected for its nth ejection.
+func (od *OutlierDetector) ejectionTime(ejections int) time.Duration {
+	ejectionTime := od.config.BaseEjectionTime
+	for i := 1; i < ejections && ejectionTime < od.config.MaxEjectionTime; i++ {
+		ejectionTime *= 2
+	}
+	if od.config.MaxEjectionTime > 0 && ejectionTime > od.config.MaxEjectionTime {
+		ejectionTime = od.config.MaxEjectionTime
+	}
+	return ejectionTime
+}
+
+// canEject reports whether one more service of the path may be ejected without
+// exceeding MaxEjectionPercent. At least one service of a path is always kept in
+// rotation. The mutex must be held when calling it.
+func (od *OutlierDetector) canEject(path string, now time.Time) bool {
+	pool := 0
+	for _, registeredService := range od.registry.GetServices() {
+		if registeredService.Path == path {
+			pool++
+		}
+	}
+
+	ejected := 0
+	for _, state := range od.states {
+		if state.path == path && now.Before(state.ejectedUntil) {
+			ejected++
+		}
+	}
+
+	allowed := pool * od.config.MaxEjectionPercent / 100
+	if allowed == 0 && od.config.MaxEjectionPercent > 0 {
+		allowed = 1
+	}
+	if allowed > pool-1 {
+		allowed = pool - 1
+	}
+
+	return ejected < allowed
+}
@@ -0,0 +1,179 @@
+package health_test
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/anjolaoluwaakindipe/duller/internal/health"
+	"github.com/anjolaoluwaakindipe/duller/internal/registry"
+	"github.com/anjolaoluwaakindipe/duller/internal/service"
+	"github.com/anjolaoluwaakindipe/duller/internal/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeClock struct {
+	currentTime time.Time
+}
+
+func (fc *fakeClock) Now() time.Time {
+	return fc.currentTime
+}
+
+func stubPool(size int) (registry.Registry, []*service.ServiceInfo) {
+	reg := registry.InitInMemoryRegistry(utils.NewClock())
+	services := make([]*service.ServiceInfo, 0)
+	for i := 0; i < size; i++ {
+		stub := &service.ServiceInfo{
+			Path:      "/path1",
+			ServiceId: "server" + strconv.Itoa(i+1),
+			IP:        "localhost",
+			Port:      strconv.Itoa(4000 + i),
+		}
+		reg.RegisterService(stub)
+		services = append(services, stub)
+	}
+	return reg, services
+}
+
+func stubOutlierConfig() health.OutlierConfig {
+	return health.OutlierConfig{
+		ConsecutiveFailures: 3,
+		BaseEjectionTime:    30 * time.Second,
+		MaxEjectionTime:     2 * time.Minute,
+		MaxEjectionPercent:  50,
+	}
+}
+
+func Test_OutlierDetector_RecordFailure(t *testing.T) {
+	t.Run("SHOULD eject a service WHEN it fails as many requests in a row as configured", func(t *testing.T) {
+		reg, services := stubPool(4)
+		detector := health.NewOutlierDetector(reg, &fakeClock{time.Now()}, stubOutlierConfig())
+
+		detector.RecordFailure(services[0])
+		detector.RecordFailure(services[0])
+		assert.False(t, detector.IsEjected(services[0].ServiceId))
+
+		detector.RecordFailure(services[0])
+		assert.True(t, detector.IsEjected(services[0].ServiceId))
+		assert.NotContains(t, detector.Filter("/path1", services), services[0])
+		assert.Len(t, detector.Filter("/path1", services), 3)
+	})
+
+	t.Run("SHOULD not eject a service WHEN a success breaks its failures", func(t *testing.T) {
+		reg, services := stubPool(4)
+		detector := health.NewOutlierDetector(reg, &fakeClock{time.Now()}, stubOutlierConfig())
+
+		detector.RecordFailure(services[0])
+		detector.RecordFailure(services[0])
+		detector.RecordSuccess(services[0])
+		detector.RecordFailure(services[0])
+		detector.RecordFailure(services[0])
+
+		assert.False(t, detector.IsEjected(services[0].ServiceId))
+	})
+
+	t.Run("SHOULD double the ejection time WHEN a service is ejected again", func(t *testing.T) {
+		reg, services := stubPool(4)
+		clock := &fakeClock{time.Now()}
+		detector := health.NewOutlierDetector(reg, clock, stubOutlierConfig())
+
+		eject := func() {
+			for i := 0; i < 3; i++ {
+				detector.RecordFailure(services[0])
+			}
+		}
+
+		eject()
+		clock.currentTime = clock.currentTime.Add(30 * time.Second)
+		assert.False(t, detector.IsEjected(services[0].ServiceId))
+
+		eject()
+		clock.currentTime = clock.currentTime.Add(30 * time.Second)
+		assert.True(t, detector.IsEjected(services[0].ServiceId))
+		clock.currentTime = clock.currentTime.Add(30 * time.Second)
+		assert.False(t, detector.IsEjected(services[0].ServiceId))
+	})
+
+	t.Run("SHOULD cap the ejection time WHEN a service keeps being ejected", func(t *testing.T) {
+		reg, services := stubPool(4)
+		clock := &fakeClock{time.Now()}
+		detector := health.NewOutlierDetector(reg, clock, stubOutlierConfig())
+
+		for ejection := 0; ejection < 5; ejection++ {
+			for i := 0; i < 3; i++ {
+				detector.RecordFailure(services[0])
+			}
+			clock.currentTime = clock.currentTime.Add(2 * time.Minute)
+			assert.False(t, detector.IsEjected(services[0].ServiceId))
+		}
+	})
+
+	t.Run("SHOULD not eject more services than the max ejection percent WHEN many services fail", func(t *testing.T) {
+		reg, services := stubPool(4)
+		detector := health.NewOutlierDetector(reg, &fakeClock{time.Now()}, stubOutlierConfig())
+
+		for _, failingService := range services {
+			for i := 0; i < 3; i++ {
+				detector.RecordFailure(failingService)
+			}
+		}
+
+		assert.Len(t, detector.Filter("/path1", services), 2)
+	})
+
+	t.Run("SHOULD keep one service in rotation WHEN every service of a small path fails", func(t *testing.T) {
+		reg, services := stubPool(2)
+		config := stubOutlierConfig()
+		config.MaxEjectionPercent = 100
+		detector := health.NewOutlierDetector(reg, &fakeClock{time.Now()}, config)
+
+		for _, failingService := range services {
+			for i := 0; i < 3; i++ {
+				detector.RecordFailure(failingService)
+			}
+		}
+
+		assert.Len(t, detector.Filter("/path1", services), 1)
+	})
+
+	t.Run("SHOULD forget ejected services WHEN they leave the registry", func(t *testing.T) {
+		reg, services := stubPool(4)
+		detector := health.NewOutlierDetector(reg, &fakeClock{time.Now()}, stubOutlierConfig())
+		for _, failing := range services[:2] {
+			for i := 0; i < 3; i++ {
+				detector.RecordFailure(failing)
+			}
+			assert.True(t, detector.IsEjected(failing.ServiceId))
+		}
+
+		assert.Nil(t, reg.DeregisterService("/path1", services[0].ServiceId))
+		assert.Nil(t, reg.DeregisterService("/path1", services[1].ServiceId))
+		_, replacements := stubPool(2)
+		for _, replacement := range replacements {
+			replacement.ServiceId += "-new"
+			assert.Nil(t, reg.RegisterService(replacement))
+		}
+
+		// the ejections of the removed services no longer use up the max ejection percent
+		for i := 0; i < 3; i++ {
+			detector.RecordFailure(services[2])
+		}
+		assert.True(t, detector.IsEjected(services[2].ServiceId))
+		assert.False(t, detector.IsEjected(services[0].ServiceId))
+		assert.False(t, detector.IsEjected(services[1].ServiceId))
+	})
+
+	t.Run("SHOULD never eject a service WHEN consecutive failures is 0", func(t *testing.T) {
+		reg, services := stubPool(4)
+		config := stubOutlierConfig()
+		config.ConsecutiveFailures = 0
+		detector := health.NewOutlierDetector(reg, &fakeClock{time.Now()}, config)
+
+		for i := 0; i < 10; i++ {
+			detector.RecordFailure(services[0])
+		}
+
+		assert.False(t, detector.IsEjected(services[0].ServiceId))
+	})
+}
@@ -0,0 +1,311 @@
+package proxy
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/anjolaoluwaakindipe/duller/internal/utils"
+)
+
+// BreakerState is the state of a circuit breaker
+type BreakerState string
+
+const (
+	// BreakerClosed lets every request through
+	BreakerClosed BreakerState = "closed"
+	// BreakerOpen fails every request fast until its open time passed
+	BreakerOpen BreakerState = "open"
+	// BreakerHalfOpen lets a few probe requests through to find out whether the
+	// services recovered
+	BreakerHalfOpen BreakerState = "half-open"
+)
+
+// number of buckets the window of a breaker is split in
+const breakerBuckets = 10
+
+// BreakerConfig holds configuration for Breakers
+type BreakerConfig struct {
+	// ErrorPercent opens a breaker once that percentage of the requests of its window
+	// failed, with a 5xx status code, a connection error or by being slow. Breakers
+	// are disabled when it is 0.
+	ErrorPercent int
+	// SlowCall is the latency above which a request counts as failed. Latency is
+	// ignored when it is 0.
+	SlowCall time.Duration
+	// Window is how far back the requests of a breaker are counted
+	Window time.Duration
+	// MinRequests is the number of requests a window needs before a breaker opens
+	MinRequests int
+	// OpenTime is how long a breaker fails requests fast before probing
+	OpenTime time.Duration
+	// Probes is the number of requests a half-open breaker lets through. The breaker
+	// closes when all of them succeed and opens again as soon as one fails.
+	Probes int
+	// PerInstance gives every service its own breaker on top of the breaker of its
+	// path. Requests to a service whose breaker is open go to other services.
+	PerInstance bool
+}
+
+// Validate returns an error when a setting of the config is out of range
+func (bc BreakerConfig) Validate() error {
+	if bc.ErrorPercent < 0 || bc.ErrorPercent > 100 {
+		return fmt.Errorf("breaker error percentage must be between 0 and 100, got %v", bc.ErrorPercent)
+	}
+	if bc.ErrorPercent > 0 && (bc.Window <= 0 || bc.OpenTime <= 0 || bc.Probes < 1) {
+		return fmt.Errorf("breaker window and open time must be positive and at least one probe is needed")
+	}
+	return nil
+}
+
+// OpenCircuitError is returned for requests a circuit breaker fails fast
+type OpenCircuitError struct {
+	Path string
+	// ServiceId is set when the breaker of a single service is open
+	ServiceId string
+}
+
+func (oce *OpenCircuitError) Error() string {
+	if len(oce.ServiceId) > 0 {
+		return fmt.Sprintf("circuit of service '%v' on path '%v' is open", oce.ServiceId, oce.Path)
+	}
+	return fmt.Sprintf("circuit of path '%v' is open", oce.Path)
+}
+
+// breakerBucket counts the requests of a part of the window of a breaker
+type breakerBucket struct {
+	index    int64
+	requests int
+	failures int
+}
+
+// breaker is the circuit breaker of a path or of a single service
+type breaker struct {
+	state    BreakerState
+	openedAt time.Time
+	// generation changes with every state, so requests started in a previous state
+	// are not counted in the current one
+	generation int
+	probes     int
+	successes  int
+	buckets    [breakerBuckets]breakerBucket
+}
+
+// BreakerStatus is the state of the breaker of a path or of a single service
+type BreakerStatus struct {
+	Path      string
+	ServiceId string
+	State     BreakerState
+}
+
+// Breakers keeps a circuit breaker for every path, and for every service when
+// configured, that requests are proxied to
+type Breakers struct {
+	clock       utils.Clock
+	config      BreakerConfig
+	mutex       sync.Mutex
+	breakers    map[BreakerStatus]*breaker
+	subscribers []func(BreakerStatus)
+}
+
+// NewBreakers creates Breakers that are all closed
+func NewBreakers(clock utils.Clock, config BreakerConfig) *Breakers {
+	return &Breakers{clock: clock, config: config, breakers: make(map[BreakerStatus]*breaker)}
+}
+
+// Subscribe registers a function called whenever a breaker changes state
+func (bs *Breakers) Subscribe(subscriber func(BreakerStatus)) {
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+	bs.subscribers = append(bs.subscribers, subscriber)
+}
+
+// Acquire asks the breakers of the path and of the service to let a request
+// through. An OpenCircuitError is returned when one of them fails it fast, else
+// the returned function must be called with the result of the request.
+func (bs *Breakers) Acquire(path string, serviceId string) (func(failed bool), error) {
+	if bs.config.ErrorPercent == 0 {
+		return func(bool) {}, nil
+	}
+
+	keys := []BreakerStatus{{Path: path}}
+	if bs.config.PerInstance {
+		keys = []BreakerStatus{{Path: path, ServiceId: serviceId}, {Path: path}}
+	}
+
+	now := bs.clock.Now()
+	changes := make([]BreakerStatus, 0)
+
+	bs.mutex.Lock()
+	generations := make([]int, 0, len(keys))
+	for i, key := range keys {
+		current := bs.breaker(key)
+		if !current.allow(now, bs.config, key, &changes) {
+			// probes taken from the breakers that let the request through are given back
+			for j := 0; j < i; j++ {
+				bs.breaker(keys[j]).release(generations[j])
+			}
+			bs.mutex.Unlock()
+			bs.notify(changes)
+			return nil, &OpenCircuitError{Path: key.Path, ServiceId: key.ServiceId}
+		}
+		generations = append(generations, current.generation)
+	}
+	bs.mutex.Unlock()
+	bs.notify(changes)
+
+	var once sync.Once
+	return func(failed bool) {
+		once.Do(func() {
+			finished := bs.clock.Now()
+			if bs.config.SlowCall > 0 && finished.Sub(now) > bs.config.SlowCall {
+				failed = true
+			}
+
+			changes := make([]BreakerStatus, 0)
+			bs.mutex.Lock()
+			for i, key := range keys {
+				bs.breaker(key).record(finished, bs.config, generations[i], failed, key, &changes)
+			}
+			bs.mutex.Unlock()
+			bs.notify(changes)
+		})
+	}, nil
+}
+
+// States returns the state of every breaker that is not closed in order of path
+// and service id
+func (bs *Breakers) States() []BreakerStatus {
+	now := bs.clock.Now()
+
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+
+	states := make([]BreakerStatus, 0)
+	for key, current := range bs.breakers {
+		state := current.state
+		if state == BreakerOpen && now.Sub(current.openedAt) >= bs.config.OpenTime {
+			state = BreakerHalfOpen
+		}
+		if state != BreakerClosed {
+			states = append(states, BreakerStatus{Path: key.Path, ServiceId: key.ServiceId, State: state})
+		}
+	}
+	sort.Slice(states, func(i, j int) bool {
+		if states[i].Path != states[j].Path {
+			return states[i].Path < states[j].Path
+		}
+		return states[i].ServiceId < states[j].ServiceId
+	})
+	return states
+}
+
+func (bs *Breakers) breaker(key BreakerStatus) *breaker {
+	current, exists := bs.breakers[key]
+	if !exists {
+		current = &breaker{state: BreakerClosed}
+		bs.breakers[key] = current
+	}
+	return current
+}
+
+func (bs *Breakers) notify(changes []BreakerStatus) {
+	if len(changes) == 0 {
+		return
+	}
+	bs.mutex.Lock()
+	subscribers := bs.subscribers
+	bs.mutex.Unlock()
+
+	for _, change := range changes {
+		for _, subscriber := range subscribers {
+			subscriber(change)
+		}
+	}
+}
+
+// transition moves the breaker to a new state and records the change
+func (b *breaker) transition(state BreakerState, now time.Time, key BreakerStatus, changes *[]BreakerStatus) {
+	b.state = state
+	b.generation++
+	b.probes = 0
+	b.successes = 0
+	if state == BreakerOpen {
+		b.openedAt = now
+	}
+	if state == BreakerClosed {
+		b.buckets = [breakerBuckets]breakerBucket{}
+	}
+	*changes = append(*changes, BreakerStatus{Path: key.Path, ServiceId: key.ServiceId, State: state})
+}
+
+// allow reports whether the breaker lets a request through, taking a probe when
+// it is half-open
+func (b *breaker) allow(now time.Time, config BreakerConfig, key BreakerStatus, changes *[]BreakerStatus) bool {
+	if b.state == BreakerOpen && now.Sub(b.openedAt) >= config.OpenTime {
+		b.transition(BreakerHalfOpen, now, key, changes)
+	}
+
+	switch b.state {
+	case BreakerClosed:
+		return true
+	case BreakerHalfOpen:
+		if b.probes < config.Probes {
+			b.probes++
+			return true
+		}
+	}
+	return false
+}
+
+// release gives back a probe taken by a request that was not sent after all
+func (b *breaker) release(generation int) {
+	if b.state == BreakerHalfOpen && b.generation == generation {
+		b.probes--
+	}
+}
+
+// record counts the result of a request let through in the given generation
+func (b *breaker) record(now time.Time, config BreakerConfig, generation int, failed bool, key BreakerStatus, changes *[]BreakerStatus) {
+	if b.generation != generation {
+		return
+	}
+
+	switch b.state {
+	case BreakerHalfOpen:
+		if failed {
+			b.transition(BreakerOpen, now, key, changes)
+			return
+		}
+		b.successes++
+		if b.successes >= config.Probes {
+			b.transition(BreakerClosed, now, key, changes)
+		}
+	case BreakerClosed:
+		width := int64(config.Window / breakerBuckets)
+		if width <= 0 {
+			width = 1
+		}
+		index := now.UnixNano() / width
+		bucket := &b.buckets[index%breakerBuckets]
+		if bucket.index != index {
+			*bucket = breakerBucket{index: index}
+		}
+		bucket.requests++
+		if failed {
+			bucket.failures++
+		}
+
+		requests, failures := 0, 0
+		for _, counted := range b.buckets {
+			if counted.index > index-breakerBuckets {
+				requests += counted.requests
+				failures += counted.failures
+			}
+		}
+		if requests >= config.MinRequests && failures*100 >= config.ErrorPercent*requests {
+			b.transition(BreakerOpen, now, key, changes)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package proxy_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/anjolaoluwaakindipe/duller/internal/proxy"
+	"github.com/stretchr/testify/assert"
+)
+
+func stubBreakerConfig() proxy.BreakerConfig {
+	return proxy.BreakerConfig{ErrorPercent: 50, Window: 10 * time.Second, MinRequests: 4, OpenTime: 30 * time.Second, Probes: 2}
+}
+
+// request sends a request through the breakers and reports whether it was let through
+func request(breakers *proxy.Breakers, serviceId string, failed bool) bool {
+	done, err := breakers.Acquire("/orders", serviceId)
+	if err != nil {
+		return false
+	}
+	done(failed)
+	return true
+}
+
+func Test_Breakers_Acquire(t *testing.T) {
+	t.Run("SHOULD open the circuit WHEN the error rate of the window reaches the threshold", func(t *testing.T) {
+		breakers := proxy.NewBreakers(&fakeClock{currentTime: time.Unix(1000, 0)}, stubBreakerConfig())
+
+		request(breakers, "orders1", false)
+		request(breakers, "orders1", true)
+		request(breakers, "orders1", false)
+		assert.Empty(t, breakers.States())
+
+		request(breakers, "orders1", true)
+		assert.Equal(t, []proxy.BreakerStatus{{Path: "/orders", State: proxy.BreakerOpen}}, breakers.States())
+
+		_, err := breakers.Acquire("/orders", "orders2")
+		assert.Equal(t, &proxy.OpenCircuitError{Path: "/orders"}, err)
+	})
+
+	t.Run("SHOULD not open the circuit WHEN the window has too few requests", func(t *testing.T) {
+		clock := &fakeClock{currentTime: time.Unix(1000, 0)}
+		breakers := proxy.NewBreakers(clock, stubBreakerConfig())
+
+		request(breakers, "orders1", true)
+		request(breakers, "orders1", true)
+		clock.currentTime = clock.currentTime.Add(11 * time.Second)
+		request(breakers, "orders1", true)
+		request(breakers, "orders1", true)
+
+		assert.Empty(t, breakers.States())
+	})
+
+	t.Run("SHOULD let the configured number of probes through WHEN the open time passed", func(t *testing.T) {
+		clock := &fakeClock{currentTime: time.Unix(1000, 0)}
+		breakers := proxy.NewBreakers(clock, stubBreakerConfig())
+		for i := 0; i < 4; i++ {
+			request(breakers, "orders1", true)
+		}
+
+		clock.currentTime = clock.currentTime.Add(30 * time.Second)
+		assert.Equal(t, proxy.BreakerHalfOpen, breakers.States()[0].State)
+
+		first, err := breakers.Acquire("/orders", "orders1")
+		assert.Nil(t, err)
+		second, err := breakers.Acquire("/orders", "orders1")
+		assert.Nil(t, err)
+		_, err = breakers.Acquire("/orders", "orders1")
+		assert.NotNil(t, err)
+
+		first(false)
+		second(false)
+		assert.Empty(t, breakers.States())
+		assert.True(t, request(breakers, "orders1", false))
+	})
+
+	t.Run("SHOULD open the circuit again WHEN a probe fails", func(t *testing.T) {
+		clock := &fakeClock{currentTime: time.Unix(1000, 0)}
+		breakers := proxy.NewBreakers(clock, stubBreakerConfig())
+		for i := 0; i < 4; i++ {
+			request(breakers, "orders1", true)
+		}
+
+		clock.currentTime = clock.currentTime.Add(30 * time.Second)
+		assert.True(t, request(breakers, "orders1", true))
+
+		assert.Equal(t, proxy.BreakerOpen, breakers.States()[0].State)
+		assert.False(t, request(breakers, "orders1", false))
+	})
+
+	t.Run("SHOULD count slow requests as failed WHEN a slow call latency is set", func(t *testing.T) {
+		clock := &fakeClock{currentTime: time.Unix(1000, 0)}
+		config := stubBreakerConfig()
+		config.SlowCall = time.Second
+		breakers := proxy.NewBreakers(clock, config)
+
+		for i := 0; i < 4; i++ {
+			done, _ := breakers.Acquire("/orders", "orders1")
+			clock.currentTime = clock.currentTime.Add(2 * time.Second)
+			done(false)
+		}
+
+		assert.Equal(t, proxy.BreakerOpen, breakers.States()[0].State)
+	})
+
+	t.Run("SHOULD only open the circuit of the failing service WHEN breakers are per instance", func(t *testing.T) {
+		config := stubBreakerConfig()
+		config.PerInstance = true
+		breakers := proxy.NewBreakers(&fakeClock{currentTime: time.Unix(1000, 0)}, config)
+		for i := 0; i < 6; i++ {
+			request(breakers, "orders2", false)
+		}
+		for i := 0; i < 4; i++ {
+			request(breakers, "orders1", true)
+		}
+
+		assert.Equal(t, []proxy.BreakerStatus{{Path: "/orders", ServiceId: "orders1", State: proxy.BreakerOpen}}, breakers.States())
+		_, err := breakers.Acquire("/orders", "orders1")
+		assert.Equal(t, &proxy.OpenCircuitError{Path: "/orders", ServiceId: "orders1"}, err)
+		assert.True(t, request(breakers, "orders2", false))
+	})
+
+	t.Run("SHOULD notify subscribers WHEN a breaker changes state", func(t *testing.T) {
+		breakers := proxy.NewBreakers(&fakeClock{currentTime: time.Unix(1000, 0)}, stubBreakerConfig())
+		changes := make([]proxy.BreakerStatus, 0)
+		breakers.Subscribe(func(status proxy.BreakerStatus) {
+			changes = append(changes, status)
+		})
+
+		for i := 0; i < 4; i++ {
+			request(breakers, "orders1", true)
+		}
+
+		assert.Equal(t, []proxy.BreakerStatus{{Path: "/orders", State: proxy.BreakerOpen}}, changes)
+	})
+
+	t.Run("SHOULD let every request through WHEN breakers are disabled", func(t *testing.T) {
+		breakers := proxy.NewBreakers(&fakeClock{currentTime: time.Unix(1000, 0)}, proxy.BreakerConfig{})
+		for i := 0; i < 100; i++ {
+			assert.True(t, request(breakers, "orders1", true))
+		}
+		assert.Empty(t, breakers.States())
+	})
+}
@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+
+	"github.com/anjolaoluwaakindipe/duller/internal/utils"
+)
+
+// budgetWindow is how far back a RetryBudget counts requests and retries
+const budgetWindow = 10 * time.Second
+
+// budgetBucket counts the requests and retries of a single second
+type budgetBucket struct {
+	second   int64
+	requests int
+	retries  int
+}
+
+// RetryBudget caps retries as a percentage of the requests of the last 10 seconds,
+// so retries cannot multiply the load of services that are already failing
+type RetryBudget struct {
+	clock   utils.Clock
+	percent int
+	// minPerSecond retries are allowed whatever the traffic, so services receiving
+	// few requests can still be retried
+	minPerSecond int
+	mutex        sync.Mutex
+	buckets      [budgetWindow / time.Second]budgetBucket
+}
+
+// NewRetryBudget creates a RetryBudget allowing percent retries for every hundred
+// requests on top of minPerSecond retries every second
+func NewRetryBudget(clock utils.Clock, percent int, minPerSecond int) *RetryBudget {
+	return &RetryBudget{clock: clock, percent: percent, minPerSecond: minPerSecond}
+}
+
+// bucket returns the bucket of the current second, emptying it when it was last
+// used more than a window ago
+func (rb *RetryBudget) bucket() *budgetBucket {
+	second := rb.clock.Now().Unix()
+	bucket := &rb.buckets[second%int64(len(rb.buckets))]
+	if bucket.second != second {
+		*bucket = budgetBucket{second: second}
+	}
+	return bucket
+}
+
+// Request records a new request
+func (rb *RetryBudget) Request() {
+	rb.mutex.Lock()
+	defer rb.mutex.Unlock()
+	rb.bucket().requests++
+}
+
+// Retry records a retry and returns true when the budget allows it
+func (rb *RetryBudget) Retry() bool {
+	rb.mutex.Lock()
+	defer rb.mutex.Unlock()
+
+	current := rb.bucket()
+	oldest := current.second - int64(len(rb.buckets)) + 1
+	requests, retries := 0, 0
+	for _, bucket := range rb.buckets {
+		if bucket.second >= oldest {
+			requests += bucket.requests
+			retries += bucket.retries
+		}
+	}
+
+	allowed := requests*rb.percent/100 + rb.minPerSecond*len(rb.buckets)
+	if retries >= allowed {
+		return false
+	}
+	current.retries++
+	return true
+}
@@ -0,0 +1,54 @@
+package proxy_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/anjolaoluwaakindipe/duller/internal/proxy"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeClock struct {
+	currentTime time.Time
+}
+
+func (fc *fakeClock) Now() time.Time {
+	return fc.currentTime
+}
+
+func Test_RetryBudget_Retry(t *testing.T) {
+	t.Run("SHOULD allow retries up to the percentage of requests WHEN there is no minimum", func(t *testing.T) {
+		budget := proxy.NewRetryBudget(&fakeClock{currentTime: time.Unix(1000, 0)}, 20, 0)
+		for i := 0; i < 10; i++ {
+			budget.Request()
+		}
+
+		assert.True(t, budget.Retry())
+		assert.True(t, budget.Retry())
+		assert.False(t, budget.Retry())
+	})
+
+	t.Run("SHOULD forget requests and retries WHEN they are older than the window", func(t *testing.T) {
+		clock := &fakeClock{currentTime: time.Unix(1000, 0)}
+		budget := proxy.NewRetryBudget(clock, 20, 0)
+		for i := 0; i < 10; i++ {
+			budget.Request()
+		}
+		assert.True(t, budget.Retry())
+
+		clock.currentTime = clock.currentTime.Add(5 * time.Second)
+		assert.True(t, budget.Retry())
+		assert.False(t, budget.Retry())
+
+		clock.currentTime = clock.currentTime.Add(10 * time.Second)
+		assert.False(t, budget.Retry())
+	})
+
+	t.Run("SHOULD allow the minimum retries WHEN there are no requests", func(t *testing.T) {
+		budget := proxy.NewRetryBudget(&fakeClock{currentTime: time.Unix(1000, 0)}, 20, 1)
+		for i := 0; i < 10; i++ {
+			assert.True(t, budget.Retry())
+		}
+		assert.False(t, budget.Retry())
+	})
+}
@@ -0,0 +1,307 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/anjolaoluwaakindipe/duller/internal/balancer"
+	"github.com/anjolaoluwaakindipe/duller/internal/service"
+	"github.com/anjolaoluwaakindipe/duller/internal/utils"
+)
+
+// how many times the load balancer is asked for a service that was not tried yet
+const pickAttempts = 3
+
+// RetryPolicy holds configuration for retrying proxied requests on other services
+type RetryPolicy struct {
+	// Retries is how many times a failed request is retried. Requests with an
+	// idempotent method are retried after any error, other requests only when the
+	// service refused the connection. Retries are disabled when it is 0.
+	Retries int
+	// PerTryTimeout bounds how long a service may take to answer a single attempt.
+	// Attempts are not bounded when it is 0.
+	PerTryTimeout time.Duration
+	// BudgetPercent caps retries as a percentage of the requests of the last 10s
+	BudgetPercent int
+	// MinRetriesPerSecond are always allowed whatever BudgetPercent allows
+	MinRetriesPerSecond int
+	// MaxBodyBytes is the largest request body buffered so it can be replayed.
+	// Requests with larger bodies are never retried.
+	MaxBodyBytes int64
+}
+
+// Validate returns an error when a setting of the policy is out of range
+func (rp RetryPolicy) Validate() error {
+	if rp.Retries < 0 || rp.PerTryTimeout < 0 || rp.MinRetriesPerSecond < 0 || rp.MaxBodyBytes < 0 {
+		return fmt.Errorf("retries, per try timeout, minimum retries per second and maximum body size cannot be negative")
+	}
+	if rp.BudgetPercent < 0 || rp.BudgetPercent > 100 {
+		return fmt.Errorf("retry budget must be between 0 and 100 percent, got %v", rp.BudgetPercent)
+	}
+	return nil
+}
+
+// Observer is told whether every attempt of a proxied request succeeded
+type Observer interface {
+	RecordSuccess(serviceInfo *service.ServiceInfo)
+	RecordFailure(serviceInfo *service.ServiceInfo)
+}
+
+// Forwarder proxies requests to services picked by a load balancer and retries
+// the attempts that fail on other services of the same path
+type Forwarder struct {
+	balancer  balancer.LoadBalancer
+	policy    RetryPolicy
+	budget    *RetryBudget
+	breakers  *Breakers
+	observers []Observer
+	// writeError answers requests the forwarder fails itself
+	writeError func(w http.ResponseWriter, status int, message string)
+}
+
+// ForwarderOpt are option functions that setup a Forwarder
+type ForwarderOpt func(*Forwarder)
+
+// WithRetryPolicy sets how failed attempts are retried
+func WithRetryPolicy(policy RetryPolicy) ForwarderOpt {
+	return func(f *Forwarder) {
+		f.policy = policy
+	}
+}
+
+// WithObserver tells the observer the result of every attempt
+func WithObserver(observer Observer) ForwarderOpt {
+	return func(f *Forwarder) {
+		f.observers = append(f.observers, observer)
+	}
+}
+
+// WithBreakers fails requests fast while the circuit breaker of their path or of
+// the picked service is open
+func WithBreakers(breakers *Breakers) ForwarderOpt {
+	return func(f *Forwarder) {
+		f.breakers = breakers
+	}
+}
+
+// WithErrorWriter sets how the requests the forwarder fails itself, such as the
+// ones failed fast by a circuit breaker, are answered
+func WithErrorWriter(writeError func(w http.ResponseWriter, status int, message string)) ForwarderOpt {
+	return func(f *Forwarder) {
+		f.writeError = writeError
+	}
+}
+
+// WithClock sets the clock the retry budget counts requests with
+func WithClock(clock utils.Clock) ForwarderOpt {
+	return func(f *Forwarder) {
+		f.budget.clock = clock
+	}
+}
+
+// NewForwarder creates a Forwarder picking services with the load balancer. Failed
+// attempts are not retried unless a RetryPolicy is given.
+func NewForwarder(loadBalancer balancer.LoadBalancer, opts ...ForwarderOpt) *Forwarder {
+	f := &Forwarder{
+		balancer:   loadBalancer,
+		budget:     NewRetryBudget(utils.NewClock(), 0, 0),
+		breakers:   NewBreakers(utils.NewClock(), BreakerConfig{}),
+		writeError: func(w http.ResponseWriter, status int, message string) { http.Error(w, message, status) },
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+	f.budget.percent = f.policy.BudgetPercent
+	f.budget.minPerSecond = f.policy.MinRetriesPerSecond
+	return f
+}
+
+// attemptError is the error an attempt failed with before anything was written
+// to the client
+type attemptError struct {
+	err      error
+	timedOut bool
+}
+
+// Forward proxies the request to the service, which was picked by the load
+// balancer for the path. Attempts that fail are retried on other services of the
+// path as long as the policy and the retry budget allow it, else the client gets
+// a 502, or a 504 when the service did not answer in time. Requests are failed
+// fast with a 503 while the circuit breaker of the path is open.
+func (f *Forwarder) Forward(w http.ResponseWriter, r *http.Request, path string, serviceInfo *service.ServiceInfo, proxyfunc func(string) (*httputil.ReverseProxy, error)) {
+	f.budget.Request()
+
+	body, replayable := f.bufferBody(r)
+	tried := map[string]bool{}
+
+	for attempt := 0; ; attempt++ {
+		tried[serviceInfo.ServiceId] = true
+		if replayable {
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		record, err := f.breakers.Acquire(path, serviceInfo.ServiceId)
+		if err != nil {
+			// a service whose own breaker is open is skipped without spending a retry
+			var open *OpenCircuitError
+			if errors.As(err, &open) && len(open.ServiceId) > 0 {
+				if next := f.untried(path, r, tried); next != nil {
+					serviceInfo = next
+					attempt--
+					continue
+				}
+			}
+			f.writeError(w, http.StatusServiceUnavailable, err.Error())
+			return
+		}
+
+		failure, err := f.attempt(w, r, serviceInfo, proxyfunc, record)
+		if err != nil {
+			slog.Error(fmt.Sprintf("address of discovered service is invalid : %v", err))
+			f.writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if failure == nil {
+			return
+		}
+
+		slog.Warn(fmt.Sprintf("Could not proxy request to service %v: %v", serviceInfo.ServiceId, failure.err))
+
+		var next *service.ServiceInfo
+		if replayable && attempt < f.policy.Retries && f.retriable(r, failure) {
+			next = f.untried(path, r, tried)
+		}
+		if next == nil || !f.budget.Retry() {
+			if failure.timedOut || errors.Is(failure.err, context.DeadlineExceeded) {
+				f.writeError(w, http.StatusGatewayTimeout, fmt.Sprintf("service of path '%v' did not answer in time", path))
+				return
+			}
+			f.writeError(w, http.StatusBadGateway, fmt.Sprintf("service of path '%v' could not be reached", path))
+			return
+		}
+		serviceInfo = next
+	}
+}
+
+// attempt proxies the request to a single service. A failure is returned when
+// the attempt failed before anything was written to the client.
+func (f *Forwarder) attempt(w http.ResponseWriter, r *http.Request, serviceInfo *service.ServiceInfo, proxyfunc func(string) (*httputil.ReverseProxy, error), record func(failed bool)) (*attemptError, error) {
+	proxy, err := proxyfunc("http://" + net.JoinHostPort(serviceInfo.IP, serviceInfo.Port))
+	if err != nil {
+		record(false)
+		return nil, err
+	}
+
+	failed := false
+	defer func() { record(failed) }()
+
+	done := balancer.StartRequest(f.balancer, serviceInfo)
+	defer done()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	var timedOut atomic.Bool
+	var timer *time.Timer
+	if f.policy.PerTryTimeout > 0 {
+		timer = time.AfterFunc(f.policy.PerTryTimeout, func() {
+			timedOut.Store(true)
+			cancel()
+		})
+		defer timer.Stop()
+	}
+
+	var failure *attemptError
+	proxy.ModifyResponse = func(response *http.Response) error {
+		// the service answered in time, so the body is copied whatever it takes
+		if timer != nil && !timer.Stop() {
+			return context.DeadlineExceeded
+		}
+		failed = response.StatusCode >= http.StatusInternalServerError
+		for _, observer := range f.observers {
+			if response.StatusCode >= http.StatusInternalServerError {
+				observer.RecordFailure(serviceInfo)
+			} else {
+				observer.RecordSuccess(serviceInfo)
+			}
+		}
+		return nil
+	}
+	proxy.ErrorHandler = func(_ http.ResponseWriter, _ *http.Request, err error) {
+		// requests the client gave up on say nothing about the service
+		failed = !errors.Is(r.Context().Err(), context.Canceled)
+		for _, observer := range f.observers {
+			observer.RecordFailure(serviceInfo)
+		}
+		failure = &attemptError{err: err, timedOut: timedOut.Load()}
+	}
+
+	proxy.ServeHTTP(w, r.WithContext(ctx))
+	return failure, nil
+}
+
+// bufferBody reads the body of the request so it can be sent again and returns
+// false when it is larger than MaxBodyBytes. The request keeps its whole body.
+func (f *Forwarder) bufferBody(r *http.Request) ([]byte, bool) {
+	if f.policy.Retries == 0 {
+		return nil, false
+	}
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, true
+	}
+	if r.ContentLength > f.policy.MaxBodyBytes {
+		return nil, false
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, f.policy.MaxBodyBytes+1))
+	if err != nil || int64(len(body)) > f.policy.MaxBodyBytes {
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		return nil, false
+	}
+	r.Body.Close()
+	return body, true
+}
+
+// retriable reports whether the policy allows retrying the failed attempt
+func (f *Forwarder) retriable(r *http.Request, failure *attemptError) bool {
+	// the client is gone or the deadline of the whole request passed
+	if r.Context().Err() != nil {
+		return false
+	}
+	if errors.Is(failure.err, syscall.ECONNREFUSED) {
+		return true
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// untried asks the load balancer for a service of the path that was not tried yet
+// and returns nil when it keeps picking tried ones
+func (f *Forwarder) untried(path string, r *http.Request, tried map[string]bool) *service.ServiceInfo {
+	for i := 0; i < pickAttempts; i++ {
+		next, err := balancer.GetServiceForRequest(f.balancer, path, r)
+		if err != nil || next == nil {
+			return nil
+		}
+		if !tried[next.ServiceId] {
+			return next
+		}
+	}
+	return nil
+}
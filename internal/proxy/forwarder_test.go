@@ -0,0 +1,210 @@
+package proxy_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anjolaoluwaakindipe/duller/internal/balancer"
+	"github.com/anjolaoluwaakindipe/duller/internal/proxy"
+	"github.com/anjolaoluwaakindipe/duller/internal/registry"
+	"github.com/anjolaoluwaakindipe/duller/internal/service"
+	"github.com/anjolaoluwaakindipe/duller/internal/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// stubService registers a service on /orders served by the handler
+func stubService(t *testing.T, reg registry.Registry, serviceId string, handler http.HandlerFunc) *service.ServiceInfo {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	address, _ := url.Parse(server.URL)
+	registeredService := &service.ServiceInfo{Path: "/orders", ServiceId: serviceId, IP: address.Hostname(), Port: address.Port()}
+	reg.RegisterService(registeredService)
+	return registeredService
+}
+
+// echo answers with the id of the service and the body of the request
+func echo(serviceId string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write([]byte(serviceId + " " + string(body)))
+	}
+}
+
+// refusing registers a service whose connections are refused
+func refusing(t *testing.T, reg registry.Registry, serviceId string) *service.ServiceInfo {
+	registeredService := stubService(t, reg, serviceId, echo(serviceId))
+	server := httptest.NewServer(nil)
+	address, _ := url.Parse(server.URL)
+	server.Close()
+	registeredService.Port = address.Port()
+	return registeredService
+}
+
+// hangingUp registers a service closing every connection without answering
+func hangingUp(t *testing.T, reg registry.Registry, serviceId string) *service.ServiceInfo {
+	return stubService(t, reg, serviceId, func(w http.ResponseWriter, r *http.Request) {
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		conn.Close()
+	})
+}
+
+func stubPolicy() proxy.RetryPolicy {
+	return proxy.RetryPolicy{Retries: 2, BudgetPercent: 20, MinRetriesPerSecond: 10, MaxBodyBytes: 1024}
+}
+
+func forward(forwarder *proxy.Forwarder, req *http.Request, first *service.ServiceInfo) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	forwarder.Forward(recorder, req, "/orders", first, utils.ProxyRequest)
+	return recorder
+}
+
+func Test_Forwarder_Forward(t *testing.T) {
+	t.Run("SHOULD retry on another service WHEN the connection is refused", func(t *testing.T) {
+		reg := registry.InitInMemoryRegistry(utils.NewClock())
+		first := refusing(t, reg, "orders1")
+		stubService(t, reg, "orders2", echo("orders2"))
+		forwarder := proxy.NewForwarder(balancer.NewRoundRobinLoadBalancer(reg), proxy.WithRetryPolicy(stubPolicy()))
+
+		response := forward(forwarder, httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader("order")), first)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, "orders2 order", response.Body.String())
+	})
+
+	t.Run("SHOULD only retry idempotent methods WHEN the service hangs up", func(t *testing.T) {
+		reg := registry.InitInMemoryRegistry(utils.NewClock())
+		first := hangingUp(t, reg, "orders1")
+		stubService(t, reg, "orders2", echo("orders2"))
+		forwarder := proxy.NewForwarder(balancer.NewRoundRobinLoadBalancer(reg), proxy.WithRetryPolicy(stubPolicy()))
+
+		response := forward(forwarder, httptest.NewRequest(http.MethodPut, "/orders", strings.NewReader("order")), first)
+		assert.Equal(t, "orders2 order", response.Body.String())
+
+		response = forward(forwarder, httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader("order")), first)
+		assert.Equal(t, http.StatusBadGateway, response.Code)
+	})
+
+	t.Run("SHOULD retry on another service WHEN an attempt takes longer than the per try timeout", func(t *testing.T) {
+		reg := registry.InitInMemoryRegistry(utils.NewClock())
+		first := stubService(t, reg, "orders1", func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-time.After(time.Second):
+			case <-r.Context().Done():
+			}
+		})
+		stubService(t, reg, "orders2", echo("orders2"))
+		policy := stubPolicy()
+		policy.PerTryTimeout = 50 * time.Millisecond
+		forwarder := proxy.NewForwarder(balancer.NewRoundRobinLoadBalancer(reg), proxy.WithRetryPolicy(policy))
+
+		response := forward(forwarder, httptest.NewRequest(http.MethodGet, "/orders", nil), first)
+		assert.Equal(t, "orders2 ", response.Body.String())
+
+		reg.DeregisterService("/orders", "orders2")
+		response = forward(forwarder, httptest.NewRequest(http.MethodGet, "/orders", nil), first)
+		assert.Equal(t, http.StatusGatewayTimeout, response.Code)
+	})
+
+	t.Run("SHOULD not retry WHEN the body is larger than the buffering limit", func(t *testing.T) {
+		reg := registry.InitInMemoryRegistry(utils.NewClock())
+		first := refusing(t, reg, "orders1")
+		stubService(t, reg, "orders2", echo("orders2"))
+		policy := stubPolicy()
+		policy.MaxBodyBytes = 4
+		forwarder := proxy.NewForwarder(balancer.NewRoundRobinLoadBalancer(reg), proxy.WithRetryPolicy(policy))
+
+		response := forward(forwarder, httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader("order")), first)
+		assert.Equal(t, http.StatusBadGateway, response.Code)
+	})
+
+	t.Run("SHOULD give up WHEN every service failed", func(t *testing.T) {
+		reg := registry.InitInMemoryRegistry(utils.NewClock())
+		first := refusing(t, reg, "orders1")
+		refusing(t, reg, "orders2")
+		forwarder := proxy.NewForwarder(balancer.NewRoundRobinLoadBalancer(reg), proxy.WithRetryPolicy(stubPolicy()))
+
+		response := forward(forwarder, httptest.NewRequest(http.MethodGet, "/orders", nil), first)
+		assert.Equal(t, http.StatusBadGateway, response.Code)
+	})
+
+	t.Run("SHOULD stop retrying WHEN the retry budget is spent", func(t *testing.T) {
+		reg := registry.InitInMemoryRegistry(utils.NewClock())
+		first := refusing(t, reg, "orders1")
+		stubService(t, reg, "orders2", echo("orders2"))
+		policy := stubPolicy()
+		policy.BudgetPercent = 50
+		policy.MinRetriesPerSecond = 0
+		forwarder := proxy.NewForwarder(balancer.NewRoundRobinLoadBalancer(reg), proxy.WithRetryPolicy(policy))
+
+		// the first request is allowed half a retry
+		response := forward(forwarder, httptest.NewRequest(http.MethodGet, "/orders", nil), first)
+		assert.Equal(t, http.StatusBadGateway, response.Code)
+
+		response = forward(forwarder, httptest.NewRequest(http.MethodGet, "/orders", nil), first)
+		assert.Equal(t, "orders2 ", response.Body.String())
+
+		response = forward(forwarder, httptest.NewRequest(http.MethodGet, "/orders", nil), first)
+		assert.Equal(t, http.StatusBadGateway, response.Code)
+	})
+
+	t.Run("SHOULD answer bad gateway WHEN no retry policy is given", func(t *testing.T) {
+		reg := registry.InitInMemoryRegistry(utils.NewClock())
+		first := refusing(t, reg, "orders1")
+		stubService(t, reg, "orders2", echo("orders2"))
+		forwarder := proxy.NewForwarder(balancer.NewRoundRobinLoadBalancer(reg))
+
+		response := forward(forwarder, httptest.NewRequest(http.MethodGet, "/orders", nil), first)
+		assert.Equal(t, http.StatusBadGateway, response.Code)
+	})
+
+	t.Run("SHOULD fail fast with the error writer WHEN the circuit of the path is open", func(t *testing.T) {
+		reg := registry.InitInMemoryRegistry(utils.NewClock())
+		first := stubService(t, reg, "orders1", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		})
+		breakers := proxy.NewBreakers(&fakeClock{currentTime: time.Unix(1000, 0)}, stubBreakerConfig())
+		forwarder := proxy.NewForwarder(balancer.NewRoundRobinLoadBalancer(reg),
+			proxy.WithBreakers(breakers),
+			proxy.WithErrorWriter(func(w http.ResponseWriter, status int, message string) {
+				w.WriteHeader(status)
+				w.Write([]byte("breaker: " + message))
+			}),
+		)
+
+		for i := 0; i < 4; i++ {
+			response := forward(forwarder, httptest.NewRequest(http.MethodGet, "/orders", nil), first)
+			assert.Equal(t, http.StatusInternalServerError, response.Code)
+		}
+
+		response := forward(forwarder, httptest.NewRequest(http.MethodGet, "/orders", nil), first)
+		assert.Equal(t, http.StatusServiceUnavailable, response.Code)
+		assert.Equal(t, "breaker: circuit of path '/orders' is open", response.Body.String())
+	})
+
+	t.Run("SHOULD send the request to another service WHEN the circuit of the first one is open", func(t *testing.T) {
+		reg := registry.InitInMemoryRegistry(utils.NewClock())
+		first := stubService(t, reg, "orders1", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		})
+		config := stubBreakerConfig()
+		config.PerInstance = true
+		breakers := proxy.NewBreakers(&fakeClock{currentTime: time.Unix(1000, 0)}, config)
+		forwarder := proxy.NewForwarder(balancer.NewRoundRobinLoadBalancer(reg), proxy.WithBreakers(breakers))
+		second := stubService(t, reg, "orders2", echo("orders2"))
+		// the path keeps succeeding often enough for its own circuit to stay closed
+		for i := 0; i < 6; i++ {
+			forward(forwarder, httptest.NewRequest(http.MethodGet, "/orders", nil), second)
+		}
+		for i := 0; i < 4; i++ {
+			forward(forwarder, httptest.NewRequest(http.MethodGet, "/orders", nil), first)
+		}
+
+		response := forward(forwarder, httptest.NewRequest(http.MethodGet, "/orders", nil), first)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, "orders2 ", response.Body.String())
+	})
+}
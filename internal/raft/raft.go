@@ -0,0 +1,976 @@
+// Package raft implements the raft consensus algorithm. It is used to replicate
+// the registry across discovery servers when strong consistency is needed.
+//
+// Membership is static: every node is started with the ids (host:port) of all the
+// other nodes of the cluster.
+package raft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNotLeader is returned when a leader only operation is done on a follower
+	ErrNotLeader = errors.New("raft: node is not the leader")
+	// ErrLeadershipLost is returned when a command was proposed but the leader lost
+	// its leadership before the command was committed. The command may or may not
+	// be applied.
+	ErrLeadershipLost = errors.New("raft: leadership lost while applying command")
+)
+
+type role int
+
+const (
+	follower role = iota
+	candidate
+	leader
+)
+
+// maxEntriesPerAppend limits the size of a single AppendEntries rpc
+const maxEntriesPerAppend = 256
+
+// Entry is a single entry of the replicated log. Entries without a command are
+// no-ops appended by a new leader.
+type Entry struct {
+	Index   uint64 `json:"index"`
+	Term    uint64 `json:"term"`
+	Command []byte `json:"command,omitempty"`
+}
+
+// FSM is the state machine that committed commands are applied to
+type FSM interface {
+	// Apply applies a committed command. Commands are applied in the same order on every node.
+	Apply(command []byte) error
+	// Snapshot returns the whole state of the state machine
+	Snapshot() ([]byte, error)
+	// Restore replaces the state of the state machine with a snapshot
+	Restore(snapshot []byte) error
+}
+
+// Config holds configuration for a Node
+type Config struct {
+	// ID is the address (host:port) other nodes reach this node on
+	ID string
+	// Peers are the ids of every other node of the cluster
+	Peers []string
+	// ElectionTimeout is the minimum time a follower waits without hearing from a
+	// leader before starting an election. The actual timeout is randomized between
+	// ElectionTimeout and twice ElectionTimeout.
+	ElectionTimeout time.Duration
+	// HeartbeatInterval is the interval at which the leader sends heartbeats. It should be
+	// a fraction of the ElectionTimeout.
+	HeartbeatInterval time.Duration
+	// SnapshotThreshold is the number of applied entries after which the log is compacted
+	SnapshotThreshold uint64
+	// Key is sent with every rpc and every rpc received must carry it
+	Key string
+}
+
+// Node is a single member of a raft cluster
+type Node struct {
+	mutex sync.Mutex
+	// applyMutex serializes every access to the fsm
+	applyMutex sync.Mutex
+	config     Config
+	fsm        FSM
+	storage    Storage
+	transport  Transport
+
+	role        role
+	currentTerm uint64
+	votedFor    string
+	leaderId    string
+	// log[0] is a placeholder for the last entry included in the snapshot
+	log         []Entry
+	snapshot    []byte
+	commitIndex uint64
+	lastApplied uint64
+
+	nextIndex  map[string]uint64
+	matchIndex map[string]uint64
+	inflight   map[string]bool
+
+	electionDeadline time.Time
+	lastBroadcast    time.Time
+
+	// waiters are notified once the entry at their index is applied
+	waiters map[uint64]waiter
+	// commitSignal wakes up the apply loop when the commit index moves
+	commitSignal chan struct{}
+	// appliedSignal is closed and replaced every time lastApplied moves
+	appliedSignal chan struct{}
+}
+
+type waiter struct {
+	term   uint64
+	result chan error
+}
+
+// NewNode creates a Node and restores any state found in storage.
+func NewNode(config Config, fsm FSM, storage Storage, transport Transport) (*Node, error) {
+	if config.ElectionTimeout <= 0 {
+		config.ElectionTimeout = time.Second
+	}
+	if config.HeartbeatInterval <= 0 {
+		config.HeartbeatInterval = config.ElectionTimeout / 5
+	}
+	if config.SnapshotThreshold == 0 {
+		config.SnapshotThreshold = 1024
+	}
+
+	peers := make([]string, 0)
+	for _, peer := range config.Peers {
+		if len(peer) > 0 && peer != config.ID {
+			peers = append(peers, peer)
+		}
+	}
+	config.Peers = peers
+
+	n := &Node{
+		config:        config,
+		fsm:           fsm,
+		storage:       storage,
+		transport:     transport,
+		log:           []Entry{{}},
+		nextIndex:     make(map[string]uint64),
+		matchIndex:    make(map[string]uint64),
+		inflight:      make(map[string]bool),
+		waiters:       make(map[uint64]waiter),
+		commitSignal:  make(chan struct{}, 1),
+		appliedSignal: make(chan struct{}),
+	}
+
+	state, found, err := storage.LoadState()
+	if err != nil {
+		return nil, err
+	}
+	if found && len(state.Log) > 0 {
+		n.currentTerm = state.CurrentTerm
+		n.votedFor = state.VotedFor
+		n.log = state.Log
+	}
+
+	snapshot, err := storage.LoadSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	if snapshot != nil {
+		if err := fsm.Restore(snapshot); err != nil {
+			return nil, fmt.Errorf("could not restore raft snapshot: %w", err)
+		}
+		n.snapshot = snapshot
+		n.commitIndex = n.log[0].Index
+		n.lastApplied = n.log[0].Index
+	}
+
+	n.resetElectionDeadline()
+	return n, nil
+}
+
+// Run drives elections, heartbeats and the application of committed entries
+// until the context is cancelled. This is meant to be used in a goroutine
+func (n *Node) Run(ctx context.Context) {
+	go n.applyLoop(ctx)
+
+	ticker := time.NewTicker(n.config.HeartbeatInterval / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			n.mutex.Lock()
+			n.becomeFollower(n.currentTerm)
+			n.mutex.Unlock()
+			return
+		case <-ticker.C:
+			n.tick(ctx)
+		}
+	}
+}
+
+// ID returns the id of the node
+func (n *Node) ID() string {
+	return n.config.ID
+}
+
+// IsLeader reports whether the node currently believes it is the leader
+func (n *Node) IsLeader() bool {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return n.role == leader
+}
+
+// Leader returns the id of the current leader or an empty string if it is unknown
+func (n *Node) Leader() string {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return n.leaderId
+}
+
+// Apply replicates a command and waits until it is applied to the state machine of
+// this node. Followers forward the command to the leader. If there is no leader
+// yet, Apply keeps retrying until the context is done.
+func (n *Node) Apply(ctx context.Context, command []byte) error {
+	for {
+		n.mutex.Lock()
+		isLeader := n.role == leader
+		leaderId := n.leaderId
+		n.mutex.Unlock()
+
+		var err error
+		var index uint64
+
+		switch {
+		case isLeader:
+			index, err = n.proposeIndex(ctx, command)
+		case len(leaderId) > 0:
+			err = n.transport.Apply(ctx, leaderId, command)
+			if err == nil {
+				// make sure the command is visible on this node before returning
+				return n.ReadBarrier(ctx)
+			}
+		default:
+			err = ErrNotLeader
+		}
+
+		if err == nil {
+			return n.waitApplied(ctx, index)
+		}
+
+		if !n.isRetryable(err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("raft: could not apply command: %w", err)
+		case <-time.After(n.config.HeartbeatInterval):
+		}
+	}
+}
+
+// ReadBarrier waits until every command committed before it was called has been
+// applied to the state machine of this node, which makes reads done after it
+// linearizable.
+func (n *Node) ReadBarrier(ctx context.Context) error {
+	for {
+		n.mutex.Lock()
+		isLeader := n.role == leader
+		leaderId := n.leaderId
+		n.mutex.Unlock()
+
+		var index uint64
+		var err error
+
+		switch {
+		case isLeader:
+			index, err = n.leaderReadIndex(ctx)
+		case len(leaderId) > 0:
+			index, err = n.transport.ReadIndex(ctx, leaderId)
+		default:
+			err = ErrNotLeader
+		}
+
+		if err == nil {
+			return n.waitApplied(ctx, index)
+		}
+
+		if !n.isRetryable(err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("raft: could not confirm leader: %w", err)
+		case <-time.After(n.config.HeartbeatInterval):
+		}
+	}
+}
+
+// isRetryable reports whether an error is caused by a leader change or an
+// unreachable leader rather than by the command itself
+func (n *Node) isRetryable(err error) bool {
+	if errors.Is(err, ErrNotLeader) || errors.Is(err, ErrLeadershipLost) {
+		return true
+	}
+	// errors applying a command are returned as plain errors by the transport, anything
+	// else wrapping an underlying cause is a network error
+	return errors.Unwrap(err) != nil
+}
+
+// propose appends a command to the log of the leader and waits until it is applied
+func (n *Node) propose(ctx context.Context, command []byte) error {
+	index, err := n.proposeIndex(ctx, command)
+	if err != nil {
+		return err
+	}
+	return n.waitApplied(ctx, index)
+}
+
+// proposeIndex appends a command to the log of the leader and waits until it is
+// committed and applied, returning its index.
+func (n *Node) proposeIndex(ctx context.Context, command []byte) (uint64, error) {
+	n.mutex.Lock()
+	if n.role != leader {
+		n.mutex.Unlock()
+		return 0, ErrNotLeader
+	}
+
+	entry := Entry{Index: n.lastIndex() + 1, Term: n.currentTerm, Command: command}
+	if err := n.persistEntries([]Entry{entry}); err != nil {
+		n.mutex.Unlock()
+		return 0, fmt.Errorf("raft: could not persist command: %w", err)
+	}
+	n.log = append(n.log, entry)
+
+	result := make(chan error, 1)
+	n.waiters[entry.Index] = waiter{term: entry.Term, result: result}
+	n.advanceCommitIndex()
+	n.mutex.Unlock()
+
+	n.broadcastAppendEntries(ctx)
+
+	select {
+	case err := <-result:
+		return entry.Index, err
+	case <-ctx.Done():
+		n.mutex.Lock()
+		delete(n.waiters, entry.Index)
+		n.mutex.Unlock()
+		return 0, ctx.Err()
+	}
+}
+
+// leaderReadIndex returns the commit index of the leader once it has confirmed
+// that it is still the leader of the cluster.
+func (n *Node) leaderReadIndex(ctx context.Context) (uint64, error) {
+	for {
+		n.mutex.Lock()
+		if n.role != leader {
+			n.mutex.Unlock()
+			return 0, ErrNotLeader
+		}
+		// a new leader only knows what is committed once an entry of its own term is committed
+		committedInTerm := n.termAt(n.commitIndex) == n.currentTerm
+		index := n.commitIndex
+		term := n.currentTerm
+		n.mutex.Unlock()
+
+		if committedInTerm {
+			if !n.confirmLeadership(ctx, term) {
+				return 0, ErrNotLeader
+			}
+			return index, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(n.config.HeartbeatInterval / 2):
+		}
+	}
+}
+
+// confirmLeadership sends a round of heartbeats and reports whether a majority of
+// the cluster still recognises this node as the leader of the given term
+func (n *Node) confirmLeadership(ctx context.Context, term uint64) bool {
+	if len(n.config.Peers) == 0 {
+		return true
+	}
+
+	acks := make(chan bool, len(n.config.Peers))
+	for _, peer := range n.config.Peers {
+		go func(peer string) {
+			acks <- n.sendAppendEntries(ctx, peer, term)
+		}(peer)
+	}
+
+	votes := 1
+	for range n.config.Peers {
+		if <-acks {
+			votes++
+		}
+		if votes >= n.quorum() {
+			return true
+		}
+	}
+	return false
+}
+
+// waitApplied waits until the entry at index is applied to the state machine
+func (n *Node) waitApplied(ctx context.Context, index uint64) error {
+	for {
+		n.mutex.Lock()
+		applied := n.lastApplied >= index
+		signal := n.appliedSignal
+		n.mutex.Unlock()
+
+		if applied {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-signal:
+		}
+	}
+}
+
+func (n *Node) tick(ctx context.Context) {
+	n.mutex.Lock()
+	now := time.Now()
+
+	if n.role == leader {
+		due := now.Sub(n.lastBroadcast) >= n.config.HeartbeatInterval
+		n.mutex.Unlock()
+		if due {
+			n.broadcastAppendEntries(ctx)
+		}
+		return
+	}
+
+	if now.After(n.electionDeadline) {
+		n.startElection(ctx)
+	}
+	n.mutex.Unlock()
+}
+
+// startElection must be called with the mutex held
+func (n *Node) startElection(ctx context.Context) {
+	n.role = candidate
+	n.currentTerm++
+	n.votedFor = n.config.ID
+	n.leaderId = ""
+	n.resetElectionDeadline()
+	if err := n.persistTerm(); err != nil {
+		// a vote that is not saved could be given again to another candidate after a
+		// restart, so no vote is asked for until the next election
+		n.role = follower
+		return
+	}
+
+	term := n.currentTerm
+	args := RequestVoteArgs{
+		Term:         term,
+		CandidateId:  n.config.ID,
+		LastLogIndex: n.lastIndex(),
+		LastLogTerm:  n.termAt(n.lastIndex()),
+	}
+
+	votes := 1
+	if votes >= n.quorum() {
+		n.becomeLeader(ctx)
+		return
+	}
+
+	for _, peer := range n.config.Peers {
+		go func(peer string) {
+			rpcCtx, cancel := context.WithTimeout(ctx, n.config.ElectionTimeout)
+			defer cancel()
+
+			reply, err := n.transport.RequestVote(rpcCtx, peer, args)
+			if err != nil {
+				return
+			}
+
+			n.mutex.Lock()
+			defer n.mutex.Unlock()
+
+			if reply.Term > n.currentTerm {
+				n.becomeFollower(reply.Term)
+				return
+			}
+
+			if n.role != candidate || n.currentTerm != term || !reply.VoteGranted {
+				return
+			}
+
+			votes++
+			if votes >= n.quorum() {
+				n.becomeLeader(ctx)
+			}
+		}(peer)
+	}
+}
+
+// becomeLeader must be called with the mutex held. The node stays a candidate
+// when it cannot persist its first entry.
+func (n *Node) becomeLeader(ctx context.Context) {
+	// a no-op entry lets the leader learn what is committed from previous terms
+	noop := Entry{Index: n.lastIndex() + 1, Term: n.currentTerm}
+	if err := n.persistEntries([]Entry{noop}); err != nil {
+		return
+	}
+
+	slog.Info(fmt.Sprintf("Raft node %v became leader for term %v", n.config.ID, n.currentTerm))
+	n.role = leader
+	n.leaderId = n.config.ID
+
+	for _, peer := range n.config.Peers {
+		n.nextIndex[peer] = noop.Index
+		n.matchIndex[peer] = 0
+	}
+
+	n.log = append(n.log, noop)
+	n.advanceCommitIndex()
+
+	go n.broadcastAppendEntries(ctx)
+}
+
+// becomeFollower must be called with the mutex held. It returns an error when the
+// new term could not be persisted, in which case the node must not answer the rpc
+// carrying the term as if it had moved to it.
+func (n *Node) becomeFollower(term uint64) error {
+	var err error
+	if term > n.currentTerm {
+		n.currentTerm = term
+		n.votedFor = ""
+		err = n.persistTerm()
+	}
+
+	if n.role == leader {
+		n.leaderId = ""
+		for index, waiter := range n.waiters {
+			waiter.result <- ErrLeadershipLost
+			delete(n.waiters, index)
+		}
+	}
+
+	n.role = follower
+	return err
+}
+
+func (n *Node) broadcastAppendEntries(ctx context.Context) {
+	n.mutex.Lock()
+	if n.role != leader {
+		n.mutex.Unlock()
+		return
+	}
+	term := n.currentTerm
+	n.lastBroadcast = time.Now()
+	peers := make([]string, 0)
+	for _, peer := range n.config.Peers {
+		if !n.inflight[peer] {
+			n.inflight[peer] = true
+			peers = append(peers, peer)
+		}
+	}
+	n.mutex.Unlock()
+
+	for _, peer := range peers {
+		go func(peer string) {
+			n.sendAppendEntries(ctx, peer, term)
+			n.mutex.Lock()
+			n.inflight[peer] = false
+			n.mutex.Unlock()
+		}(peer)
+	}
+}
+
+// sendAppendEntries sends the entries a peer is missing, or a snapshot if the
+// entries were already compacted. It reports whether the peer acknowledged this
+// node as the leader of the given term.
+func (n *Node) sendAppendEntries(ctx context.Context, peer string, term uint64) bool {
+	n.mutex.Lock()
+	if n.role != leader || n.currentTerm != term {
+		n.mutex.Unlock()
+		return false
+	}
+
+	next := n.nextIndex[peer]
+	if next <= n.log[0].Index {
+		args := InstallSnapshotArgs{
+			Term:              term,
+			LeaderId:          n.config.ID,
+			LastIncludedIndex: n.log[0].Index,
+			LastIncludedTerm:  n.log[0].Term,
+			Data:              n.snapshot,
+		}
+		n.mutex.Unlock()
+		return n.sendInstallSnapshot(ctx, peer, args)
+	}
+
+	prevIndex := next - 1
+	entries := make([]Entry, 0)
+	for index := next; index <= n.lastIndex() && len(entries) < maxEntriesPerAppend; index++ {
+		entries = append(entries, n.entryAt(index))
+	}
+
+	args := AppendEntriesArgs{
+		Term:         term,
+		LeaderId:     n.config.ID,
+		PrevLogIndex: prevIndex,
+		PrevLogTerm:  n.termAt(prevIndex),
+		Entries:      entries,
+		LeaderCommit: n.commitIndex,
+	}
+	n.mutex.Unlock()
+
+	rpcCtx, cancel := context.WithTimeout(ctx, n.config.ElectionTimeout)
+	defer cancel()
+
+	reply, err := n.transport.AppendEntries(rpcCtx, peer, args)
+	if err != nil {
+		return false
+	}
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if reply.Term > n.currentTerm {
+		n.becomeFollower(reply.Term)
+		return false
+	}
+
+	if n.role != leader || n.currentTerm != term {
+		return false
+	}
+
+	if reply.Success {
+		match := args.PrevLogIndex + uint64(len(args.Entries))
+		if match > n.matchIndex[peer] {
+			n.matchIndex[peer] = match
+		}
+		n.nextIndex[peer] = n.matchIndex[peer] + 1
+		n.advanceCommitIndex()
+	} else if reply.ConflictIndex > 0 && reply.ConflictIndex < n.nextIndex[peer] {
+		n.nextIndex[peer] = reply.ConflictIndex
+	} else if n.nextIndex[peer] > 1 {
+		n.nextIndex[peer]--
+	}
+
+	return reply.Term == term
+}
+
+func (n *Node) sendInstallSnapshot(ctx context.Context, peer string, args InstallSnapshotArgs) bool {
+	rpcCtx, cancel := context.WithTimeout(ctx, n.config.ElectionTimeout)
+	defer cancel()
+
+	reply, err := n.transport.InstallSnapshot(rpcCtx, peer, args)
+	if err != nil {
+		return false
+	}
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if reply.Term > n.currentTerm {
+		n.becomeFollower(reply.Term)
+		return false
+	}
+
+	if n.role != leader || n.currentTerm != args.Term {
+		return false
+	}
+
+	if args.LastIncludedIndex > n.matchIndex[peer] {
+		n.matchIndex[peer] = args.LastIncludedIndex
+	}
+	n.nextIndex[peer] = n.matchIndex[peer] + 1
+	return true
+}
+
+// advanceCommitIndex commits the highest entry of the current term stored on a
+// majority of the cluster. It must be called with the mutex held.
+func (n *Node) advanceCommitIndex() {
+	for index := n.lastIndex(); index > n.commitIndex; index-- {
+		if n.termAt(index) != n.currentTerm {
+			break
+		}
+
+		replicas := 1
+		for _, peer := range n.config.Peers {
+			if n.matchIndex[peer] >= index {
+				replicas++
+			}
+		}
+
+		if replicas >= n.quorum() {
+			n.commitIndex = index
+			n.signalCommit()
+			return
+		}
+	}
+}
+
+func (n *Node) signalCommit() {
+	select {
+	case n.commitSignal <- struct{}{}:
+	default:
+	}
+}
+
+func (n *Node) handleRequestVote(args RequestVoteArgs) RequestVoteReply {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if args.Term < n.currentTerm {
+		return RequestVoteReply{Term: n.currentTerm}
+	}
+
+	if args.Term > n.currentTerm {
+		if err := n.becomeFollower(args.Term); err != nil {
+			return RequestVoteReply{Term: n.currentTerm}
+		}
+	}
+
+	lastTerm := n.termAt(n.lastIndex())
+	upToDate := args.LastLogTerm > lastTerm || (args.LastLogTerm == lastTerm && args.LastLogIndex >= n.lastIndex())
+
+	if (n.votedFor == "" || n.votedFor == args.CandidateId) && upToDate {
+		if n.votedFor != args.CandidateId {
+			n.votedFor = args.CandidateId
+			if err := n.persistTerm(); err != nil {
+				// the vote is only granted once it survives a restart
+				n.votedFor = ""
+				return RequestVoteReply{Term: n.currentTerm}
+			}
+		}
+		n.resetElectionDeadline()
+		return RequestVoteReply{Term: n.currentTerm, VoteGranted: true}
+	}
+
+	return RequestVoteReply{Term: n.currentTerm}
+}
+
+func (n *Node) handleAppendEntries(args AppendEntriesArgs) AppendEntriesReply {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if args.Term < n.currentTerm {
+		return AppendEntriesReply{Term: n.currentTerm}
+	}
+
+	if args.Term > n.currentTerm || n.role != follower {
+		if err := n.becomeFollower(args.Term); err != nil {
+			return AppendEntriesReply{Term: n.currentTerm}
+		}
+	}
+	n.leaderId = args.LeaderId
+	n.resetElectionDeadline()
+
+	if args.PrevLogIndex > n.lastIndex() {
+		return AppendEntriesReply{Term: n.currentTerm, ConflictIndex: n.lastIndex() + 1}
+	}
+
+	entries := args.Entries
+	prevIndex := args.PrevLogIndex
+
+	// entries covered by the snapshot are already committed and identical
+	if prevIndex < n.log[0].Index {
+		skip := n.log[0].Index - prevIndex
+		if skip >= uint64(len(entries)) {
+			entries = nil
+		} else {
+			entries = entries[skip:]
+		}
+		prevIndex = n.log[0].Index
+	} else if n.termAt(prevIndex) != args.PrevLogTerm {
+		conflictTerm := n.termAt(prevIndex)
+		conflictIndex := prevIndex
+		for conflictIndex > n.log[0].Index+1 && n.termAt(conflictIndex-1) == conflictTerm {
+			conflictIndex--
+		}
+		return AppendEntriesReply{Term: n.currentTerm, ConflictIndex: conflictIndex}
+	}
+
+	for i, entry := range entries {
+		if entry.Index <= n.lastIndex() && n.termAt(entry.Index) == entry.Term {
+			continue
+		}
+		// entries are only acknowledged once they survive a restart, so the leader
+		// sends them again when they could not be persisted
+		if err := n.persistEntries(entries[i:]); err != nil {
+			return AppendEntriesReply{Term: n.currentTerm}
+		}
+		n.log = append(n.log[:entry.Index-n.log[0].Index], entries[i:]...)
+		break
+	}
+
+	lastNewIndex := prevIndex + uint64(len(entries))
+	if commitIndex := min(args.LeaderCommit, lastNewIndex); commitIndex > n.commitIndex {
+		n.commitIndex = commitIndex
+		n.signalCommit()
+	}
+
+	return AppendEntriesReply{Term: n.currentTerm, Success: true}
+}
+
+func (n *Node) handleInstallSnapshot(args InstallSnapshotArgs) (InstallSnapshotReply, error) {
+	n.applyMutex.Lock()
+	defer n.applyMutex.Unlock()
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if args.Term < n.currentTerm {
+		return InstallSnapshotReply{Term: n.currentTerm}, nil
+	}
+
+	if args.Term > n.currentTerm || n.role != follower {
+		if err := n.becomeFollower(args.Term); err != nil {
+			return InstallSnapshotReply{}, fmt.Errorf("could not persist term: %w", err)
+		}
+	}
+	n.leaderId = args.LeaderId
+	n.resetElectionDeadline()
+
+	if args.LastIncludedIndex <= n.log[0].Index || args.LastIncludedIndex <= n.lastApplied {
+		return InstallSnapshotReply{Term: n.currentTerm}, nil
+	}
+
+	newLog := []Entry{{Index: args.LastIncludedIndex, Term: args.LastIncludedTerm}}
+	if args.LastIncludedIndex < n.lastIndex() && n.termAt(args.LastIncludedIndex) == args.LastIncludedTerm {
+		newLog = append(newLog, n.log[args.LastIncludedIndex-n.log[0].Index+1:]...)
+	}
+
+	// the snapshot is saved before it is applied so the leader sends it again when
+	// it could not be saved
+	if err := n.storage.SaveSnapshot(args.Data, PersistentState{CurrentTerm: n.currentTerm, VotedFor: n.votedFor, Log: newLog}); err != nil {
+		slog.Error(fmt.Sprintf("Raft node %v could not save snapshot: %v", n.config.ID, err))
+		return InstallSnapshotReply{}, fmt.Errorf("could not save snapshot: %w", err)
+	}
+
+	if err := n.fsm.Restore(args.Data); err != nil {
+		return InstallSnapshotReply{}, err
+	}
+
+	n.log = newLog
+	n.snapshot = args.Data
+	n.commitIndex = max(n.commitIndex, args.LastIncludedIndex)
+	n.lastApplied = args.LastIncludedIndex
+	n.notifyApplied()
+
+	return InstallSnapshotReply{Term: n.currentTerm}, nil
+}
+
+// applyLoop applies committed entries to the fsm in order
+func (n *Node) applyLoop(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-n.commitSignal:
+		}
+
+		n.applyMutex.Lock()
+		n.mutex.Lock()
+		entries := make([]Entry, 0)
+		for index := n.lastApplied + 1; index <= n.commitIndex; index++ {
+			entries = append(entries, n.entryAt(index))
+		}
+		n.mutex.Unlock()
+
+		for _, entry := range entries {
+			var err error
+			if entry.Command != nil {
+				err = n.fsm.Apply(entry.Command)
+			}
+
+			n.mutex.Lock()
+			n.lastApplied = entry.Index
+			if waiter, ok := n.waiters[entry.Index]; ok {
+				if waiter.term != entry.Term {
+					err = ErrLeadershipLost
+				}
+				waiter.result <- err
+				delete(n.waiters, entry.Index)
+			}
+			n.notifyApplied()
+			n.mutex.Unlock()
+		}
+
+		n.compact()
+		n.applyMutex.Unlock()
+	}
+}
+
+// compact snapshots the fsm and drops the applied entries from the log once
+// there are more than SnapshotThreshold of them. It must be called with the
+// applyMutex held.
+func (n *Node) compact() {
+	n.mutex.Lock()
+	lastApplied := n.lastApplied
+	due := lastApplied-n.log[0].Index >= n.config.SnapshotThreshold
+	n.mutex.Unlock()
+
+	if !due {
+		return
+	}
+
+	snapshot, err := n.fsm.Snapshot()
+	if err != nil {
+		slog.Error(fmt.Sprintf("Raft node %v could not snapshot state machine: %v", n.config.ID, err))
+		return
+	}
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	newLog := []Entry{{Index: lastApplied, Term: n.termAt(lastApplied)}}
+	newLog = append(newLog, n.log[lastApplied-n.log[0].Index+1:]...)
+	// the whole log is rewritten only here, every other change is appended
+	if err := n.storage.SaveSnapshot(snapshot, PersistentState{CurrentTerm: n.currentTerm, VotedFor: n.votedFor, Log: newLog}); err != nil {
+		slog.Error(fmt.Sprintf("Raft node %v could not save snapshot: %v", n.config.ID, err))
+		return
+	}
+	n.log = newLog
+	n.snapshot = snapshot
+}
+
+// notifyApplied wakes up everyone waiting for lastApplied to move. It must be
+// called with the mutex held.
+func (n *Node) notifyApplied() {
+	close(n.appliedSignal)
+	n.appliedSignal = make(chan struct{})
+}
+
+// persistTerm saves the current term and vote. It must be called with the mutex
+// held and the node must not act on them when it fails.
+func (n *Node) persistTerm() error {
+	if err := n.storage.SaveTerm(n.currentTerm, n.votedFor); err != nil {
+		slog.Error(fmt.Sprintf("Raft node %v could not persist term: %v", n.config.ID, err))
+		return err
+	}
+	return nil
+}
+
+// persistEntries saves entries appended to the log. It must be called with the
+// mutex held and before the entries are added to the log.
+func (n *Node) persistEntries(entries []Entry) error {
+	if err := n.storage.AppendEntries(entries); err != nil {
+		slog.Error(fmt.Sprintf("Raft node %v could not persist log entries: %v", n.config.ID, err))
+		return err
+	}
+	return nil
+}
+
+func (n *Node) resetElectionDeadline() {
+	timeout := n.config.ElectionTimeout + time.Duration(rand.Int63n(int64(n.config.ElectionTimeout)))
+	n.electionDeadline = time.Now().Add(timeout)
+}
+
+func (n *Node) quorum() int {
+	return (len(n.config.Peers)+1)/2 + 1
+}
+
+func (n *Node) lastIndex() uint64 {
+	return n.log[len(n.log)-1].Index
+}
+
+func (n *Node) entryAt(index uint64) Entry {
+	return n.log[index-n.log[0].Index]
+}
+
+func (n *Node) termAt(index uint64) uint64 {
+	if index < n.log[0].Index || index > n.lastIndex() {
+		return 0
+	}
+	return n.entryAt(index).Term
+}
@@ -0,0 +1,317 @@
+package raft_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/anjolaoluwaakindipe/duller/internal/raft"
+	"github.com/stretchr/testify/assert"
+)
+
+// listFSM is a state machine that keeps every applied command in order
+type listFSM struct {
+	mutex    sync.Mutex
+	commands []string
+}
+
+func (l *listFSM) Apply(command []byte) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.commands = append(l.commands, string(command))
+	return nil
+}
+
+func (l *listFSM) Snapshot() ([]byte, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return json.Marshal(l.commands)
+}
+
+func (l *listFSM) Restore(snapshot []byte) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return json.Unmarshal(snapshot, &l.commands)
+}
+
+func (l *listFSM) Commands() []string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return append([]string{}, l.commands...)
+}
+
+type testNode struct {
+	node   *raft.Node
+	fsm    *listFSM
+	server *httptest.Server
+	cancel context.CancelFunc
+	// partitioned drops every rpc sent to the node
+	partitioned atomic.Bool
+}
+
+func (tn *testNode) stop() {
+	tn.cancel()
+	tn.server.CloseClientConnections()
+	tn.server.Close()
+}
+
+// stubCluster starts size raft nodes talking to each other over loopback
+func stubCluster(t *testing.T, size int, snapshotThreshold uint64) []*testNode {
+	servers := make([]*httptest.Server, size)
+	ids := make([]string, size)
+	for i := range servers {
+		servers[i] = httptest.NewUnstartedServer(nil)
+		ids[i] = servers[i].Listener.Addr().String()
+	}
+
+	nodes := make([]*testNode, size)
+	for i := range servers {
+		fsm := &listFSM{}
+		node, err := raft.NewNode(raft.Config{
+			ID:                ids[i],
+			Peers:             ids,
+			ElectionTimeout:   150 * time.Millisecond,
+			HeartbeatInterval: 30 * time.Millisecond,
+			SnapshotThreshold: snapshotThreshold,
+			Key:               "secret",
+		}, fsm, raft.NewMemoryStorage(), raft.NewHTTPTransport("secret", 150*time.Millisecond))
+		assert.Nil(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		testNode := &testNode{node: node, fsm: fsm, server: servers[i], cancel: cancel}
+		handler := node.Handler()
+		servers[i].Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if testNode.partitioned.Load() {
+				http.Error(w, "partitioned", http.StatusServiceUnavailable)
+				return
+			}
+			handler.ServeHTTP(w, r)
+		})
+		servers[i].Start()
+		go node.Run(ctx)
+
+		nodes[i] = testNode
+	}
+
+	t.Cleanup(func() {
+		for _, node := range nodes {
+			node.stop()
+		}
+	})
+
+	return nodes
+}
+
+func waitForLeader(t *testing.T, nodes []*testNode) *testNode {
+	var found *testNode
+	assert.Eventually(t, func() bool {
+		leaders := 0
+		for _, node := range nodes {
+			if node.node.IsLeader() {
+				leaders++
+				found = node
+			}
+		}
+		return leaders == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	return found
+}
+
+func Test_Node_Election(t *testing.T) {
+	t.Run("SHOULD elect a single leader known by every node WHEN a cluster starts", func(t *testing.T) {
+		nodes := stubCluster(t, 3, 0)
+		leader := waitForLeader(t, nodes)
+
+		assert.Eventually(t, func() bool {
+			for _, node := range nodes {
+				if node.node.Leader() != leader.node.ID() {
+					return false
+				}
+			}
+			return true
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("SHOULD elect a new leader WHEN the leader stops", func(t *testing.T) {
+		nodes := stubCluster(t, 3, 0)
+		oldLeader := waitForLeader(t, nodes)
+		oldLeader.stop()
+
+		remaining := make([]*testNode, 0)
+		for _, node := range nodes {
+			if node != oldLeader {
+				remaining = append(remaining, node)
+			}
+		}
+
+		newLeader := waitForLeader(t, remaining)
+		assert.NotEqual(t, oldLeader.node.ID(), newLeader.node.ID())
+	})
+}
+
+func Test_Node_Apply(t *testing.T) {
+	t.Run("SHOULD apply commands in the same order on every node WHEN commands are sent to any node", func(t *testing.T) {
+		nodes := stubCluster(t, 3, 0)
+		waitForLeader(t, nodes)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		for i, command := range []string{"a", "b", "c", "d"} {
+			assert.Nil(t, nodes[i%len(nodes)].node.Apply(ctx, []byte(command)))
+		}
+
+		for _, node := range nodes {
+			assert.Eventually(t, func() bool {
+				return len(node.fsm.Commands()) == 4
+			}, 5*time.Second, 10*time.Millisecond)
+			assert.Equal(t, []string{"a", "b", "c", "d"}, node.fsm.Commands())
+		}
+	})
+
+	t.Run("SHOULD make the command visible on the node WHEN apply returns on a follower", func(t *testing.T) {
+		nodes := stubCluster(t, 3, 0)
+		leader := waitForLeader(t, nodes)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		for _, node := range nodes {
+			if node == leader {
+				continue
+			}
+			assert.Nil(t, node.node.Apply(ctx, []byte(node.node.ID())))
+			assert.Contains(t, node.fsm.Commands(), node.node.ID())
+		}
+	})
+
+	t.Run("SHOULD keep accepting commands WHEN the leader fails", func(t *testing.T) {
+		nodes := stubCluster(t, 3, 0)
+		leader := waitForLeader(t, nodes)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		assert.Nil(t, leader.node.Apply(ctx, []byte("before")))
+		leader.stop()
+
+		remaining := make([]*testNode, 0)
+		for _, node := range nodes {
+			if node != leader {
+				remaining = append(remaining, node)
+			}
+		}
+
+		assert.Nil(t, remaining[0].node.Apply(ctx, []byte("after")))
+		assert.Nil(t, remaining[1].node.ReadBarrier(ctx))
+		assert.Equal(t, []string{"before", "after"}, remaining[1].fsm.Commands())
+	})
+}
+
+func Test_Node_Snapshot(t *testing.T) {
+	t.Run("SHOULD catch up a node through a snapshot WHEN the leader compacted its log", func(t *testing.T) {
+		nodes := stubCluster(t, 3, 4)
+		leader := waitForLeader(t, nodes)
+
+		var lagging *testNode
+		for _, node := range nodes {
+			if node != leader {
+				lagging = node
+				break
+			}
+		}
+		// drop every rpc to the lagging node while the log is compacted
+		lagging.partitioned.Store(true)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		for _, command := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"} {
+			assert.Nil(t, leader.node.Apply(ctx, []byte(command)))
+		}
+
+		lagging.partitioned.Store(false)
+
+		assert.Eventually(t, func() bool {
+			return len(lagging.fsm.Commands()) == 10
+		}, 5*time.Second, 10*time.Millisecond)
+		assert.Equal(t, leader.fsm.Commands(), lagging.fsm.Commands())
+	})
+}
+
+func Test_Node_Handler(t *testing.T) {
+	t.Run("SHOULD reject rpcs WHEN the key does not match", func(t *testing.T) {
+		nodes := stubCluster(t, 1, 0)
+
+		transport := raft.NewHTTPTransport("wrong", time.Second)
+		_, err := transport.RequestVote(context.Background(), nodes[0].node.ID(), raft.RequestVoteArgs{Term: 100, CandidateId: "someone"})
+		assert.NotNil(t, err)
+	})
+}
+
+// failingStorage fails to save anything while failing is set
+type failingStorage struct {
+	*raft.MemoryStorage
+	failing atomic.Bool
+}
+
+func (fs *failingStorage) SaveTerm(currentTerm uint64, votedFor string) error {
+	if fs.failing.Load() {
+		return errors.New("disk full")
+	}
+	return fs.MemoryStorage.SaveTerm(currentTerm, votedFor)
+}
+
+func (fs *failingStorage) AppendEntries(entries []raft.Entry) error {
+	if fs.failing.Load() {
+		return errors.New("disk full")
+	}
+	return fs.MemoryStorage.AppendEntries(entries)
+}
+
+func Test_Node_Persistence(t *testing.T) {
+	t.Run("SHOULD refuse votes and entries WHEN they cannot be persisted", func(t *testing.T) {
+		server := httptest.NewUnstartedServer(nil)
+		storage := &failingStorage{MemoryStorage: raft.NewMemoryStorage()}
+		storage.failing.Store(true)
+		node, err := raft.NewNode(raft.Config{
+			ID:              server.Listener.Addr().String(),
+			Peers:           []string{"localhost:1"},
+			ElectionTimeout: time.Minute,
+			Key:             "secret",
+		}, &listFSM{}, storage, raft.NewHTTPTransport("secret", time.Second))
+		assert.Nil(t, err)
+		server.Config.Handler = node.Handler()
+		server.Start()
+		t.Cleanup(server.Close)
+
+		ctx := context.Background()
+		transport := raft.NewHTTPTransport("secret", time.Second)
+		vote, err := transport.RequestVote(ctx, node.ID(), raft.RequestVoteArgs{Term: 2, CandidateId: "localhost:1"})
+		assert.Nil(t, err)
+		assert.False(t, vote.VoteGranted)
+
+		appended, err := transport.AppendEntries(ctx, node.ID(), raft.AppendEntriesArgs{Term: 2, LeaderId: "localhost:1", Entries: []raft.Entry{{Index: 1, Term: 2, Command: []byte("a")}}})
+		assert.Nil(t, err)
+		assert.False(t, appended.Success)
+
+		storage.failing.Store(false)
+
+		vote, err = transport.RequestVote(ctx, node.ID(), raft.RequestVoteArgs{Term: 2, CandidateId: "localhost:1"})
+		assert.Nil(t, err)
+		assert.True(t, vote.VoteGranted)
+
+		appended, err = transport.AppendEntries(ctx, node.ID(), raft.AppendEntriesArgs{Term: 2, LeaderId: "localhost:1", Entries: []raft.Entry{{Index: 1, Term: 2, Command: []byte("a")}}})
+		assert.Nil(t, err)
+		assert.True(t, appended.Success)
+
+		state, _, _ := storage.LoadState()
+		assert.Equal(t, uint64(2), state.CurrentTerm)
+		assert.Equal(t, "localhost:1", state.VotedFor)
+		assert.Equal(t, []raft.Entry{{}, {Index: 1, Term: 2, Command: []byte("a")}}, state.Log)
+	})
+}
@@ -0,0 +1,361 @@
+package raft
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	stateFile = "raft.state"
+	termFile  = "raft.term"
+	logFile   = "raft.log"
+)
+
+// PersistentState is the state a node must keep across restarts to stay safe.
+// The first entry of Log is a placeholder holding the index and term of the last
+// entry included in the snapshot.
+type PersistentState struct {
+	CurrentTerm uint64  `json:"currentTerm"`
+	VotedFor    string  `json:"votedFor"`
+	Log         []Entry `json:"log"`
+}
+
+// snapshotRecord is the content of the state file. The snapshot is stored in the
+// same record as the log whose first entry stands for it, so a crash can never
+// leave a snapshot next to a log it does not match.
+type snapshotRecord struct {
+	PersistentState
+	Snapshot []byte `json:"snapshot,omitempty"`
+}
+
+// termState is the current term of a node and the candidate it voted for in it
+type termState struct {
+	CurrentTerm uint64 `json:"currentTerm"`
+	VotedFor    string `json:"votedFor"`
+}
+
+// Storage persists the state of a node. A node only acts on a change of its state,
+// e.g. by granting a vote or acknowledging entries, once it was saved.
+type Storage interface {
+	// SaveTerm stores the current term of a node and the candidate it voted for
+	SaveTerm(currentTerm uint64, votedFor string) error
+	// AppendEntries stores entries at the end of the log. An entry replaces the
+	// stored entry of the same index and every entry after it.
+	AppendEntries(entries []Entry) error
+	// SaveSnapshot stores the latest snapshot of the state machine and replaces the
+	// whole persistent state of a node, whose first log entry stands for the
+	// snapshot, which is done when its log is compacted. Either both are saved or
+	// neither is.
+	SaveSnapshot(snapshot []byte, state PersistentState) error
+	// LoadState returns the last saved state. The returned bool is false if no
+	// state was ever saved.
+	LoadState() (PersistentState, bool, error)
+	// LoadSnapshot returns the latest snapshot or nil if there is none
+	LoadSnapshot() ([]byte, error)
+}
+
+// MemoryStorage is an in memory implementation of Storage. Nothing survives
+// a restart, so a restarted node catches up from the leader.
+type MemoryStorage struct {
+	mutex    sync.Mutex
+	state    *PersistentState
+	snapshot []byte
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{}
+}
+
+// SaveTerm implements Storage.
+func (ms *MemoryStorage) SaveTerm(currentTerm uint64, votedFor string) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	if ms.state == nil {
+		ms.state = &PersistentState{Log: []Entry{{}}}
+	}
+	ms.state.CurrentTerm = currentTerm
+	ms.state.VotedFor = votedFor
+	return nil
+}
+
+// AppendEntries implements Storage.
+func (ms *MemoryStorage) AppendEntries(entries []Entry) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	if ms.state == nil {
+		ms.state = &PersistentState{Log: []Entry{{}}}
+	}
+	log, err := appendToLog(ms.state.Log, entries)
+	if err != nil {
+		return err
+	}
+	ms.state.Log = log
+	return nil
+}
+
+// SaveSnapshot implements Storage.
+func (ms *MemoryStorage) SaveSnapshot(snapshot []byte, state PersistentState) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	state.Log = append([]Entry{}, state.Log...)
+	ms.state = &state
+	ms.snapshot = snapshot
+	return nil
+}
+
+// LoadState implements Storage.
+func (ms *MemoryStorage) LoadState() (PersistentState, bool, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	if ms.state == nil {
+		return PersistentState{}, false, nil
+	}
+	state := *ms.state
+	state.Log = append([]Entry{}, state.Log...)
+	return state, true, nil
+}
+
+// LoadSnapshot implements Storage.
+func (ms *MemoryStorage) LoadSnapshot() ([]byte, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	return ms.snapshot, nil
+}
+
+// FileStorage is an implementation of Storage that keeps the state and snapshot
+// of a node in a directory. New entries are appended to a log file, which is only
+// folded into the state file along with the snapshot when the log is compacted.
+// The term and state files are replaced atomically.
+type FileStorage struct {
+	mutex sync.Mutex
+	dir   string
+}
+
+// NewFileStorage creates a FileStorage inside dir
+func NewFileStorage(dir string) (*FileStorage, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileStorage{dir: dir}, nil
+}
+
+// SaveTerm implements Storage.
+func (fs *FileStorage) SaveTerm(currentTerm uint64, votedFor string) error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	return fs.saveTerm(termState{CurrentTerm: currentTerm, VotedFor: votedFor})
+}
+
+// AppendEntries implements Storage. Every entry is written as a line of json and
+// replayed in order when the state is loaded.
+func (fs *FileStorage) AppendEntries(entries []Entry) error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	var data bytes.Buffer
+	encoder := json.NewEncoder(&data)
+	for _, entry := range entries {
+		if err := encoder.Encode(entry); err != nil {
+			return err
+		}
+	}
+
+	file, err := os.OpenFile(filepath.Join(fs.dir, logFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data.Bytes()); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// SaveSnapshot implements Storage. The log file is emptied once the state file
+// holds every entry.
+func (fs *FileStorage) SaveSnapshot(snapshot []byte, state PersistentState) error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	data, err := json.Marshal(snapshotRecord{PersistentState: state, Snapshot: snapshot})
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(fs.dir, stateFile), data); err != nil {
+		return err
+	}
+	if err := fs.saveTerm(termState{CurrentTerm: state.CurrentTerm, VotedFor: state.VotedFor}); err != nil {
+		return err
+	}
+	// entries left in the log file after a crash are replayed over the same entries
+	// of the state file, which does not change the log
+	return writeFileAtomic(filepath.Join(fs.dir, logFile), nil)
+}
+
+// LoadState implements Storage.
+func (fs *FileStorage) LoadState() (PersistentState, bool, error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	record, found, err := fs.readState()
+	if err != nil {
+		return PersistentState{}, false, err
+	}
+	state := record.PersistentState
+
+	data, err := os.ReadFile(filepath.Join(fs.dir, termFile))
+	if err != nil && !os.IsNotExist(err) {
+		return state, false, err
+	}
+	if err == nil {
+		var term termState
+		if err := json.Unmarshal(data, &term); err != nil {
+			return state, false, err
+		}
+		state.CurrentTerm = term.CurrentTerm
+		state.VotedFor = term.VotedFor
+		found = true
+	}
+
+	entries, err := fs.readLog()
+	if err != nil {
+		return state, false, err
+	}
+	if len(entries) > 0 {
+		if state.Log, err = appendToLog(state.Log, entries); err != nil {
+			return state, false, err
+		}
+		found = true
+	}
+
+	return state, found, nil
+}
+
+// readState returns the content of the state file. It must be called with the
+// mutex held.
+func (fs *FileStorage) readState() (snapshotRecord, bool, error) {
+	record := snapshotRecord{PersistentState: PersistentState{Log: []Entry{{}}}}
+
+	data, err := os.ReadFile(filepath.Join(fs.dir, stateFile))
+	if os.IsNotExist(err) {
+		return record, false, nil
+	}
+	if err != nil {
+		return record, false, err
+	}
+	if err := json.Unmarshal(data, &record); err != nil {
+		return record, false, err
+	}
+	if len(record.Log) == 0 {
+		record.Log = []Entry{{}}
+	}
+	return record, true, nil
+}
+
+// saveTerm must be called with the mutex held
+func (fs *FileStorage) saveTerm(term termState) error {
+	data, err := json.Marshal(term)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(fs.dir, termFile), data)
+}
+
+// readLog returns the entries of the log file in the order they were appended. A
+// last line cut short by a crash was never acknowledged, so it is cut off the file
+// before new entries are appended after it.
+func (fs *FileStorage) readLog() ([]Entry, error) {
+	name := filepath.Join(fs.dir, logFile)
+	file, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	entries := make([]Entry, 0)
+	reader := bufio.NewReader(file)
+	var size int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(line) > 0 {
+				return entries, os.Truncate(name, size)
+			}
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		size += int64(len(line))
+
+		var entry Entry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return nil, fmt.Errorf("raft log file is corrupted: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+}
+
+// appendToLog appends entries to a log whose first entry is the snapshot
+// placeholder. Entries covered by the snapshot are skipped and every other entry
+// replaces the entry of the same index and every entry after it.
+func appendToLog(log []Entry, entries []Entry) ([]Entry, error) {
+	for _, entry := range entries {
+		if entry.Index <= log[0].Index {
+			continue
+		}
+		position := entry.Index - log[0].Index
+		if position > uint64(len(log)) {
+			return nil, fmt.Errorf("raft log is missing the entries before index %v", entry.Index)
+		}
+		log = append(log[:position], entry)
+	}
+	return log, nil
+}
+
+// LoadSnapshot implements Storage.
+func (fs *FileStorage) LoadSnapshot() ([]byte, error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	record, _, err := fs.readState()
+	return record.Snapshot, err
+}
+
+func writeFileAtomic(name string, data []byte) error {
+	tmpName := name + ".tmp"
+	file, err := os.OpenFile(tmpName, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpName, name)
+}
@@ -0,0 +1,108 @@
+package raft_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/anjolaoluwaakindipe/duller/internal/raft"
+	"github.com/stretchr/testify/assert"
+)
+
+func entries(term uint64, indexes ...uint64) []raft.Entry {
+	list := make([]raft.Entry, 0, len(indexes))
+	for _, index := range indexes {
+		list = append(list, raft.Entry{Index: index, Term: term, Command: []byte("command")})
+	}
+	return list
+}
+
+func Test_FileStorage(t *testing.T) {
+	t.Run("SHOULD load the appended entries WHEN the storage is opened again", func(t *testing.T) {
+		dir := t.TempDir()
+		storage, err := raft.NewFileStorage(dir)
+		assert.Nil(t, err)
+
+		assert.Nil(t, storage.SaveTerm(1, "node1"))
+		assert.Nil(t, storage.AppendEntries(entries(1, 1, 2, 3)))
+		// entries of a new leader replace the conflicting ones
+		assert.Nil(t, storage.SaveTerm(2, ""))
+		assert.Nil(t, storage.AppendEntries(entries(2, 3, 4)))
+
+		reopened, err := raft.NewFileStorage(dir)
+		assert.Nil(t, err)
+		state, found, err := reopened.LoadState()
+		assert.Nil(t, err)
+		assert.True(t, found)
+		assert.Equal(t, uint64(2), state.CurrentTerm)
+		assert.Equal(t, "", state.VotedFor)
+		assert.Equal(t, append([]raft.Entry{{}}, append(entries(1, 1, 2), entries(2, 3, 4)...)...), state.Log)
+	})
+
+	t.Run("SHOULD rewrite the log only WHEN a snapshot is saved", func(t *testing.T) {
+		dir := t.TempDir()
+		storage, _ := raft.NewFileStorage(dir)
+		assert.Nil(t, storage.AppendEntries(entries(1, 1, 2, 3)))
+
+		compacted := append([]raft.Entry{{Index: 2, Term: 1}}, entries(1, 3)...)
+		assert.Nil(t, storage.SaveSnapshot([]byte("snapshot"), raft.PersistentState{CurrentTerm: 1, VotedFor: "node1", Log: compacted}))
+		info, err := os.Stat(filepath.Join(dir, "raft.log"))
+		assert.Nil(t, err)
+		assert.Zero(t, info.Size())
+
+		// entries covered by the snapshot are skipped
+		assert.Nil(t, storage.AppendEntries(entries(1, 2, 3, 4)))
+		state, _, err := storage.LoadState()
+		assert.Nil(t, err)
+		assert.Equal(t, append([]raft.Entry{{Index: 2, Term: 1}}, entries(1, 3, 4)...), state.Log)
+	})
+
+	t.Run("SHOULD load the snapshot along with the log it stands for WHEN the storage is opened again", func(t *testing.T) {
+		dir := t.TempDir()
+		storage, _ := raft.NewFileStorage(dir)
+		assert.Nil(t, storage.AppendEntries(entries(1, 1, 2, 3)))
+		assert.Nil(t, storage.SaveSnapshot([]byte("first"), raft.PersistentState{CurrentTerm: 1, Log: append([]raft.Entry{{Index: 2, Term: 1}}, entries(1, 3)...)}))
+		assert.Nil(t, storage.AppendEntries(entries(1, 4, 5)))
+		assert.Nil(t, storage.SaveSnapshot([]byte("second"), raft.PersistentState{CurrentTerm: 1, Log: []raft.Entry{{Index: 5, Term: 1}}}))
+
+		reopened, _ := raft.NewFileStorage(dir)
+		snapshot, err := reopened.LoadSnapshot()
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("second"), snapshot)
+		state, _, err := reopened.LoadState()
+		assert.Nil(t, err)
+		assert.Equal(t, []raft.Entry{{Index: 5, Term: 1}}, state.Log)
+
+		// only the state file holds the snapshot, so there is no second file to fall behind
+		files, _ := os.ReadDir(dir)
+		for _, file := range files {
+			assert.NotContains(t, file.Name(), "snapshot")
+		}
+	})
+
+	t.Run("SHOULD drop the last entry WHEN a crash cut it short", func(t *testing.T) {
+		dir := t.TempDir()
+		storage, _ := raft.NewFileStorage(dir)
+		assert.Nil(t, storage.AppendEntries(entries(1, 1)))
+
+		file, _ := os.OpenFile(filepath.Join(dir, "raft.log"), os.O_WRONLY|os.O_APPEND, 0644)
+		file.Write([]byte(`{"index":2,"te`))
+		file.Close()
+
+		state, _, err := storage.LoadState()
+		assert.Nil(t, err)
+		assert.Equal(t, append([]raft.Entry{{}}, entries(1, 1)...), state.Log)
+
+		assert.Nil(t, storage.AppendEntries(entries(1, 2)))
+		state, _, err = storage.LoadState()
+		assert.Nil(t, err)
+		assert.Equal(t, append([]raft.Entry{{}}, entries(1, 1, 2)...), state.Log)
+	})
+
+	t.Run("SHOULD report no state WHEN nothing was saved", func(t *testing.T) {
+		storage, _ := raft.NewFileStorage(t.TempDir())
+		_, found, err := storage.LoadState()
+		assert.Nil(t, err)
+		assert.False(t, found)
+	})
+}
@@ -0,0 +1,247 @@
+package raft
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// paths of the raft rpc endpoints
+const (
+	requestVotePath     = "/raft/request-vote"
+	appendEntriesPath   = "/raft/append-entries"
+	installSnapshotPath = "/raft/install-snapshot"
+	applyPath           = "/raft/apply"
+	readIndexPath       = "/raft/read-index"
+)
+
+type RequestVoteArgs struct {
+	Term         uint64 `json:"term"`
+	CandidateId  string `json:"candidateId"`
+	LastLogIndex uint64 `json:"lastLogIndex"`
+	LastLogTerm  uint64 `json:"lastLogTerm"`
+}
+
+type RequestVoteReply struct {
+	Term        uint64 `json:"term"`
+	VoteGranted bool   `json:"voteGranted"`
+}
+
+type AppendEntriesArgs struct {
+	Term         uint64  `json:"term"`
+	LeaderId     string  `json:"leaderId"`
+	PrevLogIndex uint64  `json:"prevLogIndex"`
+	PrevLogTerm  uint64  `json:"prevLogTerm"`
+	Entries      []Entry `json:"entries"`
+	LeaderCommit uint64  `json:"leaderCommit"`
+}
+
+type AppendEntriesReply struct {
+	Term    uint64 `json:"term"`
+	Success bool   `json:"success"`
+	// ConflictIndex is the index the leader should retry from when Success is false
+	ConflictIndex uint64 `json:"conflictIndex"`
+}
+
+type InstallSnapshotArgs struct {
+	Term              uint64 `json:"term"`
+	LeaderId          string `json:"leaderId"`
+	LastIncludedIndex uint64 `json:"lastIncludedIndex"`
+	LastIncludedTerm  uint64 `json:"lastIncludedTerm"`
+	Data              []byte `json:"data"`
+}
+
+type InstallSnapshotReply struct {
+	Term uint64 `json:"term"`
+}
+
+type applyArgs struct {
+	Command []byte `json:"command"`
+}
+
+type readIndexReply struct {
+	Index uint64 `json:"index"`
+}
+
+type errorReply struct {
+	Error string `json:"error"`
+}
+
+// Transport sends rpcs to the other nodes of a cluster
+type Transport interface {
+	RequestVote(ctx context.Context, peer string, args RequestVoteArgs) (RequestVoteReply, error)
+	AppendEntries(ctx context.Context, peer string, args AppendEntriesArgs) (AppendEntriesReply, error)
+	InstallSnapshot(ctx context.Context, peer string, args InstallSnapshotArgs) (InstallSnapshotReply, error)
+	// Apply forwards a command to the leader
+	Apply(ctx context.Context, peer string, command []byte) error
+	// ReadIndex asks the leader for an index that is safe to serve linearizable reads from
+	ReadIndex(ctx context.Context, peer string) (uint64, error)
+}
+
+// HTTPTransport is an implementation of Transport that sends json encoded rpcs
+// over http. Peers are addressed by host:port.
+type HTTPTransport struct {
+	client *http.Client
+	key    string
+}
+
+// NewHTTPTransport creates an HTTPTransport. The key is sent with every rpc and
+// must match the key of the receiving node.
+func NewHTTPTransport(key string, timeout time.Duration) *HTTPTransport {
+	return &HTTPTransport{client: &http.Client{Timeout: timeout}, key: key}
+}
+
+// RequestVote implements Transport.
+func (ht *HTTPTransport) RequestVote(ctx context.Context, peer string, args RequestVoteArgs) (RequestVoteReply, error) {
+	var reply RequestVoteReply
+	err := ht.call(ctx, peer, requestVotePath, args, &reply)
+	return reply, err
+}
+
+// AppendEntries implements Transport.
+func (ht *HTTPTransport) AppendEntries(ctx context.Context, peer string, args AppendEntriesArgs) (AppendEntriesReply, error) {
+	var reply AppendEntriesReply
+	err := ht.call(ctx, peer, appendEntriesPath, args, &reply)
+	return reply, err
+}
+
+// InstallSnapshot implements Transport.
+func (ht *HTTPTransport) InstallSnapshot(ctx context.Context, peer string, args InstallSnapshotArgs) (InstallSnapshotReply, error) {
+	var reply InstallSnapshotReply
+	err := ht.call(ctx, peer, installSnapshotPath, args, &reply)
+	return reply, err
+}
+
+// Apply implements Transport.
+func (ht *HTTPTransport) Apply(ctx context.Context, peer string, command []byte) error {
+	return ht.call(ctx, peer, applyPath, applyArgs{Command: command}, nil)
+}
+
+// ReadIndex implements Transport.
+func (ht *HTTPTransport) ReadIndex(ctx context.Context, peer string) (uint64, error) {
+	var reply readIndexReply
+	err := ht.call(ctx, peer, readIndexPath, struct{}{}, &reply)
+	return reply.Index, err
+}
+
+func (ht *HTTPTransport) call(ctx context.Context, peer string, path string, args interface{}, reply interface{}) error {
+	body, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+
+	if !strings.HasPrefix(peer, "http://") && !strings.HasPrefix(peer, "https://") {
+		peer = "http://" + peer
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, peer+path, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(ht.key) > 0 {
+		req.Header.Set("Authorization", "Bearer "+ht.key)
+	}
+
+	response, err := ht.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		if reply == nil {
+			return nil
+		}
+		return json.NewDecoder(response.Body).Decode(reply)
+	case http.StatusConflict:
+		return ErrNotLeader
+	case http.StatusUnprocessableEntity:
+		var errReply errorReply
+		if err := json.NewDecoder(response.Body).Decode(&errReply); err != nil {
+			return err
+		}
+		return errors.New(errReply.Error)
+	default:
+		responseBody, _ := io.ReadAll(response.Body)
+		return fmt.Errorf("raft rpc %v to %v failed with status code %v: %v", path, peer, response.StatusCode, strings.TrimSpace(string(responseBody)))
+	}
+}
+
+// Handler returns an http handler serving the raft rpcs of the node. It is meant
+// to be mounted on the "/raft/" prefix of the server listening on the node id.
+func (n *Node) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(requestVotePath, n.rpcHandler(func(ctx context.Context, body io.Reader) (interface{}, error) {
+		var args RequestVoteArgs
+		if err := json.NewDecoder(body).Decode(&args); err != nil {
+			return nil, err
+		}
+		return n.handleRequestVote(args), nil
+	}))
+	mux.HandleFunc(appendEntriesPath, n.rpcHandler(func(ctx context.Context, body io.Reader) (interface{}, error) {
+		var args AppendEntriesArgs
+		if err := json.NewDecoder(body).Decode(&args); err != nil {
+			return nil, err
+		}
+		return n.handleAppendEntries(args), nil
+	}))
+	mux.HandleFunc(installSnapshotPath, n.rpcHandler(func(ctx context.Context, body io.Reader) (interface{}, error) {
+		var args InstallSnapshotArgs
+		if err := json.NewDecoder(body).Decode(&args); err != nil {
+			return nil, err
+		}
+		return n.handleInstallSnapshot(args)
+	}))
+	mux.HandleFunc(applyPath, n.rpcHandler(func(ctx context.Context, body io.Reader) (interface{}, error) {
+		var args applyArgs
+		if err := json.NewDecoder(body).Decode(&args); err != nil {
+			return nil, err
+		}
+		return struct{}{}, n.propose(ctx, args.Command)
+	}))
+	mux.HandleFunc(readIndexPath, n.rpcHandler(func(ctx context.Context, body io.Reader) (interface{}, error) {
+		index, err := n.leaderReadIndex(ctx)
+		return readIndexReply{Index: index}, err
+	}))
+	return mux
+}
+
+func (n *Node) rpcHandler(handle func(ctx context.Context, body io.Reader) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if len(n.config.Key) > 0 {
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(n.config.Key)) != 1 {
+				http.Error(w, "Unauthorized Request", http.StatusUnauthorized)
+				return
+			}
+		}
+
+		reply, err := handle(r.Context(), r.Body)
+		w.Header().Set("Content-Type", "application/json")
+
+		switch {
+		case errors.Is(err, ErrNotLeader) || errors.Is(err, ErrLeadershipLost):
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(errorReply{Error: err.Error()})
+		case err != nil:
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			json.NewEncoder(w).Encode(errorReply{Error: err.Error()})
+		default:
+			json.NewEncoder(w).Encode(reply)
+		}
+	}
+}
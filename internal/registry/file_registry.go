@@ -0,0 +1,310 @@
+package registry
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/anjolaoluwaakindipe/duller/internal/service"
+	"github.com/anjolaoluwaakindipe/duller/internal/utils"
+)
+
+const (
+	registryLogFile      = "registry.log"
+	registrySnapshotFile = "registry.snapshot"
+)
+
+// log operations stored in the registry log file
+const (
+	registerOp   = "register"
+	deregisterOp = "deregister"
+	overrideOp   = "override"
+)
+
+// logEntry is a single line of the append-only registry log
+type logEntry struct {
+	Op      string               `json:"op"`
+	Service *service.ServiceInfo `json:"service"`
+}
+
+// FileRegistry is a file backed implementation of the Registry interface. Every
+// registration and deregistration is appended to a log file and the whole registry
+// is periodically written to a snapshot file, which allows all registrations to be
+// restored when the discovery server restarts.
+type FileRegistry struct {
+	*InMemoryRegistry
+	// fileMutex guards the log file and keeps the log in the same order as
+	// the changes made to the in memory tables.
+	fileMutex sync.Mutex
+	dir       string
+	logFile   *os.File
+}
+
+// RegisterService implements Registry. The registration is logged before it is
+// applied, so the registry is left unchanged when it cannot be logged.
+func (r *FileRegistry) RegisterService(msg *service.ServiceInfo) error {
+	r.fileMutex.Lock()
+	defer r.fileMutex.Unlock()
+
+	if err := r.validateService(msg); err != nil {
+		return err
+	}
+
+	// the first registration of a service is logged with the time it was first seen
+	if _, err := r.GetServiceById(msg.ServiceId); err != nil {
+		msg.RegisteredAt = registeredAt(msg, r.Clock.Now())
+	}
+
+	if err := r.appendLog(logEntry{Op: registerOp, Service: msg}); err != nil {
+		return err
+	}
+
+	return r.InMemoryRegistry.RegisterService(msg)
+}
+
+// DeregisterService implements Registry. The deregistration is logged before it is
+// applied, so the registry is left unchanged when it cannot be logged.
+func (r *FileRegistry) DeregisterService(path string, serviceId string) error {
+	r.fileMutex.Lock()
+	defer r.fileMutex.Unlock()
+
+	if registeredService, err := r.GetServiceById(serviceId); err != nil || registeredService.Path != path {
+		// nothing is logged for a service the in memory registry refuses to remove
+		return r.InMemoryRegistry.DeregisterService(path, serviceId)
+	}
+
+	if err := r.appendLog(logEntry{Op: deregisterOp, Service: &service.ServiceInfo{Path: path, ServiceId: serviceId}}); err != nil {
+		return err
+	}
+
+	return r.InMemoryRegistry.DeregisterService(path, serviceId)
+}
+
+// OverrideServiceStatus implements Registry. The override is logged before it is
+// applied, so the registry is left unchanged when it cannot be logged.
+func (r *FileRegistry) OverrideServiceStatus(serviceId string, status service.Status) error {
+	r.fileMutex.Lock()
+	defer r.fileMutex.Unlock()
+
+	if err := isValidStatus(status); err != nil {
+		return err
+	}
+	if _, err := r.GetServiceById(serviceId); err != nil {
+		return err
+	}
+
+	if err := r.appendLog(logEntry{Op: overrideOp, Service: &service.ServiceInfo{ServiceId: serviceId, OverriddenStatus: status}}); err != nil {
+		return err
+	}
+
+	return r.InMemoryRegistry.OverrideServiceStatus(serviceId, status)
+}
+
+// RefreshRegistry implements Registry. Expired services are also removed from
+// the log so they are not restored on the next boot.
+func (r *FileRegistry) RefreshRegistry(duration time.Duration, ctx context.Context) {
+	ticker := time.NewTicker(duration)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.fileMutex.Lock()
+			for _, deadService := range r.expireServices(duration) {
+				entry := logEntry{Op: deregisterOp, Service: &service.ServiceInfo{Path: deadService.Path, ServiceId: deadService.ServiceId}}
+				if err := r.appendLog(entry); err != nil {
+					slog.Error(fmt.Sprintf("Could not persist expiry of service %v: %v", deadService.ServiceId, err))
+				}
+			}
+			r.fileMutex.Unlock()
+		}
+	}
+}
+
+// RunSnapshots writes a snapshot of the registry at the given interval until
+// the context is cancelled. This is meant to be used in a goroutine
+func (r *FileRegistry) RunSnapshots(interval time.Duration, ctx context.Context) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Snapshot(); err != nil {
+				slog.Error(fmt.Sprintf("Could not snapshot registry: %v", err))
+			}
+		}
+	}
+}
+
+// Snapshot writes every registered service to the snapshot file and truncates
+// the log, since all of its entries are now contained in the snapshot.
+func (r *FileRegistry) Snapshot() error {
+	r.fileMutex.Lock()
+	defer r.fileMutex.Unlock()
+
+	data, err := json.Marshal(r.GetServices())
+	if err != nil {
+		return err
+	}
+
+	snapshotPath := filepath.Join(r.dir, registrySnapshotFile)
+	tmpPath := snapshotPath + ".tmp"
+
+	if err := writeFileSync(tmpPath, data); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpPath, snapshotPath); err != nil {
+		return err
+	}
+
+	if err := r.logFile.Truncate(0); err != nil {
+		return err
+	}
+
+	_, err = r.logFile.Seek(0, 0)
+	return err
+}
+
+// Close takes a final snapshot and closes the log file.
+func (r *FileRegistry) Close() error {
+	snapshotErr := r.Snapshot()
+
+	r.fileMutex.Lock()
+	defer r.fileMutex.Unlock()
+
+	return errors.Join(snapshotErr, r.logFile.Close())
+}
+
+func (r *FileRegistry) appendLog(entry logEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	if _, err := r.logFile.Write(append(data, '\n')); err != nil {
+		return err
+	}
+
+	// a change is only acknowledged once it survives a power loss
+	return r.logFile.Sync()
+}
+
+// restore loads the snapshot file and replays the log file on top of it.
+// Restored services are given a fresh heartbeat so they are not expired before
+// they get the chance to send their next heartbeat.
+func (r *FileRegistry) restore() error {
+	data, err := os.ReadFile(filepath.Join(r.dir, registrySnapshotFile))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if len(data) > 0 {
+		services := make([]*service.ServiceInfo, 0)
+		if err := json.Unmarshal(data, &services); err != nil {
+			return fmt.Errorf("invalid registry snapshot: %w", err)
+		}
+
+		for _, snapshotService := range services {
+			r.InMemoryRegistry.RegisterService(snapshotService)
+		}
+	}
+
+	logFile, err := os.Open(filepath.Join(r.dir, registryLogFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
+	scanner := bufio.NewScanner(logFile)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	line := 0
+
+	for scanner.Scan() {
+		line++
+		var entry logEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil || entry.Service == nil {
+			// a torn write can only happen on the last line so everything before it is still valid
+			slog.Warn(fmt.Sprintf("Stopped replaying registry log at invalid line %v", line))
+			break
+		}
+
+		switch entry.Op {
+		case registerOp:
+			r.InMemoryRegistry.RegisterService(entry.Service)
+		case deregisterOp:
+			r.InMemoryRegistry.DeregisterService(entry.Service.Path, entry.Service.ServiceId)
+		case overrideOp:
+			r.InMemoryRegistry.OverrideServiceStatus(entry.Service.ServiceId, entry.Service.OverriddenStatus)
+		}
+	}
+
+	return scanner.Err()
+}
+
+func writeFileSync(name string, data []byte) error {
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// InitFileRegistry creates a FileRegistry that stores its data inside dir, restoring
+// any registrations found in a previous snapshot and log.
+func InitFileRegistry(clock utils.Clock, dir string) (*FileRegistry, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	r := &FileRegistry{
+		InMemoryRegistry: newInMemoryRegistry(clock),
+		dir:              dir,
+	}
+
+	if err := r.restore(); err != nil {
+		return nil, err
+	}
+
+	// compact what was restored so a torn line at the end of the log is never appended to
+	if err := r.compactRestored(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// compactRestored opens the log file and immediately snapshots the restored state.
+func (r *FileRegistry) compactRestored() error {
+	logFile, err := os.OpenFile(filepath.Join(r.dir, registryLogFile), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	r.logFile = logFile
+
+	return r.Snapshot()
+}
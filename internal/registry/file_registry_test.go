@@ -0,0 +1,184 @@
+package registry_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/anjolaoluwaakindipe/duller/internal/registry"
+	"github.com/anjolaoluwaakindipe/duller/internal/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_FileRegistry_Restore(t *testing.T) {
+	t.Run("SHOULD restore all registrations WHEN the registry is reopened", func(t *testing.T) {
+		dir := t.TempDir()
+		clock := &FakeTime{time.Now()}
+
+		fileRegistry, err := registry.InitFileRegistry(clock, dir)
+		assert.Nil(t, err)
+
+		assert.Nil(t, fileRegistry.RegisterService(&service.ServiceInfo{Path: "/hello", IP: "127.0.0.1", Port: "3000", ServiceId: "server_1"}))
+		assert.Nil(t, fileRegistry.RegisterService(&service.ServiceInfo{Path: "/hello", IP: "127.0.0.1", Port: "3001", ServiceId: "server_2"}))
+		assert.Nil(t, fileRegistry.RegisterService(&service.ServiceInfo{Path: "/world", IP: "127.0.0.1", Port: "3002", ServiceId: "server_3"}))
+		assert.Nil(t, fileRegistry.DeregisterService("/hello", "server_2"))
+
+		restored, err := registry.InitFileRegistry(clock, dir)
+		assert.Nil(t, err)
+
+		services, err := restored.GetServicesByPath("/hello")
+		assert.Nil(t, err)
+		assert.Len(t, services, 1)
+		assert.Equal(t, "server_1", services[0].ServiceId)
+		assert.Equal(t, "3000", services[0].Port)
+
+		_, err = restored.GetServiceById("server_2")
+		assert.NotNil(t, err)
+
+		_, err = restored.GetServiceById("server_3")
+		assert.Nil(t, err)
+	})
+
+	t.Run("SHOULD restore the attributes of services WHEN the registry is reopened", func(t *testing.T) {
+		dir := t.TempDir()
+		clock := &FakeTime{time.Now()}
+
+		fileRegistry, err := registry.InitFileRegistry(clock, dir)
+		assert.Nil(t, err)
+		assert.Nil(t, fileRegistry.RegisterService(&service.ServiceInfo{Path: "/hello", IP: "127.0.0.1", Port: "3000", ServiceId: "server_1",
+			Metadata: map[string]string{"team": "payments"}, Tags: []string{"canary"}, Version: "1.0.0", Zone: "eu-west-1a"}))
+
+		restored, err := registry.InitFileRegistry(clock, dir)
+		assert.Nil(t, err)
+
+		restoredService, err := restored.GetServiceById("server_1")
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]string{"team": "payments"}, restoredService.Metadata)
+		assert.Equal(t, []string{"canary"}, restoredService.Tags)
+		assert.Equal(t, "1.0.0", restoredService.Version)
+		assert.Equal(t, "eu-west-1a", restoredService.Zone)
+	})
+
+	t.Run("SHOULD restore status overrides WHEN the registry is reopened", func(t *testing.T) {
+		dir := t.TempDir()
+		clock := &FakeTime{time.Now()}
+
+		fileRegistry, err := registry.InitFileRegistry(clock, dir)
+		assert.Nil(t, err)
+		assert.Nil(t, fileRegistry.RegisterService(&service.ServiceInfo{Path: "/hello", IP: "127.0.0.1", Port: "3000", ServiceId: "server_1"}))
+		assert.Nil(t, fileRegistry.RegisterService(&service.ServiceInfo{Path: "/hello", IP: "127.0.0.1", Port: "3001", ServiceId: "server_2"}))
+		assert.Nil(t, fileRegistry.Snapshot())
+		assert.Nil(t, fileRegistry.OverrideServiceStatus("server_1", service.StatusOutOfService))
+
+		restored, err := registry.InitFileRegistry(clock, dir)
+		assert.Nil(t, err)
+
+		restoredService, err := restored.GetServiceById("server_1")
+		assert.Nil(t, err)
+		assert.Equal(t, service.StatusOutOfService, restoredService.CurrentStatus())
+	})
+
+	t.Run("SHOULD give restored services a fresh heartbeat WHEN the registry is reopened", func(t *testing.T) {
+		dir := t.TempDir()
+		clock := &FakeTime{time.Now()}
+
+		fileRegistry, err := registry.InitFileRegistry(clock, dir)
+		assert.Nil(t, err)
+		assert.Nil(t, fileRegistry.RegisterService(&service.ServiceInfo{Path: "/hello", IP: "127.0.0.1", Port: "3000", ServiceId: "server_1"}))
+
+		restartClock := &FakeTime{clock.CurrentTime.Add(time.Hour)}
+		restored, err := registry.InitFileRegistry(restartClock, dir)
+		assert.Nil(t, err)
+
+		restoredService, err := restored.GetServiceById("server_1")
+		assert.Nil(t, err)
+		assert.Equal(t, restartClock.CurrentTime, restoredService.LastHeartbeat)
+	})
+
+	t.Run("SHOULD ignore a torn last line WHEN replaying the log", func(t *testing.T) {
+		dir := t.TempDir()
+		clock := &FakeTime{time.Now()}
+
+		fileRegistry, err := registry.InitFileRegistry(clock, dir)
+		assert.Nil(t, err)
+		assert.Nil(t, fileRegistry.RegisterService(&service.ServiceInfo{Path: "/hello", IP: "127.0.0.1", Port: "3000", ServiceId: "server_1"}))
+
+		logFile, err := os.OpenFile(filepath.Join(dir, "registry.log"), os.O_APPEND|os.O_WRONLY, 0644)
+		assert.Nil(t, err)
+		logFile.WriteString(`{"op":"register","service":{"serviceId":"ser`)
+		logFile.Close()
+
+		restored, err := registry.InitFileRegistry(clock, dir)
+		assert.Nil(t, err)
+		assert.Len(t, restored.GetServices(), 1)
+	})
+
+	t.Run("SHOULD leave the registry unchanged WHEN a change cannot be logged", func(t *testing.T) {
+		fileRegistry, err := registry.InitFileRegistry(&FakeTime{time.Now()}, t.TempDir())
+		assert.Nil(t, err)
+		assert.Nil(t, fileRegistry.RegisterService(&service.ServiceInfo{Path: "/hello", IP: "127.0.0.1", Port: "3000", ServiceId: "server_1"}))
+
+		// appends to a closed log file fail
+		assert.Nil(t, fileRegistry.Close())
+
+		assert.NotNil(t, fileRegistry.RegisterService(&service.ServiceInfo{Path: "/hello", IP: "127.0.0.1", Port: "3001", ServiceId: "server_2"}))
+		_, err = fileRegistry.GetServiceById("server_2")
+		assert.NotNil(t, err)
+
+		assert.NotNil(t, fileRegistry.OverrideServiceStatus("server_1", service.StatusDraining))
+		assert.NotNil(t, fileRegistry.DeregisterService("/hello", "server_1"))
+		registeredService, err := fileRegistry.GetServiceById("server_1")
+		assert.Nil(t, err)
+		assert.Empty(t, registeredService.OverriddenStatus)
+	})
+}
+
+func Test_FileRegistry_Snapshot(t *testing.T) {
+	t.Run("SHOULD truncate the log and keep all registrations WHEN a snapshot is taken", func(t *testing.T) {
+		dir := t.TempDir()
+		clock := &FakeTime{time.Now()}
+
+		fileRegistry, err := registry.InitFileRegistry(clock, dir)
+		assert.Nil(t, err)
+		assert.Nil(t, fileRegistry.RegisterService(&service.ServiceInfo{Path: "/hello", IP: "127.0.0.1", Port: "3000", ServiceId: "server_1"}))
+
+		assert.Nil(t, fileRegistry.Snapshot())
+
+		info, err := os.Stat(filepath.Join(dir, "registry.log"))
+		assert.Nil(t, err)
+		assert.Equal(t, int64(0), info.Size())
+
+		assert.Nil(t, fileRegistry.RegisterService(&service.ServiceInfo{Path: "/world", IP: "127.0.0.1", Port: "3001", ServiceId: "server_2"}))
+		assert.Nil(t, fileRegistry.Close())
+
+		restored, err := registry.InitFileRegistry(clock, dir)
+		assert.Nil(t, err)
+		assert.Len(t, restored.GetServices(), 2)
+	})
+}
+
+func Test_FileRegistry_RefreshRegistry(t *testing.T) {
+	t.Run("SHOULD not restore expired services WHEN the registry is reopened", func(t *testing.T) {
+		dir := t.TempDir()
+		clock := &FakeTime{time.Now()}
+
+		fileRegistry, err := registry.InitFileRegistry(clock, dir)
+		assert.Nil(t, err)
+		assert.Nil(t, fileRegistry.RegisterService(&service.ServiceInfo{Path: "/hello", IP: "127.0.0.1", Port: "3000", ServiceId: "server_1"}))
+
+		clock.CurrentTime = clock.CurrentTime.Add(time.Minute)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go fileRegistry.RefreshRegistry(10*time.Millisecond, ctx)
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+
+		assert.Len(t, fileRegistry.GetServices(), 0)
+
+		restored, err := registry.InitFileRegistry(clock, dir)
+		assert.Nil(t, err)
+		assert.Len(t, restored.GetServices(), 0)
+	})
+}
@@ -3,8 +3,6 @@ package registry
 import (
 	"context"
 	"fmt"
-	"regexp"
-	"strings"
 	"sync"
 	"time"
 
@@ -14,50 +12,75 @@ import (
 )
 
 // InMemoryRegistry is an in memory implementation of the
-// Registry interface.
+// Registry interface. Stored services are never changed once other goroutines can
+// read them: every update stores a changed copy in their place, so the services it
+// returns can be read without holding its mutex.
 type InMemoryRegistry struct {
 	mutex sync.Mutex
 	// PathTable is a store for all the services indexed by their path.
 	PathTable map[string][]*service.ServiceInfo
-	// servicePathRegex is used to quickly get the available service path that has been stored from
-	// a given external path.
-	servicPathRegex string
+	// paths indexes the paths of the PathTable to quickly get the stored service
+	// path of a given external path.
+	paths *PathTrie
 	// Clock is an app interface for time.
 	Clock utils.Clock
 	// ServiceIdTable is a store for all the services indexed by their id
 	ServiceIdTable map[string]*service.ServiceInfo
 }
 
-func (r *InMemoryRegistry) GetServicePathRegex() string {
-	return r.servicPathRegex
+// GetPathFromRequest implements Registry.
+func (r *InMemoryRegistry) GetPathFromRequest(requestPath string) (string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.matchPath(requestPath)
 }
 
-func (r *InMemoryRegistry) GetPathFromRequest(str string) (string, error) {
-	reg, err := regexp.Compile(r.servicPathRegex)
-	if err != nil {
-		return "", err
+// matchPath returns the stored path matching the most segments of the request
+// path. The caller must hold the registry mutex.
+func (r *InMemoryRegistry) matchPath(requestPath string) (string, error) {
+	servicePath, found := r.pathIndex().Match(requestPath)
+	if !found {
+		return "", fmt.Errorf("path '%v' does not exist", requestPath)
 	}
+	return servicePath, nil
+}
 
-	serviceNameIndexes := reg.FindAllStringIndex(str, -1)
+// pathIndex returns the index of the paths of the PathTable, building it for
+// registries that were not created through InitInMemoryRegistry. The caller must
+// hold the registry mutex.
+func (r *InMemoryRegistry) pathIndex() *PathTrie {
+	if r.paths == nil {
+		r.paths = NewPathTrie()
+		for path := range r.PathTable {
+			r.paths.Insert(path)
+		}
+	}
+	return r.paths
+}
 
-	if serviceNameIndexes == nil {
-		return "", fmt.Errorf("no services available")
+// isValidStatus accepts an empty status or one of the known statuses
+func isValidStatus(value interface{}) error {
+	status, _ := value.(service.Status)
+	if len(status) > 0 && !status.IsValid() {
+		return fmt.Errorf("unknown status '%v'", status)
 	}
+	return nil
+}
 
-	serviceNameIndex := serviceNameIndexes[len(serviceNameIndexes)-1]
-	return str[serviceNameIndex[0]:serviceNameIndex[1]], nil
+// isValidPath accepts paths the PathTrie can index
+func isValidPath(value interface{}) error {
+	path, _ := value.(string)
+	return ValidatePath(path)
 }
 
-func (r *InMemoryRegistry) SetServicePathRegex() {
-	if len(r.PathTable) == 0 {
-		return
-	}
-	paths := make([]string, 0)
-	for k := range r.PathTable {
-		reg := regexp.QuoteMeta(k)
-		paths = append(paths, reg)
+// isValidVersion accepts an empty version or a semantic version
+func isValidVersion(value interface{}) error {
+	version, _ := value.(string)
+	if len(version) > 0 && !service.IsValidVersion(version) {
+		return fmt.Errorf("'%v' is not a semantic version", version)
 	}
-	r.servicPathRegex = "^(" + strings.Join(paths, "|") + ")"
+	return nil
 }
 
 func (r *InMemoryRegistry) validateService(msg *service.ServiceInfo) error {
@@ -65,7 +88,11 @@ func (r *InMemoryRegistry) validateService(msg *service.ServiceInfo) error {
 		validation.Field(&msg.IP, validation.Required),
 		validation.Field(&msg.Port, validation.Required),
 		validation.Field(&msg.ServiceId, validation.Required),
-		validation.Field(&msg.Path, validation.Required),
+		validation.Field(&msg.Path, validation.Required, validation.By(isValidPath)),
+		validation.Field(&msg.Status, validation.By(isValidStatus)),
+		validation.Field(&msg.OverriddenStatus, validation.By(isValidStatus)),
+		validation.Field(&msg.Version, validation.By(isValidVersion)),
+		validation.Field(&msg.Tags, validation.Each(validation.Required)),
 	)
 }
 
@@ -77,94 +104,127 @@ func (r *InMemoryRegistry) RegisterService(msg *service.ServiceInfo) error {
 	}
 
 	utils.MakeUrlPathValid(&msg.Path)
-	_, pathExist := r.PathTable[msg.Path]
 
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
+	_, pathExist := r.PathTable[msg.Path]
+
 	if !pathExist {
+		msg.LastHeartbeat = r.Clock.Now()
+		msg.RegisteredAt = registeredAt(msg, msg.LastHeartbeat)
 		r.PathTable[msg.Path] = []*service.ServiceInfo{msg}
 		r.ServiceIdTable[msg.ServiceId] = msg
-		r.SetServicePathRegex()
+		r.pathIndex().Insert(msg.Path)
 		return nil
 	}
 
-	service, serviceIdExist := r.ServiceIdTable[msg.ServiceId]
+	registeredService, serviceIdExist := r.ServiceIdTable[msg.ServiceId]
 
 	if !serviceIdExist {
+		msg.LastHeartbeat = r.Clock.Now()
+		msg.RegisteredAt = registeredAt(msg, msg.LastHeartbeat)
 		r.PathTable[msg.Path] = append(r.PathTable[msg.Path], msg)
 		r.ServiceIdTable[msg.ServiceId] = msg
 		return nil
 	}
 
-	service.LastHeartbeat = r.Clock.Now()
-	service.IP = msg.IP
-	service.Port = msg.Port
+	updated := *registeredService
+	updated.LastHeartbeat = r.Clock.Now()
+	updated.IP = msg.IP
+	updated.Port = msg.Port
+	updated.Status = msg.Status
+	updated.Metadata = msg.Metadata
+	updated.Tags = msg.Tags
+	updated.Version = msg.Version
+	updated.Zone = msg.Zone
+	updated.Strategy = msg.Strategy
+	updated.WeightedUse = msg.WeightedUse
+	if !msg.RegisteredAt.IsZero() {
+		updated.RegisteredAt = msg.RegisteredAt
+	}
+	r.replaceService(registeredService, &updated)
 
 	return nil
 }
 
-func (r *InMemoryRegistry) UpdateServiceCurrentUse(serviceId string) {
-	r.mutex.Lock()
-	defer r.mutex.Unlock()
-	service, exists := r.ServiceIdTable[serviceId]
-
-	if !exists {
-		return
+// replaceService stores the updated copy of a service in its place. The caller must
+// hold the registry mutex.
+func (r *InMemoryRegistry) replaceService(registeredService *service.ServiceInfo, updated *service.ServiceInfo) {
+	r.ServiceIdTable[updated.ServiceId] = updated
+	for index, pathService := range r.PathTable[registeredService.Path] {
+		if pathService == registeredService {
+			r.PathTable[registeredService.Path][index] = updated
+			return
+		}
 	}
+}
 
-	if service.CurrentUse < service.WeightedUse {
-		service.CurrentUse += 1
+// registeredAt returns when a service was first seen. Restored and synced services
+// keep the time they carry so they do not warm up again.
+func registeredAt(msg *service.ServiceInfo, now time.Time) time.Time {
+	if msg.RegisteredAt.IsZero() {
+		return now
 	}
-	return
+	return msg.RegisteredAt
 }
 
-func (r *InMemoryRegistry) ResetCurrentUse(serviceId string) {
+func (r *InMemoryRegistry) SetCheckStatus(serviceId string, status service.Status) error {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
-	service, exists := r.ServiceIdTable[serviceId]
+
+	registeredService, exists := r.ServiceIdTable[serviceId]
 
 	if !exists {
-		return
+		return fmt.Errorf("service with serviceId '%v' does not exist", serviceId)
 	}
 
-	service.CurrentUse = 0
+	updated := *registeredService
+	updated.CheckStatus = status
+	r.replaceService(registeredService, &updated)
+	return nil
 }
 
-func (r *InMemoryRegistry) IsServiceWeightFull(serviceId string) (bool, error) {
+func (r *InMemoryRegistry) OverrideServiceStatus(serviceId string, status service.Status) error {
+	if err := isValidStatus(status); err != nil {
+		return err
+	}
+
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
-	service, exists := r.ServiceIdTable[serviceId]
+	registeredService, exists := r.ServiceIdTable[serviceId]
 
 	if !exists {
-		return false, fmt.Errorf("service id does not exist in registry")
+		return fmt.Errorf("service with serviceId '%v' does not exist", serviceId)
 	}
 
-	if service.CurrentUse == service.WeightedUse {
-		return true, nil
-	}
-
-	return false, nil
+	updated := *registeredService
+	updated.OverriddenStatus = status
+	r.replaceService(registeredService, &updated)
+	return nil
 }
 
 func (r *InMemoryRegistry) GetServicesByPath(path string) ([]*service.ServiceInfo, error) {
-	servicePath, err := r.GetPathFromRequest(path)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	servicePath, err := r.matchPath(path)
 	if err != nil {
-		return nil, fmt.Errorf("invalid path")
+		return nil, err
 	}
-	r.mutex.Lock()
 	services, exist := r.PathTable[servicePath]
-	r.mutex.Unlock()
 
 	if !exist {
 		return nil, fmt.Errorf("path '%v' does not exist", path)
 	}
-	return services, nil
+	return append([]*service.ServiceInfo{}, services...), nil
 }
 
 func (r *InMemoryRegistry) GetServiceById(serviceId string) (*service.ServiceInfo, error) {
+	r.mutex.Lock()
 	service, exist := r.ServiceIdTable[serviceId]
+	r.mutex.Unlock()
 
 	if !exist {
 		return nil, fmt.Errorf("service with serviceId '%v' does not exist", serviceId)
@@ -174,6 +234,9 @@ func (r *InMemoryRegistry) GetServiceById(serviceId string) (*service.ServiceInf
 }
 
 func (r *InMemoryRegistry) GetServices() []*service.ServiceInfo {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
 	services := make([]*service.ServiceInfo, 0)
 
 	for _, service := range r.ServiceIdTable {
@@ -187,16 +250,22 @@ func (r *InMemoryRegistry) DeregisterService(path string, serviceId string) erro
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
+	return r.removeService(path, serviceId)
+}
+
+// removeService deletes a service from both the PathTable and the ServiceIdTable.
+// The caller must hold the registry mutex.
+func (r *InMemoryRegistry) removeService(path string, serviceId string) error {
 	services, pathExist := r.PathTable[path]
 
 	if !pathExist {
 		return fmt.Errorf("path '%v' does not exist inside registry", path)
 	}
 
-	_, serviceExist := r.ServiceIdTable[serviceId]
+	registeredService, serviceExist := r.ServiceIdTable[serviceId]
 
-	if !serviceExist {
-		return fmt.Errorf("service with id '%v' does not exist", serviceId)
+	if !serviceExist || registeredService.Path != path {
+		return fmt.Errorf("service with id '%v' does not exist on path '%v'", serviceId, path)
 	}
 
 	delete(r.ServiceIdTable, serviceId)
@@ -215,9 +284,66 @@ func (r *InMemoryRegistry) DeregisterService(path string, serviceId string) erro
 		r.PathTable[path] = append(r.PathTable[path][:rmInd], r.PathTable[path][rmInd+1:]...)
 	}
 
+	if len(r.PathTable[path]) == 0 {
+		r.removePath(path)
+	}
+
 	return nil
 }
 
+// removePath forgets a path without services, so its requests fall back to shorter
+// paths. Paths differing from it only by parameter names end on the same node of
+// the index, so the remaining paths are indexed again. The caller must hold the
+// registry mutex.
+func (r *InMemoryRegistry) removePath(path string) {
+	delete(r.PathTable, path)
+	r.pathIndex().Remove(path)
+	for remainingPath := range r.PathTable {
+		r.paths.Insert(remainingPath)
+	}
+}
+
+// findExpiredServices returns every service whose last heartbeat is older than the given
+// duration (plus a one second grace period). The caller must hold the registry mutex.
+func (r *InMemoryRegistry) findExpiredServices(duration time.Duration) []*service.ServiceInfo {
+	now := r.Clock.Now()
+	deadServices := make([]*service.ServiceInfo, 0)
+
+	for _, services := range r.PathTable {
+		for _, service := range services {
+			if now.After(service.LastHeartbeat.Add(duration).Add(1 * time.Second)) {
+				deadServices = append(deadServices, service)
+			}
+		}
+	}
+
+	return deadServices
+}
+
+// expireServices removes every expired service and returns the removed services.
+func (r *InMemoryRegistry) expireServices(duration time.Duration) []*service.ServiceInfo {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	deadServices := r.findExpiredServices(duration)
+
+	for _, service := range deadServices {
+		r.removeService(service.Path, service.ServiceId)
+	}
+
+	return deadServices
+}
+
+// clear removes every service from the registry
+func (r *InMemoryRegistry) clear() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.PathTable = make(map[string][]*service.ServiceInfo)
+	r.ServiceIdTable = make(map[string]*service.ServiceInfo)
+	r.paths = NewPathTrie()
+}
+
 func (r *InMemoryRegistry) RefreshRegistry(duration time.Duration, ctx context.Context) {
 	ticker := time.NewTicker(duration)
 	defer ticker.Stop()
@@ -226,39 +352,15 @@ func (r *InMemoryRegistry) RefreshRegistry(duration time.Duration, ctx context.C
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			r.mutex.Lock()
-			defer r.mutex.Unlock()
-			deadServices := make([]struct {
-				Path string
-				Id   string
-				Ind  int
-			}, 0)
-
-			for path, services := range r.PathTable {
-				for ind, service := range services {
-					if time.Now().After(service.LastHeartbeat.Add(duration).Add(1 * time.Second)) {
-						deadServices = append(deadServices, struct {
-							Path string
-							Id   string
-							Ind  int
-						}{
-							Path: path,
-							Id:   service.ServiceId,
-							Ind:  ind,
-						})
-					}
-				}
-			}
-
-			for _, val := range deadServices {
-				delete(r.ServiceIdTable, val.Id)
-				r.PathTable[val.Path][val.Ind] = nil
-				r.PathTable[val.Path] = append(r.PathTable[val.Path][:val.Ind], r.PathTable[val.Path][val.Ind+1:]...)
-			}
+			r.expireServices(duration)
 		}
 	}
 }
 
+func newInMemoryRegistry(clock utils.Clock) *InMemoryRegistry {
+	return &InMemoryRegistry{PathTable: make(map[string][]*service.ServiceInfo), paths: NewPathTrie(), Clock: clock, ServiceIdTable: make(map[string]*service.ServiceInfo)}
+}
+
 func InitInMemoryRegistry(clock utils.Clock) Registry {
-	return &InMemoryRegistry{PathTable: make(map[string][]*service.ServiceInfo), Clock: clock, ServiceIdTable: make(map[string]*service.ServiceInfo)}
+	return newInMemoryRegistry(clock)
 }
@@ -0,0 +1,195 @@
+package registry
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PathTrie indexes service paths for longest prefix matching of request paths. It
+// is a radix tree whose edges are whole path segments, so /api never matches
+// /apis and a lookup costs one step per segment of the request path no matter how
+// many paths are indexed.
+//
+// Besides static segments a path may hold parameter segments such as {id}, which
+// match any single segment, and end with a * wildcard, which matches one or more
+// segments. Static segments win over parameters and parameters over wildcards when
+// several paths match as deep into the request path.
+//
+// A PathTrie is not safe for concurrent use.
+type PathTrie struct {
+	root *pathNode
+}
+
+type pathNode struct {
+	// path is the indexed path ending at this node when terminal is set
+	path     string
+	terminal bool
+	children map[string]*pathNode
+	param    *pathNode
+	wildcard *pathNode
+}
+
+// NewPathTrie creates an empty PathTrie
+func NewPathTrie() *PathTrie {
+	return &PathTrie{root: newPathNode()}
+}
+
+func newPathNode() *pathNode {
+	return &pathNode{children: make(map[string]*pathNode)}
+}
+
+// ValidatePath returns an error when the path holds a wildcard anywhere but in
+// its last segment
+func ValidatePath(path string) error {
+	segments := splitPath(path)
+	for i, segment := range segments {
+		if segment == "*" && i != len(segments)-1 {
+			return fmt.Errorf("path '%v' may only hold a wildcard in its last segment", path)
+		}
+	}
+	return nil
+}
+
+// Insert indexes the path. Parameter names do not take part in matching, so
+// /users/{id} and /users/{name} end on the same node and the path inserted first
+// is kept.
+func (pt *PathTrie) Insert(path string) error {
+	if err := ValidatePath(path); err != nil {
+		return err
+	}
+
+	node := pt.root
+	for _, segment := range splitPath(path) {
+		switch {
+		case segment == "*":
+			if node.wildcard == nil {
+				node.wildcard = newPathNode()
+			}
+			node = node.wildcard
+		case isParamSegment(segment):
+			if node.param == nil {
+				node.param = newPathNode()
+			}
+			node = node.param
+		default:
+			child, exists := node.children[segment]
+			if !exists {
+				child = newPathNode()
+				node.children[segment] = child
+			}
+			node = child
+		}
+	}
+
+	if !node.terminal {
+		node.path = path
+		node.terminal = true
+	}
+	return nil
+}
+
+// Remove stops indexing the path and prunes the nodes left without paths, so
+// request paths fall back to shorter indexed paths. A path sharing the node of
+// another one that only differs by parameter names is left indexed.
+func (pt *PathTrie) Remove(path string) {
+	nodes := []*pathNode{pt.root}
+	segments := splitPath(path)
+	for _, segment := range segments {
+		node := nodes[len(nodes)-1].child(segment)
+		if node == nil {
+			return
+		}
+		nodes = append(nodes, node)
+	}
+
+	node := nodes[len(nodes)-1]
+	if !node.terminal || node.path != path {
+		return
+	}
+	node.path = ""
+	node.terminal = false
+
+	for i := len(segments) - 1; i >= 0 && nodes[i+1].isEmpty(); i-- {
+		nodes[i].removeChild(segments[i])
+	}
+}
+
+// child returns the node the segment leads to, or nil when there is none
+func (pn *pathNode) child(segment string) *pathNode {
+	switch {
+	case segment == "*":
+		return pn.wildcard
+	case isParamSegment(segment):
+		return pn.param
+	default:
+		return pn.children[segment]
+	}
+}
+
+// removeChild detaches the node the segment leads to
+func (pn *pathNode) removeChild(segment string) {
+	switch {
+	case segment == "*":
+		pn.wildcard = nil
+	case isParamSegment(segment):
+		pn.param = nil
+	default:
+		delete(pn.children, segment)
+	}
+}
+
+// isEmpty reports whether no indexed path ends at or below the node
+func (pn *pathNode) isEmpty() bool {
+	return !pn.terminal && len(pn.children) == 0 && pn.param == nil && pn.wildcard == nil
+}
+
+// Match returns the indexed path matching the most segments of the request path
+func (pt *PathTrie) Match(requestPath string) (string, bool) {
+	path, depth := pt.root.match(splitPath(requestPath), 0)
+	return path, depth >= 0
+}
+
+// match returns the path of the deepest terminal node reachable with the segments
+// and how many segments it consumed, or -1 when no terminal node is reachable.
+func (pn *pathNode) match(segments []string, depth int) (string, int) {
+	bestPath, bestDepth := "", -1
+	if pn.terminal {
+		bestPath, bestDepth = pn.path, depth
+	}
+
+	if len(segments) == 0 {
+		return bestPath, bestDepth
+	}
+
+	// strictly deeper matches replace the best one, so ties go to static segments
+	// first and parameters second
+	if child, exists := pn.children[segments[0]]; exists {
+		if path, childDepth := child.match(segments[1:], depth+1); childDepth > bestDepth {
+			bestPath, bestDepth = path, childDepth
+		}
+	}
+
+	if pn.param != nil {
+		if path, paramDepth := pn.param.match(segments[1:], depth+1); paramDepth > bestDepth {
+			bestPath, bestDepth = path, paramDepth
+		}
+	}
+
+	if pn.wildcard != nil && pn.wildcard.terminal && depth+len(segments) > bestDepth {
+		bestPath, bestDepth = pn.wildcard.path, depth+len(segments)
+	}
+
+	return bestPath, bestDepth
+}
+
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if len(path) == 0 {
+		return []string{}
+	}
+	return strings.Split(path, "/")
+}
+
+func isParamSegment(segment string) bool {
+	return len(segment) > 2 && segment[0] == '{' && segment[len(segment)-1] == '}'
+}
@@ -0,0 +1,203 @@
+package registry_test
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/anjolaoluwaakindipe/duller/internal/registry"
+	"github.com/anjolaoluwaakindipe/duller/internal/service"
+	"github.com/anjolaoluwaakindipe/duller/internal/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func stubTrie(paths ...string) *registry.PathTrie {
+	trie := registry.NewPathTrie()
+	for _, path := range paths {
+		trie.Insert(path)
+	}
+	return trie
+}
+
+func Test_PathTrie_Match(t *testing.T) {
+	t.Run("SHOULD return the longest matching path WHEN paths overlap", func(t *testing.T) {
+		trie := stubTrie("/api", "/api/v2", "/orders")
+
+		path, found := trie.Match("/api/v2/users")
+		assert.True(t, found)
+		assert.Equal(t, "/api/v2", path)
+
+		path, _ = trie.Match("/api/v1/users")
+		assert.Equal(t, "/api", path)
+
+		path, _ = trie.Match("/api")
+		assert.Equal(t, "/api", path)
+	})
+
+	t.Run("SHOULD only match whole segments WHEN a path is a prefix of a request segment", func(t *testing.T) {
+		trie := stubTrie("/api")
+
+		_, found := trie.Match("/apis")
+		assert.False(t, found)
+
+		_, found = trie.Match("/")
+		assert.False(t, found)
+	})
+
+	t.Run("SHOULD match any segment WHEN a path holds a parameter", func(t *testing.T) {
+		trie := stubTrie("/users/{id}/orders", "/users")
+
+		path, _ := trie.Match("/users/42/orders/7")
+		assert.Equal(t, "/users/{id}/orders", path)
+
+		path, _ = trie.Match("/users/42/profile")
+		assert.Equal(t, "/users", path)
+	})
+
+	t.Run("SHOULD prefer static segments over parameters and parameters over wildcards WHEN they match as deep", func(t *testing.T) {
+		trie := stubTrie("/files/*", "/files/{name}", "/files/readme")
+
+		path, _ := trie.Match("/files/readme")
+		assert.Equal(t, "/files/readme", path)
+
+		path, _ = trie.Match("/files/notes")
+		assert.Equal(t, "/files/{name}", path)
+
+		path, _ = trie.Match("/files/notes/2023")
+		assert.Equal(t, "/files/*", path)
+	})
+
+	t.Run("SHOULD keep the deepest match WHEN a static branch stops before a parameter branch", func(t *testing.T) {
+		trie := stubTrie("/shop/cart", "/shop/{id}/items")
+
+		path, _ := trie.Match("/shop/cart/items")
+		assert.Equal(t, "/shop/{id}/items", path)
+	})
+
+	t.Run("SHOULD reject a path WHEN a wildcard is not its last segment", func(t *testing.T) {
+		trie := registry.NewPathTrie()
+
+		assert.NotNil(t, trie.Insert("/files/*/meta"))
+		_, found := trie.Match("/files/a/meta")
+		assert.False(t, found)
+	})
+}
+
+func Test_PathTrie_Remove(t *testing.T) {
+	t.Run("SHOULD fall back to the shorter path WHEN the longer one is removed", func(t *testing.T) {
+		trie := stubTrie("/api", "/api/v2", "/api/{version}/users", "/files/*")
+
+		trie.Remove("/api/v2")
+		path, _ := trie.Match("/api/v2/orders")
+		assert.Equal(t, "/api", path)
+
+		trie.Remove("/api/{version}/users")
+		path, _ = trie.Match("/api/v2/users")
+		assert.Equal(t, "/api", path)
+
+		trie.Remove("/files/*")
+		_, found := trie.Match("/files/readme")
+		assert.False(t, found)
+	})
+
+	t.Run("SHOULD keep the path WHEN another path only differs by parameter names", func(t *testing.T) {
+		trie := stubTrie("/users/{id}")
+
+		trie.Remove("/users/{name}")
+		trie.Remove("/orders")
+		path, found := trie.Match("/users/42")
+		assert.True(t, found)
+		assert.Equal(t, "/users/{id}", path)
+	})
+}
+
+func Test_InMemoryRegistry_GetServicesByPath(t *testing.T) {
+	t.Run("SHOULD return the services of the longest path WHEN paths are nested", func(t *testing.T) {
+		reg := registry.InitInMemoryRegistry(utils.NewClock())
+		reg.RegisterService(&service.ServiceInfo{Path: "/api", ServiceId: "api1", IP: "127.0.0.1", Port: "4000"})
+		reg.RegisterService(&service.ServiceInfo{Path: "/api/v2", ServiceId: "v2", IP: "127.0.0.1", Port: "4001"})
+
+		services, err := reg.GetServicesByPath("/api/v2/users")
+		assert.Nil(t, err)
+		assert.Len(t, services, 1)
+		assert.Equal(t, "v2", services[0].ServiceId)
+
+		_, err = reg.GetServicesByPath("/apis")
+		assert.NotNil(t, err)
+	})
+
+	t.Run("SHOULD fall back to the shorter path WHEN the last service of the longer one leaves", func(t *testing.T) {
+		reg := registry.InitInMemoryRegistry(utils.NewClock())
+		reg.RegisterService(&service.ServiceInfo{Path: "/api", ServiceId: "api1", IP: "127.0.0.1", Port: "4000"})
+		reg.RegisterService(&service.ServiceInfo{Path: "/api/v2", ServiceId: "v2", IP: "127.0.0.1", Port: "4001"})
+
+		assert.Nil(t, reg.DeregisterService("/api/v2", "v2"))
+
+		path, err := reg.GetPathFromRequest("/api/v2/x")
+		assert.Nil(t, err)
+		assert.Equal(t, "/api", path)
+
+		services, err := reg.GetServicesByPath("/api/v2/x")
+		assert.Nil(t, err)
+		assert.Len(t, services, 1)
+		assert.Equal(t, "api1", services[0].ServiceId)
+	})
+
+	t.Run("SHOULD keep matching a path WHEN a path only differing by parameter names leaves", func(t *testing.T) {
+		reg := registry.InitInMemoryRegistry(utils.NewClock())
+		reg.RegisterService(&service.ServiceInfo{Path: "/users/{id}", ServiceId: "users1", IP: "127.0.0.1", Port: "4000"})
+		reg.RegisterService(&service.ServiceInfo{Path: "/users/{name}", ServiceId: "users2", IP: "127.0.0.1", Port: "4001"})
+
+		assert.Nil(t, reg.DeregisterService("/users/{id}", "users1"))
+
+		services, err := reg.GetServicesByPath("/users/42")
+		assert.Nil(t, err)
+		assert.Len(t, services, 1)
+		assert.Equal(t, "users2", services[0].ServiceId)
+	})
+
+	t.Run("SHOULD refuse a service WHEN its path holds a wildcard before its last segment", func(t *testing.T) {
+		reg := registry.InitInMemoryRegistry(utils.NewClock())
+
+		err := reg.RegisterService(&service.ServiceInfo{Path: "/files/*/meta", ServiceId: "files1", IP: "127.0.0.1", Port: "4000"})
+		assert.NotNil(t, err)
+	})
+}
+
+// benchmarkPaths returns count paths of three segments
+func benchmarkPaths(count int) []string {
+	paths := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		paths = append(paths, fmt.Sprintf("/team%v/service%v/v%v", i%50, i, i%3))
+	}
+	return paths
+}
+
+func BenchmarkPathTrie_Match(b *testing.B) {
+	for _, count := range []int{100, 1000, 10000} {
+		b.Run(strconv.Itoa(count), func(b *testing.B) {
+			paths := benchmarkPaths(count)
+			trie := stubTrie(paths...)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				trie.Match(paths[i%count] + "/orders/123")
+			}
+		})
+	}
+}
+
+func BenchmarkInMemoryRegistry_GetServicesByPath(b *testing.B) {
+	for _, count := range []int{100, 1000, 10000} {
+		b.Run(strconv.Itoa(count), func(b *testing.B) {
+			paths := benchmarkPaths(count)
+			reg := registry.InitInMemoryRegistry(utils.NewClock())
+			for i, path := range paths {
+				reg.RegisterService(&service.ServiceInfo{Path: path, ServiceId: "server" + strconv.Itoa(i), IP: "127.0.0.1", Port: "4000"})
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				reg.GetServicesByPath(paths[i%count] + "/orders/123")
+			}
+		})
+	}
+}
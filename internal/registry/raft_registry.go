@@ -0,0 +1,183 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/anjolaoluwaakindipe/duller/internal/raft"
+	"github.com/anjolaoluwaakindipe/duller/internal/service"
+	"github.com/anjolaoluwaakindipe/duller/internal/utils"
+)
+
+// registryFSM applies the commands of the raft log to an InMemoryRegistry
+type registryFSM struct {
+	registry *InMemoryRegistry
+}
+
+// Apply implements raft.FSM.
+func (f *registryFSM) Apply(command []byte) error {
+	var entry logEntry
+	if err := json.Unmarshal(command, &entry); err != nil {
+		return err
+	}
+
+	switch entry.Op {
+	case registerOp:
+		return f.registry.RegisterService(entry.Service)
+	case deregisterOp:
+		return f.registry.DeregisterService(entry.Service.Path, entry.Service.ServiceId)
+	case overrideOp:
+		return f.registry.OverrideServiceStatus(entry.Service.ServiceId, entry.Service.OverriddenStatus)
+	default:
+		return fmt.Errorf("unknown registry operation '%v'", entry.Op)
+	}
+}
+
+// Snapshot implements raft.FSM.
+func (f *registryFSM) Snapshot() ([]byte, error) {
+	return json.Marshal(f.registry.GetServices())
+}
+
+// Restore implements raft.FSM.
+func (f *registryFSM) Restore(snapshot []byte) error {
+	services := make([]*service.ServiceInfo, 0)
+	if err := json.Unmarshal(snapshot, &services); err != nil {
+		return err
+	}
+
+	f.registry.clear()
+	for _, snapshotService := range services {
+		f.registry.RegisterService(snapshotService)
+	}
+	return nil
+}
+
+// RaftRegistry is an implementation of the Registry interface whose writes go
+// through a raft log replicated across discovery servers. Writes made on a
+// follower are forwarded to the leader, GetServicesByPath is linearizable and
+// only the leader expires services, so every node expires the same services.
+type RaftRegistry struct {
+	*InMemoryRegistry
+	node *raft.Node
+	// timeout bounds how long a write or a linearizable read waits for the cluster
+	timeout time.Duration
+}
+
+// RegisterService implements Registry.
+func (r *RaftRegistry) RegisterService(msg *service.ServiceInfo) error {
+	if err := r.validateService(msg); err != nil {
+		return err
+	}
+
+	utils.MakeUrlPathValid(&msg.Path)
+
+	return r.apply(logEntry{Op: registerOp, Service: msg})
+}
+
+// DeregisterService implements Registry.
+func (r *RaftRegistry) DeregisterService(path string, serviceId string) error {
+	return r.apply(logEntry{Op: deregisterOp, Service: &service.ServiceInfo{Path: path, ServiceId: serviceId}})
+}
+
+// OverrideServiceStatus implements Registry.
+func (r *RaftRegistry) OverrideServiceStatus(serviceId string, status service.Status) error {
+	if err := isValidStatus(status); err != nil {
+		return err
+	}
+
+	return r.apply(logEntry{Op: overrideOp, Service: &service.ServiceInfo{ServiceId: serviceId, OverriddenStatus: status}})
+}
+
+// GetServicesByPath implements Registry. The returned services include every
+// change committed before the call.
+func (r *RaftRegistry) GetServicesByPath(path string) ([]*service.ServiceInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	if err := r.node.ReadBarrier(ctx); err != nil {
+		return nil, err
+	}
+
+	return r.InMemoryRegistry.GetServicesByPath(path)
+}
+
+// RefreshRegistry implements Registry. Only the leader looks for expired services
+// and removes them through the raft log.
+func (r *RaftRegistry) RefreshRegistry(duration time.Duration, ctx context.Context) {
+	ticker := time.NewTicker(duration)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !r.node.IsLeader() {
+				continue
+			}
+
+			r.mutex.Lock()
+			deadServices := r.findExpiredServices(duration)
+			r.mutex.Unlock()
+
+			for _, deadService := range deadServices {
+				if err := r.DeregisterService(deadService.Path, deadService.ServiceId); err != nil {
+					slog.Warn(fmt.Sprintf("Could not expire service %v: %v", deadService.ServiceId, err))
+				}
+			}
+		}
+	}
+}
+
+// Run runs the raft node of the registry until the context is cancelled. This is
+// meant to be used in a goroutine
+func (r *RaftRegistry) Run(ctx context.Context) {
+	r.node.Run(ctx)
+}
+
+// Handler returns the http handler serving the raft rpcs of the registry. It must
+// be mounted on the "/raft/" prefix of the server listening on the node id.
+func (r *RaftRegistry) Handler() http.Handler {
+	return r.node.Handler()
+}
+
+// IsLeader reports whether this registry is the leader of the cluster
+func (r *RaftRegistry) IsLeader() bool {
+	return r.node.IsLeader()
+}
+
+func (r *RaftRegistry) apply(entry logEntry) error {
+	command, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	return r.node.Apply(ctx, command)
+}
+
+// InitRaftRegistry creates a RaftRegistry backed by a raft node using the given
+// configuration and storage. Run must be called for the node to join the cluster.
+func InitRaftRegistry(clock utils.Clock, config raft.Config, storage raft.Storage) (*RaftRegistry, error) {
+	inMemoryRegistry := newInMemoryRegistry(clock)
+
+	if config.ElectionTimeout <= 0 {
+		config.ElectionTimeout = time.Second
+	}
+
+	node, err := raft.NewNode(config, &registryFSM{registry: inMemoryRegistry}, storage, raft.NewHTTPTransport(config.Key, 10*time.Second))
+	if err != nil {
+		return nil, err
+	}
+
+	return &RaftRegistry{
+		InMemoryRegistry: inMemoryRegistry,
+		node:             node,
+		timeout:          5 * config.ElectionTimeout,
+	}, nil
+}
@@ -0,0 +1,133 @@
+package registry_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/anjolaoluwaakindipe/duller/internal/raft"
+	"github.com/anjolaoluwaakindipe/duller/internal/registry"
+	"github.com/anjolaoluwaakindipe/duller/internal/service"
+	"github.com/stretchr/testify/assert"
+)
+
+// stubRaftCluster starts size raft registries talking to each other over loopback
+// and waits until one of them is the leader
+func stubRaftCluster(t *testing.T, size int, clock *FakeTime) (leader *registry.RaftRegistry, followers []*registry.RaftRegistry) {
+	servers := make([]*httptest.Server, size)
+	ids := make([]string, size)
+	for i := range servers {
+		servers[i] = httptest.NewUnstartedServer(nil)
+		ids[i] = servers[i].Listener.Addr().String()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	registries := make([]*registry.RaftRegistry, size)
+	for i := range servers {
+		raftRegistry, err := registry.InitRaftRegistry(clock, raft.Config{
+			ID:                ids[i],
+			Peers:             ids,
+			ElectionTimeout:   150 * time.Millisecond,
+			HeartbeatInterval: 30 * time.Millisecond,
+		}, raft.NewMemoryStorage())
+		assert.Nil(t, err)
+
+		servers[i].Config.Handler = raftRegistry.Handler()
+		servers[i].Start()
+		go raftRegistry.Run(ctx)
+		registries[i] = raftRegistry
+	}
+
+	t.Cleanup(func() {
+		cancel()
+		for _, server := range servers {
+			server.Close()
+		}
+	})
+
+	assert.Eventually(t, func() bool {
+		leader = nil
+		followers = make([]*registry.RaftRegistry, 0)
+		for _, raftRegistry := range registries {
+			if raftRegistry.IsLeader() {
+				leader = raftRegistry
+			} else {
+				followers = append(followers, raftRegistry)
+			}
+		}
+		return leader != nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	return leader, followers
+}
+
+func Test_RaftRegistry_RegisterService(t *testing.T) {
+	t.Run("SHOULD make a service visible on every node WHEN it is registered on a follower", func(t *testing.T) {
+		leader, followers := stubRaftCluster(t, 3, &FakeTime{time.Now()})
+
+		err := followers[0].RegisterService(&service.ServiceInfo{Path: "hello", IP: "127.0.0.1", Port: "3000", ServiceId: "server_1"})
+		assert.Nil(t, err)
+
+		for _, raftRegistry := range append(followers, leader) {
+			services, err := raftRegistry.GetServicesByPath("/hello")
+			assert.Nil(t, err)
+			assert.Len(t, services, 1)
+			assert.Equal(t, "server_1", services[0].ServiceId)
+		}
+	})
+
+	t.Run("SHOULD return a validation error WHEN an invalid service is registered", func(t *testing.T) {
+		_, followers := stubRaftCluster(t, 3, &FakeTime{time.Now()})
+
+		err := followers[0].RegisterService(&service.ServiceInfo{Path: "/hello", ServiceId: "server_1"})
+		assert.NotNil(t, err)
+	})
+}
+
+func Test_RaftRegistry_DeregisterService(t *testing.T) {
+	t.Run("SHOULD remove a service from every node WHEN it is deregistered", func(t *testing.T) {
+		leader, followers := stubRaftCluster(t, 3, &FakeTime{time.Now()})
+
+		assert.Nil(t, leader.RegisterService(&service.ServiceInfo{Path: "/hello", IP: "127.0.0.1", Port: "3000", ServiceId: "server_1"}))
+		assert.Nil(t, followers[1].DeregisterService("/hello", "server_1"))
+
+		// the path is gone along with its last service
+		for _, raftRegistry := range append(followers, leader) {
+			_, err := raftRegistry.GetServicesByPath("/hello")
+			assert.NotNil(t, err)
+		}
+	})
+
+	t.Run("SHOULD return an error WHEN the service does not exist", func(t *testing.T) {
+		_, followers := stubRaftCluster(t, 3, &FakeTime{time.Now()})
+
+		assert.NotNil(t, followers[0].DeregisterService("/hello", "server_1"))
+	})
+}
+
+func Test_RaftRegistry_RefreshRegistry(t *testing.T) {
+	t.Run("SHOULD expire a service on every node WHEN the leader sees it expired", func(t *testing.T) {
+		clock := &FakeTime{time.Now()}
+		leader, followers := stubRaftCluster(t, 3, clock)
+
+		assert.Nil(t, leader.RegisterService(&service.ServiceInfo{Path: "/hello", IP: "127.0.0.1", Port: "3000", ServiceId: "server_1"}))
+		// wait for every node to apply the registration before moving the clock
+		for _, raftRegistry := range append(followers, leader) {
+			_, err := raftRegistry.GetServicesByPath("/hello")
+			assert.Nil(t, err)
+		}
+		clock.CurrentTime = clock.CurrentTime.Add(time.Minute)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		for _, raftRegistry := range append(followers, leader) {
+			go raftRegistry.RefreshRegistry(10*time.Millisecond, ctx)
+		}
+
+		assert.Eventually(t, func() bool {
+			_, err := followers[0].GetServicesByPath("/hello")
+			return err != nil
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+}
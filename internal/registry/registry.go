@@ -18,6 +18,10 @@ type Registry interface {
 	// any service tied to it thus the slice return can still have a length of zero even if there
 	// is no error
 	GetServicesByPath(path string) ([]*service.ServiceInfo, error)
+	// GetPathFromRequest returns the stored path matching the most segments of the given
+	// request path, so /orders/123 returns /orders unless /orders/123 or /orders/{id}
+	// is stored. An error is returned when no stored path matches.
+	GetPathFromRequest(requestPath string) (string, error)
 	// Returns all available services in Registry
 	GetServices() []*service.ServiceInfo
 	// RefreshRegistry helps remove dead services. This is meant to be used in a goroutine
@@ -25,11 +29,10 @@ type Registry interface {
 	// DeregisterService a service from registry given a path and serviceId
 	// returns an error if an invalid path or serviceId is given
 	DeregisterService(path string, serviceId string) error
-	// UpdateServiceCurrentUse is used to increment the CurrentUse of a service until it
-	// reaches its weighted use value
-	UpdateServiceCurrentUse(serviceId string)
-	// IsServiceWeightFull checks if the CurrentUse of a service has reached its weighted value
-	IsServiceWeightFull(serviceId string) (bool, error)
-	// ResetCurrentUse resets the current use of the specified service to 0
-	ResetCurrentUse(serviceId string)
+	// SetCheckStatus stores the result of the active health checks of the specified
+	// service, either service.StatusUp or service.StatusDown
+	SetCheckStatus(serviceId string, status service.Status) error
+	// OverrideServiceStatus sets a status that wins over the status reported by the
+	// specified service. An empty status removes the override.
+	OverrideServiceStatus(serviceId string, status service.Status) error
 }
@@ -1,7 +1,6 @@
 package registry_test
 
 import (
-	"regexp"
 	"testing"
 	"time"
 
@@ -10,35 +9,6 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
-func Test_SetServicePathRegex(t *testing.T) {
-	t.Run("WHEN there is no service SHOULD generate regex string when from service map", func(t *testing.T) {
-		services := make(map[string][]*service.ServiceInfo)
-		registry := registry.InMemoryRegistry{PathTable: services, Clock: &FakeTime{}}
-
-		registry.SetServicePathRegex()
-
-		assert.Equal(t, registry.GetServicePathRegex(), "")
-	})
-	t.Run("WHEN there is one service SHOULD generate regex string when from service map", func(t *testing.T) {
-		services := make(map[string][]*service.ServiceInfo)
-		registry := registry.InMemoryRegistry{PathTable: services, Clock: &FakeTime{}}
-		services["/hello"] = []*service.ServiceInfo{{Path: "/hello"}}
-		registry.SetServicePathRegex()
-		assert.Equal(t, registry.GetServicePathRegex(), "^(/hello)")
-	})
-
-	t.Run("WHEN there is more than one service SHOULD generate regex string when from service map", func(t *testing.T) {
-		services := make(map[string][]*service.ServiceInfo)
-		services["/req"] = []*service.ServiceInfo{}
-		services["/hello"] = []*service.ServiceInfo{}
-		registry := registry.InMemoryRegistry{PathTable: services, Clock: &FakeTime{}}
-		registry.SetServicePathRegex()
-		output := registry.GetServicePathRegex()
-		reg := regexp.MustCompile(`\^\((\/req|/hello)\|(\/req|/hello)\)`)
-		assert.Equal(t, true, reg.MatchString(output))
-	})
-}
-
 func Test_RegisterService(t *testing.T) {
 	t.Run("SHOULD create new add it to the registry service WHEN given a valid RegisterServiceMessage with a service that does not exist ", func(t *testing.T) {
 		newMessage := service.ServiceInfo{Path: "/hello", IP: "http://localhost", Port: "3000", ServiceId: "server_1"}
@@ -88,12 +58,116 @@ func Test_RegisterService(t *testing.T) {
 			return
 		}
 
+		service, err = registry.GetServiceById(newMessage.ServiceId)
+		if err != nil {
+			t.Errorf("Error while getting Service %v: %v", newMessage.ServiceId, err)
+			return
+		}
+
 		updatedAt := service.LastHeartbeat
 
 		assert.NotEqual(t, createdAt, updatedAt)
 	})
 }
 
+func Test_RegisterService_Attributes(t *testing.T) {
+	t.Run("SHOULD replace the attributes of a service WHEN it registers again", func(t *testing.T) {
+		reg := registry.InitInMemoryRegistry(&FakeTime{time.Now()})
+		assert.Nil(t, reg.RegisterService(&service.ServiceInfo{Path: "/hello", IP: "127.0.0.1", Port: "3000", ServiceId: "server_1",
+			Metadata: map[string]string{"team": "payments"}, Tags: []string{"canary"}, Version: "1.0.0", Zone: "eu-west-1a"}))
+
+		assert.Nil(t, reg.RegisterService(&service.ServiceInfo{Path: "/hello", IP: "127.0.0.1", Port: "3000", ServiceId: "server_1",
+			Metadata: map[string]string{"team": "search"}, Version: "1.1.0", Zone: "eu-west-1b"}))
+
+		registeredService, err := reg.GetServiceById("server_1")
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]string{"team": "search"}, registeredService.Metadata)
+		assert.Empty(t, registeredService.Tags)
+		assert.Equal(t, "1.1.0", registeredService.Version)
+		assert.Equal(t, "eu-west-1b", registeredService.Zone)
+	})
+
+	t.Run("SHOULD return an error WHEN the version is not a semantic version", func(t *testing.T) {
+		reg := registry.InitInMemoryRegistry(&FakeTime{time.Now()})
+
+		assert.NotNil(t, reg.RegisterService(&service.ServiceInfo{Path: "/hello", IP: "127.0.0.1", Port: "3000", ServiceId: "server_1", Version: "latest"}))
+		assert.NotNil(t, reg.RegisterService(&service.ServiceInfo{Path: "/hello", IP: "127.0.0.1", Port: "3000", ServiceId: "server_1", Tags: []string{""}}))
+	})
+}
+
+func Test_RegisterService_RegisteredAt(t *testing.T) {
+	t.Run("SHOULD keep the time the service was first seen WHEN it sends heartbeats", func(t *testing.T) {
+		clock := &FakeTime{time.Now()}
+		reg := registry.InitInMemoryRegistry(clock)
+		firstSeen := clock.CurrentTime
+		assert.Nil(t, reg.RegisterService(&service.ServiceInfo{Path: "/hello", IP: "127.0.0.1", Port: "3000", ServiceId: "server_1"}))
+
+		clock.CurrentTime = clock.CurrentTime.Add(5 * time.Second)
+		assert.Nil(t, reg.RegisterService(&service.ServiceInfo{Path: "/hello", IP: "127.0.0.1", Port: "3000", ServiceId: "server_1"}))
+
+		registeredService, _ := reg.GetServiceById("server_1")
+		assert.Equal(t, firstSeen, registeredService.RegisteredAt)
+	})
+
+	t.Run("SHOULD start over WHEN the service registers again after it was removed", func(t *testing.T) {
+		clock := &FakeTime{time.Now()}
+		reg := registry.InitInMemoryRegistry(clock)
+		assert.Nil(t, reg.RegisterService(&service.ServiceInfo{Path: "/hello", IP: "127.0.0.1", Port: "3000", ServiceId: "server_1"}))
+		assert.Nil(t, reg.DeregisterService("/hello", "server_1"))
+
+		clock.CurrentTime = clock.CurrentTime.Add(time.Minute)
+		assert.Nil(t, reg.RegisterService(&service.ServiceInfo{Path: "/hello", IP: "127.0.0.1", Port: "3000", ServiceId: "server_1"}))
+
+		registeredService, _ := reg.GetServiceById("server_1")
+		assert.Equal(t, clock.CurrentTime, registeredService.RegisteredAt)
+	})
+
+	t.Run("SHOULD keep the time a service carries WHEN it is restored or synced", func(t *testing.T) {
+		reg := registry.InitInMemoryRegistry(&FakeTime{time.Now()})
+		firstSeen := time.Now().Add(-time.Hour)
+		assert.Nil(t, reg.RegisterService(&service.ServiceInfo{Path: "/hello", IP: "127.0.0.1", Port: "3000", ServiceId: "server_1", RegisteredAt: firstSeen}))
+
+		registeredService, _ := reg.GetServiceById("server_1")
+		assert.Equal(t, firstSeen, registeredService.RegisteredAt)
+	})
+}
+
+func Test_OverrideServiceStatus(t *testing.T) {
+	t.Run("SHOULD win over the reported status WHEN an override is set", func(t *testing.T) {
+		reg := registry.InitInMemoryRegistry(&FakeTime{time.Now()})
+		assert.Nil(t, reg.RegisterService(&service.ServiceInfo{Path: "/hello", IP: "127.0.0.1", Port: "3000", ServiceId: "server_1", Status: service.StatusUp}))
+
+		assert.Nil(t, reg.OverrideServiceStatus("server_1", service.StatusOutOfService))
+
+		registeredService, err := reg.GetServiceById("server_1")
+		assert.Nil(t, err)
+		assert.Equal(t, service.StatusOutOfService, registeredService.CurrentStatus())
+
+		// heartbeats do not remove the override
+		assert.Nil(t, reg.RegisterService(&service.ServiceInfo{Path: "/hello", IP: "127.0.0.1", Port: "3000", ServiceId: "server_1", Status: service.StatusUp}))
+		registeredService, _ = reg.GetServiceById("server_1")
+		assert.Equal(t, service.StatusOutOfService, registeredService.CurrentStatus())
+
+		assert.Nil(t, reg.OverrideServiceStatus("server_1", ""))
+		registeredService, _ = reg.GetServiceById("server_1")
+		assert.Equal(t, service.StatusUp, registeredService.CurrentStatus())
+	})
+
+	t.Run("SHOULD return an error WHEN the status is unknown", func(t *testing.T) {
+		reg := registry.InitInMemoryRegistry(&FakeTime{time.Now()})
+		assert.Nil(t, reg.RegisterService(&service.ServiceInfo{Path: "/hello", IP: "127.0.0.1", Port: "3000", ServiceId: "server_1"}))
+
+		assert.NotNil(t, reg.OverrideServiceStatus("server_1", "SLEEPING"))
+		assert.NotNil(t, reg.RegisterService(&service.ServiceInfo{Path: "/hello", IP: "127.0.0.1", Port: "3000", ServiceId: "server_2", Status: "SLEEPING"}))
+	})
+
+	t.Run("SHOULD return an error WHEN the service does not exist", func(t *testing.T) {
+		reg := registry.InitInMemoryRegistry(&FakeTime{time.Now()})
+
+		assert.NotNil(t, reg.OverrideServiceStatus("server_1", service.StatusDraining))
+	})
+}
+
 // Mocks
 
 type FakeTime struct {
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"net/url"
+	"strings"
+)
+
+// prefix of the query parameters selecting services on a metadata key
+const metadataQueryPrefix = "meta."
+
+// Selector matches services on the attributes they registered with. Empty fields
+// match every service.
+type Selector struct {
+	// Tags must all be carried by a service
+	Tags []string
+	// Metadata keys must all be set to the given values
+	Metadata map[string]string
+	// Version is either a full version or a prefix such as "1" or "1.4"
+	Version string
+	Zone    string
+}
+
+// ParseSelector builds a Selector from query parameters. Tags are given with
+// repeated or comma separated "tag" parameters and metadata with "meta.<key>"
+// parameters, e.g. ?tag=canary&version=1.4&zone=eu-west-1a&meta.team=payments
+func ParseSelector(query url.Values) Selector {
+	selector := Selector{
+		Version:  query.Get("version"),
+		Zone:     query.Get("zone"),
+		Tags:     make([]string, 0),
+		Metadata: make(map[string]string),
+	}
+
+	for _, tags := range query["tag"] {
+		for _, tag := range strings.Split(tags, ",") {
+			if tag = strings.TrimSpace(tag); len(tag) > 0 {
+				selector.Tags = append(selector.Tags, tag)
+			}
+		}
+	}
+
+	for key := range query {
+		if strings.HasPrefix(key, metadataQueryPrefix) {
+			selector.Metadata[strings.TrimPrefix(key, metadataQueryPrefix)] = query.Get(key)
+		}
+	}
+
+	return selector
+}
+
+// IsEmpty reports whether the selector matches every service
+func (s Selector) IsEmpty() bool {
+	return len(s.Tags) == 0 && len(s.Metadata) == 0 && len(s.Version) == 0 && len(s.Zone) == 0
+}
+
+// Matches reports whether the service has every attribute of the selector
+func (s Selector) Matches(info *ServiceInfo) bool {
+	if len(s.Zone) > 0 && s.Zone != info.Zone {
+		return false
+	}
+
+	if len(s.Version) > 0 && !versionMatches(info.Version, s.Version) {
+		return false
+	}
+
+	for _, tag := range s.Tags {
+		if !info.HasTag(tag) {
+			return false
+		}
+	}
+
+	for key, value := range s.Metadata {
+		if registeredValue, exists := info.Metadata[key]; !exists || registeredValue != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Filter returns the services matching the selector. It lets a Selector be used
+// as a balancer.ServiceFilter.
+func (s Selector) Filter(path string, services []*ServiceInfo) []*ServiceInfo {
+	if s.IsEmpty() {
+		return services
+	}
+
+	matching := make([]*ServiceInfo, 0, len(services))
+	for _, info := range services {
+		if s.Matches(info) {
+			matching = append(matching, info)
+		}
+	}
+	return matching
+}
@@ -0,0 +1,81 @@
+package service_test
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/anjolaoluwaakindipe/duller/internal/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func stubServices() []*service.ServiceInfo {
+	return []*service.ServiceInfo{
+		{ServiceId: "server1", Version: "1.4.2", Zone: "eu-west-1a", Tags: []string{"canary"}, Metadata: map[string]string{"team": "payments"}},
+		{ServiceId: "server2", Version: "1.40.0", Zone: "eu-west-1b", Tags: []string{"stable", "gpu"}},
+		{ServiceId: "server3", Version: "v2.0.0-rc.1", Zone: "eu-west-1a", Metadata: map[string]string{"team": "search"}},
+	}
+}
+
+func matchingIds(selector service.Selector) []string {
+	ids := make([]string, 0)
+	for _, matching := range selector.Filter("/path1", stubServices()) {
+		ids = append(ids, matching.ServiceId)
+	}
+	return ids
+}
+
+func Test_Selector_Matches(t *testing.T) {
+	t.Run("SHOULD match every service WHEN the selector is empty", func(t *testing.T) {
+		assert.Equal(t, []string{"server1", "server2", "server3"}, matchingIds(service.Selector{}))
+	})
+
+	t.Run("SHOULD match services carrying every tag WHEN tags are given", func(t *testing.T) {
+		assert.Equal(t, []string{"server2"}, matchingIds(service.Selector{Tags: []string{"gpu", "stable"}}))
+		assert.Empty(t, matchingIds(service.Selector{Tags: []string{"gpu", "canary"}}))
+	})
+
+	t.Run("SHOULD match services with the same metadata values WHEN metadata is given", func(t *testing.T) {
+		assert.Equal(t, []string{"server3"}, matchingIds(service.Selector{Metadata: map[string]string{"team": "search"}}))
+	})
+
+	t.Run("SHOULD match services by version prefix WHEN a partial version is given", func(t *testing.T) {
+		assert.Equal(t, []string{"server1", "server2"}, matchingIds(service.Selector{Version: "1"}))
+		assert.Equal(t, []string{"server1"}, matchingIds(service.Selector{Version: "1.4"}))
+		assert.Equal(t, []string{"server1"}, matchingIds(service.Selector{Version: "v1.4.2"}))
+		assert.Equal(t, []string{"server3"}, matchingIds(service.Selector{Version: "2"}))
+	})
+
+	t.Run("SHOULD combine every attribute WHEN several are given", func(t *testing.T) {
+		assert.Equal(t, []string{"server1"}, matchingIds(service.Selector{Zone: "eu-west-1a", Version: "1"}))
+	})
+}
+
+func Test_ParseSelector(t *testing.T) {
+	t.Run("SHOULD read every attribute WHEN given query parameters", func(t *testing.T) {
+		query, _ := url.ParseQuery("tag=canary,gpu&tag=stable&version=1.4&zone=eu-west-1a&meta.team=payments")
+		selector := service.ParseSelector(query)
+
+		assert.Equal(t, []string{"canary", "gpu", "stable"}, selector.Tags)
+		assert.Equal(t, "1.4", selector.Version)
+		assert.Equal(t, "eu-west-1a", selector.Zone)
+		assert.Equal(t, map[string]string{"team": "payments"}, selector.Metadata)
+	})
+
+	t.Run("SHOULD return an empty selector WHEN no attribute is given", func(t *testing.T) {
+		assert.True(t, service.ParseSelector(url.Values{}).IsEmpty())
+	})
+}
+
+func Test_IsValidVersion(t *testing.T) {
+	t.Run("SHOULD accept semantic versions WHEN validating", func(t *testing.T) {
+		for _, version := range []string{"1.0.0", "v2.3.4", "1.0.0-rc.1", "1.0.0+build.5"} {
+			assert.True(t, service.IsValidVersion(version), version)
+		}
+	})
+
+	t.Run("SHOULD reject other versions WHEN validating", func(t *testing.T) {
+		for _, version := range []string{"1", "1.0", "01.0.0", "latest", "1.0.0.0"} {
+			assert.False(t, service.IsValidVersion(version), version)
+		}
+	})
+}
@@ -4,11 +4,52 @@ import "time"
 
 type ServiceInfo struct {
 	LastHeartbeat time.Time `json:"lastHearbeat"`
-	ServiceId     string    `json:"serviceId"`
-	IP            string    `json:"ip"`
-	Port          string    `json:"port"`
-	Path          string    `json:"path"`
-	IsHealthy     bool      `json:"isHealthy"`
-	CurrentUse    int       `json:"-"`
-	WeightedUse   int       `json:"weightedUse,omitempty"`
+	// RegisteredAt is when the registry first saw the instance since it last expired
+	// or deregistered
+	RegisteredAt time.Time `json:"registeredAt"`
+	ServiceId    string    `json:"serviceId"`
+	IP           string    `json:"ip"`
+	Port         string    `json:"port"`
+	Path         string    `json:"path"`
+	// Status is the status the instance reports in its heartbeats
+	Status Status `json:"status"`
+	// CheckStatus is DOWN while the instance fails its active health checks
+	CheckStatus Status `json:"checkStatus,omitempty"`
+	// OverriddenStatus is set by operators and wins over every other status
+	OverriddenStatus Status `json:"overriddenStatus,omitempty"`
+	// Metadata holds free-form key/value pairs describing the instance
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Tags     []string          `json:"tags,omitempty"`
+	// Version is the semantic version of the instance
+	Version string `json:"version,omitempty"`
+	Zone    string `json:"zone,omitempty"`
+	// Strategy is the load balancing strategy the instance asks for on its path
+	Strategy    string `json:"strategy,omitempty"`
+	WeightedUse int    `json:"weightedUse,omitempty"`
+}
+
+// HasTag reports whether the instance registered with the given tag
+func (s *ServiceInfo) HasTag(tag string) bool {
+	for _, registeredTag := range s.Tags {
+		if registeredTag == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// CurrentStatus returns the status of the instance as seen by load balancers. An
+// operator override wins over failed health checks, which win over the status
+// reported by the instance.
+func (s *ServiceInfo) CurrentStatus() Status {
+	if len(s.OverriddenStatus) > 0 {
+		return s.OverriddenStatus
+	}
+	if s.CheckStatus == StatusDown {
+		return StatusDown
+	}
+	if len(s.Status) == 0 {
+		return StatusUp
+	}
+	return s.Status
 }
@@ -0,0 +1,27 @@
+package service
+
+// Status is the lifecycle state of a service instance
+type Status string
+
+const (
+	// StatusStarting is reported by an instance that is not ready to serve requests yet
+	StatusStarting Status = "STARTING"
+	// StatusUp is the only status load balancers select
+	StatusUp Status = "UP"
+	// StatusDown is reported by an instance or set when it fails its health checks
+	StatusDown Status = "DOWN"
+	// StatusOutOfService is usually set by an operator to take an instance out of rotation
+	StatusOutOfService Status = "OUT_OF_SERVICE"
+	// StatusDraining instances finish their in-flight requests but receive no new ones
+	StatusDraining Status = "DRAINING"
+)
+
+// IsValid reports whether the status is one of the known statuses
+func (s Status) IsValid() bool {
+	switch s {
+	case StatusStarting, StatusUp, StatusDown, StatusOutOfService, StatusDraining:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,46 @@
+package service
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// semanticVersion matches versions such as 1.4.2, v2.0.0-rc.1 or 1.0.0+build.5
+var semanticVersion = regexp.MustCompile(`^v?(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)(-[0-9A-Za-z.-]+)?(\+[0-9A-Za-z.-]+)?$`)
+
+// IsValidVersion reports whether version is a semantic version. A leading v is allowed.
+func IsValidVersion(version string) bool {
+	return semanticVersion.MatchString(version)
+}
+
+// versionMatches reports whether version matches the pattern. A pattern holding
+// fewer than three components matches every version starting with them, so
+// "1" matches 1.4.2 and "1.4" matches 1.4.0-rc.1 but not 1.40.0.
+func versionMatches(version string, pattern string) bool {
+	version = strings.TrimPrefix(version, "v")
+	pattern = strings.TrimPrefix(pattern, "v")
+
+	if version == pattern {
+		return true
+	}
+
+	patternParts := strings.Split(pattern, ".")
+	if len(patternParts) > 2 {
+		return false
+	}
+
+	versionParts := strings.SplitN(version, ".", 3)
+	for i, patternPart := range patternParts {
+		if i >= len(versionParts) {
+			return false
+		}
+		if _, err := strconv.Atoi(patternPart); err != nil {
+			return false
+		}
+		if versionParts[i] != patternPart {
+			return false
+		}
+	}
+	return true
+}
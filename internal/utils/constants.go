@@ -11,19 +11,115 @@ const (
 	DISCOVERY_HEARTBEAT_PATH = "/sendheartbeat"
 	GATEWAY_PORT             = "5923"
 	GATEWAY_GRACEFULL_WAIT   = 15 * time.Second
+	GATEWAY_SYNC_WAIT        = 30 * time.Second
+	GATEWAY_STRIP_PREFIX     = ""
+	GATEWAY_REWRITE          = ""
+	GATEWAY_ROUTE_FILE       = ""
 	HEARTBEAT_INTERVAL       = 15 * time.Second
 	DISCOVERY_KEY            = ""
+	REGISTRY_STORE           = "memory"
+	REGISTRY_DIR             = "./duller-data"
+	REGISTRY_SNAPSHOT        = 5 * time.Minute
+	DISCOVERY_PEERS          = ""
+	RAFT_ADDRESS             = ""
+	RAFT_ELECTION_TIMEOUT    = 1 * time.Second
+	HEALTH_CHECK_MODE        = "none"
+	HEALTH_CHECK_PATH        = "/health"
+	HEALTH_CHECK_STATUS      = 0
+	HEALTH_CHECK_INTERVAL    = 10 * time.Second
+	HEALTH_CHECK_TIMEOUT     = 2 * time.Second
+	HEALTHY_THRESHOLD        = 2
+	UNHEALTHY_THRESHOLD      = 3
+	OUTLIER_FAILURES         = 5
+	OUTLIER_EJECTION         = 30 * time.Second
+	OUTLIER_MAX_EJECTION     = 5 * time.Minute
+	OUTLIER_MAX_PERCENT      = 50
+	BALANCER_STRATEGY        = "round-robin"
+	BALANCER_PATHS           = ""
+	BALANCER_HASH_KEY        = "ip"
+	GATEWAY_ZONE             = ""
+	ZONE_MIN_LOCAL_PERCENT   = 50
+	PROXY_RETRIES            = 2
+	PROXY_RETRY_TIMEOUT      = 0 * time.Second
+	PROXY_RETRY_BUDGET       = 20
+	PROXY_RETRY_MIN          = 10
+	PROXY_RETRY_MAX_BODY     = 64 * 1024
+	BREAKER_ERROR_PERCENT    = 50
+	BREAKER_SLOW_CALL        = 0 * time.Second
+	BREAKER_WINDOW           = 10 * time.Second
+	BREAKER_MIN_REQUESTS     = 20
+	BREAKER_OPEN_TIME        = 30 * time.Second
+	BREAKER_PROBES           = 3
+	BREAKER_PER_INSTANCE     = false
+	SLOW_START_WINDOW        = 0 * time.Second
+	SLOW_START_AGGRESSION    = 1.0
+	SLOW_START_MIN_PERCENT   = 10
 )
 
 // flag names for the gateway and cli commands
 const (
-	DISCOVERY_HOST_FLAG           = "dhost"
-	DISCOVERY_PORT_FLAG           = "dport"
-	DISCOVERY_TYPE_FLAG           = "dtype"
-	DISCOVERY_SERVICE_PATH_FLAG   = "dservice_path"
-	DISCOVERY_HEARTBEAT_PATH_FLAG = "dheartbeat_path"
-	GATEWAY_PORT_FLAG             = "gport"
-	GATEWAY_GRACEFULL_WAIT_FLAG   = "gwait"
-	HEARTBEAT_INTERVAL_FLAG       = "dheartbeat"
-	DISCOVERY_KEY_FLAG            = "dkey"
+	DISCOVERY_HOST_FLAG             = "dhost"
+	DISCOVERY_PORT_FLAG             = "dport"
+	DISCOVERY_TYPE_FLAG             = "dtype"
+	DISCOVERY_SERVICE_PATH_FLAG     = "dservice_path"
+	DISCOVERY_HEARTBEAT_PATH_FLAG   = "dheartbeat_path"
+	GATEWAY_PORT_FLAG               = "gport"
+	GATEWAY_GRACEFULL_WAIT_FLAG     = "gwait"
+	GATEWAY_SYNC_WAIT_FLAG          = "gsync_wait"
+	GATEWAY_STRIP_PREFIX_FLAG       = "gstrip_prefix"
+	GATEWAY_REWRITE_FLAG            = "grewrite"
+	GATEWAY_ROUTE_FILE_FLAG         = "groutes"
+	HEARTBEAT_INTERVAL_FLAG         = "dheartbeat"
+	DISCOVERY_KEY_FLAG              = "dkey"
+	REGISTRY_STORE_FLAG             = "dstore"
+	REGISTRY_DIR_FLAG               = "dstore_dir"
+	REGISTRY_SNAPSHOT_FLAG          = "dsnapshot"
+	DISCOVERY_PEERS_FLAG            = "dpeers"
+	RAFT_ADDRESS_FLAG               = "draft_addr"
+	HEALTH_CHECK_MODE_FLAG          = "dhealth_mode"
+	HEALTH_CHECK_PATH_FLAG          = "dhealth_path"
+	HEALTH_CHECK_STATUS_FLAG        = "dhealth_status"
+	HEALTH_CHECK_INTERVAL_FLAG      = "dhealth_interval"
+	HEALTH_CHECK_TIMEOUT_FLAG       = "dhealth_timeout"
+	HEALTHY_THRESHOLD_FLAG          = "dhealth_healthy"
+	UNHEALTHY_THRESHOLD_FLAG        = "dhealth_unhealthy"
+	OUTLIER_FAILURES_FLAG           = "doutlier_failures"
+	OUTLIER_EJECTION_FLAG           = "doutlier_ejection"
+	OUTLIER_MAX_EJECTION_FLAG       = "doutlier_max_ejection"
+	OUTLIER_MAX_PERCENT_FLAG        = "doutlier_max_percent"
+	BALANCER_STRATEGY_FLAG          = "dbalancer"
+	BALANCER_PATHS_FLAG             = "dbalancer_paths"
+	DISCOVERY_HASH_KEY_FLAG         = "dhash_key"
+	GATEWAY_HASH_KEY_FLAG           = "ghash_key"
+	GATEWAY_ZONE_FLAG               = "gzone"
+	GATEWAY_ZONE_MIN_LOCAL_FLAG     = "gzone_min_local"
+	DISCOVERY_ZONE_MIN_LOCAL_FLAG   = "dzone_min_local"
+	DISCOVERY_RETRIES_FLAG          = "dretries"
+	DISCOVERY_RETRY_TIMEOUT_FLAG    = "dretry_timeout"
+	DISCOVERY_RETRY_BUDGET_FLAG     = "dretry_budget"
+	DISCOVERY_RETRY_MAX_BODY_FLAG   = "dretry_max_body"
+	GATEWAY_RETRIES_FLAG            = "gretries"
+	GATEWAY_RETRY_TIMEOUT_FLAG      = "gretry_timeout"
+	GATEWAY_RETRY_BUDGET_FLAG       = "gretry_budget"
+	GATEWAY_RETRY_MAX_BODY_FLAG     = "gretry_max_body"
+	DISCOVERY_BREAKER_ERRORS_FLAG   = "dbreaker_errors"
+	DISCOVERY_BREAKER_SLOW_FLAG     = "dbreaker_slow"
+	DISCOVERY_BREAKER_WINDOW_FLAG   = "dbreaker_window"
+	DISCOVERY_BREAKER_MIN_FLAG      = "dbreaker_min_requests"
+	DISCOVERY_BREAKER_OPEN_FLAG     = "dbreaker_open"
+	DISCOVERY_BREAKER_PROBES_FLAG   = "dbreaker_probes"
+	DISCOVERY_BREAKER_SERVICE_FLAG  = "dbreaker_per_instance"
+	GATEWAY_BREAKER_ERRORS_FLAG     = "gbreaker_errors"
+	GATEWAY_BREAKER_SLOW_FLAG       = "gbreaker_slow"
+	GATEWAY_BREAKER_WINDOW_FLAG     = "gbreaker_window"
+	GATEWAY_BREAKER_MIN_FLAG        = "gbreaker_min_requests"
+	GATEWAY_BREAKER_OPEN_FLAG       = "gbreaker_open"
+	GATEWAY_BREAKER_PROBES_FLAG     = "gbreaker_probes"
+	GATEWAY_BREAKER_SERVICE_FLAG    = "gbreaker_per_instance"
+	DISCOVERY_SLOW_START_FLAG       = "dslow_start"
+	DISCOVERY_SLOW_START_CURVE_FLAG = "dslow_start_aggression"
+	DISCOVERY_SLOW_START_MIN_FLAG   = "dslow_start_min"
+	GATEWAY_SLOW_START_FLAG         = "gslow_start"
+	GATEWAY_SLOW_START_CURVE_FLAG   = "gslow_start_aggression"
+	GATEWAY_SLOW_START_MIN_FLAG     = "gslow_start_min"
 )
@@ -4,12 +4,17 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/anjolaoluwaakindipe/duller/internal/discovery"
+	"github.com/anjolaoluwaakindipe/duller/internal/service"
 )
 
 type DiscoveryClient struct {
@@ -21,6 +26,24 @@ type DiscoveryClient struct {
 	ip                string
 	port              string
 	heartbeatPath     string
+	discoveryKey      string
+	metadata          map[string]string
+	tags              []string
+	version           string
+	zone              string
+	weight            int
+	strategy          string
+	client            *http.Client
+	// status is shared by every copy of the client so SetStatus affects the
+	// heartbeats sent by SendHeartBeat
+	status *atomic.Value
+}
+
+// SetStatus changes the lifecycle status sent with the following heartbeats. A
+// service can for example report STARTING until it is ready and DRAINING before
+// it shuts down.
+func (dc DiscoveryClient) SetStatus(status service.Status) {
+	dc.status.Store(status)
 }
 
 // SendHearbeat sends a hearbeat message to a servcie discovery server
@@ -42,25 +65,59 @@ func (dc DiscoveryClient) SendHeartBeat(ctx context.Context) {
 				Path:      dc.path,
 				IP:        dc.ip,
 				Port:      dc.port,
+				Status:    dc.status.Load().(service.Status),
+				Metadata:  dc.metadata,
+				Tags:      dc.tags,
+				Version:   dc.version,
+				Zone:      dc.zone,
+				Weight:    dc.weight,
+				Strategy:  dc.strategy,
 			}
 			jsonMessage, err := json.Marshal(message)
 			if err != nil {
 				log.Println("Error occured when parsing heartbeat to json: ", err)
+				continue
 			}
 
-			response, err := http.Post("http://"+dc.discoveryIP+":"+dc.discoveryPort+"/"+dc.heartbeatPath, "application/json", bytes.NewBuffer(jsonMessage))
-			if err != nil {
+			if err := dc.send(ctx, http.MethodPost, "/"+strings.TrimPrefix(dc.heartbeatPath, "/"), bytes.NewBuffer(jsonMessage)); err != nil {
 				log.Println("Error occured when sending heartbeat: ", err)
 			}
-
-			if (response.StatusCode != http.StatusOK) && (response.StatusCode != http.StatusCreated) {
-				body, _ := io.ReadAll(response.Body)
-				log.Printf("Error response from discovery server with status code %v: \n %v \n", response.StatusCode, string(body))
-			}
 		}
 	}
 }
 
+// Deregister removes the service from the service discovery server so it stops
+// receiving requests right away. It is meant to be called when the service shuts
+// down, after the context given to SendHeartBeat is cancelled.
+func (dc DiscoveryClient) Deregister(ctx context.Context) error {
+	return dc.send(ctx, http.MethodDelete, "/services/"+strings.Trim(dc.path, "/")+"/"+url.PathEscape(dc.serviceId), nil)
+}
+
+// send sends a request to the service discovery server and returns an error if
+// it does not respond with a 2xx status code
+func (dc DiscoveryClient) send(ctx context.Context, method string, path string, body io.Reader) error {
+	req, err := http.NewRequestWithContext(ctx, method, "http://"+dc.discoveryIP+":"+dc.discoveryPort+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(dc.discoveryKey) > 0 {
+		req.Header.Set("Authorization", "Bearer "+dc.discoveryKey)
+	}
+
+	response, err := dc.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		responseBody, _ := io.ReadAll(response.Body)
+		return fmt.Errorf("discovery server responded with status code %v: %v", response.StatusCode, strings.TrimSpace(string(responseBody)))
+	}
+	return nil
+}
+
 // WithHeartbeatInterval sets the HeartbeatInterval for the DiscoveryClient.
 func WithHeartbeatInterval(interval time.Duration) DiscoveryClientOptions {
 	return func(dc *DiscoveryClient) {
@@ -89,6 +146,65 @@ func WithDiscoveryIP(ip string) DiscoveryClientOptions {
 	}
 }
 
+// WithDiscoveryKey sets the key sent to the discovery server with every request.
+// It must match the key the discovery server was started with.
+func WithDiscoveryKey(key string) DiscoveryClientOptions {
+	return func(dc *DiscoveryClient) {
+		dc.discoveryKey = key
+	}
+}
+
+// WithMetadata sets free-form key/value pairs registered with the service
+func WithMetadata(metadata map[string]string) DiscoveryClientOptions {
+	return func(dc *DiscoveryClient) {
+		dc.metadata = metadata
+	}
+}
+
+// WithTags sets the tags registered with the service
+func WithTags(tags ...string) DiscoveryClientOptions {
+	return func(dc *DiscoveryClient) {
+		dc.tags = tags
+	}
+}
+
+// WithVersion sets the semantic version registered with the service
+func WithVersion(version string) DiscoveryClientOptions {
+	return func(dc *DiscoveryClient) {
+		dc.version = version
+	}
+}
+
+// WithZone sets the zone the service runs in
+func WithZone(zone string) DiscoveryClientOptions {
+	return func(dc *DiscoveryClient) {
+		dc.zone = zone
+	}
+}
+
+// WithWeight sets the share of requests the service gets from weighted load
+// balancing strategies
+func WithWeight(weight int) DiscoveryClientOptions {
+	return func(dc *DiscoveryClient) {
+		dc.weight = weight
+	}
+}
+
+// WithStrategy asks the discovery server to balance the path of the service with
+// the given load balancing strategy, unless an operator chose another one
+func WithStrategy(strategy string) DiscoveryClientOptions {
+	return func(dc *DiscoveryClient) {
+		dc.strategy = strategy
+	}
+}
+
+// WithStatus sets the status sent with the first heartbeats of the DiscoveryClient
+func WithStatus(status service.Status) DiscoveryClientOptions {
+	return func(dc *DiscoveryClient) {
+		dc.status.Store(status)
+	}
+}
+
 // DiscoveryClientOptions is an option fucntion type for any DiscoveryClient
 type DiscoveryClientOptions = func(dc *DiscoveryClient)
 
@@ -101,8 +217,10 @@ type DiscoveryClientOptions = func(dc *DiscoveryClient)
 //	discoveryPort: "9876",
 //	heartbeatPath: "/heartbeat"
 //	heartbeatInterval: 15 * time.Second
+//	status: "UP"
 func NewDiscoveryClient(serviceId string, path string, ip string, port string, opts ...DiscoveryClientOptions) (DiscoveryClient, error) {
-	dc := DiscoveryClient{serviceId: serviceId, path: path, ip: ip, port: port, discoveryIP: "localhost", discoveryPort: "9876", heartbeatPath: "/heartbeat", heartbeatInterval: 15 * time.Second}
+	dc := DiscoveryClient{serviceId: serviceId, path: path, ip: ip, port: port, discoveryIP: "localhost", discoveryPort: "9876", heartbeatPath: "/heartbeat", heartbeatInterval: 15 * time.Second, client: &http.Client{Timeout: 10 * time.Second}, status: &atomic.Value{}}
+	dc.status.Store(service.StatusUp)
 	for _, opt := range opts {
 		opt(&dc)
 	}
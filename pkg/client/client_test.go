@@ -0,0 +1,129 @@
+package duller_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/anjolaoluwaakindipe/duller/internal/balancer"
+	"github.com/anjolaoluwaakindipe/duller/internal/discovery"
+	"github.com/anjolaoluwaakindipe/duller/internal/registry"
+	"github.com/anjolaoluwaakindipe/duller/internal/service"
+	"github.com/anjolaoluwaakindipe/duller/internal/utils"
+	duller "github.com/anjolaoluwaakindipe/duller/pkg/client"
+	"github.com/stretchr/testify/assert"
+)
+
+// stubDiscovery starts a discovery server and returns a client pointed at it
+func stubDiscovery(t *testing.T, ctx context.Context, key string, opts ...duller.DiscoveryClientOptions) (duller.DiscoveryClient, registry.Registry) {
+	return stubDiscoveryOnPath(t, ctx, key, "/orders", opts...)
+}
+
+// stubDiscoveryOnPath starts a discovery server and returns a client registering on the path
+func stubDiscoveryOnPath(t *testing.T, ctx context.Context, key string, path string, opts ...duller.DiscoveryClientOptions) (duller.DiscoveryClient, registry.Registry) {
+	reg := registry.InitInMemoryRegistry(utils.NewClock())
+	router, err := discovery.NewMuxRouter(balancer.NewRoundRobinLoadBalancer(reg), reg, ctx, discovery.WithSecretKey(key))
+	assert.Nil(t, err)
+
+	server := httptest.NewServer(router.SetupRoutes())
+	t.Cleanup(server.Close)
+
+	serverUrl, _ := url.Parse(server.URL)
+	opts = append(opts, duller.WithDiscoveryIP(serverUrl.Hostname()), duller.WithDiscoveryPort(serverUrl.Port()), duller.WithDiscoveryKey(key), duller.WithHeartbeatInterval(10*time.Millisecond))
+	client, err := duller.NewDiscoveryClient("server1", path, "127.0.0.1", "4000", opts...)
+	assert.Nil(t, err)
+	return client, reg
+}
+
+func Test_DiscoveryClient_Deregister(t *testing.T) {
+	t.Run("SHOULD remove the service from the discovery server WHEN it deregisters", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		client, reg := stubDiscovery(t, ctx, "")
+
+		heartbeatCtx, stopHeartbeats := context.WithCancel(ctx)
+		go client.SendHeartBeat(heartbeatCtx)
+
+		assert.Eventually(t, func() bool {
+			_, err := reg.GetServiceById("server1")
+			return err == nil
+		}, 5*time.Second, 10*time.Millisecond)
+
+		stopHeartbeats()
+		assert.Nil(t, client.Deregister(ctx))
+
+		_, err := reg.GetServiceById("server1")
+		assert.NotNil(t, err)
+	})
+
+	t.Run("SHOULD remove the service from the discovery server WHEN it registered on a nested path", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		client, reg := stubDiscoveryOnPath(t, ctx, "", "/orders/v2")
+
+		heartbeatCtx, stopHeartbeats := context.WithCancel(ctx)
+		go client.SendHeartBeat(heartbeatCtx)
+
+		assert.Eventually(t, func() bool {
+			_, err := reg.GetServiceById("server1")
+			return err == nil
+		}, 5*time.Second, 10*time.Millisecond)
+
+		stopHeartbeats()
+		assert.Nil(t, client.Deregister(ctx))
+
+		_, err := reg.GetServiceById("server1")
+		assert.NotNil(t, err)
+	})
+
+	t.Run("SHOULD return an error WHEN the service is not registered", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		client, _ := stubDiscovery(t, ctx, "")
+
+		assert.NotNil(t, client.Deregister(ctx))
+	})
+}
+
+func Test_DiscoveryClient_SendHeartBeat(t *testing.T) {
+	t.Run("SHOULD report the status set on the client WHEN sending heartbeats", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		heartbeats := make(chan discovery.HeartBeatMessage, 100)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var message discovery.HeartBeatMessage
+			json.NewDecoder(r.Body).Decode(&message)
+			select {
+			case heartbeats <- message:
+			default:
+			}
+		}))
+		defer server.Close()
+
+		serverUrl, _ := url.Parse(server.URL)
+		client, err := duller.NewDiscoveryClient("server1", "/orders", "127.0.0.1", "4000",
+			duller.WithDiscoveryIP(serverUrl.Hostname()),
+			duller.WithDiscoveryPort(serverUrl.Port()),
+			duller.WithHeartbeatInterval(10*time.Millisecond),
+			duller.WithStatus(service.StatusStarting),
+		)
+		assert.Nil(t, err)
+		go client.SendHeartBeat(ctx)
+
+		assert.Equal(t, service.StatusStarting, (<-heartbeats).Status)
+
+		client.SetStatus(service.StatusDraining)
+
+		assert.Eventually(t, func() bool {
+			return (<-heartbeats).Status == service.StatusDraining
+		}, 5*time.Second, time.Millisecond)
+	})
+}
@@ -1,10 +1,16 @@
 package duller
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
@@ -12,6 +18,7 @@ type ClientServerSettings struct {
 	RegistryLocation  string
 	DiscoveryIP       string
 	DiscoverPort      string
+	DiscoveryKey      string
 	ClientPort        string
 	HeartBeatInterval time.Duration
 	Path              string
@@ -19,22 +26,51 @@ type ClientServerSettings struct {
 }
 
 // Setups a test client and sends a heartbeat to service discovery
-// server
+// server. The client deregisters itself when it receives SIGINT or SIGTERM.
 func InitClientServer(settings ClientServerSettings) {
 	serviceAddress := fmt.Sprintf("http://localhost:%v", settings.ClientPort)
-	client, _ := NewDiscoveryClient(settings.ServerName, settings.Path, settings.DiscoveryIP, settings.DiscoverPort, WithHeartbeatInterval(settings.HeartBeatInterval))
-
-	go client.SendHeartBeat(nil)
-
-	err := http.ListenAndServe(fmt.Sprintf(":%v", settings.ClientPort), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		msg, _ := json.Marshal(map[string]interface{}{
-			"message": fmt.Sprintf("Hello from server %v, with address %v, and you used path %v from the gateway to get to me", settings.ServerName, serviceAddress, settings.Path),
-		})
-		w.WriteHeader(http.StatusOK)
-		w.Write(msg)
-	}))
-	if err != nil {
+
+	discoveryIP, discoveryPort := settings.DiscoveryIP, settings.DiscoverPort
+	if host, port, err := net.SplitHostPort(settings.RegistryLocation); err == nil && len(discoveryIP) == 0 {
+		discoveryIP, discoveryPort = host, port
+	}
+
+	opts := []DiscoveryClientOptions{WithHeartbeatInterval(settings.HeartBeatInterval), WithDiscoveryKey(settings.DiscoveryKey)}
+	if len(discoveryIP) > 0 {
+		opts = append(opts, WithDiscoveryIP(discoveryIP), WithDiscoveryPort(discoveryPort))
+	}
+	client, _ := NewDiscoveryClient(settings.ServerName, settings.Path, "localhost", settings.ClientPort, opts...)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go client.SendHeartBeat(ctx)
+
+	server := &http.Server{
+		Addr: fmt.Sprintf(":%v", settings.ClientPort),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			msg, _ := json.Marshal(map[string]interface{}{
+				"message": fmt.Sprintf("Hello from server %v, with address %v, and you used path %v from the gateway to get to me", settings.ServerName, serviceAddress, settings.Path),
+			})
+			w.WriteHeader(http.StatusOK)
+			w.Write(msg)
+		}),
+	}
+
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if err := client.Deregister(shutdownCtx); err != nil {
+			log.Printf("Could not deregister from discovery server: %v", err)
+		}
+		server.Shutdown(shutdownCtx)
+	}()
+
+	fmt.Printf("starting server at port %v\n", settings.ClientPort)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Fatalf("Server error occured: %v", err)
 	}
-	fmt.Printf("starting server at port %v", settings.ClientPort)
 }